@@ -0,0 +1,229 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+//
+// ──────────────────────────────────────────────────── I ──────────
+//   :::::: B I N A R Y : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────────
+//
+
+// Statuses of the response frames of the binary transport.
+const (
+	binaryOK     = 0
+	binaryFailed = 1
+)
+
+// DefaultMaxFrameSize bounds the response frames of a BinaryConn, unless
+// set with MaxFrameSize.
+const DefaultMaxFrameSize = 16 << 20
+
+// ErrClosed is returned by the calls of a closed BinaryConn, and by those
+// pending when its connection failed.
+var ErrClosed = errors.New("connection closed")
+
+// BinaryConn is a connection to the binary transport of an instance, for
+// callers that cannot afford the overhead of HTTP. Its calls can be made
+// concurrently, and share the connection. Unlike Client, it neither
+// balances calls between instances nor retries them.
+//
+//	c, err := client.DialBinary(ctx, "10.0.0.1:9091")
+//	if err != nil {
+//		...
+//	}
+//	defer c.Close()
+//	var resp struct{ V string }
+//	err = c.Call(ctx, "uppercase", map[string]string{"s": "hello"}, &resp)
+type BinaryConn struct {
+	conn         net.Conn
+	maxFrameSize int
+
+	writeMu sync.Mutex
+	w       *bufio.Writer
+
+	mu      sync.Mutex
+	nextID  uint32
+	pending map[uint32]chan binaryReply
+	err     error
+}
+
+// binaryReply is the status and the JSON response of a call.
+type binaryReply struct {
+	status byte
+	body   []byte
+}
+
+// BinaryOption configures a BinaryConn.
+type BinaryOption func(*BinaryConn)
+
+// MaxFrameSize bounds the size of the response frames, so that a faulty or
+// hostile instance cannot make the connection allocate without bounds. The
+// connection fails on larger frames.
+func MaxFrameSize(n int) BinaryOption {
+	return func(c *BinaryConn) { c.maxFrameSize = n }
+}
+
+// DialBinary connects to the binary transport at addr, "host:port" or the
+// path of a Unix domain socket behind "unix:", like "unix:/run/svc.sock".
+func DialBinary(ctx context.Context, addr string, opts ...BinaryOption) (*BinaryConn, error) {
+	network := "tcp"
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		network, addr = "unix", path
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &BinaryConn{
+		conn:         conn,
+		maxFrameSize: DefaultMaxFrameSize,
+		w:            bufio.NewWriter(conn),
+		pending:      make(map[uint32]chan binaryReply),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	go c.read()
+	return c, nil
+}
+
+// Close closes the connection. Pending calls fail with ErrClosed.
+func (c *BinaryConn) Close() error {
+	return c.conn.Close()
+}
+
+// Authenticate sets the credentials of the calls made next on the
+// connection: an OIDC access token and an API key, either of which may be
+// empty, like the Authorization and X-API-Key headers of HTTP requests.
+func (c *BinaryConn) Authenticate(ctx context.Context, accessToken, apiKey string) error {
+	return c.Call(ctx, ":credentials", map[string]string{"access_token": accessToken, "api_key": apiKey}, &struct{}{})
+}
+
+// Call invokes op with req, encoded as JSON, and decodes its response into
+// resp. Failures reported by the service are Errors, without StatusCode.
+func (c *BinaryConn) Call(ctx context.Context, op string, req, resp interface{}) error {
+	if len(op) > 255 {
+		return fmt.Errorf("%s: operation name too long", op)
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	reply := make(chan binaryReply, 1)
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return c.err
+	}
+	id := c.nextID
+	c.nextID++
+	c.pending[id] = reply
+	c.mu.Unlock()
+
+	frame := make([]byte, 9, 9+len(op)+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(cap(frame)-4))
+	binary.BigEndian.PutUint32(frame[4:], id)
+	frame[8] = byte(len(op))
+	frame = append(append(frame, op...), data...)
+	c.writeMu.Lock()
+	if deadline, ok := ctx.Deadline(); ok {
+		c.conn.SetWriteDeadline(deadline)
+	} else {
+		c.conn.SetWriteDeadline(time.Time{})
+	}
+	_, err = c.w.Write(frame)
+	if err == nil {
+		err = c.w.Flush()
+	}
+	c.writeMu.Unlock()
+	if err != nil {
+		// The frame may have been partly written, so that the connection
+		// cannot be used anymore.
+		c.conn.Close()
+		return err
+	}
+
+	select {
+	case r, ok := <-reply:
+		if !ok {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			return c.err
+		}
+		if r.status != binaryOK {
+			var failure struct {
+				Err  string `json:"err"`
+				Code string `json:"code"`
+			}
+			if err := json.Unmarshal(r.body, &failure); err != nil {
+				return fmt.Errorf("%s: %v", op, err)
+			}
+			return Error{Op: op, Message: failure.Err, Code: failure.Code}
+		}
+		return json.Unmarshal(r.body, resp)
+	case <-ctx.Done():
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// read hands the responses of the connection to their calls until it
+// fails, then fails the pending calls with ErrClosed, wrapping what broke
+// the protocol if anything did.
+func (c *BinaryConn) read() {
+	r := bufio.NewReader(c.conn)
+	header := make([]byte, 9)
+	failure := ErrClosed
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			break
+		}
+		frameSize := int64(binary.BigEndian.Uint32(header))
+		if frameSize < 5 {
+			failure = fmt.Errorf("%w: malformed response frame", ErrClosed)
+			break
+		}
+		if frameSize > int64(c.maxFrameSize) {
+			failure = fmt.Errorf("%w: response frame of %d bytes larger than %d", ErrClosed, frameSize, c.maxFrameSize)
+			break
+		}
+		size := int(frameSize) - 5
+		body := make([]byte, size)
+		if _, err := io.ReadFull(r, body); err != nil {
+			break
+		}
+		id := binary.BigEndian.Uint32(header[4:])
+		c.mu.Lock()
+		reply, ok := c.pending[id]
+		delete(c.pending, id)
+		c.mu.Unlock()
+		if ok {
+			reply <- binaryReply{header[8], body}
+		}
+	}
+
+	c.conn.Close()
+	c.mu.Lock()
+	c.err = failure
+	for id, reply := range c.pending {
+		close(reply)
+		delete(c.pending, id)
+	}
+	c.mu.Unlock()
+}
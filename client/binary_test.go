@@ -0,0 +1,86 @@
+package client
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveBinaryFrame answers the first call on l with a frame whose length
+// field is size, followed by body.
+func serveBinaryFrame(t *testing.T, l net.Listener, size uint32, body string) {
+	conn, err := l.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	header := make([]byte, 9)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := io.CopyN(io.Discard, conn, int64(binary.BigEndian.Uint32(header))-5); err != nil {
+		t.Error(err)
+		return
+	}
+	frame := make([]byte, 9, 9+len(body))
+	binary.BigEndian.PutUint32(frame, size)
+	copy(frame[4:8], header[4:8])
+	frame[8] = binaryOK
+	conn.Write(append(frame, body...))
+}
+
+func TestBinaryFrames(t *testing.T) {
+	const body = `{"v":"HELLO"}`
+	tests := []struct {
+		name         string
+		size         uint32
+		maxFrameSize int
+		wantErr      string
+	}{
+		{name: "response", size: uint32(5 + len(body))},
+		{name: "response of the max size", size: uint32(5 + len(body)), maxFrameSize: 5 + len(body)},
+		{name: "response too large", size: uint32(6 + len(body)), maxFrameSize: 5 + len(body), wantErr: "larger than"},
+		{name: "huge response", size: 1<<32 - 1, wantErr: "larger than"},
+		{name: "malformed frame", size: 4, wantErr: "malformed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+			go serveBinaryFrame(t, l, tt.size, body)
+
+			var opts []BinaryOption
+			if tt.maxFrameSize > 0 {
+				opts = append(opts, MaxFrameSize(tt.maxFrameSize))
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			c, err := DialBinary(ctx, l.Addr().String(), opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+
+			var resp struct{ V string }
+			err = c.Call(ctx, "uppercase", map[string]string{"s": "hello"}, &resp)
+			if tt.wantErr == "" {
+				if err != nil || resp.V != "HELLO" {
+					t.Fatalf("Call = %q, %v", resp.V, err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrClosed) || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want ErrClosed with %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,451 @@
+// Package client is a Go client of stringsvc. Its Client implements Service,
+// the interface of the service, by calling the HTTP endpoints of one or more
+// instances, balancing requests between them and retrying failed ones with
+// an exponential backoff, within a budget of retries.
+//
+//	c, err := client.New([]string{"10.0.0.1:8080", "10.0.0.2:8080"}, client.Timeout(2*time.Second))
+//	if err != nil {
+//		...
+//	}
+//	defer c.Close()
+//	s, err := c.Uppercase(ctx, "hello")
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
+	"github.com/go-kit/kit/sd"
+	"github.com/go-kit/kit/sd/lb"
+	httptransport "github.com/go-kit/kit/transport/http"
+)
+
+//
+// ──────────────────────────────────────────────────── I ──────────
+//   :::::: C L I E N T : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────────
+//
+
+// Defaults of the options.
+const (
+	DefaultTimeout      = 10 * time.Second
+	DefaultAttempts     = 3
+	DefaultBackoffBase  = 50 * time.Millisecond
+	DefaultBackoffMax   = time.Second
+	DefaultRetryRatio   = 0.2
+	DefaultRetryReserve = 10
+)
+
+// Error is a failure answered by the service, with the "err" field of the
+// response when it has one. Such failures are not retried, since another
+// instance would answer the same, unless they are temporary: an overload,
+// a timeout or an injected fault.
+type Error struct {
+	Op         string
+	StatusCode int
+	Message    string
+	// Code identifies the kind of failure, like "ERR_EMPTY_INPUT", for
+	// callers to branch on rather than on Message. It is empty with the
+	// versions of the service that answered no code.
+	Code string
+}
+
+func (e Error) Error() string { return e.Op + ": " + e.Message }
+
+// transientCodes are the codes of the failures that another attempt, or
+// another instance, may not have.
+var transientCodes = map[string]bool{
+	"ERR_OVERLOADED":     true,
+	"ERR_FAULT_INJECTED": true,
+	"ERR_TIMEOUT":        true,
+}
+
+// temporary tells whether e may not happen again: a failure with a
+// transient code, or a 5xx one without code, as answered by proxies.
+func (e Error) temporary() bool {
+	if e.Code != "" {
+		return transientCodes[e.Code]
+	}
+	return e.StatusCode >= http.StatusInternalServerError
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// Timeout bounds the time of each call, retries included.
+func Timeout(d time.Duration) Option {
+	return func(c *Client) { c.timeout = d }
+}
+
+// Attempts sets how many instances a call is tried on before giving up.
+// 1 disables retries.
+func Attempts(n int) Option {
+	return func(c *Client) { c.attempts = n }
+}
+
+// HTTPClient sets the client doing the requests, http.DefaultClient by
+// default.
+func HTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.http = hc }
+}
+
+// AttemptTimeout bounds the time of each attempt, so that a slow instance
+// leaves time to retry on another. Attempts are only bounded by Timeout by
+// default.
+func AttemptTimeout(d time.Duration) Option {
+	return func(c *Client) { c.attemptTimeout = d }
+}
+
+// Backoff sets the delays between attempts: a random delay up to base,
+// doubled after every attempt up to max. It defaults to DefaultBackoffBase
+// and DefaultBackoffMax.
+func Backoff(base, max time.Duration) Option {
+	return func(c *Client) { c.backoffBase, c.backoffMax = base, max }
+}
+
+// RetryBudget bounds retries to a ratio of calls, so that retries do not
+// overload instances that are already failing. Up to reserve retries can
+// happen in a row, before calls replenish the budget. It defaults to
+// DefaultRetryRatio and DefaultRetryReserve.
+func RetryBudget(ratio float64, reserve int) Option {
+	return func(c *Client) { c.budget = newRetryBudget(ratio, reserve) }
+}
+
+// Metrics counts the calls of the client in calls, by "op" and "outcome":
+// "success" on the first attempt, "retried_success", or "failure".
+func Metrics(calls metrics.Counter) Option {
+	return func(c *Client) { c.calls = calls }
+}
+
+// InstanceMetrics reports the number of instances in instances, and in
+// healthy 1 while the instancer is subscribed to its backend, 0 after it
+// failed to be.
+func InstanceMetrics(instances, healthy metrics.Gauge) Option {
+	return func(c *Client) { c.instanceCount, c.healthy = instances, healthy }
+}
+
+// Logger sets the logger of the endpointers, which report the instances
+// they fail to make endpoints for.
+func Logger(logger log.Logger) Option {
+	return func(c *Client) { c.logger = logger }
+}
+
+// Client calls the operations of the service on its instances, picked
+// round-robin.
+type Client struct {
+	instances      sd.Instancer
+	timeout        time.Duration
+	attempts       int
+	attemptTimeout time.Duration
+	backoffBase    time.Duration
+	backoffMax     time.Duration
+	budget         *retryBudget
+	calls          metrics.Counter
+	http           *http.Client
+	logger         log.Logger
+	// instanceCount and healthy are set by InstanceMetrics.
+	instanceCount metrics.Gauge
+	healthy       metrics.Gauge
+	events        chan sd.Event
+
+	endpointers []*sd.DefaultEndpointer
+	operations  map[string]lb.Balancer
+	streams     map[string]lb.Balancer
+}
+
+// New returns a client of the instances, given as "host:port" or as base
+// URLs like "https://host/prefix".
+func New(instances []string, opts ...Option) (*Client, error) {
+	if len(instances) == 0 {
+		return nil, errors.New("no instances")
+	}
+	fixed := make(sd.FixedInstancer, len(instances))
+	for i, instance := range instances {
+		base, err := baseURL(instance)
+		if err != nil {
+			return nil, err
+		}
+		fixed[i] = base
+	}
+	return NewWithInstancer(fixed, opts...)
+}
+
+// NewWithInstancer returns a client of the instances of instancer, like
+// those of NewInstancer, which keep changing. Closing the client does not
+// stop instancer.
+func NewWithInstancer(instancer sd.Instancer, opts ...Option) (*Client, error) {
+	c := &Client{
+		instances:   instancer,
+		timeout:     DefaultTimeout,
+		attempts:    DefaultAttempts,
+		backoffBase: DefaultBackoffBase,
+		backoffMax:  DefaultBackoffMax,
+		budget:      newRetryBudget(DefaultRetryRatio, DefaultRetryReserve),
+		calls:       discard.NewCounter(),
+		http:        http.DefaultClient,
+		logger:      log.NewNopLogger(),
+		operations:  make(map[string]lb.Balancer),
+		streams:     make(map[string]lb.Balancer),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.attempts < 1 {
+		c.attempts = 1
+	}
+
+	for _, op := range operations {
+		c.operations[op] = c.balancer(c.operationFactory(op))
+	}
+	for _, op := range []string{"stream/uppercase", "stream/count", "v2/stream/count"} {
+		c.streams[op] = c.balancer(c.streamFactory(op))
+	}
+	if c.instanceCount != nil {
+		c.events = make(chan sd.Event)
+		go c.watch()
+		c.instances.Register(c.events)
+	}
+	return c, nil
+}
+
+// Close releases the endpoints of the client.
+func (c *Client) Close() error {
+	for _, e := range c.endpointers {
+		e.Close()
+	}
+	if c.events != nil {
+		c.instances.Deregister(c.events)
+		close(c.events)
+	}
+	return nil
+}
+
+// watch reports the instances of every event of the instancer in the
+// metrics of InstanceMetrics.
+func (c *Client) watch() {
+	for event := range c.events {
+		c.instanceCount.Set(float64(len(event.Instances)))
+		if event.Err != nil {
+			c.healthy.Set(0)
+		} else {
+			c.healthy.Set(1)
+		}
+	}
+}
+
+// baseURL returns the URL of instance, which defaults to HTTP.
+func baseURL(instance string) (string, error) {
+	if !strings.Contains(instance, "://") {
+		instance = "http://" + instance
+	}
+	if _, err := url.Parse(instance); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(instance, "/"), nil
+}
+
+func (c *Client) balancer(factory sd.Factory) lb.Balancer {
+	endpointer := sd.NewEndpointer(c.instances, factory, c.logger)
+	c.endpointers = append(c.endpointers, endpointer)
+	return lb.NewRoundRobin(endpointer)
+}
+
+// retryable returns the callback of lb.Retry for a call ending at deadline,
+// which retries the transport errors and the temporary failures of the
+// service, up to the configured number of attempts and within the budget. It waits for the
+// backoff before every retry, and sets retried once it does.
+func (c *Client) retryable(ctx context.Context, deadline time.Time, retried *bool) lb.Callback {
+	return func(n int, err error) (bool, error) {
+		var svcErr Error
+		if errors.As(err, &svcErr) && !svcErr.temporary() {
+			return false, svcErr
+		}
+		if n >= c.attempts {
+			return false, nil
+		}
+		delay := backoff(n, c.backoffBase, c.backoffMax)
+		if time.Now().Add(delay).After(deadline) || !c.budget.withdraw() {
+			return false, nil
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+		*retried = true
+		return true, nil
+	}
+}
+
+// operationFactory makes the endpoints of op, which POST their request as
+// JSON and return the raw JSON response.
+func (c *Client) operationFactory(op string) sd.Factory {
+	return func(instance string) (endpoint.Endpoint, io.Closer, error) {
+		base, err := baseURL(instance)
+		if err != nil {
+			return nil, nil, err
+		}
+		u, err := url.Parse(base + "/" + op)
+		if err != nil {
+			return nil, nil, err
+		}
+		e := httptransport.NewClient(
+			http.MethodPost,
+			u,
+			httptransport.EncodeJSONRequest,
+			makeResponseDecoder(op),
+			httptransport.SetClient(c.http),
+		).Endpoint()
+		if c.attemptTimeout > 0 {
+			e = withTimeout(e, c.attemptTimeout)
+		}
+		return e, nil, nil
+	}
+}
+
+// makeResponseDecoder returns the body of successful responses, and an
+// Error for the others, with the "err" field or "error" object of their
+// body if any.
+func makeResponseDecoder(op string) httptransport.DecodeResponseFunc {
+	return func(_ context.Context, r *http.Response) (interface{}, error) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Err  string `json:"err"`
+			Code string `json:"code"`
+			// Error is the failure of v2 routes.
+			Error *struct {
+				Message string `json:"message"`
+				Code    string `json:"code"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal(body, &result); err != nil {
+			if r.StatusCode != http.StatusOK {
+				return nil, Error{op, r.StatusCode, r.Status, ""}
+			}
+			return nil, fmt.Errorf("%s: %v", op, err)
+		}
+		if result.Error != nil {
+			result.Err, result.Code = result.Error.Message, result.Error.Code
+		}
+		if result.Err != "" {
+			return nil, Error{op, r.StatusCode, result.Err, result.Code}
+		}
+		if r.StatusCode != http.StatusOK {
+			return nil, Error{op, r.StatusCode, r.Status, ""}
+		}
+		return json.RawMessage(body), nil
+	}
+}
+
+// withTimeout bounds the time of every call of next to d.
+func withTimeout(next endpoint.Endpoint, d time.Duration) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		ctx, cancel := context.WithTimeout(ctx, d)
+		defer cancel()
+		return next(ctx, request)
+	}
+}
+
+// call invokes op with req and decodes its response into resp.
+func (c *Client) call(ctx context.Context, op string, req, resp interface{}) error {
+	c.budget.deposit()
+	var retried bool
+	retry := lb.RetryWithCallback(c.timeout, c.operations[op], c.retryable(ctx, time.Now().Add(c.timeout), &retried))
+	raw, err := retry(ctx, req)
+	var retryErr lb.RetryError
+	if errors.As(err, &retryErr) {
+		err = retryErr.Final
+	}
+	switch {
+	case err != nil:
+		c.calls.With("op", op, "outcome", "failure").Add(1)
+		return err
+	case retried:
+		c.calls.With("op", op, "outcome", "retried_success").Add(1)
+	default:
+		c.calls.With("op", op, "outcome", "success").Add(1)
+	}
+	return json.Unmarshal(raw.(json.RawMessage), resp)
+}
+
+//
+// ─── STREAMS ────────────────────────────────────────────────────────────────────
+//
+
+// streamRequest is the request of the streaming endpoints. Its body cannot
+// be read twice, so streams are load balanced but never retried.
+type streamRequest struct {
+	body io.Reader
+	// line is called with the "v" of every line of the response.
+	line func(v json.RawMessage) error
+}
+
+// streamFactory makes the endpoints of a streaming route, which send the
+// body of their request chunked and read the response line by line.
+func (c *Client) streamFactory(route string) sd.Factory {
+	return func(instance string) (endpoint.Endpoint, io.Closer, error) {
+		base, err := baseURL(instance)
+		if err != nil {
+			return nil, nil, err
+		}
+		target := base + "/" + route
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			req := request.(streamRequest)
+			httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, target, io.NopCloser(req.body))
+			if err != nil {
+				return nil, err
+			}
+			resp, err := c.http.Do(httpReq)
+			if err != nil {
+				return nil, err
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return nil, fmt.Errorf("%s: %s", route, resp.Status)
+			}
+
+			dec := json.NewDecoder(resp.Body)
+			for {
+				var line struct {
+					V    json.RawMessage `json:"v"`
+					Err  string          `json:"err"`
+					Code string          `json:"code"`
+				}
+				if err := dec.Decode(&line); err == io.EOF {
+					return nil, nil
+				} else if err != nil {
+					return nil, err
+				}
+				if line.Err != "" {
+					return nil, Error{route, resp.StatusCode, line.Err, line.Code}
+				}
+				if err := req.line(line.V); err != nil {
+					return nil, err
+				}
+			}
+		}, nil, nil
+	}
+}
+
+func (c *Client) stream(ctx context.Context, route string, body io.Reader, line func(json.RawMessage) error) error {
+	e, err := c.streams[route].Endpoint()
+	if err != nil {
+		return err
+	}
+	_, err = e(ctx, streamRequest{body, line})
+	return err
+}
@@ -0,0 +1,66 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		body      string
+		wantCalls int32
+		wantCode  string
+	}{
+		{name: "success", status: 200, body: `{"v":"A"}`, wantCalls: 1},
+		{name: "validation", status: 400, body: `{"err":"Empty input","code":"ERR_EMPTY_INPUT"}`, wantCalls: 1, wantCode: "ERR_EMPTY_INPUT"},
+		{name: "error in a 200", status: 200, body: `{"err":"Empty input","code":"ERR_EMPTY_INPUT"}`, wantCalls: 1, wantCode: "ERR_EMPTY_INPUT"},
+		{name: "rate limited", status: 429, body: `{"err":"Rate limit exceeded","code":"ERR_RATE_LIMITED"}`, wantCalls: 1, wantCode: "ERR_RATE_LIMITED"},
+		{name: "overloaded", status: 503, body: `{"err":"Overloaded","code":"ERR_OVERLOADED"}`, wantCalls: 3, wantCode: "ERR_OVERLOADED"},
+		{name: "injected fault", status: 503, body: `{"err":"Injected fault","code":"ERR_FAULT_INJECTED"}`, wantCalls: 3, wantCode: "ERR_FAULT_INJECTED"},
+		{name: "timeout", status: 504, body: `{"err":"Timed out","code":"ERR_TIMEOUT"}`, wantCalls: 3, wantCode: "ERR_TIMEOUT"},
+		{name: "permanent server failure", status: 500, body: `{"err":"No encryption key","code":"ERR_NOT_CONFIGURED"}`, wantCalls: 1, wantCode: "ERR_NOT_CONFIGURED"},
+		{name: "proxy failure", status: 502, body: `<html>Bad Gateway</html>`, wantCalls: 3},
+		{name: "proxy rejection", status: 403, body: `<html>Forbidden</html>`, wantCalls: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&calls, 1)
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+			c, err := New([]string{srv.URL}, Attempts(3), Backoff(time.Millisecond, time.Millisecond))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+
+			_, err = c.Uppercase(context.Background(), "a")
+			if got := atomic.LoadInt32(&calls); got != tt.wantCalls {
+				t.Errorf("%d calls, want %d", got, tt.wantCalls)
+			}
+			if tt.status == 200 && tt.wantCode == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			var svcErr Error
+			if !errors.As(err, &svcErr) {
+				t.Fatalf("error = %v, want an Error", err)
+			}
+			if svcErr.Code != tt.wantCode || svcErr.StatusCode != tt.status {
+				t.Errorf("error = %+v, want code %q and status %d", svcErr, tt.wantCode, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/sd"
+	"github.com/go-kit/kit/sd/consul"
+	"github.com/go-kit/kit/sd/dnssrv"
+	"github.com/go-kit/kit/sd/etcdv3"
+	"github.com/go-kit/kit/sd/eureka"
+	"github.com/go-kit/kit/sd/zk"
+	stdconsul "github.com/hashicorp/consul/api"
+	"github.com/hudl/fargo"
+)
+
+//
+// ────────────────────────────────────────────────────────── I ──────────
+//   :::::: D I S C O V E R Y : :  :   :    :     :        :          :
+// ────────────────────────────────────────────────────────────────────
+//
+
+// DefaultDNSRefresh is how often SRV records are looked up again.
+const DefaultDNSRefresh = 30 * time.Second
+
+// Discovery tells where to find the instances of the service, for
+// NewInstancer. It can be read from JSON.
+type Discovery struct {
+	// Backend is "consul", "etcd", "zookeeper", "eureka", "dnssrv", or one
+	// added with RegisterDiscovery.
+	Backend string `json:"backend"`
+	// Addrs are the addresses of the servers of the backend. Consul uses
+	// the first one, or its local agent when there is none.
+	Addrs []string `json:"addrs"`
+	// Service is the name of the service in Consul and Eureka, the prefix
+	// of its keys in etcd, the path of its nodes in ZooKeeper, and the name
+	// of its SRV records in DNS, like the
+	// "_http._tcp.stringsvc.default.svc.cluster.local" of a headless
+	// Kubernetes service with a port named "http".
+	Service string `json:"service"`
+	// Tags only keeps the instances of Consul that have all of them.
+	Tags []string `json:"tags"`
+	// PassingOnly only keeps the instances of Consul passing their health
+	// checks.
+	PassingOnly bool `json:"passing_only"`
+	// RefreshSeconds is how often SRV records are looked up again, every
+	// DefaultDNSRefresh by default.
+	RefreshSeconds int `json:"refresh_seconds"`
+}
+
+// discoveryBackends make the instancers of the backends of Discovery.
+var discoveryBackends = map[string]func(Discovery, log.Logger) (sd.Instancer, error){
+	"consul":    newConsulInstancer,
+	"etcd":      newEtcdInstancer,
+	"zookeeper": newZooKeeperInstancer,
+	"eureka":    newEurekaInstancer,
+	"dnssrv":    newDNSSRVInstancer,
+}
+
+// RegisterDiscovery makes the instancers made by newInstancer available as
+// the backend name.
+func RegisterDiscovery(name string, newInstancer func(Discovery, log.Logger) (sd.Instancer, error)) {
+	discoveryBackends[name] = newInstancer
+}
+
+// NewInstancer returns an instancer of the instances found as d says, to
+// give to NewWithInstancer. Stopping it closes its connection to the
+// backend.
+func NewInstancer(d Discovery, logger log.Logger) (sd.Instancer, error) {
+	newInstancer, ok := discoveryBackends[d.Backend]
+	if !ok {
+		return nil, fmt.Errorf("unknown discovery backend %q", d.Backend)
+	}
+	if d.Service == "" {
+		return nil, errors.New("no service to discover")
+	}
+	return newInstancer(d, log.With(logger, "discovery", d.Backend))
+}
+
+// stoppingInstancer also stops the client of its backend when stopped.
+type stoppingInstancer struct {
+	sd.Instancer
+	stopClient func()
+}
+
+func (i stoppingInstancer) Stop() {
+	i.Instancer.Stop()
+	i.stopClient()
+}
+
+func newConsulInstancer(d Discovery, logger log.Logger) (sd.Instancer, error) {
+	cfg := stdconsul.DefaultConfig()
+	if len(d.Addrs) > 0 {
+		cfg.Address = d.Addrs[0]
+	}
+	c, err := stdconsul.NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return consul.NewInstancer(consul.NewClient(c), logger, d.Service, d.Tags, d.PassingOnly), nil
+}
+
+func newEtcdInstancer(d Discovery, logger log.Logger) (sd.Instancer, error) {
+	c, err := etcdv3.NewClient(context.Background(), d.Addrs, etcdv3.ClientOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return etcdv3.NewInstancer(c, d.Service, logger)
+}
+
+func newZooKeeperInstancer(d Discovery, logger log.Logger) (sd.Instancer, error) {
+	c, err := zk.NewClient(d.Addrs, logger)
+	if err != nil {
+		return nil, err
+	}
+	instancer, err := zk.NewInstancer(c, d.Service, logger)
+	if err != nil {
+		c.Stop()
+		return nil, err
+	}
+	return stoppingInstancer{instancer, c.Stop}, nil
+}
+
+func newEurekaInstancer(d Discovery, logger log.Logger) (sd.Instancer, error) {
+	if len(d.Addrs) == 0 {
+		return nil, errors.New("no Eureka server")
+	}
+	conn := fargo.NewConn(d.Addrs...)
+	return eureka.NewInstancer(&conn, d.Service, logger), nil
+}
+
+// newDNSSRVInstancer finds instances in the SRV records of DNS, using the
+// system resolver, so that no registry needs to run next to the service.
+func newDNSSRVInstancer(d Discovery, logger log.Logger) (sd.Instancer, error) {
+	refresh := DefaultDNSRefresh
+	if d.RefreshSeconds > 0 {
+		refresh = time.Duration(d.RefreshSeconds) * time.Second
+	}
+	return dnssrv.NewInstancer(d.Service, refresh, logger), nil
+}
@@ -0,0 +1,64 @@
+package client
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+//
+// ────────────────────────────────────────────────────── I ──────────
+//   :::::: R E T R I E S : :  :   :    :     :        :          :
+// ────────────────────────────────────────────────────────────────
+//
+
+// backoff returns the delay before retrying after the nth attempt: a random
+// delay up to base·2ⁿ⁻¹, bounded by max. The randomness keeps the clients
+// that failed together from retrying together.
+func backoff(n int, base, max time.Duration) time.Duration {
+	ceiling := max
+	if n-1 < 32 {
+		if d := base << uint(n-1); d > 0 && d < max {
+			ceiling = d
+		}
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// retryBudget allows retries for a ratio of the calls. Every call deposits
+// ratio in the budget and every retry withdraws 1, so that when an instance
+// fails every call, the client sends ratio more requests instead of as many
+// times more as it has attempts. The budget holds at most reserve, and
+// starts full.
+type retryBudget struct {
+	mu      sync.Mutex
+	ratio   float64
+	reserve float64
+	balance float64
+}
+
+func newRetryBudget(ratio float64, reserve int) *retryBudget {
+	return &retryBudget{ratio: ratio, reserve: float64(reserve), balance: float64(reserve)}
+}
+
+func (b *retryBudget) deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.balance += b.ratio; b.balance > b.reserve {
+		b.balance = b.reserve
+	}
+}
+
+// withdraw takes a retry from the budget, if there is one left.
+func (b *retryBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.balance < 1 {
+		return false
+	}
+	b.balance--
+	return true
+}
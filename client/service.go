@@ -0,0 +1,544 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+)
+
+//
+// ────────────────────────────────────────────────────── I ──────────
+//   :::::: S E R V I C E : :  :   :    :     :        :          :
+// ────────────────────────────────────────────────────────────────
+//
+
+// Service has the methods of the IStringService interface of the server,
+// with exported result types.
+type Service interface {
+	Uppercase(context.Context, string) (string, error)
+	Count(context.Context, string) (int, error)
+	CountRunes(context.Context, string) (int, error)
+	Hash(ctx context.Context, s, algorithm, encoding string) (string, error)
+	Encode(ctx context.Context, s, encoding string) (string, error)
+	Decode(ctx context.Context, s, encoding, padding string) (string, error)
+	URLEncode(ctx context.Context, s, mode string) (string, error)
+	URLDecode(ctx context.Context, s, mode string) (string, error)
+	HTML(ctx context.Context, s, op, policy string) (string, error)
+	Slugify(ctx context.Context, s, separator string, maxLength int, preserveUnicode bool) (string, error)
+	ConvertCase(ctx context.Context, s, target string) (output, detected string, err error)
+	Similarity(ctx context.Context, a, b, algorithm string) (distance, score float64, err error)
+	Palindrome(ctx context.Context, s string, normalize, ignorePunctuation bool) (ok bool, normalized string, err error)
+	Anagram(ctx context.Context, a, b string, normalize, ignorePunctuation bool) (ok bool, normalized []string, err error)
+	Stats(ctx context.Context, s string, wordsPerMinute int) (TextStats, error)
+	DetectLanguage(ctx context.Context, s string, maxResults int) ([]LanguageConfidence, error)
+	Transliterate(ctx context.Context, s, scheme string) (string, error)
+	Random(ctx context.Context, length int, classes []string, count int) ([]string, error)
+	ID(ctx context.Context, kind string, count int) ([]string, error)
+	Cipher(ctx context.Context, s, scheme, key string, decrypt bool) (string, error)
+	Encrypt(ctx context.Context, s string) (ciphertext, keyID string, err error)
+	Decrypt(ctx context.Context, s string) (plaintext, keyID string, err error)
+	Compress(ctx context.Context, s, algorithm string, level int) (string, error)
+	Decompress(ctx context.Context, s, algorithm string) (string, error)
+	Render(ctx context.Context, s string, data map[string]interface{}) (string, error)
+	Diff(ctx context.Context, a, b, granularity string, contextLines int) (DiffResult, error)
+	Inflect(ctx context.Context, s, op string) (string, error)
+	Truncate(ctx context.Context, s string, maxLength int, ellipsis string, wordBoundary bool) (string, error)
+	Pad(ctx context.Context, s string, width int, align, fill string) (string, error)
+	Redact(ctx context.Context, s string, types []string, mask string) (string, []RedactionFinding, error)
+	Moderate(ctx context.Context, s string, languages []string, mask string) (ModerationResult, error)
+	WordFreq(ctx context.Context, s string, limit, minCount int, stopWords, exclude []string, caseSensitive bool) ([]WordCount, error)
+	Tokenize(ctx context.Context, s, unit string, n int, lowercase, stripPunctuation bool) ([]string, error)
+	Stem(ctx context.Context, s, language, algorithm string) (string, error)
+	NormalizeUnicode(ctx context.Context, s, form string) (output string, normalized bool, err error)
+	Recode(ctx context.Context, s, charset string) (output, detected string, confidence float64, err error)
+	NumWords(ctx context.Context, n, locale string) (words, formatted string, err error)
+	Sign(ctx context.Context, s, keyID, algorithm, encoding string) (signature, signingKeyID string, err error)
+	Verify(ctx context.Context, s, signature, keyID, algorithm, encoding string) (ok bool, signingKeyID string, err error)
+	JSON(ctx context.Context, s, op, indent string) (string, error)
+	CSVParse(ctx context.Context, s string, dialect CSVDialect) ([][]string, error)
+	CSVFormat(ctx context.Context, rows [][]string, dialect CSVDialect) (string, error)
+	Expand(ctx context.Context, s string, vars map[string]string, missing string, maxDepth int) (output string, unset []string, err error)
+	Emoji(ctx context.Context, s, op string) (output string, count int, err error)
+	Wrap(ctx context.Context, s string, width int, align string, breakLongWords bool, indent, hangingIndent string) (string, error)
+	Phonetic(ctx context.Context, s, algorithm string) (code, alternate string, err error)
+	UppercaseStream(ctx context.Context, r io.Reader, w io.Writer) (n int64, err error)
+	CountStream(ctx context.Context, r io.Reader) (int64, error)
+	CountRunesStream(ctx context.Context, r io.Reader) (int64, error)
+}
+
+var _ Service = (*Client)(nil)
+
+// operations are the routes called through retrying endpoints.
+var operations = []string{
+	"uppercase", "count", "v2/count", "hash", "encode", "decode", "urlencode", "urldecode",
+	"html", "slugify", "convertcase", "similarity", "analyze/palindrome", "analyze/anagram",
+	"stats", "detect-language", "transliterate", "random", "id", "cipher",
+	"encrypt", "decrypt", "compress", "decompress", "render", "diff",
+	"inflect", "truncate", "pad", "redact", "moderate", "wordfreq", "tokenize", "stem",
+	"normalize-unicode", "recode", "numwords", "sign", "verify", "json", "csv/parse",
+	"csv/format", "expand", "emoji", "wrap", "phonetic",
+}
+
+type TextStats struct {
+	Bytes       int `json:"bytes"`
+	Runes       int `json:"runes"`
+	Characters  int `json:"characters"`
+	Words       int `json:"words"`
+	Sentences   int `json:"sentences"`
+	Lines       int `json:"lines"`
+	Paragraphs  int `json:"paragraphs"`
+	ReadingTime int `json:"reading_time_seconds"`
+}
+
+type LanguageConfidence struct {
+	Code       string  `json:"code"`
+	Language   string  `json:"language"`
+	Confidence float64 `json:"confidence"`
+}
+
+type DiffSpan struct {
+	// Op is one of "equal", "insert" or "delete".
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+type DiffResult struct {
+	Unified string     `json:"unified"`
+	Spans   []DiffSpan `json:"spans"`
+}
+
+type RedactionFinding struct {
+	Type string `json:"type"`
+	// Start and End are byte offsets in the original string.
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+type ModerationResult struct {
+	Censored string   `json:"censored"`
+	Severity int      `json:"severity"`
+	Matches  []string `json:"matches"`
+}
+
+type WordCount struct {
+	Word  string `json:"word"`
+	Count int    `json:"count"`
+}
+
+// CSVDialect describes a CSV variant. The zero value is RFC 4180, except
+// that records are written ending with LF rather than CRLF.
+type CSVDialect struct {
+	// Delimiter is "," and Quote is `"` by default. Quote "none" disables
+	// quoting.
+	Delimiter, Quote string
+	// Comment starts lines skipped when parsing; there is none by default.
+	Comment string
+	// FieldsPerRecord is the number of fields of every record: when 0, as
+	// many as the first record, and any when negative.
+	FieldsPerRecord  int
+	LazyQuotes       bool
+	TrimLeadingSpace bool
+	// CRLF ends written records with CRLF.
+	CRLF bool
+}
+
+// options adds the fields of d to r.
+func (d CSVDialect) options(r request) request {
+	r["delimiter"], r["quote"], r["comment"] = d.Delimiter, d.Quote, d.Comment
+	r["fields_per_record"] = d.FieldsPerRecord
+	r["lazy_quotes"], r["trim_leading_space"], r["crlf"] = d.LazyQuotes, d.TrimLeadingSpace, d.CRLF
+	return r
+}
+
+// The responses of most operations only hold a "v" of one of these types.
+type (
+	stringResponse struct {
+		V string `json:"v"`
+	}
+	stringsResponse struct {
+		V []string `json:"v"`
+	}
+)
+
+// request is the JSON object of a request.
+type request map[string]interface{}
+
+func (c *Client) Uppercase(ctx context.Context, s string) (string, error) {
+	var resp stringResponse
+	err := c.call(ctx, "uppercase", request{"s": s}, &resp)
+	return resp.V, err
+}
+
+func (c *Client) Count(ctx context.Context, s string) (int, error) {
+	var resp struct {
+		V int `json:"v"`
+	}
+	err := c.call(ctx, "count", request{"s": s}, &resp)
+	return resp.V, err
+}
+
+func (c *Client) CountRunes(ctx context.Context, s string) (int, error) {
+	var resp struct {
+		V int `json:"v"`
+	}
+	err := c.call(ctx, "v2/count", request{"s": s}, &resp)
+	return resp.V, err
+}
+
+func (c *Client) Hash(ctx context.Context, s, algorithm, encoding string) (string, error) {
+	var resp stringResponse
+	err := c.call(ctx, "hash", request{"s": s, "algorithm": algorithm, "encoding": encoding}, &resp)
+	return resp.V, err
+}
+
+func (c *Client) Encode(ctx context.Context, s, encoding string) (string, error) {
+	var resp stringResponse
+	err := c.call(ctx, "encode", request{"s": s, "encoding": encoding}, &resp)
+	return resp.V, err
+}
+
+func (c *Client) Decode(ctx context.Context, s, encoding, padding string) (string, error) {
+	var resp stringResponse
+	err := c.call(ctx, "decode", request{"s": s, "encoding": encoding, "padding": padding}, &resp)
+	return resp.V, err
+}
+
+func (c *Client) URLEncode(ctx context.Context, s, mode string) (string, error) {
+	var resp stringResponse
+	err := c.call(ctx, "urlencode", request{"s": s, "mode": mode}, &resp)
+	return resp.V, err
+}
+
+func (c *Client) URLDecode(ctx context.Context, s, mode string) (string, error) {
+	var resp stringResponse
+	err := c.call(ctx, "urldecode", request{"s": s, "mode": mode}, &resp)
+	return resp.V, err
+}
+
+func (c *Client) HTML(ctx context.Context, s, op, policy string) (string, error) {
+	var resp stringResponse
+	err := c.call(ctx, "html", request{"s": s, "op": op, "policy": policy}, &resp)
+	return resp.V, err
+}
+
+func (c *Client) Slugify(ctx context.Context, s, separator string, maxLength int, preserveUnicode bool) (string, error) {
+	var resp stringResponse
+	err := c.call(ctx, "slugify", request{
+		"s":                s,
+		"separator":        separator,
+		"max_length":       maxLength,
+		"preserve_unicode": preserveUnicode,
+	}, &resp)
+	return resp.V, err
+}
+
+func (c *Client) ConvertCase(ctx context.Context, s, target string) (output, detected string, err error) {
+	var resp struct {
+		V        string `json:"v"`
+		Detected string `json:"detected"`
+	}
+	err = c.call(ctx, "convertcase", request{"s": s, "target": target}, &resp)
+	return resp.V, resp.Detected, err
+}
+
+func (c *Client) Similarity(ctx context.Context, a, b, algorithm string) (distance, score float64, err error) {
+	var resp struct {
+		Distance float64 `json:"distance"`
+		Score    float64 `json:"score"`
+	}
+	err = c.call(ctx, "similarity", request{"a": a, "b": b, "algorithm": algorithm}, &resp)
+	return resp.Distance, resp.Score, err
+}
+
+func (c *Client) Palindrome(ctx context.Context, s string, normalize, ignorePunctuation bool) (ok bool, normalized string, err error) {
+	var resp struct {
+		V          bool   `json:"v"`
+		Normalized string `json:"normalized"`
+	}
+	err = c.call(ctx, "analyze/palindrome", request{"s": s, "normalize": normalize, "ignore_punctuation": ignorePunctuation}, &resp)
+	return resp.V, resp.Normalized, err
+}
+
+func (c *Client) Anagram(ctx context.Context, a, b string, normalize, ignorePunctuation bool) (ok bool, normalized []string, err error) {
+	var resp struct {
+		V          bool     `json:"v"`
+		Normalized []string `json:"normalized"`
+	}
+	err = c.call(ctx, "analyze/anagram", request{"a": a, "b": b, "normalize": normalize, "ignore_punctuation": ignorePunctuation}, &resp)
+	return resp.V, resp.Normalized, err
+}
+
+func (c *Client) Stats(ctx context.Context, s string, wordsPerMinute int) (TextStats, error) {
+	var resp struct {
+		V TextStats `json:"v"`
+	}
+	err := c.call(ctx, "stats", request{"s": s, "words_per_minute": wordsPerMinute}, &resp)
+	return resp.V, err
+}
+
+func (c *Client) DetectLanguage(ctx context.Context, s string, maxResults int) ([]LanguageConfidence, error) {
+	var resp struct {
+		V []LanguageConfidence `json:"v"`
+	}
+	err := c.call(ctx, "detect-language", request{"s": s, "max_results": maxResults}, &resp)
+	return resp.V, err
+}
+
+func (c *Client) Transliterate(ctx context.Context, s, scheme string) (string, error) {
+	var resp stringResponse
+	err := c.call(ctx, "transliterate", request{"s": s, "scheme": scheme}, &resp)
+	return resp.V, err
+}
+
+func (c *Client) Random(ctx context.Context, length int, classes []string, count int) ([]string, error) {
+	var resp stringsResponse
+	err := c.call(ctx, "random", request{"length": length, "classes": classes, "count": count}, &resp)
+	return resp.V, err
+}
+
+func (c *Client) ID(ctx context.Context, kind string, count int) ([]string, error) {
+	var resp stringsResponse
+	err := c.call(ctx, "id", request{"kind": kind, "count": count}, &resp)
+	return resp.V, err
+}
+
+func (c *Client) Cipher(ctx context.Context, s, scheme, key string, decrypt bool) (string, error) {
+	var resp stringResponse
+	err := c.call(ctx, "cipher", request{"s": s, "scheme": scheme, "key": key, "decrypt": decrypt}, &resp)
+	return resp.V, err
+}
+
+func (c *Client) Encrypt(ctx context.Context, s string) (ciphertext, keyID string, err error) {
+	var resp struct {
+		V     string `json:"v"`
+		KeyID string `json:"key_id"`
+	}
+	err = c.call(ctx, "encrypt", request{"s": s}, &resp)
+	return resp.V, resp.KeyID, err
+}
+
+func (c *Client) Decrypt(ctx context.Context, s string) (plaintext, keyID string, err error) {
+	var resp struct {
+		V     string `json:"v"`
+		KeyID string `json:"key_id"`
+	}
+	err = c.call(ctx, "decrypt", request{"s": s}, &resp)
+	return resp.V, resp.KeyID, err
+}
+
+func (c *Client) Compress(ctx context.Context, s, algorithm string, level int) (string, error) {
+	var resp stringResponse
+	err := c.call(ctx, "compress", request{"s": s, "algorithm": algorithm, "level": level}, &resp)
+	return resp.V, err
+}
+
+func (c *Client) Decompress(ctx context.Context, s, algorithm string) (string, error) {
+	var resp stringResponse
+	err := c.call(ctx, "decompress", request{"s": s, "algorithm": algorithm}, &resp)
+	return resp.V, err
+}
+
+func (c *Client) Render(ctx context.Context, s string, data map[string]interface{}) (string, error) {
+	var resp stringResponse
+	err := c.call(ctx, "render", request{"s": s, "data": data}, &resp)
+	return resp.V, err
+}
+
+func (c *Client) Diff(ctx context.Context, a, b, granularity string, contextLines int) (DiffResult, error) {
+	var resp struct {
+		V DiffResult `json:"v"`
+	}
+	err := c.call(ctx, "diff", request{"a": a, "b": b, "granularity": granularity, "context": contextLines}, &resp)
+	return resp.V, err
+}
+
+func (c *Client) Inflect(ctx context.Context, s, op string) (string, error) {
+	var resp stringResponse
+	err := c.call(ctx, "inflect", request{"s": s, "op": op}, &resp)
+	return resp.V, err
+}
+
+func (c *Client) Truncate(ctx context.Context, s string, maxLength int, ellipsis string, wordBoundary bool) (string, error) {
+	var resp stringResponse
+	err := c.call(ctx, "truncate", request{
+		"s":             s,
+		"max_length":    maxLength,
+		"ellipsis":      ellipsis,
+		"word_boundary": wordBoundary,
+	}, &resp)
+	return resp.V, err
+}
+
+func (c *Client) Pad(ctx context.Context, s string, width int, align, fill string) (string, error) {
+	var resp stringResponse
+	err := c.call(ctx, "pad", request{"s": s, "width": width, "align": align, "fill": fill}, &resp)
+	return resp.V, err
+}
+
+func (c *Client) Redact(ctx context.Context, s string, types []string, mask string) (string, []RedactionFinding, error) {
+	var resp struct {
+		V        string             `json:"v"`
+		Findings []RedactionFinding `json:"findings"`
+	}
+	err := c.call(ctx, "redact", request{"s": s, "types": types, "mask": mask}, &resp)
+	return resp.V, resp.Findings, err
+}
+
+func (c *Client) Moderate(ctx context.Context, s string, languages []string, mask string) (ModerationResult, error) {
+	var resp struct {
+		V ModerationResult `json:"v"`
+	}
+	err := c.call(ctx, "moderate", request{"s": s, "languages": languages, "mask": mask}, &resp)
+	return resp.V, err
+}
+
+func (c *Client) WordFreq(ctx context.Context, s string, limit, minCount int, stopWords, exclude []string, caseSensitive bool) ([]WordCount, error) {
+	var resp struct {
+		V []WordCount `json:"v"`
+	}
+	err := c.call(ctx, "wordfreq", request{"s": s, "limit": limit, "min_count": minCount, "stop_words": stopWords, "exclude": exclude, "case_sensitive": caseSensitive}, &resp)
+	return resp.V, err
+}
+
+func (c *Client) Tokenize(ctx context.Context, s, unit string, n int, lowercase, stripPunctuation bool) ([]string, error) {
+	var resp stringsResponse
+	err := c.call(ctx, "tokenize", request{"s": s, "unit": unit, "n": n, "lowercase": lowercase, "strip_punctuation": stripPunctuation}, &resp)
+	return resp.V, err
+}
+
+func (c *Client) Stem(ctx context.Context, s, language, algorithm string) (string, error) {
+	var resp stringResponse
+	err := c.call(ctx, "stem", request{"s": s, "language": language, "algorithm": algorithm}, &resp)
+	return resp.V, err
+}
+
+func (c *Client) NormalizeUnicode(ctx context.Context, s, form string) (output string, normalized bool, err error) {
+	var resp struct {
+		V          string `json:"v"`
+		Normalized bool   `json:"normalized"`
+	}
+	err = c.call(ctx, "normalize-unicode", request{"s": s, "form": form}, &resp)
+	return resp.V, resp.Normalized, err
+}
+
+func (c *Client) Recode(ctx context.Context, s, charset string) (output, detected string, confidence float64, err error) {
+	var resp struct {
+		V          string  `json:"v"`
+		Charset    string  `json:"charset"`
+		Confidence float64 `json:"confidence"`
+	}
+	err = c.call(ctx, "recode", request{"s": s, "charset": charset}, &resp)
+	return resp.V, resp.Charset, resp.Confidence, err
+}
+
+func (c *Client) NumWords(ctx context.Context, n, locale string) (words, formatted string, err error) {
+	var resp struct {
+		V         string `json:"v"`
+		Formatted string `json:"formatted"`
+	}
+	err = c.call(ctx, "numwords", request{"n": n, "locale": locale}, &resp)
+	return resp.V, resp.Formatted, err
+}
+
+func (c *Client) Sign(ctx context.Context, s, keyID, algorithm, encoding string) (signature, signingKeyID string, err error) {
+	var resp struct {
+		V     string `json:"v"`
+		KeyID string `json:"key_id"`
+	}
+	err = c.call(ctx, "sign", request{"s": s, "key_id": keyID, "algorithm": algorithm, "encoding": encoding}, &resp)
+	return resp.V, resp.KeyID, err
+}
+
+func (c *Client) Verify(ctx context.Context, s, signature, keyID, algorithm, encoding string) (ok bool, signingKeyID string, err error) {
+	var resp struct {
+		V     bool   `json:"v"`
+		KeyID string `json:"key_id"`
+	}
+	err = c.call(ctx, "verify", request{"s": s, "signature": signature, "key_id": keyID, "algorithm": algorithm, "encoding": encoding}, &resp)
+	return resp.V, resp.KeyID, err
+}
+
+func (c *Client) JSON(ctx context.Context, s, op, indent string) (string, error) {
+	var resp stringResponse
+	err := c.call(ctx, "json", request{"s": s, "op": op, "indent": indent}, &resp)
+	return resp.V, err
+}
+
+func (c *Client) CSVParse(ctx context.Context, s string, dialect CSVDialect) ([][]string, error) {
+	var resp struct {
+		V [][]string `json:"v"`
+	}
+	err := c.call(ctx, "csv/parse", dialect.options(request{"s": s}), &resp)
+	return resp.V, err
+}
+
+func (c *Client) CSVFormat(ctx context.Context, rows [][]string, dialect CSVDialect) (string, error) {
+	var resp stringResponse
+	err := c.call(ctx, "csv/format", dialect.options(request{"rows": rows}), &resp)
+	return resp.V, err
+}
+
+func (c *Client) Expand(ctx context.Context, s string, vars map[string]string, missing string, maxDepth int) (output string, unset []string, err error) {
+	var resp struct {
+		V     string   `json:"v"`
+		Unset []string `json:"unset"`
+	}
+	err = c.call(ctx, "expand", request{"s": s, "vars": vars, "missing": missing, "max_depth": maxDepth}, &resp)
+	return resp.V, resp.Unset, err
+}
+
+func (c *Client) Emoji(ctx context.Context, s, op string) (output string, count int, err error) {
+	var resp struct {
+		V     string `json:"v"`
+		Count int    `json:"count"`
+	}
+	err = c.call(ctx, "emoji", request{"s": s, "op": op}, &resp)
+	return resp.V, resp.Count, err
+}
+
+func (c *Client) Wrap(ctx context.Context, s string, width int, align string, breakLongWords bool, indent, hangingIndent string) (string, error) {
+	var resp stringResponse
+	err := c.call(ctx, "wrap", request{"s": s, "width": width, "align": align, "break_long_words": breakLongWords, "indent": indent, "hanging_indent": hangingIndent}, &resp)
+	return resp.V, err
+}
+
+func (c *Client) Phonetic(ctx context.Context, s, algorithm string) (code, alternate string, err error) {
+	var resp struct {
+		V         string `json:"v"`
+		Alternate string `json:"alternate"`
+	}
+	err = c.call(ctx, "phonetic", request{"s": s, "algorithm": algorithm}, &resp)
+	return resp.V, resp.Alternate, err
+}
+
+// UppercaseStream sends everything read from r to /stream/uppercase and
+// writes the output to w as it arrives.
+func (c *Client) UppercaseStream(ctx context.Context, r io.Reader, w io.Writer) (n int64, err error) {
+	err = c.stream(ctx, "stream/uppercase", r, func(v json.RawMessage) error {
+		var chunk string
+		if err := json.Unmarshal(v, &chunk); err != nil {
+			return err
+		}
+		written, err := io.WriteString(w, chunk)
+		n += int64(written)
+		return err
+	})
+	return n, err
+}
+
+// CountStream sends everything read from r to /stream/count.
+func (c *Client) CountStream(ctx context.Context, r io.Reader) (n int64, err error) {
+	err = c.stream(ctx, "stream/count", r, func(v json.RawMessage) error {
+		return json.Unmarshal(v, &n)
+	})
+	return n, err
+}
+
+// CountRunesStream sends everything read from r to /v2/stream/count, which
+// counts characters.
+func (c *Client) CountRunesStream(ctx context.Context, r io.Reader) (n int64, err error) {
+	err = c.stream(ctx, "v2/stream/count", r, func(v json.RawMessage) error {
+		return json.Unmarshal(v, &n)
+	})
+	return n, err
+}
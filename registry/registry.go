@@ -0,0 +1,141 @@
+// Package registry adds operations to stringsvc without editing it. The
+// operations registered with Register are served like the built-in ones: at
+// their HTTP route, also under /v2, as steps of pipelines and batches, over
+// every transport, in the OpenAPI document and in the metrics.
+//
+// Operations are registered by the init function of a package, which is
+// either imported by the service, or built as a Go plugin with
+// "go build -buildmode=plugin" and listed in the "plugins" section of its
+// configuration:
+//
+//	type reverseRequest struct {
+//		S string `json:"s" validate:"required"`
+//	}
+//
+//	type reverseResponse struct {
+//		V   string `json:"v"`
+//		Err string `json:"err,omitempty"`
+//	}
+//
+//	func init() {
+//		registry.Register("reverse", reverse, registry.Codec{
+//			Request:  reverseRequest{},
+//			Response: reverseResponse{},
+//		})
+//	}
+//
+// Operations, built-in or registered, can have alternate implementations,
+// registered with RegisterImplementation, between which the "routing"
+// section of the configuration splits their calls. Migrating to a new
+// algorithm then takes a growing share of the calls:
+//
+//	registry.RegisterImplementation("reverse", "graphemes", reverseGraphemes)
+package registry
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+//
+// ──────────────────────────────────────────────────────── I ──────────
+//   :::::: R E G I S T R Y : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────────────
+//
+
+// Handler runs an operation. Its request is a value of the Request type of
+// the codec of the operation, and its response should be one of the
+// Response type.
+type Handler func(ctx context.Context, request interface{}) (response interface{}, err error)
+
+// Codec describes the messages of an operation, which are JSON objects.
+type Codec struct {
+	// Request is the zero value of the requests. Their fields are checked
+	// with their validate tags before the handler is called.
+	Request interface{}
+	// Response is the zero value of the responses. Like those of the
+	// built-in operations, they should have a "v" field holding the result
+	// and an "err" one holding the failure, so that the operation can be a
+	// step of pipelines.
+	Response interface{}
+}
+
+// Operation is a registered operation.
+type Operation struct {
+	Name    string
+	Handler Handler
+	Codec   Codec
+}
+
+var (
+	mu              sync.Mutex
+	operations      = make(map[string]Operation)
+	implementations = make(map[string]map[string]Handler)
+)
+
+// Register adds the operation name, served at the route "/name". Like
+// http.Handle, it panics when name is empty or already registered.
+func Register(name string, handler Handler, codec Codec) {
+	if name == "" || handler == nil || codec.Request == nil || codec.Response == nil {
+		panic("registry: operation without name, handler or codec")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := operations[name]; ok {
+		panic(fmt.Sprintf("registry: operation %s registered twice", name))
+	}
+	operations[name] = Operation{name, handler, codec}
+}
+
+// Operations returns the registered operations, by name.
+func Operations() map[string]Operation {
+	mu.Lock()
+	defer mu.Unlock()
+	ops := make(map[string]Operation, len(operations))
+	for name, op := range operations {
+		ops[name] = op
+	}
+	return ops
+}
+
+// DefaultImplementation names the implementation of an operation given by
+// Register, or built in the service.
+const DefaultImplementation = "default"
+
+// RegisterImplementation adds the implementation impl of the operation op,
+// which takes the requests and answers the responses of its codec. Like
+// Register, it panics when impl is empty, is DefaultImplementation or is
+// already registered for op. The operation itself may be registered later.
+func RegisterImplementation(op, impl string, handler Handler) {
+	if op == "" || impl == "" || handler == nil {
+		panic("registry: implementation without operation, name or handler")
+	}
+	if impl == DefaultImplementation {
+		panic(fmt.Sprintf("registry: implementation %s of %s is reserved", impl, op))
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := implementations[op][impl]; ok {
+		panic(fmt.Sprintf("registry: implementation %s of %s registered twice", impl, op))
+	}
+	if implementations[op] == nil {
+		implementations[op] = make(map[string]Handler)
+	}
+	implementations[op][impl] = handler
+}
+
+// Implementations returns the alternate implementations registered, by
+// operation and name.
+func Implementations() map[string]map[string]Handler {
+	mu.Lock()
+	defer mu.Unlock()
+	impls := make(map[string]map[string]Handler, len(implementations))
+	for op, handlers := range implementations {
+		impls[op] = make(map[string]Handler, len(handlers))
+		for name, h := range handlers {
+			impls[op][name] = h
+		}
+	}
+	return impls
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/go-kit/kit/metrics"
+)
+
+//
+// ──────────────────────────────────────────────────────────────────── I ──────────
+//   :::::: A C C E S S   C O N T R O L : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────────────────────────
+//
+
+// ErrAccessDenied is returned to the clients rejected by the "access"
+// config section, with a 403.
+var ErrAccessDenied = errors.New("Access denied")
+
+// accessRules decide which client addresses may call the API.
+type accessRules struct {
+	allow          []netip.Prefix
+	deny           []netip.Prefix
+	trustedProxies []netip.Prefix
+}
+
+func newAccessRules(cfg accessConfig) (*accessRules, error) {
+	var (
+		rules accessRules
+		err   error
+	)
+	if rules.allow, err = parsePrefixes(cfg.Allow); err != nil {
+		return nil, fmt.Errorf("access.allow: %v", err)
+	}
+	if rules.deny, err = parsePrefixes(cfg.Deny); err != nil {
+		return nil, fmt.Errorf("access.deny: %v", err)
+	}
+	if rules.trustedProxies, err = parsePrefixes(cfg.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("access.trusted_proxies: %v", err)
+	}
+	return &rules, nil
+}
+
+// parsePrefixes parses CIDR ranges, and single addresses as ranges of one
+// address.
+func parsePrefixes(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, v := range values {
+		if !strings.Contains(v, "/") {
+			addr, err := netip.ParseAddr(v)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(v)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
+
+func prefixesContain(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// check returns the reason addr is rejected for, or "" when it is allowed.
+func (a *accessRules) check(addr netip.Addr) string {
+	switch {
+	case prefixesContain(a.deny, addr):
+		return "denied"
+	case len(a.allow) > 0 && !prefixesContain(a.allow, addr):
+		return "not_allowed"
+	}
+	return ""
+}
+
+// clientAddr returns the address of the client of r. Behind trusted
+// proxies, it is the last address of X-Forwarded-For that is not one of
+// them, since earlier ones can be forged by the client. The clients of the
+// Unix socket, which have no address, are local proxies: the permissions of
+// the socket decide who they are, and they are trusted with the address
+// 127.0.0.1.
+func (a *accessRules) clientAddr(r *http.Request) (netip.Addr, error) {
+	var addr netip.Addr
+	if local, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok && local.Network() == "unix" {
+		addr = netip.AddrFrom4([4]byte{127, 0, 0, 1})
+	} else {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		if addr, err = netip.ParseAddr(host); err != nil {
+			return netip.Addr{}, err
+		}
+		addr = addr.Unmap()
+		if !prefixesContain(a.trustedProxies, addr) {
+			return addr, nil
+		}
+	}
+
+	var forwarded []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		forwarded = append(forwarded, strings.Split(h, ",")...)
+	}
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			// What comes before a malformed hop cannot be trusted either.
+			break
+		}
+		addr = hop.Unmap()
+		if !prefixesContain(a.trustedProxies, addr) {
+			break
+		}
+	}
+	return addr, nil
+}
+
+type clientAddrKey struct{}
+
+// clientAddrFrom returns the client address found by withAccessControl.
+func clientAddrFrom(ctx context.Context) (netip.Addr, bool) {
+	addr, ok := ctx.Value(clientAddrKey{}).(netip.Addr)
+	return addr, ok
+}
+
+// withAccessControl rejects the clients the rules do not allow, counting
+// them in rejected by reason, and keeps the address of the others in the
+// request context.
+func withAccessControl(next http.Handler, rules *accessRules, rejected metrics.Counter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addr, err := rules.clientAddr(r)
+		if err != nil {
+			rejected.With("reason", "invalid_address").Add(1)
+			encodeError(r.Context(), ErrAccessDenied, w)
+			return
+		}
+		if reason := rules.check(addr); reason != "" {
+			rejected.With("reason", reason).Add(1)
+			encodeError(r.Context(), ErrAccessDenied, w)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientAddrKey{}, addr)))
+	})
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"expvar"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	runtimepprof "runtime/pprof"
+	"strings"
+	"sync/atomic"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+//
+// ────────────────────────────────────────────────── I ──────────
+//   :::::: A D M I N : :  :   :    :     :        :          :
+// ────────────────────────────────────────────────────────────
+//
+
+// ErrUnauthorized is returned to the requests of the admin listener without
+// its token, with a 401.
+var ErrUnauthorized = errors.New("Unauthorized")
+
+func init() {
+	expvar.Publish("build", expvar.Func(buildInfo))
+	expvar.Publish("goroutines", expvar.Func(func() interface{} {
+		return runtime.NumGoroutine()
+	}))
+}
+
+// buildInfo returns the versions the binary was built with.
+func buildInfo() interface{} {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return nil
+	}
+	settings := make(map[string]string, len(info.Settings))
+	for _, s := range info.Settings {
+		settings[s.Key] = s.Value
+	}
+	return map[string]interface{}{
+		"go_version": info.GoVersion,
+		"path":       info.Path,
+		"version":    info.Main.Version,
+		"settings":   settings,
+	}
+}
+
+// serveAdmin serves the debug endpoints, the log level and the injected
+// faults on the admin listener of cfg, if any:
+//
+//	/debug/pprof/       the profiles of net/http/pprof
+//	/debug/vars         expvar, with the build info and goroutine count
+//	/debug/goroutines   the stacks of all goroutines
+//	/admin/loglevel     the log level, changed with PUT
+//	/admin/chaos        the settings of the injected faults
+//	/admin/jobs/{id}/redeliver
+//	                    redelivers the notification of a job
+//	/admin/history      the history of requests, when kept
+//	/admin/flags        the flags of operations and middlewares, which can
+//	                    be set until the service restarts
+//	/admin/config       the configuration in effect, without its secrets
+//
+// Its routes require the token of cfg, when set, as a bearer token. The
+// listener is shut down by the function returned, nil without listener.
+func serveAdmin(cfg adminConfig, l *logLevel, faults *chaos, jobs *jobQueue, history *historyStore, flags *featureFlags, effective *atomic.Pointer[config], logger log.Logger) (shutdown func(context.Context) error, err error) {
+	if cfg.Addr == "" {
+		return nil, nil
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/debug/goroutines", serveGoroutines)
+	mux.Handle("/admin/loglevel", makeLogLevelHandler(l))
+	mux.Handle("/admin/chaos", makeChaosHandler(faults))
+	mux.Handle("/admin/jobs/", makeJobRedeliveryHandler(jobs))
+	if history != nil {
+		mux.Handle("/admin/history", makeHistoryHandler(history))
+	}
+	mux.Handle("/admin/flags", makeFlagsHandler(flags))
+	mux.Handle("/admin/flags/", makeFlagsHandler(flags))
+	mux.Handle("/admin/config", makeConfigHandler(effective))
+
+	ln, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
+		return nil, err
+	}
+	// Profiles take as long as they are asked to, so the admin listener
+	// has no read or write timeout.
+	server := &http.Server{
+		Handler:           withAdminToken(mux, cfg.Token),
+		ReadHeaderTimeout: defaultReadHeaderTimeout,
+	}
+	go func() {
+		if err := server.Serve(ln); err != http.ErrServerClosed {
+			level.Error(logger).Log("msg", "admin listener stopped", "err", err)
+		}
+	}()
+	return func(ctx context.Context) error {
+		err := server.Shutdown(ctx)
+		// Serve may not have started to close ln on shutdown yet.
+		ln.Close()
+		return err
+	}, nil
+}
+
+// makeConfigHandler answers the configuration in effect, reloads included,
+// with its secrets redacted.
+func makeConfigHandler(effective *atomic.Pointer[config]) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		encodeResponse(r.Context(), w, effective.Load().redacted())
+	})
+}
+
+// serveGoroutines writes the stacks of all goroutines, like a panic does.
+func serveGoroutines(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	runtimepprof.Lookup("goroutine").WriteTo(w, 2)
+}
+
+// withAdminToken rejects the requests without "Authorization: Bearer
+// <token>", unless token is empty.
+func withAdminToken(next http.Handler, token string) http.Handler {
+	if token == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			encodeError(r.Context(), ErrUnauthorized, w)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	amqptransport "github.com/go-kit/kit/transport/amqp"
+	"github.com/streadway/amqp"
+)
+
+//
+// ──────────────────────────────────────────────── I ──────────
+//   :::::: A M Q P : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────
+//
+
+// Defaults of the "amqp" config section.
+const (
+	defaultAMQPQueue    = "stringsvc.requests"
+	defaultAMQPWorkers  = 4
+	deadLetterSuffix    = ".dead"
+	amqpConsumerTagName = "stringsvc"
+)
+
+// serveAMQP consumes the request queue of cfg with cfg.Workers goroutines.
+// The operation of each message is named by its AMQP "type" property and
+// its body is the JSON request of that operation.
+//
+// Replies go to the "reply-to" queue of the message, or to cfg.ReplyQueue
+// when it has none, with the correlation ID of the request. Messages are
+// acknowledged once their reply is published. Messages that cannot be
+// handled at all, because their operation is unknown or their body does not
+// decode, are rejected without requeueing and end up in the dead-letter
+// queue, the request queue name followed by ".dead".
+func serveAMQP(cfg amqpConfig, ops map[string]operation, logger log.Logger) (*amqpConsumer, error) {
+	queue, workers := cfg.Queue, cfg.Workers
+	if queue == "" {
+		queue = defaultAMQPQueue
+	}
+	if workers <= 0 {
+		workers = defaultAMQPWorkers
+	}
+	logger = log.With(logger, "transport", "amqp")
+
+	conn, err := amqp.Dial(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	deliveries, err := declareAMQPQueues(ch, queue, workers)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	subscribers := make(map[string]func(*amqp.Delivery))
+	for name, op := range ops {
+		sub := amqptransport.NewSubscriber(
+			op.Endpoint,
+			makeAMQPRequestDecoder(op),
+			amqptransport.EncodeJSONResponse,
+			amqptransport.SubscriberBefore(defaultReplyQueue(cfg.ReplyQueue)),
+			amqptransport.SubscriberResponsePublisher(publishAndAck),
+			amqptransport.SubscriberErrorEncoder(replyAndDeadLetter),
+			amqptransport.SubscriberErrorLogger(log.With(logger, "op", name)),
+		)
+		subscribers[name] = sub.ServeDelivery(ch)
+	}
+
+	c := &amqpConsumer{conn: conn, ch: ch}
+	c.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer c.workers.Done()
+			for d := range deliveries {
+				serve, ok := subscribers[d.Type]
+				if !ok {
+					level.Warn(logger).Log("err", fmt.Sprintf("Unknown operation %q", d.Type))
+					d.Nack(false, false)
+					continue
+				}
+				serve(&d)
+			}
+		}()
+	}
+	go func() {
+		// The channel is closed without error by shutdown.
+		if err := <-conn.NotifyClose(make(chan *amqp.Error, 1)); err != nil {
+			level.Error(logger).Log("msg", "connection closed, no longer consuming", "err", err)
+		}
+	}()
+	return c, nil
+}
+
+// amqpConsumer consumes the request queue with its workers.
+type amqpConsumer struct {
+	conn    *amqp.Connection
+	ch      *amqp.Channel
+	workers sync.WaitGroup
+}
+
+// shutdown stops consuming, and waits for the messages being handled to be
+// replied to before closing the connection, or closes it when ctx is done.
+// The messages delivered but not handled yet are requeued by the broker.
+func (c *amqpConsumer) shutdown(ctx context.Context) error {
+	err := c.ch.Cancel(amqpConsumerTagName, false)
+	done := make(chan struct{})
+	go func() {
+		c.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if closeErr := c.conn.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// declareAMQPQueues declares the request queue along with its dead-letter
+// queue, and starts consuming the former.
+func declareAMQPQueues(ch *amqp.Channel, queue string, prefetch int) (<-chan amqp.Delivery, error) {
+	if _, err := ch.QueueDeclare(queue+deadLetterSuffix, true, false, false, false, nil); err != nil {
+		return nil, err
+	}
+	args := amqp.Table{
+		"x-dead-letter-exchange":    "",
+		"x-dead-letter-routing-key": queue + deadLetterSuffix,
+	}
+	if _, err := ch.QueueDeclare(queue, true, false, false, false, args); err != nil {
+		return nil, err
+	}
+	if err := ch.Qos(prefetch, 0, false); err != nil {
+		return nil, err
+	}
+	return ch.Consume(queue, amqpConsumerTagName, false, false, false, false, nil)
+}
+
+func makeAMQPRequestDecoder(op operation) amqptransport.DecodeRequestFunc {
+	return func(_ context.Context, d *amqp.Delivery) (interface{}, error) {
+		return op.decode(d.Body)
+	}
+}
+
+// defaultReplyQueue sends replies to queue when messages have no reply-to.
+func defaultReplyQueue(queue string) amqptransport.RequestFunc {
+	return func(ctx context.Context, pub *amqp.Publishing, d *amqp.Delivery) context.Context {
+		if d.ReplyTo != "" || queue == "" {
+			return ctx
+		}
+		return amqptransport.SetPublishKey(queue)(ctx, pub, d)
+	}
+}
+
+func publishAndAck(ctx context.Context, d *amqp.Delivery, ch amqptransport.Channel, pub *amqp.Publishing) error {
+	pub.ContentType = "application/json"
+	if err := amqptransport.DefaultResponsePublisher(ctx, d, ch, pub); err != nil {
+		return err
+	}
+	return d.Ack(false)
+}
+
+// replyAndDeadLetter tells the sender what went wrong, then rejects the
+// message so that it moves to the dead-letter queue instead of being
+// redelivered forever.
+func replyAndDeadLetter(ctx context.Context, err error, d *amqp.Delivery, ch amqptransport.Channel, pub *amqp.Publishing) {
+	pub.ContentType = "application/json"
+	amqptransport.ReplyErrorEncoder(ctx, err, d, ch, pub)
+	d.Nack(false, false)
+}
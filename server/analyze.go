@@ -0,0 +1,184 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/go-kit/kit/endpoint"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+//
+// ────────────────────────────────────────────────────── I ──────────
+//   :::::: A N A L Y Z E : :  :   :    :     :        :          :
+// ────────────────────────────────────────────────────────────────
+//
+
+type palindromeRequest struct {
+	S                 string `json:"s" validate:"required"`
+	Normalize         bool   `json:"normalize"`
+	IgnorePunctuation bool   `json:"ignore_punctuation"`
+}
+
+type palindromeResponse struct {
+	V          bool   `json:"v"`
+	Normalized string `json:"normalized"`
+	Err        string `json:"err,omitempty"`
+}
+
+type anagramRequest struct {
+	A                 string `json:"a" validate:"required"`
+	B                 string `json:"b" validate:"required"`
+	Normalize         bool   `json:"normalize"`
+	IgnorePunctuation bool   `json:"ignore_punctuation"`
+}
+
+type anagramResponse struct {
+	V          bool     `json:"v"`
+	Normalized []string `json:"normalized"`
+	Err        string   `json:"err,omitempty"`
+}
+
+func (stringService) Palindrome(ctx context.Context, s string, normalize, ignorePunctuation bool) (ok bool, normalized string, err error) {
+	rs := []rune(analysisForm(s, normalize, ignorePunctuation))
+	for i, j := 0, len(rs)-1; i < j; i, j = i+1, j-1 {
+		if rs[i] != rs[j] {
+			return false, string(rs), nil
+		}
+	}
+	return true, string(rs), nil
+}
+
+// Anagram reports whether a and b use the same letters. The normalized forms
+// it returns are the sorted runes that were actually compared.
+func (stringService) Anagram(ctx context.Context, a, b string, normalize, ignorePunctuation bool) (ok bool, normalized []string, err error) {
+	na := sortedRunes(analysisForm(a, normalize, ignorePunctuation))
+	nb := sortedRunes(analysisForm(b, normalize, ignorePunctuation))
+	return na == nb, []string{na, nb}, nil
+}
+
+// analysisForm prepares s for comparison. Normalization applies NFKC, full
+// Unicode case folding and diacritic stripping, so "Ｅ", "É" and "e" compare
+// equal; the punctuation option also drops spaces and symbols.
+func analysisForm(s string, normalize, ignorePunctuation bool) string {
+	if normalize {
+		s = foldDiacritics(cases.Fold().String(norm.NFKC.String(s)))
+	}
+	if ignorePunctuation {
+		s = strings.Map(func(r rune) rune {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r) {
+				return r
+			}
+			return -1
+		}, s)
+	}
+	return s
+}
+
+func sortedRunes(s string) string {
+	rs := []rune(s)
+	sort.Slice(rs, func(i, j int) bool { return rs[i] < rs[j] })
+	return string(rs)
+}
+
+func makePalindromeEndpoint(svc IStringService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(palindromeRequest)
+		v, normalized, err := svc.Palindrome(ctx, req.S, req.Normalize, req.IgnorePunctuation)
+		if err != nil {
+			return palindromeResponse{false, "", err.Error()}, nil
+		}
+		return palindromeResponse{v, normalized, ""}, nil
+	}
+}
+
+func makeAnagramEndpoint(svc IStringService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(anagramRequest)
+		v, normalized, err := svc.Anagram(ctx, req.A, req.B, req.Normalize, req.IgnorePunctuation)
+		if err != nil {
+			return anagramResponse{false, nil, err.Error()}, nil
+		}
+		return anagramResponse{v, normalized, ""}, nil
+	}
+}
+
+func decodePalindromeRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request palindromeRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+func decodeAnagramRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request anagramRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+//
+// ─── LOGGING ────────────────────────────────────────────────────────────────────
+//
+
+func (mw loggingMiddleware) Palindrome(ctx context.Context, s string, normalize, ignorePunctuation bool) (ok bool, normalized string, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "palindrome",
+			"tenant", tenantFrom(ctx),
+			"input", s,
+			"normalize", normalize,
+			"ignore_punctuation", ignorePunctuation,
+			"output", ok,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	ok, normalized, err = mw.next.Palindrome(ctx, s, normalize, ignorePunctuation)
+	return
+}
+
+func (mw loggingMiddleware) Anagram(ctx context.Context, a, b string, normalize, ignorePunctuation bool) (ok bool, normalized []string, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "anagram",
+			"tenant", tenantFrom(ctx),
+			"a", a,
+			"b", b,
+			"normalize", normalize,
+			"ignore_punctuation", ignorePunctuation,
+			"output", ok,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	ok, normalized, err = mw.next.Anagram(ctx, a, b, normalize, ignorePunctuation)
+	return
+}
+
+//
+// ─── INSTRUMENTATION ────────────────────────────────────────────────────────────
+//
+
+func (mw instrumentingMiddleware) Palindrome(ctx context.Context, s string, normalize, ignorePunctuation bool) (ok bool, normalized string, err error) {
+	done := mw.begin(ctx, "palindrome", s)
+	defer func() { done(-1, err) }()
+
+	ok, normalized, err = mw.next.Palindrome(ctx, s, normalize, ignorePunctuation)
+	return
+}
+
+func (mw instrumentingMiddleware) Anagram(ctx context.Context, a, b string, normalize, ignorePunctuation bool) (ok bool, normalized []string, err error) {
+	done := mw.begin(ctx, "anagram", a+b)
+	defer func() { done(-1, err) }()
+
+	ok, normalized, err = mw.next.Anagram(ctx, a, b, normalize, ignorePunctuation)
+	return
+}
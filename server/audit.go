@@ -0,0 +1,343 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"log/syslog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/segmentio/kafka-go"
+)
+
+//
+// ────────────────────────────────────────────────── I ──────────
+//   :::::: A U D I T : :  :   :    :     :        :          :
+// ────────────────────────────────────────────────────────────
+//
+
+// Defaults of the "audit" config section.
+const (
+	defaultAuditFileMaxBytes   = 100 << 20
+	defaultAuditFileMaxBackups = 5
+	defaultAuditSyslogTag      = "stringsvc-audit"
+)
+
+// AuditRecord tells who called what, and how it went. Inputs are never
+// recorded, only their size and a keyed hash, which tells identical inputs
+// apart without revealing them.
+type AuditRecord struct {
+	Time       time.Time `json:"time"`
+	Tenant     string    `json:"tenant"`
+	ClientAddr string    `json:"client_addr,omitempty"`
+	// APIKeyHash is the keyed hash of the X-API-Key header, if any.
+	APIKeyHash string  `json:"api_key_hash,omitempty"`
+	Method     string  `json:"method"`
+	Route      string  `json:"route"`
+	InputBytes int64   `json:"input_bytes"`
+	InputHash  string  `json:"input_hash,omitempty"`
+	Status     int     `json:"status"`
+	DurationMS float64 `json:"duration_ms"`
+}
+
+// AuditSink stores audit records, away from the debug logs.
+type AuditSink interface {
+	Write(AuditRecord) error
+	Close() error
+}
+
+// auditSinks make the sinks that can be named in the "audit" config
+// section.
+var auditSinks = map[string]func(AuditConfig) (AuditSink, error){
+	"file":   newFileAuditSink,
+	"syslog": newSyslogAuditSink,
+	"kafka":  newKafkaAuditSink,
+}
+
+// RegisterAuditSink makes the sink made by newSink available as name.
+func RegisterAuditSink(name string, newSink func(AuditConfig) (AuditSink, error)) {
+	auditSinks[name] = newSink
+}
+
+// newAuditSink returns the sink of cfg, or nil when auditing is disabled.
+func newAuditSink(cfg AuditConfig) (AuditSink, error) {
+	if cfg.Sink == "" {
+		return nil, nil
+	}
+	newSink, ok := auditSinks[cfg.Sink]
+	if !ok {
+		return nil, fmt.Errorf("unknown audit sink %q", cfg.Sink)
+	}
+	return newSink(cfg)
+}
+
+//
+// ─── FILE ───────────────────────────────────────────────────────────────────────
+//
+
+// fileAuditSink writes records as JSON lines, rotating the file once it
+// reaches maxBytes: path is renamed path.1, path.1 path.2, and so on up to
+// maxBackups files.
+type fileAuditSink struct {
+	path       string
+	maxBytes   int64
+	maxBackups int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+func newFileAuditSink(cfg AuditConfig) (AuditSink, error) {
+	if cfg.File.Path == "" {
+		return nil, fmt.Errorf("audit.file.path is required")
+	}
+	s := &fileAuditSink{
+		path:       cfg.File.Path,
+		maxBytes:   cfg.File.MaxBytes,
+		maxBackups: cfg.File.MaxBackups,
+	}
+	if s.maxBytes <= 0 {
+		s.maxBytes = defaultAuditFileMaxBytes
+	}
+	if s.maxBackups <= 0 {
+		s.maxBackups = defaultAuditFileMaxBackups
+	}
+	return s, s.open()
+}
+
+func (s *fileAuditSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.f, s.size = f, info.Size()
+	return nil
+}
+
+func (s *fileAuditSink) rotate() error {
+	if err := s.f.Close(); err != nil {
+		return err
+	}
+	for i := s.maxBackups - 1; i > 0; i-- {
+		os.Rename(s.path+"."+strconv.Itoa(i), s.path+"."+strconv.Itoa(i+1))
+	}
+	if err := os.Rename(s.path, s.path+".1"); err != nil {
+		return err
+	}
+	return s.open()
+}
+
+func (s *fileAuditSink) Write(rec AuditRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.size > 0 && s.size+int64(len(line)) > s.maxBytes {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.f.Write(line)
+	s.size += int64(n)
+	return err
+}
+
+func (s *fileAuditSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.f.Close()
+}
+
+//
+// ─── SYSLOG ─────────────────────────────────────────────────────────────────────
+//
+
+// syslogAuditSink sends records as JSON to syslog, with the facility
+// LOG_AUTH.
+type syslogAuditSink struct {
+	w *syslog.Writer
+}
+
+func newSyslogAuditSink(cfg AuditConfig) (AuditSink, error) {
+	tag := cfg.Syslog.Tag
+	if tag == "" {
+		tag = defaultAuditSyslogTag
+	}
+	w, err := syslog.Dial(cfg.Syslog.Network, cfg.Syslog.Addr, syslog.LOG_INFO|syslog.LOG_AUTH, tag)
+	if err != nil {
+		return nil, err
+	}
+	return syslogAuditSink{w}, nil
+}
+
+func (s syslogAuditSink) Write(rec AuditRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return s.w.Info(string(line))
+}
+
+func (s syslogAuditSink) Close() error {
+	return s.w.Close()
+}
+
+//
+// ─── KAFKA ──────────────────────────────────────────────────────────────────────
+//
+
+// kafkaAuditSink produces records as JSON messages keyed by tenant.
+// Messages are sent in the background, so that auditing does not slow
+// requests down.
+type kafkaAuditSink struct {
+	w *kafka.Writer
+}
+
+func newKafkaAuditSink(cfg AuditConfig) (AuditSink, error) {
+	if len(cfg.Kafka.Brokers) == 0 || cfg.Kafka.Topic == "" {
+		return nil, fmt.Errorf("audit.kafka.brokers and audit.kafka.topic are required")
+	}
+	return kafkaAuditSink{&kafka.Writer{
+		Addr:     kafka.TCP(cfg.Kafka.Brokers...),
+		Topic:    cfg.Kafka.Topic,
+		Balancer: &kafka.Hash{},
+		Async:    true,
+	}}, nil
+}
+
+func (s kafkaAuditSink) Write(rec AuditRecord) error {
+	value, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return s.w.WriteMessages(context.Background(), kafka.Message{Key: []byte(rec.Tenant), Value: value})
+}
+
+func (s kafkaAuditSink) Close() error {
+	return s.w.Close()
+}
+
+//
+// ─── MIDDLEWARE ─────────────────────────────────────────────────────────────────
+//
+
+// auditHashKey returns the key of the hashes of audit records: the
+// configured one, or a random one, in which case hashes only match within
+// the same run.
+func auditHashKey(cfg AuditConfig) ([]byte, error) {
+	if cfg.HashKey != "" {
+		return []byte(cfg.HashKey), nil
+	}
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	return key, err
+}
+
+// withAudit writes a record of every request to sink once served. Failures
+// to write are logged, and do not fail the request.
+func withAudit(next http.Handler, sink AuditSink, hashKey []byte, logger log.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		begin := time.Now()
+		rec := AuditRecord{
+			Time:   begin.UTC(),
+			Tenant: tenantFrom(r.Context()),
+			Method: r.Method,
+			Route:  r.URL.Path,
+		}
+		if addr, ok := clientAddrFrom(r.Context()); ok {
+			rec.ClientAddr = addr.String()
+		}
+		if key := r.Header.Get(apiKeyHeader); key != "" {
+			mac := hmac.New(sha256.New, hashKey)
+			io.WriteString(mac, key)
+			rec.APIKeyHash = hex.EncodeToString(mac.Sum(nil))
+		}
+
+		body := &hashingReader{ReadCloser: r.Body, mac: hmac.New(sha256.New, hashKey)}
+		r.Body = body
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		if r.Header.Get("Upgrade") != "" {
+			// Hijacked connections have no status to record.
+			next.ServeHTTP(w, r)
+			sw.status = http.StatusSwitchingProtocols
+		} else {
+			next.ServeHTTP(sw, r)
+		}
+
+		rec.InputBytes = body.n
+		if body.n > 0 {
+			rec.InputHash = hex.EncodeToString(body.mac.Sum(nil))
+		}
+		rec.Status = sw.status
+		rec.DurationMS = float64(time.Since(begin).Microseconds()) / 1000
+		if err := sink.Write(rec); err != nil {
+			level.Error(logger).Log("msg", "cannot write audit record", "err", err)
+		}
+	})
+}
+
+// hashingReader counts and hashes the bytes read from a request body.
+type hashingReader struct {
+	io.ReadCloser
+	mac hash.Hash
+	n   int64
+}
+
+func (r *hashingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.mac.Write(p[:n])
+	r.n += int64(n)
+	return n, err
+}
+
+// statusWriter keeps the status of the response it writes.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status, w.wroteHeader = status, true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the connection.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,228 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/go-kit/kit/metrics"
+)
+
+//
+// ────────────────────────────────────────────────────────────────── I ──────────
+//   :::::: A U T H O R I Z A T I O N : :  :   :    :     :        :          :
+// ────────────────────────────────────────────────────────────────────────────
+//
+
+// defaultRolesClaim is the claim of access tokens listing their roles.
+const defaultRolesClaim = "roles"
+
+// ErrPermissionDenied is returned for the calls of operations that the
+// identity of the request may not call, with a 403.
+var ErrPermissionDenied = errors.New("Permission denied")
+
+// Identity is who calls an operation, as known from the access token, the
+// API key and the tenant of the request.
+type Identity struct {
+	// Subject is the "sub" of the access token, empty without a token.
+	Subject string
+	// Tenant is the tenant of the request.
+	Tenant string
+	// Roles are those of the access token and of the API key, sorted.
+	Roles []string
+	// Claims are the claims of the access token, nil without a token.
+	Claims map[string]interface{}
+}
+
+// HasRole tells whether the identity has the role.
+func (id Identity) HasRole(role string) bool {
+	i := sort.SearchStrings(id.Roles, role)
+	return i < len(id.Roles) && id.Roles[i] == role
+}
+
+// PolicyEngine decides which identities may call which operations, like an
+// Open Policy Agent client. It replaces the roles of the "authorization"
+// config section. An error denies the call, with a 500.
+type PolicyEngine interface {
+	Authorize(ctx context.Context, id Identity, op string) (allowed bool, err error)
+}
+
+// rolePolicy is the PolicyEngine of the config, allowing the identities
+// with one of the roles of each operation.
+type rolePolicy struct {
+	operations map[string][]string
+}
+
+func (p rolePolicy) Authorize(ctx context.Context, id Identity, op string) (bool, error) {
+	roles, ok := p.operations[op]
+	if !ok {
+		roles, ok = p.operations["*"]
+	}
+	if !ok || len(roles) == 0 {
+		return true, nil
+	}
+	for _, role := range roles {
+		if id.HasRole(role) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// authorizer checks the identities of the requests against its engine.
+type authorizer struct {
+	engine      PolicyEngine
+	rolesClaim  []string
+	apiKeyRoles map[string][]string
+	denied      metrics.Counter
+	logger      log.Logger
+}
+
+// newAuthorizer returns the authorizer of cfg, or of engine when it is not
+// nil, and nil when every identity may call every operation. denied counts
+// the calls denied by operation and reason: "role", or "error" when the
+// engine failed.
+func newAuthorizer(cfg authorizationConfig, engine PolicyEngine, denied metrics.Counter, logger log.Logger) (*authorizer, error) {
+	if engine == nil {
+		if len(cfg.Operations) == 0 {
+			return nil, nil
+		}
+		engine = rolePolicy{cfg.Operations}
+	}
+	rolesClaim := cfg.RolesClaim
+	if rolesClaim == "" {
+		rolesClaim = defaultRolesClaim
+	}
+	a := &authorizer{
+		engine:      engine,
+		rolesClaim:  strings.Split(rolesClaim, "."),
+		apiKeyRoles: make(map[string][]string, len(cfg.APIKeys)),
+		denied:      denied,
+		logger:      logger,
+	}
+	for i, k := range cfg.APIKeys {
+		if k.APIKey == "" {
+			return nil, fmt.Errorf("api_keys[%d] has no api_key", i)
+		}
+		a.apiKeyRoles[k.APIKey] = k.Roles
+	}
+	return a, nil
+}
+
+type identityKey struct{}
+
+// identityFrom returns the identity of the call of ctx, nil when the
+// authorization is off.
+func identityFrom(ctx context.Context) *Identity {
+	id, _ := ctx.Value(identityKey{}).(*Identity)
+	return id
+}
+
+// withIdentities keeps the identity of the requests in their context, for
+// the operations to be authorized. It needs the access token and the tenant
+// of the requests.
+func withIdentities(next http.Handler, a *authorizer) http.Handler {
+	if a == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := a.identity(r)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, id)))
+	})
+}
+
+// identity returns the identity of r.
+func (a *authorizer) identity(r *http.Request) *Identity {
+	id := &Identity{Tenant: tenantFrom(r.Context())}
+	roles := make(map[string]bool)
+	if token := accessTokenFrom(r.Context()); token != nil {
+		id.Subject, id.Claims = token.Subject, token.Claims
+		for _, role := range claimValues(token.Claims, a.rolesClaim) {
+			roles[role] = true
+		}
+	}
+	if key := r.Header.Get(apiKeyHeader); key != "" {
+		for _, role := range a.apiKeyRoles[key] {
+			roles[role] = true
+		}
+	}
+	for role := range roles {
+		id.Roles = append(id.Roles, role)
+	}
+	sort.Strings(id.Roles)
+	return id
+}
+
+// callerOf returns who sends r within its tenant: the subject of its access
+// token, or the hash of its API key. Anonymous requests have no caller.
+func callerOf(r *http.Request) string {
+	if token := accessTokenFrom(r.Context()); token != nil {
+		return "sub:" + token.Subject
+	}
+	if key := r.Header.Get(apiKeyHeader); key != "" {
+		sum := sha256.Sum256([]byte(key))
+		return "key:" + hex.EncodeToString(sum[:])
+	}
+	return ""
+}
+
+// claimValues returns the strings of the claim at path, a list or a string
+// of values separated by spaces.
+func claimValues(claims map[string]interface{}, path []string) []string {
+	var v interface{} = claims
+	for _, name := range path {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[name]
+	}
+	switch v := v.(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, value := range v {
+			if s, ok := value.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// authorized rejects the calls of the operation name that the engine does
+// not allow. The calls without an identity are checked as anonymous ones of
+// their tenant, without roles.
+func (a *authorizer) authorized(name string, next endpoint.Endpoint) endpoint.Endpoint {
+	if a == nil {
+		return next
+	}
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		id := identityFrom(ctx)
+		if id == nil {
+			id = &Identity{Tenant: tenantFrom(ctx)}
+		}
+		allowed, err := a.engine.Authorize(ctx, *id, name)
+		if err != nil {
+			a.denied.With("op", name, "reason", "error").Add(1)
+			level.Warn(a.logger).Log("msg", "cannot authorize call", "op", name, "subject", id.Subject, "err", err)
+			return nil, fmt.Errorf("cannot authorize call: %w", err)
+		}
+		if !allowed {
+			a.denied.With("op", name, "reason", "role").Add(1)
+			level.Debug(a.logger).Log("msg", "call denied", "op", name, "subject", id.Subject, "tenant", id.Tenant, "roles", strings.Join(id.Roles, ","))
+			return nil, ErrPermissionDenied
+		}
+		return next(ctx, request)
+	}
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"mime"
+	"net/http"
+	"runtime"
+	"sync"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+//
+// ────────────────────────────────────────────────── I ──────────
+//   :::::: B A T C H : :  :   :    :     :        :          :
+// ────────────────────────────────────────────────────────────
+//
+
+// maxBatchItems bounds the number of strings of a single batch request.
+const maxBatchItems = 1000
+
+// batchWorkers is the number of items of a batch processed concurrently.
+var batchWorkers = runtime.NumCPU()
+
+type batchRequest struct {
+	Op    string   `json:"op" validate:"max=64,charset=printable"`
+	Items []string `json:"items"`
+	// Options are the request fields of the operation, "s" excepted. They
+	// apply to every item.
+	Options map[string]interface{} `json:"options"`
+}
+
+// batchResponse holds one result per item, in the order of the request.
+// Failed items carry their own error; Err is only set when the batch as a
+// whole is rejected.
+type batchResponse struct {
+	V   []operationResult `json:"v"`
+	Err string            `json:"err,omitempty"`
+}
+
+// makeBatchEndpoint runs the operation of a request on each of its items
+// with a pool of batchWorkers goroutines.
+func makeBatchEndpoint(ops map[string]operation) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(batchRequest)
+		op, ok := ops[req.Op]
+		if !ok {
+			err := errorf(CodeUnknownOperation, "Unknown operation %q", req.Op)
+			recordErrorCode(ctx, err)
+			return batchResponse{Err: err.Error()}, nil
+		}
+		if len(req.Items) > maxBatchItems {
+			err := errorf(CodeLimitExceeded, "A batch has at most %d items", maxBatchItems)
+			recordErrorCode(ctx, err)
+			return batchResponse{Err: err.Error()}, nil
+		}
+
+		results := make([]operationResult, len(req.Items))
+		indexes := make(chan int)
+		var wg sync.WaitGroup
+		for w := 0; w < batchWorkers && w < len(req.Items); w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range indexes {
+					results[i] = runBatchItem(ctx, op, req.Items[i], req.Options)
+				}
+			}()
+		}
+		for i := range req.Items {
+			indexes <- i
+		}
+		close(indexes)
+		wg.Wait()
+
+		return batchResponse{V: results}, nil
+	}
+}
+
+func runBatchItem(ctx context.Context, op operation, s string, options map[string]interface{}) operationResult {
+	if err := ctx.Err(); err != nil {
+		return operationResult{Err: localeFrom(ctx).Error(err), Code: errorCodeOf(err)}
+	}
+	itemOptions := map[string]interface{}{}
+	for k, v := range options {
+		itemOptions[k] = v
+	}
+	itemOptions["s"] = s
+	result, err := op.invoke(ctx, itemOptions)
+	if err != nil {
+		return operationResult{Err: localeFrom(ctx).Error(err), Code: errorCodeOf(err)}
+	}
+	return result
+}
+
+func decodeBatchRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" || mediaType == "text/plain" {
+		return decodeBatchUpload(r)
+	}
+	var request batchRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
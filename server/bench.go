@@ -0,0 +1,450 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"text/tabwriter"
+	"time"
+
+	"github.com/anhle128/gokit-stringsvc/client"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/sd"
+)
+
+//
+// ────────────────────────────────────────────────── I ──────────
+//   :::::: B E N C H : :  :   :    :     :        :          :
+// ────────────────────────────────────────────────────────────
+//
+
+// benchUsage is printed by "stringsvc bench -h".
+const benchUsage = `Usage: stringsvc bench [flags] <addr>...
+       stringsvc bench [flags] -discovery <file>
+
+Sends requests to running stringsvc instances from concurrent workers for
+a while, and reports the throughput and latency percentiles of every
+operation. Each request calls an operation picked by its weight in -mix,
+with a payload of one of the -sizes, e.g.
+  stringsvc bench -mix uppercase=3,hash=1 -sizes 64,4096 -c 16 -d 1m host:8080
+
+Payloads are drawn from -seed, so that runs can be compared. Instances can
+also be discovered as set in a JSON file like
+  {"backend": "consul", "addrs": ["consul:8500"], "service": "stringsvc"}
+
+With -codecs, no instance is called: the decoding of requests and the
+encoding of responses of uppercase are measured in process instead, for
+each of the -sizes, by encoding/json and by the fast path of the service.
+
+Flags:
+`
+
+// benchOperations are the operations bench can call, with the settings of
+// a typical request.
+var benchOperations = map[string]func(ctx context.Context, c *client.Client, s string) error{
+	"uppercase": func(ctx context.Context, c *client.Client, s string) error {
+		_, err := c.Uppercase(ctx, s)
+		return err
+	},
+	"count": func(ctx context.Context, c *client.Client, s string) error {
+		_, err := c.Count(ctx, s)
+		return err
+	},
+	"count_runes": func(ctx context.Context, c *client.Client, s string) error {
+		_, err := c.CountRunes(ctx, s)
+		return err
+	},
+	"hash": func(ctx context.Context, c *client.Client, s string) error {
+		_, err := c.Hash(ctx, s, "sha256", "hex")
+		return err
+	},
+	"encode": func(ctx context.Context, c *client.Client, s string) error {
+		_, err := c.Encode(ctx, s, "base64")
+		return err
+	},
+	"slugify": func(ctx context.Context, c *client.Client, s string) error {
+		_, err := c.Slugify(ctx, s, "-", 0, false)
+		return err
+	},
+	"convertcase": func(ctx context.Context, c *client.Client, s string) error {
+		_, _, err := c.ConvertCase(ctx, s, caseSnake)
+		return err
+	},
+	"stats": func(ctx context.Context, c *client.Client, s string) error {
+		_, err := c.Stats(ctx, s, 0)
+		return err
+	},
+	"detect-language": func(ctx context.Context, c *client.Client, s string) error {
+		_, err := c.DetectLanguage(ctx, s, 0)
+		return err
+	},
+	"compress": func(ctx context.Context, c *client.Client, s string) error {
+		_, err := c.Compress(ctx, s, "gzip", 0)
+		return err
+	},
+	"redact": func(ctx context.Context, c *client.Client, s string) error {
+		_, _, err := c.Redact(ctx, s, nil, "")
+		return err
+	},
+}
+
+// benchWeight is an operation of the mix and its share of the requests.
+type benchWeight struct {
+	Op     string
+	Weight int
+}
+
+// parseBenchMix parses a mix like "uppercase=3,hash=1". Operations without
+// a weight have a weight of 1.
+func parseBenchMix(mix string) ([]benchWeight, error) {
+	var weights []benchWeight
+	for _, item := range strings.Split(mix, ",") {
+		op, weight := strings.TrimSpace(item), 1
+		if i := strings.IndexByte(op, '='); i >= 0 {
+			n, err := strconv.Atoi(op[i+1:])
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid weight in %q", item)
+			}
+			op, weight = op[:i], n
+		}
+		if _, ok := benchOperations[op]; !ok {
+			names := make([]string, 0, len(benchOperations))
+			for name := range benchOperations {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("unknown operation %q, expected one of: %s", op, strings.Join(names, ", "))
+		}
+		weights = append(weights, benchWeight{op, weight})
+	}
+	return weights, nil
+}
+
+// pick returns an operation of weights with the probability of its weight.
+func pick(weights []benchWeight, total int, rnd *rand.Rand) string {
+	n := rnd.Intn(total)
+	for _, w := range weights {
+		if n < w.Weight {
+			return w.Op
+		}
+		n -= w.Weight
+	}
+	return weights[len(weights)-1].Op
+}
+
+// benchPayload returns size bytes of lowercase words.
+func benchPayload(size int, rnd *rand.Rand) string {
+	const letters = "abcdefghijklmnopqrstuvwxyz"
+	b := make([]byte, size)
+	for i := range b {
+		if i > 0 && b[i-1] != ' ' && rnd.Intn(6) == 0 {
+			b[i] = ' '
+		} else {
+			b[i] = letters[rnd.Intn(len(letters))]
+		}
+	}
+	return string(b)
+}
+
+// benchResult sums up the requests of an operation. Latencies are of the
+// successful requests, in milliseconds.
+type benchResult struct {
+	Op         string  `json:"op"`
+	Requests   int     `json:"requests"`
+	Errors     int     `json:"errors"`
+	Throughput float64 `json:"throughput"`
+	P50        float64 `json:"p50_ms"`
+	P90        float64 `json:"p90_ms"`
+	P99        float64 `json:"p99_ms"`
+	Max        float64 `json:"max_ms"`
+	// FirstError is the message of the first failure, if any.
+	FirstError string `json:"first_error,omitempty"`
+}
+
+// benchSamples collects the outcome of the requests of an operation.
+type benchSamples struct {
+	latencies  []time.Duration
+	errors     int
+	firstError string
+}
+
+func (s *benchSamples) result(op string, elapsed time.Duration) benchResult {
+	r := benchResult{
+		Op:         op,
+		Requests:   len(s.latencies) + s.errors,
+		Errors:     s.errors,
+		FirstError: s.firstError,
+	}
+	r.Throughput = float64(r.Requests) / elapsed.Seconds()
+	if len(s.latencies) == 0 {
+		return r
+	}
+	sort.Slice(s.latencies, func(i, j int) bool { return s.latencies[i] < s.latencies[j] })
+	percentile := func(p float64) float64 {
+		i := int(p * float64(len(s.latencies)-1))
+		return float64(s.latencies[i].Microseconds()) / 1000
+	}
+	r.P50, r.P90, r.P99, r.Max = percentile(.5), percentile(.9), percentile(.99), percentile(1)
+	return r
+}
+
+func (s *benchSamples) merge(other *benchSamples) {
+	s.latencies = append(s.latencies, other.latencies...)
+	s.errors += other.errors
+	if s.firstError == "" {
+		s.firstError = other.firstError
+	}
+}
+
+// RunBench implements the bench subcommand and returns its exit code: 1
+// when any request failed, 2 on usage errors.
+func RunBench(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, benchUsage)
+		fs.PrintDefaults()
+	}
+	mix := fs.String("mix", "uppercase,count", "operations to call, with their weight, as op=weight,...")
+	sizes := fs.String("sizes", "256", "sizes of the payloads in bytes, picked evenly, as n,...")
+	concurrency := fs.Int("c", 8, "number of concurrent workers")
+	duration := fs.Duration("d", 30*time.Second, "duration of the run")
+	timeout := fs.Duration("timeout", 10*time.Second, "timeout of each request")
+	seed := fs.Int64("seed", 1, "seed of the payloads and of the mix")
+	asJSON := fs.Bool("json", false, "print the results as JSON")
+	discoveryPath := fs.String("discovery", "", "JSON file telling how to discover instances, instead of addresses")
+	codecs := fs.Bool("codecs", false, "measure the JSON codecs in process instead of calling instances")
+
+	instances, err := parseInterspersed(fs, args)
+	if err != nil {
+		return 2
+	}
+	if *codecs {
+		return runCodecBench(*sizes, *seed, *asJSON, stdout, stderr)
+	}
+	if (len(instances) == 0) == (*discoveryPath == "") || *concurrency < 1 {
+		fs.Usage()
+		return 2
+	}
+	weights, err := parseBenchMix(*mix)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	total := 0
+	for _, w := range weights {
+		total += w.Weight
+	}
+	rnd := rand.New(rand.NewSource(*seed))
+	var payloads []string
+	for _, size := range strings.Split(*sizes, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(size))
+		if err != nil || n < 1 {
+			fmt.Fprintf(stderr, "invalid size %q\n", size)
+			return 2
+		}
+		payloads = append(payloads, benchPayload(n, rnd))
+	}
+
+	// Without retries, failures are seen as they happen, and idle
+	// connections are kept for every worker.
+	opts := []client.Option{
+		client.Timeout(*timeout),
+		client.Attempts(1),
+		client.HTTPClient(&http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: *concurrency}}),
+	}
+	var c *client.Client
+	if *discoveryPath != "" {
+		instancer, err := newBenchInstancer(*discoveryPath, stderr)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 2
+		}
+		defer instancer.Stop()
+		c, err = client.NewWithInstancer(instancer, opts...)
+	} else {
+		c, err = client.New(instances, opts...)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	defer c.Close()
+
+	var (
+		mu      sync.Mutex
+		samples = make(map[string]*benchSamples)
+		wg      sync.WaitGroup
+	)
+	begin := time.Now()
+	end := begin.Add(*duration)
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func(rnd *rand.Rand) {
+			defer wg.Done()
+			own := make(map[string]*benchSamples)
+			for time.Now().Before(end) {
+				op := pick(weights, total, rnd)
+				s := own[op]
+				if s == nil {
+					s = new(benchSamples)
+					own[op] = s
+				}
+				start := time.Now()
+				if err := benchOperations[op](context.Background(), c, payloads[rnd.Intn(len(payloads))]); err != nil {
+					s.errors++
+					if s.firstError == "" {
+						s.firstError = err.Error()
+					}
+					continue
+				}
+				s.latencies = append(s.latencies, time.Since(start))
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for op, s := range own {
+				if samples[op] == nil {
+					samples[op] = new(benchSamples)
+				}
+				samples[op].merge(s)
+			}
+		}(rand.New(rand.NewSource(*seed + int64(i) + 1)))
+	}
+	wg.Wait()
+	elapsed := time.Since(begin)
+
+	var (
+		results []benchResult
+		all     benchSamples
+	)
+	for _, w := range weights {
+		if s, ok := samples[w.Op]; ok {
+			results = append(results, s.result(w.Op, elapsed))
+			all.merge(s)
+			delete(samples, w.Op)
+		}
+	}
+	results = append(results, all.result("total", elapsed))
+	if err := printBenchResults(stdout, results, *asJSON); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	if all.errors > 0 {
+		return 1
+	}
+	return 0
+}
+
+// codecBenchResult is the cost of a codec path for a payload size.
+type codecBenchResult struct {
+	Codec       string `json:"codec"`
+	Size        int    `json:"size"`
+	Path        string `json:"path"`
+	NsPerOp     int64  `json:"ns_per_op"`
+	BytesPerOp  int64  `json:"bytes_per_op"`
+	AllocsPerOp int64  `json:"allocs_per_op"`
+}
+
+// runCodecBench measures the decoding of uppercase requests and the
+// encoding of their responses, by encoding/json as the service did before
+// its fast path, and by the fast path.
+func runCodecBench(sizes string, seed int64, asJSON bool, stdout, stderr io.Writer) int {
+	rnd := rand.New(rand.NewSource(seed))
+	var results []codecBenchResult
+	for _, size := range strings.Split(sizes, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(size))
+		if err != nil || n < 1 {
+			fmt.Fprintf(stderr, "invalid size %q\n", size)
+			return 2
+		}
+		s := benchPayload(n, rnd)
+		body, _ := json.Marshal(uppercaseRequest{s})
+		response := uppercaseResponse{V: strings.ToUpper(s)}
+		w := discardWriter{make(http.Header)}
+		r, _ := http.NewRequest(http.MethodPost, "/uppercase", nil)
+		paths := []struct {
+			codec, path string
+			run         func()
+		}{
+			{"decode", "json", func() {
+				var request uppercaseRequest
+				r.Body = io.NopCloser(bytes.NewReader(body))
+				json.NewDecoder(r.Body).Decode(&request)
+			}},
+			{"decode", "fast", func() {
+				var request uppercaseRequest
+				r.Body = io.NopCloser(bytes.NewReader(body))
+				decodeJSONBody(r, &request)
+			}},
+			{"encode", "json", func() { json.NewEncoder(w).Encode(response) }},
+			{"encode", "fast", func() { writeJSON(w, response) }},
+		}
+		for _, p := range paths {
+			run := p.run
+			b := testing.Benchmark(func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					run()
+				}
+			})
+			results = append(results, codecBenchResult{p.codec, n, p.path, b.NsPerOp(), b.AllocedBytesPerOp(), b.AllocsPerOp()})
+		}
+	}
+	if asJSON {
+		json.NewEncoder(stdout).Encode(results)
+		return 0
+	}
+	tw := tabwriter.NewWriter(stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "codec\tsize\tpath\tns/op\tB/op\tallocs/op\t")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%d\t%d\t%d\t\n", r.Codec, r.Size, r.Path, r.NsPerOp, r.BytesPerOp, r.AllocsPerOp)
+	}
+	tw.Flush()
+	return 0
+}
+
+// newBenchInstancer returns the instancer of the client.Discovery in the
+// JSON file at path.
+func newBenchInstancer(path string, stderr io.Writer) (sd.Instancer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var d client.Discovery
+	if err := json.Unmarshal(data, &d); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return client.NewInstancer(d, log.NewLogfmtLogger(stderr))
+}
+
+func printBenchResults(w io.Writer, results []benchResult, asJSON bool) error {
+	if asJSON {
+		return json.NewEncoder(w).Encode(results)
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "op\trequests\terrors\treq/s\tp50 ms\tp90 ms\tp99 ms\tmax ms\t")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f\t%.2f\t%.2f\t%.2f\t%.2f\t\n",
+			r.Op, r.Requests, r.Errors, r.Throughput, r.P50, r.P90, r.P99, r.Max)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	for _, r := range results {
+		if r.FirstError != "" && r.Op != "total" {
+			fmt.Fprintf(w, "%s: first error: %s\n", r.Op, r.FirstError)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,343 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/go-kit/kit/metrics"
+)
+
+//
+// ──────────────────────────────────────────────────── I ──────────
+//   :::::: B I N A R Y : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────────
+//
+
+// The binary transport serves the operations over raw TCP, for internal
+// callers that cannot afford the overhead of HTTP. Every frame starts with
+// its length as a big-endian uint32, not counting those 4 bytes, and with
+// the ID of its call as a big-endian uint32. Requests follow with the
+// length of the operation name as a byte, the name and the JSON request;
+// responses with a status byte, binaryOK or binaryFailed, and the JSON
+// response. Failed responses are {"err": ..., "code": ...} objects, like
+// over HTTP. Calls are answered as they complete, so that a connection can
+// carry several of them at once, matched by their IDs.
+//
+// A request to the operation binaryCredentialsOp, with a JSON object of an
+// "access_token" and an "api_key", sets the Credentials of the calls that
+// follow on its connection, and is answered with an empty object.
+
+// Statuses of response frames.
+const (
+	binaryOK     = 0
+	binaryFailed = 1
+)
+
+// binaryCredentialsOp is the name of the requests setting the credentials
+// of their connection, which no operation can have.
+const binaryCredentialsOp = ":credentials"
+
+const (
+	// defaultBinaryMaxFrameSize is the largest request frame by default.
+	defaultBinaryMaxFrameSize = 1 << 20
+	// defaultBinaryIdleTimeout closes the connections that have not sent a
+	// request for that long by default.
+	defaultBinaryIdleTimeout = 5 * time.Minute
+	// binaryWriteTimeout bounds the time spent sending a single response.
+	binaryWriteTimeout = 10 * time.Second
+	// binaryMaxPipelined bounds the calls of a connection being served at
+	// once. Further requests wait to be read.
+	binaryMaxPipelined = 128
+)
+
+// binaryMetrics are the metrics of the binary transport. The operations
+// themselves are measured by the service middlewares.
+type binaryMetrics struct {
+	Connections metrics.Gauge
+	// Calls counts the calls by operation, and whether they failed in
+	// "error".
+	Calls   metrics.Counter
+	Latency metrics.Histogram
+}
+
+// binaryServer serves the binary transport on its listener.
+type binaryServer struct {
+	ops          map[string]operation
+	auth         *callAuthenticator
+	maxFrameSize int
+	idleTimeout  time.Duration
+	metrics      binaryMetrics
+	logger       log.Logger
+	listener     net.Listener
+
+	draining atomic.Bool
+	mu       sync.Mutex
+	conns    map[net.Conn]struct{}
+	wg       sync.WaitGroup
+}
+
+// serveBinary listens on cfg.Addr, or on its Unix socket, and serves the
+// binary transport in the background. auth authenticates its calls.
+func serveBinary(cfg binaryConfig, ops map[string]operation, auth *callAuthenticator, m binaryMetrics, logger log.Logger) (*binaryServer, error) {
+	l, err := listen(cfg.Addr, cfg.UnixSocket)
+	if err != nil {
+		return nil, err
+	}
+	s := &binaryServer{
+		ops:          ops,
+		auth:         auth,
+		maxFrameSize: cfg.MaxFrameSize,
+		idleTimeout:  secondsOr(cfg.IdleTimeoutSeconds, defaultBinaryIdleTimeout),
+		metrics:      m,
+		logger:       logger,
+		listener:     l,
+		conns:        make(map[net.Conn]struct{}),
+	}
+	if s.maxFrameSize <= 0 {
+		s.maxFrameSize = defaultBinaryMaxFrameSize
+	}
+	go s.accept()
+	return s, nil
+}
+
+func (s *binaryServer) accept() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if s.draining.Load() {
+				return
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			level.Error(s.logger).Log("transport", "binary", "msg", "listener stopped", "err", err)
+			return
+		}
+		s.mu.Lock()
+		if s.draining.Load() {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go s.serve(conn)
+	}
+}
+
+// shutdown stops accepting connections and reading requests, and waits for
+// the calls being served to be answered before closing the connections, or
+// closes them when ctx is done.
+func (s *binaryServer) shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.draining.Store(true)
+	err := s.listener.Close()
+	for conn := range s.conns {
+		// Unblocks the reads of serve, which then waits for its calls.
+		conn.SetReadDeadline(time.Now())
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return err
+	case <-ctx.Done():
+		s.mu.Lock()
+		for conn := range s.conns {
+			conn.Close()
+		}
+		s.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// binaryConn is a connection being served, whose responses are written one
+// at a time.
+type binaryConn struct {
+	net.Conn
+	mu sync.Mutex
+	w  *bufio.Writer
+}
+
+// serve reads the requests of conn until it fails, is idle or the server
+// drains, and answers them.
+func (s *binaryServer) serve(conn net.Conn) {
+	c := &binaryConn{Conn: conn, w: bufio.NewWriter(conn)}
+	var calls sync.WaitGroup
+	s.metrics.Connections.Add(1)
+	defer func() {
+		calls.Wait()
+		s.metrics.Connections.Add(-1)
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+		s.wg.Done()
+	}()
+
+	r := bufio.NewReader(conn)
+	pipelined := make(chan struct{}, binaryMaxPipelined)
+	header := make([]byte, 8)
+	// credentials are those of the calls read next, set by the requests to
+	// binaryCredentialsOp.
+	var credentials Credentials
+	for {
+		conn.SetReadDeadline(time.Now().Add(s.idleTimeout))
+		// shutdown sets the deadline once draining, which either this read
+		// sees or this check does.
+		if s.draining.Load() {
+			return
+		}
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err != io.EOF && !s.draining.Load() {
+				level.Debug(s.logger).Log("transport", "binary", "remote", conn.RemoteAddr(), "err", err)
+			}
+			return
+		}
+		size := int(binary.BigEndian.Uint32(header)) - 4
+		id := binary.BigEndian.Uint32(header[4:])
+		if size < 1 {
+			level.Debug(s.logger).Log("transport", "binary", "remote", conn.RemoteAddr(), "err", "frame without operation")
+			return
+		}
+		if size > s.maxFrameSize {
+			if _, err := r.Discard(size); err != nil {
+				return
+			}
+			s.reply(c, id, "", time.Now(), nil, true, ErrTooLarge)
+			continue
+		}
+		frame := make([]byte, size)
+		if _, err := io.ReadFull(r, frame); err != nil {
+			return
+		}
+		if n := int(frame[0]); len(frame) > n && string(frame[1:1+n]) == binaryCredentialsOp {
+			// The calls read next have the credentials, so that they are
+			// set before reading on.
+			var next Credentials
+			err := decodeJSON(frame[1+n:], &next)
+			if err == nil {
+				credentials = next
+			} else {
+				err = malformedInputError{"credentials", err}
+			}
+			s.reply(c, id, binaryCredentialsOp, time.Now(), struct{}{}, false, err)
+			continue
+		}
+
+		pipelined <- struct{}{}
+		calls.Add(1)
+		go func(begin time.Time, credentials Credentials) {
+			defer func() {
+				<-pipelined
+				calls.Done()
+			}()
+			name, resp, failed, err := s.call(frame, credentials)
+			s.reply(c, id, name, begin, resp, failed, err)
+		}(time.Now(), credentials)
+	}
+}
+
+// call calls the operation of frame, the part of a request frame after its
+// ID, with credentials, and returns its name and response, which failed
+// when it has an "err".
+func (s *binaryServer) call(frame []byte, credentials Credentials) (name string, resp interface{}, failed bool, err error) {
+	n := int(frame[0])
+	if len(frame) < 1+n {
+		return "", nil, true, malformedInputError{"frame", errors.New("operation name past the end of the frame")}
+	}
+	name = string(frame[1 : 1+n])
+	op, ok := s.ops[name]
+	if !ok {
+		return "", nil, true, errorf(CodeUnknownOperation, "Unknown operation %q", name)
+	}
+	req := reflect.New(reflect.TypeOf(op.Request))
+	if err := decodeJSON(frame[1+n:], req.Interface()); err != nil {
+		return name, nil, true, malformedInputError{"request", err}
+	}
+	ctx, err := s.auth.authenticate(WithCredentials(context.Background(), credentials), name)
+	if err != nil {
+		return name, nil, true, err
+	}
+	ctx, codes := withErrorCodes(ctx)
+	if resp, err = op.Endpoint(ctx, req.Elem().Interface()); err != nil {
+		return name, nil, true, err
+	}
+	if hasErr(resp) {
+		resp, err = withErrorCode(resp, codes.Code())
+		return name, resp, true, err
+	}
+	return name, resp, false, nil
+}
+
+// reply writes the response frame of the call id, or its failure err, and
+// records the call. Calls of unknown operations have no name.
+func (s *binaryServer) reply(c *binaryConn, id uint32, name string, begin time.Time, resp interface{}, failed bool, err error) {
+	if err != nil {
+		resp = map[string]interface{}{"err": err.Error(), "code": errorCodeOf(err)}
+	}
+	status := byte(binaryOK)
+	if failed || err != nil {
+		status = binaryFailed
+	}
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+	frame := append(buf.AvailableBuffer(), 0, 0, 0, 0, 0, 0, 0, 0, status)
+	if f, ok := resp.(fastEncodable); ok {
+		frame, ok = f.appendJSON(frame)
+		if !ok {
+			frame = frame[:9]
+		}
+	}
+	if len(frame) == 9 {
+		data, err := json.Marshal(resp)
+		if err != nil {
+			data, _ = json.Marshal(map[string]interface{}{"err": err.Error(), "code": errorCodeOf(err)})
+			status = binaryFailed
+			frame[8] = status
+		}
+		frame = append(frame, data...)
+	}
+	binary.BigEndian.PutUint32(frame, uint32(len(frame)-4))
+	binary.BigEndian.PutUint32(frame[4:], id)
+
+	c.mu.Lock()
+	c.SetWriteDeadline(time.Now().Add(binaryWriteTimeout))
+	_, werr := c.w.Write(frame)
+	if werr == nil {
+		werr = c.w.Flush()
+	}
+	c.mu.Unlock()
+	if werr != nil {
+		level.Debug(s.logger).Log("transport", "binary", "remote", c.RemoteAddr(), "err", werr)
+		// The reads of serve fail too, which ends the connection.
+		c.Close()
+	}
+
+	if name == "" {
+		name = "unknown"
+	}
+	s.metrics.Calls.With("op", name, "error", strconv.FormatBool(status == binaryFailed)).Add(1)
+	s.metrics.Latency.With("op", name).Observe(time.Since(begin).Seconds())
+}
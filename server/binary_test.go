@@ -0,0 +1,128 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics/discard"
+)
+
+// binaryFrame returns a request frame calling op with body. Its length
+// field is size when positive.
+func binaryFrame(id uint32, op, body string, size int) []byte {
+	frame := make([]byte, 9, 9+len(op)+len(body))
+	binary.BigEndian.PutUint32(frame[4:], id)
+	frame[8] = byte(len(op))
+	frame = append(append(frame, op...), body...)
+	if size <= 0 {
+		size = len(frame) - 4
+	}
+	binary.BigEndian.PutUint32(frame, uint32(size))
+	return frame
+}
+
+func TestBinaryFraming(t *testing.T) {
+	tenants, err := newTenancy(tenancyConfig{}, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tenants.close()
+	ops := map[string]operation{
+		"uppercase": {
+			Endpoint: validated(makeUppercaseEndpoint(stringService{})),
+			Request:  uppercaseRequest{},
+			Response: uppercaseResponse{},
+		},
+	}
+	s, err := serveBinary(binaryConfig{Addr: "127.0.0.1:0", MaxFrameSize: 64}, ops, &callAuthenticator{tenants: tenants, rejected: discard.NewCounter()}, binaryMetrics{
+		Connections: discard.NewGauge(),
+		Calls:       discard.NewCounter(),
+		Latency:     discard.NewHistogram(),
+	}, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.shutdown(context.Background())
+
+	namePastTheEnd := binaryFrame(5, "up", "", 0)
+	namePastTheEnd[8] = 9
+
+	tests := []struct {
+		name  string
+		frame []byte
+		// wantClosed tells that the connection is closed rather than
+		// answered.
+		wantClosed bool
+		wantStatus byte
+		wantBody   string
+	}{
+		{name: "call", frame: binaryFrame(1, "uppercase", `{"s":"a"}`, 0), wantStatus: binaryOK, wantBody: `{"v":"A"}`},
+		{name: "invalid request", frame: binaryFrame(2, "uppercase", `{"s":""}`, 0), wantStatus: binaryFailed, wantBody: `"code":"ERR_EMPTY_INPUT"`},
+		{name: "malformed request", frame: binaryFrame(3, "uppercase", `{"s":`, 0), wantStatus: binaryFailed, wantBody: `"code":"ERR_MALFORMED_INPUT"`},
+		{name: "unknown operation", frame: binaryFrame(4, "lowercase", `{"s":"a"}`, 0), wantStatus: binaryFailed, wantBody: `"code":"ERR_UNKNOWN_OPERATION"`},
+		{name: "name past the end", frame: namePastTheEnd, wantStatus: binaryFailed, wantBody: `"code":"ERR_MALFORMED_INPUT"`},
+		{name: "credentials", frame: binaryFrame(6, binaryCredentialsOp, `{"api_key":"k"}`, 0), wantStatus: binaryOK, wantBody: `{}`},
+		{name: "frame too large", frame: binaryFrame(7, "uppercase", `{"s":"`+strings.Repeat("a", 64)+`"}`, 0), wantStatus: binaryFailed, wantBody: `"code":"ERR_TOO_LARGE"`},
+		{name: "frame without operation", frame: binaryFrame(8, "", "", 4)[:8], wantClosed: true},
+		{name: "truncated frame", frame: binaryFrame(9, "uppercase", `{"s":"a"}`, 100)[:20], wantClosed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", s.listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			if _, err := conn.Write(tt.frame); err != nil {
+				t.Fatal(err)
+			}
+			if frame := tt.frame; len(frame) >= 8 && int(binary.BigEndian.Uint32(frame))+4 > len(frame) {
+				// The server waits for the rest of the frame until the
+				// client is done writing.
+				conn.(*net.TCPConn).CloseWrite()
+			}
+			// A call follows, which is only answered when the connection
+			// survived the frame.
+			conn.Write(binaryFrame(100, "uppercase", `{"s":"b"}`, 0))
+
+			// Calls are answered as they complete, in any order.
+			r := bufio.NewReader(conn)
+			replies := make(map[uint32][]byte)
+			for len(replies) < 2 {
+				header := make([]byte, 9)
+				if _, err := io.ReadFull(r, header); err != nil {
+					break
+				}
+				body := make([]byte, binary.BigEndian.Uint32(header)-5)
+				if _, err := io.ReadFull(r, body); err != nil {
+					t.Fatal(err)
+				}
+				replies[binary.BigEndian.Uint32(header[4:])] = append(header[8:], body...)
+			}
+			if tt.wantClosed {
+				if len(replies) > 0 {
+					t.Fatalf("answered %d calls", len(replies))
+				}
+				return
+			}
+			reply, ok := replies[binary.BigEndian.Uint32(tt.frame[4:])]
+			if !ok {
+				t.Fatalf("not answered, %d calls answered", len(replies))
+			}
+			if reply[0] != tt.wantStatus || !strings.Contains(string(reply[1:]), tt.wantBody) {
+				t.Errorf("status %d %s, want %d %s", reply[0], reply[1:], tt.wantStatus, tt.wantBody)
+			}
+			if _, ok := replies[100]; !ok {
+				t.Error("next call not answered")
+			}
+		})
+	}
+}
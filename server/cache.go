@@ -0,0 +1,271 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/go-kit/kit/metrics"
+	"github.com/redis/go-redis/v9"
+)
+
+//
+// ────────────────────────────────────────────────── I ──────────
+//   :::::: C A C H E : :  :   :    :     :        :          :
+// ────────────────────────────────────────────────────────────
+//
+
+// Defaults of the "cache" config section.
+const (
+	defaultCacheTTL       = 5 * time.Minute
+	defaultCacheKeyPrefix = "stringsvc:cache:"
+)
+
+// defaultCachedOperations are cached when the config lists none.
+var defaultCachedOperations = []string{"uppercase", "hash", "slugify"}
+
+// cacheableOperations are the operations whose result only depends on
+// their request, the only ones that can be cached.
+var cacheableOperations = map[string]bool{
+	"uppercase": true, "count": true, "hash": true, "encode": true,
+	"decode": true, "urlencode": true, "urldecode": true, "html": true,
+	"slugify": true, "convertcase": true, "similarity": true,
+	"palindrome": true, "anagram": true, "stats": true,
+	"detect-language": true, "transliterate": true, "cipher": true,
+	"diff": true, "inflect": true, "truncate": true, "pad": true,
+	"redact": true, "moderate": true, "wordfreq": true, "tokenize": true,
+	"stem": true, "normalize-unicode": true, "recode": true, "numwords": true,
+	"json": true, "csv-parse": true, "csv-format": true, "expand": true, "emoji": true, "wrap": true, "phonetic": true,
+}
+
+// cacheBypassHeader makes a request skip the cached result, which is then
+// replaced by the fresh one, when set to "true".
+const cacheBypassHeader = "X-Cache-Bypass"
+
+type resultCacheMetrics struct {
+	Hits      metrics.Counter
+	Misses    metrics.Counter
+	HitRatio  metrics.Gauge
+	Evictions metrics.Counter
+}
+
+// CacheStore keeps the cached responses. Get reports a missing key with a
+// false ok and no error.
+type CacheStore interface {
+	Get(ctx context.Context, key string) (value []byte, ok bool, err error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+}
+
+// redisStore is a CacheStore shared by every instance.
+type redisStore struct {
+	client *redis.Client
+}
+
+func (s redisStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, err := s.client.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	return value, err == nil, err
+}
+
+func (s redisStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return s.client.Set(ctx, key, value, ttl).Err()
+}
+
+// resultCache memoizes the responses of operations, keyed by the operation
+// and the hash of its request, tenant and version, the fingerprint of the
+// configuration, like ETags. A nil *resultCache caches nothing.
+type resultCache struct {
+	store     CacheStore
+	version   string
+	ttls      map[string]time.Duration
+	responses map[string]reflect.Type
+	metrics   resultCacheMetrics
+	logger    log.Logger
+}
+
+// newCacheStore returns the store described by cfg: in Redis when a server
+// is configured, else in memory when it is bounded, else nil.
+func newCacheStore(cfg cacheConfig, evictions metrics.Counter) CacheStore {
+	switch {
+	case cfg.Addr != "":
+		return redisStore{redis.NewClient(&redis.Options{
+			Addr:     cfg.Addr,
+			Password: cfg.Password,
+			DB:       cfg.DB,
+		})}
+	case cfg.MaxEntries > 0 || cfg.MaxBytes > 0:
+		return newLRUStore(cfg.MaxEntries, cfg.MaxBytes, evictions)
+	}
+	return nil
+}
+
+// newResultCache returns the cache of the operations of cfg in store, or
+// nil without a store.
+func newResultCache(cfg cacheConfig, store CacheStore, version string, ops map[string]operation, m resultCacheMetrics, logger log.Logger) (*resultCache, error) {
+	if store == nil {
+		return nil, nil
+	}
+	ttl := defaultCacheTTL
+	if cfg.TTLSeconds > 0 {
+		ttl = time.Duration(cfg.TTLSeconds) * time.Second
+	}
+	operations := cfg.Operations
+	if len(operations) == 0 {
+		operations = make(map[string]int, len(defaultCachedOperations))
+		for _, name := range defaultCachedOperations {
+			operations[name] = 0
+		}
+	}
+
+	c := &resultCache{
+		store:     store,
+		version:   version,
+		ttls:      make(map[string]time.Duration, len(operations)),
+		responses: make(map[string]reflect.Type, len(operations)),
+		metrics:   m,
+		logger:    log.With(logger, "component", "cache"),
+	}
+	for name, seconds := range operations {
+		if !cacheableOperations[name] {
+			return nil, fmt.Errorf("operation %q cannot be cached", name)
+		}
+		c.ttls[name] = ttl
+		if seconds > 0 {
+			c.ttls[name] = time.Duration(seconds) * time.Second
+		}
+		c.responses[name] = reflect.TypeOf(ops[name].Response)
+	}
+	return c, nil
+}
+
+type cacheBypassKey struct{}
+
+// cacheBypassFromHTTP is a ServerBefore function keeping whether the
+// request asked to bypass the cache.
+func cacheBypassFromHTTP(ctx context.Context, r *http.Request) context.Context {
+	if r.Header.Get(cacheBypassHeader) == "true" {
+		return context.WithValue(ctx, cacheBypassKey{}, true)
+	}
+	return ctx
+}
+
+type cacheOffKey struct{}
+
+// withoutCache serves the requests without reading or writing the cache,
+// while the flag "middleware.cache" is off.
+func withoutCache(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), cacheOffKey{}, true)))
+	})
+}
+
+// cached serves the responses of the operation name from the cache, and
+// caches the successful responses of next. Store failures are logged and
+// the request is served by next, the cache being an optimization only.
+func (c *resultCache) cached(name string, next endpoint.Endpoint) endpoint.Endpoint {
+	if c == nil {
+		return next
+	}
+	ttl, ok := c.ttls[name]
+	if !ok {
+		return next
+	}
+	responseType := c.responses[name]
+	var ratio hitRatio
+	ratioGauge := c.metrics.HitRatio.With("op", name)
+	hits := c.metrics.Hits.With("op", name)
+	misses := c.metrics.Misses.With("op", name)
+
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		if off, _ := ctx.Value(cacheOffKey{}).(bool); off {
+			return next(ctx, request)
+		}
+		key, err := c.key(ctx, name, request)
+		if err != nil {
+			return next(ctx, request)
+		}
+
+		if bypass, _ := ctx.Value(cacheBypassKey{}).(bool); !bypass {
+			cachedData, ok, err := c.store.Get(ctx, key)
+			if err != nil {
+				level.Error(c.logger).Log("op", name, "msg", "cannot read cache", "err", err)
+			}
+			if ok {
+				response := reflect.New(responseType)
+				if err := json.Unmarshal(cachedData, response.Interface()); err == nil {
+					hits.Add(1)
+					ratioGauge.Set(ratio.record(true))
+					return response.Elem().Interface(), nil
+				}
+			}
+		}
+		misses.Add(1)
+		ratioGauge.Set(ratio.record(false))
+
+		response, err := next(ctx, request)
+		if err != nil || hasErr(response) {
+			return response, err
+		}
+		if data, err := json.Marshal(response); err == nil {
+			if err := c.store.Set(ctx, key, data, ttl); err != nil {
+				level.Error(c.logger).Log("op", name, "msg", "cannot write cache", "err", err)
+			}
+		}
+		return response, nil
+	}
+}
+
+// key returns the key of the response of the operation name to request.
+func (c *resultCache) key(ctx context.Context, name string, request interface{}) (string, error) {
+	data, err := json.Marshal(request)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	for _, part := range []string{c.version, tenantFrom(ctx)} {
+		io.WriteString(h, part)
+		h.Write([]byte{0})
+	}
+	h.Write(data)
+	return defaultCacheKeyPrefix + name + ":" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hasErr reports whether response carries an error in its Err field, the
+// way the service reports failures.
+func hasErr(response interface{}) bool {
+	v := reflect.ValueOf(response)
+	if v.Kind() != reflect.Struct {
+		return false
+	}
+	f := v.FieldByName("Err")
+	return f.IsValid() && f.Kind() == reflect.String && f.String() != ""
+}
+
+// hitRatio counts the lookups of an operation to report its hit ratio.
+type hitRatio struct {
+	mu           sync.Mutex
+	hits, misses int
+}
+
+func (r *hitRatio) record(hit bool) float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if hit {
+		r.hits++
+	} else {
+		r.misses++
+	}
+	return float64(r.hits) / float64(r.hits+r.misses)
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics/discard"
+)
+
+// cacheCall is a request of TestResultCache, made to the cache of the
+// configuration version.
+type cacheCall struct {
+	version, tenant, op, s string
+	wantCalls              int
+}
+
+func TestResultCache(t *testing.T) {
+	tests := []struct {
+		name  string
+		calls []cacheCall
+	}{
+		{
+			name: "same request",
+			calls: []cacheCall{
+				{version: "v1", op: "uppercase", s: "a", wantCalls: 1},
+				{version: "v1", op: "uppercase", s: "a", wantCalls: 1},
+			},
+		},
+		{
+			name: "other request",
+			calls: []cacheCall{
+				{version: "v1", op: "uppercase", s: "a", wantCalls: 1},
+				{version: "v1", op: "uppercase", s: "b", wantCalls: 2},
+			},
+		},
+		{
+			name: "other operation",
+			calls: []cacheCall{
+				{version: "v1", op: "uppercase", s: "a", wantCalls: 1},
+				{version: "v1", op: "slugify", s: "a", wantCalls: 2},
+			},
+		},
+		{
+			name: "other tenant",
+			calls: []cacheCall{
+				{version: "v1", tenant: "acme", op: "uppercase", s: "a", wantCalls: 1},
+				{version: "v1", tenant: "globex", op: "uppercase", s: "a", wantCalls: 2},
+				{version: "v1", tenant: "acme", op: "uppercase", s: "a", wantCalls: 2},
+			},
+		},
+		{
+			name: "other configuration",
+			calls: []cacheCall{
+				{version: "v1", op: "uppercase", s: "a", wantCalls: 1},
+				{version: "v2", op: "uppercase", s: "a", wantCalls: 2},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newLRUStore(100, 0, discard.NewCounter())
+			ops := map[string]operation{
+				"uppercase": {Response: uppercaseResponse{}},
+				"slugify":   {Response: uppercaseResponse{}},
+			}
+			calls := 0
+			next := func(_ context.Context, request interface{}) (interface{}, error) {
+				calls++
+				return uppercaseResponse{V: request.(uppercaseRequest).S}, nil
+			}
+			for i, call := range tt.calls {
+				c, err := newResultCache(cacheConfig{Operations: map[string]int{"uppercase": 0, "slugify": 0}}, store, call.version, ops, resultCacheMetrics{
+					Hits:     discard.NewCounter(),
+					Misses:   discard.NewCounter(),
+					HitRatio: discard.NewGauge(),
+				}, log.NewNopLogger())
+				if err != nil {
+					t.Fatal(err)
+				}
+				ctx := context.WithValue(context.Background(), tenantKey{}, call.tenant)
+				response, err := c.cached(call.op, next)(ctx, uppercaseRequest{S: call.s})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if v := response.(uppercaseResponse).V; v != call.s {
+					t.Errorf("call %d: response %q, want %q", i, v, call.s)
+				}
+				if calls != call.wantCalls {
+					t.Errorf("call %d: %d calls of the operation, want %d", i, calls, call.wantCalls)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/go-kit/kit/metrics"
+)
+
+//
+// ────────────────────────────────────────────────── I ──────────
+//   :::::: C H A O S : :  :   :    :     :        :          :
+// ────────────────────────────────────────────────────────────
+//
+
+// defaultChaosErrorStatus is the status of injected errors.
+const defaultChaosErrorStatus = http.StatusServiceUnavailable
+
+// errInjectedFault is the error of the injected error responses.
+var errInjectedFault = errors.New("Injected fault")
+
+// chaos injects faults in requests, to test how clients cope with them. Its
+// settings can be changed while serving.
+type chaos struct {
+	settings atomic.Pointer[chaosConfig]
+	injected metrics.Counter
+	logger   log.Logger
+}
+
+func newChaos(cfg chaosConfig, injected metrics.Counter, logger log.Logger) (*chaos, error) {
+	c := &chaos{injected: injected, logger: logger}
+	return c, c.set(cfg)
+}
+
+func (c *chaos) set(cfg chaosConfig) error {
+	if err := validateRequest(cfg); err != nil {
+		return err
+	}
+	c.settings.Store(&cfg)
+	if cfg.enabled() {
+		level.Warn(c.logger).Log("msg", "injecting faults",
+			"latency_rate", cfg.LatencyRate, "latency_ms", cfg.LatencyMS,
+			"error_rate", cfg.ErrorRate, "error_status", cfg.ErrorStatus,
+			"drop_rate", cfg.DropRate, "routes", len(cfg.Routes),
+		)
+	}
+	return nil
+}
+
+func (cfg chaosConfig) enabled() bool {
+	return cfg.LatencyRate > 0 || cfg.ErrorRate > 0 || cfg.DropRate > 0
+}
+
+// withChaos delays, fails or drops the responses to a share of requests,
+// as set in c. Each fault is drawn independently, so a delayed request can
+// also fail. Dropped requests are served, but the connection is closed
+// instead of answering, like when a server crashes.
+func withChaos(next http.Handler, c *chaos) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := c.settings.Load()
+		if !cfg.enabled() || (len(cfg.Routes) > 0 && !contains(cfg.Routes, r.URL.Path)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if rand.Float64() < cfg.LatencyRate {
+			c.injected.With("fault", "latency").Add(1)
+			select {
+			case <-time.After(time.Duration(cfg.LatencyMS) * time.Millisecond):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if rand.Float64() < cfg.ErrorRate {
+			c.injected.With("fault", "error").Add(1)
+			status := cfg.ErrorStatus
+			if status == 0 {
+				status = defaultChaosErrorStatus
+			}
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(map[string]interface{}{"err": errInjectedFault.Error(), "code": CodeFaultInjected})
+			return
+		}
+		if rand.Float64() < cfg.DropRate {
+			c.injected.With("fault", "drop").Add(1)
+			if r.Header.Get("Upgrade") == "" {
+				next.ServeHTTP(discardWriter{make(http.Header)}, r)
+			}
+			panic(http.ErrAbortHandler)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// discardWriter is the writer of the responses to drop.
+type discardWriter struct {
+	header http.Header
+}
+
+func (w discardWriter) Header() http.Header         { return w.header }
+func (w discardWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (w discardWriter) WriteHeader(int)             {}
+
+// makeChaosHandler answers the fault settings of c on GET, and replaces
+// them on PUT with a body like the "chaos" config section. An empty object
+// stops injecting faults.
+func makeChaosHandler(c *chaos) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var cfg chaosConfig
+			dec := json.NewDecoder(r.Body)
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(&cfg); err != nil {
+				encodeError(r.Context(), malformedInputError{"request", err}, w)
+				return
+			}
+			if err := c.set(cfg); err != nil {
+				encodeError(r.Context(), err, w)
+				return
+			}
+		default:
+			w.Header().Set("Allow", "GET, PUT")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		encodeResponse(r.Context(), w, c.settings.Load())
+	})
+}
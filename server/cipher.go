@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+//
+// ──────────────────────────────────────────────────── I ──────────
+//   :::::: C I P H E R : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────────
+//
+
+// classicalCipher encrypts s with key, or decrypts it when decrypt is set.
+// Ciphers only touch ASCII letters; everything else is passed through.
+type classicalCipher func(s, key string, decrypt bool) (string, error)
+
+// ciphers holds every scheme the Cipher method knows about, keyed by the
+// name clients send in the "scheme" field.
+var ciphers = map[string]classicalCipher{
+	"rot13": func(s, _ string, _ bool) (string, error) {
+		return shiftLetters(s, func(int) int { return 13 }), nil
+	},
+	"caesar":   caesar,
+	"vigenere": vigenere,
+	"atbash": func(s, _ string, _ bool) (string, error) {
+		return mapLetters(s, func(_ int, x int) int { return 25 - x }), nil
+	},
+}
+
+// RegisterCipher makes a new scheme available to the /cipher endpoint. It is
+// meant to be called from init functions, before the server starts.
+func RegisterCipher(name string, c classicalCipher) {
+	ciphers[name] = c
+}
+
+type cipherRequest struct {
+	S       string `json:"s"`
+	Scheme  string `json:"scheme" validate:"max=64,charset=printable"`
+	Key     string `json:"key"`
+	Decrypt bool   `json:"decrypt"`
+}
+
+type cipherResponse struct {
+	V   string `json:"v"`
+	Err string `json:"err,omitempty"`
+}
+
+func (stringService) Cipher(ctx context.Context, s, scheme, key string, decrypt bool) (string, error) {
+	c, ok := ciphers[scheme]
+	if !ok {
+		return "", errorf(CodeUnknownOption, "Unknown cipher %q", scheme)
+	}
+	return c(s, key, decrypt)
+}
+
+// caesar shifts every letter by the number of positions given in key.
+func caesar(s, key string, decrypt bool) (string, error) {
+	shift, err := strconv.Atoi(key)
+	if err != nil {
+		return "", errorf(CodePatternInvalid, "Caesar key must be an integer shift")
+	}
+	if decrypt {
+		shift = -shift
+	}
+	return shiftLetters(s, func(int) int { return shift }), nil
+}
+
+// vigenere shifts each letter by the matching letter of key, which is
+// repeated as needed. Only letters of s consume key letters.
+func vigenere(s, key string, decrypt bool) (string, error) {
+	var shifts []int
+	for _, r := range strings.ToLower(key) {
+		if r < 'a' || r > 'z' {
+			return "", errorf(CodePatternInvalid, "Vigenère key must only contain letters")
+		}
+		shift := int(r - 'a')
+		if decrypt {
+			shift = -shift
+		}
+		shifts = append(shifts, shift)
+	}
+	if len(shifts) == 0 {
+		return "", errorf(CodePatternInvalid, "Vigenère key must not be empty")
+	}
+	return shiftLetters(s, func(i int) int { return shifts[i%len(shifts)] }), nil
+}
+
+// shiftLetters rotates the i-th letter of s by shift(i) positions.
+func shiftLetters(s string, shift func(i int) int) string {
+	return mapLetters(s, func(i, x int) int {
+		return ((x+shift(i))%26 + 26) % 26
+	})
+}
+
+// mapLetters replaces the i-th ASCII letter of s, seen as its position x in
+// the alphabet, with the letter at position fn(i, x), preserving case.
+func mapLetters(s string, fn func(i, x int) int) string {
+	i := 0
+	return strings.Map(func(r rune) rune {
+		if r > unicode.MaxASCII || !unicode.IsLetter(r) {
+			return r
+		}
+		base := 'a'
+		if unicode.IsUpper(r) {
+			base = 'A'
+		}
+		out := base + rune(fn(i, int(r-base)))
+		i++
+		return out
+	}, s)
+}
+
+func makeCipherEndpoint(svc IStringService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(cipherRequest)
+		v, err := svc.Cipher(ctx, req.S, req.Scheme, req.Key, req.Decrypt)
+		if err != nil {
+			return cipherResponse{"", err.Error()}, nil
+		}
+		return cipherResponse{v, ""}, nil
+	}
+}
+
+func decodeCipherRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request cipherRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+//
+// ─── LOGGING ────────────────────────────────────────────────────────────────────
+//
+
+func (mw loggingMiddleware) Cipher(ctx context.Context, s, scheme, key string, decrypt bool) (output string, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "cipher",
+			"tenant", tenantFrom(ctx),
+			"input", s,
+			"scheme", scheme,
+			"decrypt", decrypt,
+			"output", output,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	output, err = mw.next.Cipher(ctx, s, scheme, key, decrypt)
+	return
+}
+
+//
+// ─── INSTRUMENTATION ────────────────────────────────────────────────────────────
+//
+
+func (mw instrumentingMiddleware) Cipher(ctx context.Context, s, scheme, key string, decrypt bool) (output string, err error) {
+	done := mw.begin(ctx, "cipher", s)
+	defer func() { done(len(output), err) }()
+
+	output, err = mw.next.Cipher(ctx, s, scheme, key, decrypt)
+	return
+}
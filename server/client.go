@@ -0,0 +1,242 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	httptransport "github.com/go-kit/kit/transport/http"
+)
+
+//
+// ──────────────────────────────────────────────────── I ──────────
+//   :::::: C L I E N T : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────────
+//
+
+// clientUsage is printed by "stringsvc client -h".
+const clientUsage = `Usage: stringsvc client <op> [s] [flags]
+
+Calls <op> on a running stringsvc and prints the "v" of its response. When
+the operation takes an s that is not given, every line of stdin is sent as
+the "s" of its own request.
+Other request fields are set with -set, e.g.
+  stringsvc client hash hello -set algorithm=sha256 -addr host:8080
+
+Flags:
+`
+
+// clientFields collects repeated -set key=value flags. Values that are
+// valid JSON, like numbers, booleans or lists, are sent as such and any
+// other value as a string.
+type clientFields map[string]interface{}
+
+func (f clientFields) String() string { return "" }
+
+func (f clientFields) Set(kv string) error {
+	i := strings.IndexByte(kv, '=')
+	if i <= 0 {
+		return fmt.Errorf("%q is not key=value", kv)
+	}
+	var v interface{}
+	if err := json.Unmarshal([]byte(kv[i+1:]), &v); err != nil {
+		v = kv[i+1:]
+	}
+	f[kv[:i]] = v
+	return nil
+}
+
+// RunClient implements the client subcommand and returns its exit code:
+// 1 when a request fails, 2 on usage errors.
+func RunClient(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("client", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, clientUsage)
+		fs.PrintDefaults()
+	}
+	addr := fs.String("addr", "localhost:8080", "address of the service")
+	asJSON := fs.Bool("json", false, "print whole JSON responses instead of their \"v\"")
+	timeout := fs.Duration("timeout", 10*time.Second, "timeout of each request")
+	fields := clientFields{}
+	fs.Var(fields, "set", "set a request field, as key=value (repeatable)")
+
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return 2
+	}
+	if len(positional) == 0 || len(positional) > 2 {
+		fs.Usage()
+		return 2
+	}
+
+	// The endpoints of the operations are never called here; the service
+	// is only needed to build them.
+	ops := newOperations(nil)
+	name := positional[0]
+	op, ok := ops[name]
+	if !ok {
+		fmt.Fprintf(stderr, "Unknown operation %q, expected one of: %s\n", name, strings.Join(operationNames(ops), ", "))
+		return 2
+	}
+	target := *addr
+	if !strings.Contains(target, "://") {
+		target = "http://" + target
+	}
+	u, err := url.Parse(strings.TrimSuffix(target, "/") + operationRoute(name))
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	call := makeClientEndpoint(u, op)
+
+	run := func(s *string) bool {
+		req := make(map[string]interface{}, len(fields)+1)
+		for k, v := range fields {
+			req[k] = v
+		}
+		if s != nil {
+			req["s"] = *s
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		defer cancel()
+		resp, err := call(ctx, req)
+		if err == nil {
+			err = printClientResponse(stdout, resp, *asJSON)
+		}
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return false
+		}
+		return true
+	}
+
+	if len(positional) == 2 || !readsStdin(op, fields) {
+		var s *string
+		if len(positional) == 2 {
+			s = &positional[1]
+		}
+		if !run(s) {
+			return 1
+		}
+		return 0
+	}
+	code := 0
+	scanner := bufio.NewScanner(stdin)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !run(&line) {
+			code = 1
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return code
+}
+
+// readsStdin reports whether the requests of op need an "s" that is not in
+// fields.
+func readsStdin(op operation, fields clientFields) bool {
+	if _, ok := fields["s"]; ok {
+		return false
+	}
+	f, ok := reflect.TypeOf(op.Request).FieldByName("S")
+	return ok && jsonFieldName(f) == "s"
+}
+
+// parseInterspersed parses flags wherever they appear among the positional
+// arguments, which the flag package alone stops at.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+func operationNames(ops map[string]operation) []string {
+	names := make([]string, 0, len(ops))
+	for name := range ops {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// makeClientEndpoint returns an endpoint that POSTs its request to u and
+// decodes the response of op.
+func makeClientEndpoint(u *url.URL, op operation) endpoint.Endpoint {
+	return httptransport.NewClient(
+		http.MethodPost,
+		u,
+		httptransport.EncodeJSONRequest,
+		makeClientResponseDecoder(op),
+	).Endpoint()
+}
+
+// makeClientResponseDecoder decodes responses into the response type of op.
+// The "err" of responses is returned as the error, whatever their status.
+func makeClientResponseDecoder(op operation) httptransport.DecodeResponseFunc {
+	return func(_ context.Context, r *http.Response) (interface{}, error) {
+		resp := reflect.New(reflect.TypeOf(op.Response))
+		if err := json.NewDecoder(r.Body).Decode(resp.Interface()); err != nil {
+			if r.StatusCode != http.StatusOK {
+				return nil, errors.New(r.Status)
+			}
+			return nil, err
+		}
+		if msg := resp.Elem().FieldByName("Err"); msg.IsValid() && msg.String() != "" {
+			return nil, errors.New(msg.String())
+		}
+		if r.StatusCode != http.StatusOK {
+			return nil, errors.New(r.Status)
+		}
+		return resp.Elem().Interface(), nil
+	}
+}
+
+// printClientResponse prints the whole response as JSON when asJSON is set,
+// and its "v" otherwise: strings as they are, anything else as JSON.
+// Responses without a "v" are always printed whole.
+func printClientResponse(w io.Writer, resp interface{}, asJSON bool) error {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	if !asJSON {
+		var result operationResult
+		if err := json.Unmarshal(data, &result); err != nil {
+			return err
+		}
+		var s string
+		if json.Unmarshal(result.V, &s) == nil {
+			_, err := fmt.Fprintln(w, s)
+			return err
+		}
+		if len(result.V) > 0 {
+			data = result.V
+		}
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
@@ -0,0 +1,289 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+//
+// ──────────────────────────────────────────────────── I ──────────
+//   :::::: C O D E C S : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────────
+//
+
+// ErrUnsupportedMediaType is returned for request bodies of a content type
+// no codec can decode.
+var ErrUnsupportedMediaType = errors.New("Unsupported media type")
+
+// BodyCodec converts between the body of a request or response and its
+// JSON data model: maps, lists, strings, bool, int64 and float64 values.
+// Requests and responses are defined with JSON tags only, and every codec
+// works on that model, so field names are the same in every format.
+type BodyCodec struct {
+	Marshal func(v interface{}) ([]byte, error)
+	// Unmarshal is nil for codecs that are only used for responses.
+	Unmarshal func(data []byte) (interface{}, error)
+}
+
+// bodyCodecs holds the codecs used besides JSON, keyed by media type.
+// Protobuf bodies are google.protobuf.Struct messages.
+var bodyCodecs = map[string]BodyCodec{
+	"application/xml": {Marshal: marshalXML},
+	"text/xml":        {Marshal: marshalXML},
+	"application/msgpack": {
+		Marshal:   msgpack.Marshal,
+		Unmarshal: unmarshalMsgpack,
+	},
+	"application/x-msgpack": {
+		Marshal:   msgpack.Marshal,
+		Unmarshal: unmarshalMsgpack,
+	},
+	"application/x-protobuf": {
+		Marshal:   marshalProtobuf,
+		Unmarshal: unmarshalProtobuf,
+	},
+	"application/protobuf": {
+		Marshal:   marshalProtobuf,
+		Unmarshal: unmarshalProtobuf,
+	},
+}
+
+// RegisterBodyCodec makes c available for requests and responses of the
+// given media type.
+func RegisterBodyCodec(mediaType string, c BodyCodec) {
+	bodyCodecs[mediaType] = c
+}
+
+type responseCodecKey struct{}
+
+// withBodyCodecs negotiates the codecs of every request. Bodies of another
+// type than JSON are converted to JSON before reaching the handlers, and
+// the codec of the response, picked from the Accept header, is kept in the
+// request context for encodeResponse and encodeError.
+func withBodyCodecs(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if mediaType, ok := acceptedCodec(r.Header.Get("Accept")); ok {
+			r = r.WithContext(context.WithValue(r.Context(), responseCodecKey{}, mediaType))
+		}
+
+		if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil {
+			if c, ok := bodyCodecs[mediaType]; ok {
+				if err := transcodeBody(r, c); err != nil {
+					encodeError(r.Context(), err, w)
+					return
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// acceptedCodec returns the first media type of accept that has a codec,
+// by decreasing quality. JSON, including */*, needs no codec.
+func acceptedCodec(accept string) (string, bool) {
+	type mediaRange struct {
+		mediaType string
+		q         float64
+	}
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			q, _ = strconv.ParseFloat(v, 64)
+		}
+		ranges = append(ranges, mediaRange{mediaType, q})
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+
+	for _, mr := range ranges {
+		if mr.q <= 0 {
+			continue
+		}
+		switch mr.mediaType {
+		case "application/json", "*/*", "application/*":
+			return "", false
+		}
+		if _, ok := bodyCodecs[mr.mediaType]; ok {
+			return mr.mediaType, true
+		}
+	}
+	return "", false
+}
+
+// transcodeBody replaces the body of r with its JSON equivalent.
+func transcodeBody(r *http.Request, c BodyCodec) error {
+	if c.Unmarshal == nil {
+		return ErrUnsupportedMediaType
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	v, err := c.Unmarshal(data)
+	if err != nil {
+		return malformedInputError{r.Header.Get("Content-Type"), err}
+	}
+	if data, err = json.Marshal(v); err != nil {
+		return malformedInputError{r.Header.Get("Content-Type"), err}
+	}
+	r.Body = io.NopCloser(bytes.NewReader(data))
+	r.ContentLength = int64(len(data))
+	r.Header.Set("Content-Type", "application/json")
+	return nil
+}
+
+// responseCodec returns the codec negotiated for the response, if any.
+func responseCodec(ctx context.Context) (string, BodyCodec, bool) {
+	mediaType, ok := ctx.Value(responseCodecKey{}).(string)
+	if !ok {
+		return "", BodyCodec{}, false
+	}
+	c, ok := bodyCodecs[mediaType]
+	return mediaType, c, ok
+}
+
+// writeWithCodec writes response with c, after converting it to the JSON
+// data model.
+func writeWithCodec(w http.ResponseWriter, mediaType string, c BodyCodec, code int, response interface{}) error {
+	v, err := jsonDataModel(response)
+	if err != nil {
+		return err
+	}
+	data, err := c.Marshal(v)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", mediaType)
+	w.WriteHeader(code)
+	_, err = w.Write(data)
+	return err
+}
+
+// jsonDataModel returns v as it would be decoded from its JSON encoding,
+// with integers kept apart from floats.
+func jsonDataModel(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var m interface{}
+	if err := dec.Decode(&m); err != nil {
+		return nil, err
+	}
+	return resolveNumbers(m), nil
+}
+
+func resolveNumbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			v[k] = resolveNumbers(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = resolveNumbers(item)
+		}
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	}
+	return v
+}
+
+func unmarshalMsgpack(data []byte) (interface{}, error) {
+	var v interface{}
+	err := msgpack.Unmarshal(data, &v)
+	return v, err
+}
+
+func marshalProtobuf(v interface{}) ([]byte, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("cannot encode %T as a protobuf Struct", v)
+	}
+	s, err := structpb.NewStruct(m)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(s)
+}
+
+func unmarshalProtobuf(data []byte) (interface{}, error) {
+	var s structpb.Struct
+	if err := proto.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	return s.AsMap(), nil
+}
+
+// marshalXML encodes v as a <response> element, with an element per field
+// and <item> elements for list items.
+func marshalXML(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	if err := encodeXMLValue(enc, "response", v); err != nil {
+		return nil, err
+	}
+	if err := enc.Flush(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func encodeXMLValue(enc *xml.Encoder, name string, v interface{}) error {
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		if err := enc.EncodeToken(start); err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if err := encodeXMLValue(enc, k, v[k]); err != nil {
+				return err
+			}
+		}
+		return enc.EncodeToken(start.End())
+	case []interface{}:
+		if err := enc.EncodeToken(start); err != nil {
+			return err
+		}
+		for _, item := range v {
+			if err := encodeXMLValue(enc, "item", item); err != nil {
+				return err
+			}
+		}
+		return enc.EncodeToken(start.End())
+	case nil:
+		return enc.EncodeElement("", start)
+	}
+	return enc.EncodeElement(fmt.Sprint(v), start)
+}
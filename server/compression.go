@@ -0,0 +1,256 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/klauspost/compress/zstd"
+)
+
+//
+// ────────────────────────────────────────────────────────────── I ──────────
+//   :::::: C O M P R E S S I O N : :  :   :    :     :        :          :
+// ────────────────────────────────────────────────────────────────────────
+//
+
+// Size limits for Compress and Decompress. maxDecompressedSize is what
+// protects the service against decompression bombs: decompression stops as
+// soon as the output grows past it.
+const (
+	maxCompressInputSize = 8 << 20
+	maxDecompressedSize  = 8 << 20
+)
+
+// ErrTooLarge is returned when an input or output exceeds the size limits.
+var ErrTooLarge = errors.New("Payload too large")
+
+// compressionCodec knows how to wrap a writer with a compressor at a given
+// level (0 meaning the codec default) and a reader with a decompressor.
+type compressionCodec struct {
+	NewWriter func(w io.Writer, level int) (io.WriteCloser, error)
+	NewReader func(r io.Reader) (io.ReadCloser, error)
+}
+
+// compressionCodecs holds every algorithm Compress and Decompress know
+// about, keyed by the name clients send in the "algorithm" field.
+var compressionCodecs = map[string]compressionCodec{
+	"gzip": {
+		NewWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+			if level == 0 {
+				level = gzip.DefaultCompression
+			}
+			return gzip.NewWriterLevel(w, level)
+		},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+	},
+	"zstd": {
+		NewWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+			opts := []zstd.EOption{zstd.WithEncoderConcurrency(1)}
+			if level != 0 {
+				opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+			}
+			return zstd.NewWriter(w, opts...)
+		},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			d, err := zstd.NewReader(r,
+				zstd.WithDecoderConcurrency(1),
+				zstd.WithDecoderMaxMemory(maxDecompressedSize),
+			)
+			if err != nil {
+				return nil, err
+			}
+			return d.IOReadCloser(), nil
+		},
+	},
+	"brotli": {
+		NewWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+			if level == 0 {
+				level = brotli.DefaultCompression
+			}
+			if level < brotli.BestSpeed || level > brotli.BestCompression {
+				return nil, errorf(CodeOutOfRange, "Brotli level must be between %d and %d", brotli.BestSpeed, brotli.BestCompression)
+			}
+			return brotli.NewWriterLevel(w, level), nil
+		},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			return io.NopCloser(brotli.NewReader(r)), nil
+		},
+	},
+}
+
+type compressRequest struct {
+	S         string `json:"s"`
+	Algorithm string `json:"algorithm" validate:"max=64,charset=printable"`
+	Level     int    `json:"level"`
+}
+
+type compressResponse struct {
+	V   string `json:"v"`
+	Err string `json:"err,omitempty"`
+}
+
+type decompressRequest struct {
+	S         string `json:"s"`
+	Algorithm string `json:"algorithm" validate:"max=64,charset=printable"`
+}
+
+type decompressResponse struct {
+	V   string `json:"v"`
+	Err string `json:"err,omitempty"`
+}
+
+// Compress returns the standard base64 encoding of the compressed s.
+func (stringService) Compress(ctx context.Context, s, algorithm string, level int) (string, error) {
+	if len(s) > maxCompressInputSize {
+		return "", ErrTooLarge
+	}
+	codec, ok := compressionCodecs[algorithm]
+	if !ok {
+		return "", errorf(CodeUnknownOption, "Unknown compression algorithm %q", algorithm)
+	}
+
+	var buf bytes.Buffer
+	w, err := codec.NewWriter(&buf, level)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.WriteString(w, s); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// Decompress reverses Compress. s must be standard base64.
+func (stringService) Decompress(ctx context.Context, s, algorithm string) (string, error) {
+	codec, ok := compressionCodecs[algorithm]
+	if !ok {
+		return "", errorf(CodeUnknownOption, "Unknown compression algorithm %q", algorithm)
+	}
+	compressed, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", withCode(CodeMalformedInput, err)
+	}
+
+	r, err := codec.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return "", withCode(CodeMalformedInput, err)
+	}
+	defer r.Close()
+
+	out, err := io.ReadAll(io.LimitReader(r, maxDecompressedSize+1))
+	if err != nil {
+		return "", withCode(CodeMalformedInput, err)
+	}
+	if len(out) > maxDecompressedSize {
+		return "", ErrTooLarge
+	}
+	return string(out), nil
+}
+
+func makeCompressEndpoint(svc IStringService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(compressRequest)
+		v, err := svc.Compress(ctx, req.S, req.Algorithm, req.Level)
+		if err != nil {
+			return compressResponse{"", err.Error()}, nil
+		}
+		return compressResponse{v, ""}, nil
+	}
+}
+
+func makeDecompressEndpoint(svc IStringService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(decompressRequest)
+		v, err := svc.Decompress(ctx, req.S, req.Algorithm)
+		if err != nil {
+			return decompressResponse{"", err.Error()}, nil
+		}
+		return decompressResponse{v, ""}, nil
+	}
+}
+
+func decodeCompressRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request compressRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+func decodeDecompressRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request decompressRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+//
+// ─── LOGGING ────────────────────────────────────────────────────────────────────
+//
+
+func (mw loggingMiddleware) Compress(ctx context.Context, s, algorithm string, level int) (output string, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "compress",
+			"tenant", tenantFrom(ctx),
+			"algorithm", algorithm,
+			"level", level,
+			"input_size", len(s),
+			"output_size", len(output),
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	output, err = mw.next.Compress(ctx, s, algorithm, level)
+	return
+}
+
+func (mw loggingMiddleware) Decompress(ctx context.Context, s, algorithm string) (output string, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "decompress",
+			"tenant", tenantFrom(ctx),
+			"algorithm", algorithm,
+			"input_size", len(s),
+			"output_size", len(output),
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	output, err = mw.next.Decompress(ctx, s, algorithm)
+	return
+}
+
+//
+// ─── INSTRUMENTATION ────────────────────────────────────────────────────────────
+//
+
+func (mw instrumentingMiddleware) Compress(ctx context.Context, s, algorithm string, level int) (output string, err error) {
+	done := mw.begin(ctx, "compress", s)
+	defer func() { done(len(output), err) }()
+
+	output, err = mw.next.Compress(ctx, s, algorithm, level)
+	return
+}
+
+func (mw instrumentingMiddleware) Decompress(ctx context.Context, s, algorithm string) (output string, err error) {
+	done := mw.begin(ctx, "decompress", s)
+	defer func() { done(len(output), err) }()
+
+	output, err = mw.next.Decompress(ctx, s, algorithm)
+	return
+}
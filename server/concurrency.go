@@ -0,0 +1,172 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/metrics"
+)
+
+//
+// ────────────────────────────────────────────────────────────── I ──────────
+//   :::::: C O N C U R R E N C Y : :  :   :    :     :        :          :
+// ────────────────────────────────────────────────────────────────────────
+//
+
+// ErrOverloaded is returned to the calls of expensive operations that find
+// the server saturated, with a 503.
+var ErrOverloaded = errors.New("Server overloaded, retry later")
+
+// defaultExpensiveOperations are the operations limited when the
+// "concurrency" config section lists none: those whose cost grows faster
+// than their input, or that run other operations.
+var defaultExpensiveOperations = []string{
+	"batch",
+	"pipeline",
+	"convertcase/batch",
+	"diff",
+	"similarity",
+	"compress",
+	"decompress",
+	"render",
+	"detect-language",
+	"moderate",
+}
+
+const defaultQueueTimeout = time.Second
+
+type concurrencyMetrics struct {
+	InFlight   metrics.Gauge
+	QueueDepth metrics.Gauge
+	Rejections metrics.Counter
+}
+
+// concurrencyLimiter runs at most as many expensive operations at once as it
+// has slots, over all of them. The calls that find no free slot wait in a
+// queue of bounded depth, for a bounded time, and are rejected with
+// ErrOverloaded beyond.
+type concurrencyLimiter struct {
+	slots        chan struct{}
+	ops          map[string]bool
+	maxQueued    int
+	queueTimeout time.Duration
+	metrics      concurrencyMetrics
+
+	mu       sync.Mutex
+	queued   int
+	inFlight int
+}
+
+// newConcurrencyLimiter returns the limiter of cfg, or nil when
+// MaxInFlight is negative.
+func newConcurrencyLimiter(cfg concurrencyConfig, m concurrencyMetrics) *concurrencyLimiter {
+	if cfg.MaxInFlight < 0 {
+		return nil
+	}
+	maxInFlight := cfg.MaxInFlight
+	if maxInFlight == 0 {
+		maxInFlight = 4 * runtime.GOMAXPROCS(0)
+	}
+	maxQueued := cfg.MaxQueued
+	switch {
+	case maxQueued == 0:
+		maxQueued = 2 * maxInFlight
+	case maxQueued < 0:
+		maxQueued = 0
+	}
+	names := cfg.Operations
+	if len(names) == 0 {
+		names = defaultExpensiveOperations
+	}
+	l := &concurrencyLimiter{
+		slots:        make(chan struct{}, maxInFlight),
+		ops:          make(map[string]bool, len(names)),
+		maxQueued:    maxQueued,
+		queueTimeout: millisecondsOr(cfg.QueueTimeoutMS, defaultQueueTimeout),
+		metrics:      m,
+	}
+	for _, name := range names {
+		l.ops[name] = true
+	}
+	return l
+}
+
+// admittedKey marks the contexts of calls holding a slot, so that the
+// operations run by batches and pipelines do not wait for another one.
+type admittedKey struct{}
+
+// limited runs the calls of the operation name in a slot of l, when it is
+// an expensive one.
+func (l *concurrencyLimiter) limited(name string, next endpoint.Endpoint) endpoint.Endpoint {
+	if l == nil || !l.ops[name] {
+		return next
+	}
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		if ctx.Value(admittedKey{}) != nil {
+			return next(ctx, request)
+		}
+		if err := l.acquire(ctx, name); err != nil {
+			return nil, err
+		}
+		defer l.release()
+		return next(context.WithValue(ctx, admittedKey{}, true), request)
+	}
+}
+
+func (l *concurrencyLimiter) acquire(ctx context.Context, name string) error {
+	select {
+	case l.slots <- struct{}{}:
+		l.update(0, 1)
+		return nil
+	default:
+	}
+
+	l.mu.Lock()
+	if l.queued >= l.maxQueued {
+		l.mu.Unlock()
+		l.metrics.Rejections.With("op", name, "reason", "queue_full").Add(1)
+		return ErrOverloaded
+	}
+	l.queued++
+	l.metrics.QueueDepth.Set(float64(l.queued))
+	l.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if l.queueTimeout > 0 {
+		timer := time.NewTimer(l.queueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case l.slots <- struct{}{}:
+		l.update(-1, 1)
+		return nil
+	case <-timeout:
+		l.update(-1, 0)
+		l.metrics.Rejections.With("op", name, "reason", "queue_timeout").Add(1)
+		return ErrOverloaded
+	case <-ctx.Done():
+		l.update(-1, 0)
+		return ctx.Err()
+	}
+}
+
+func (l *concurrencyLimiter) release() {
+	<-l.slots
+	l.update(0, -1)
+}
+
+// update moves the queue depth and the calls in flight by the given deltas,
+// and reports them.
+func (l *concurrencyLimiter) update(queued, inFlight int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.queued += queued
+	l.inFlight += inFlight
+	l.metrics.QueueDepth.Set(float64(l.queued))
+	l.metrics.InFlight.Set(float64(l.inFlight))
+}
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+//
+// ──────────────────────────────────────────────────────────────────────── I ──────────
+//   :::::: C O N T E N T   E N C O D I N G : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────────────────────────────
+//
+
+// defaultCompressionMinSize is the size under which responses are sent
+// uncompressed, compression not being worth its overhead.
+const defaultCompressionMinSize = 1024
+
+// contentEncodings maps the HTTP content codings to the compression codecs
+// implementing them, in order of preference.
+var contentEncodings = []struct {
+	Token string
+	Codec string
+}{
+	{"gzip", "gzip"},
+	{"br", "brotli"},
+	{"zstd", "zstd"},
+}
+
+func contentEncodingCodec(token string) (compressionCodec, bool) {
+	for _, e := range contentEncodings {
+		if e.Token == token {
+			c, ok := compressionCodecs[e.Codec]
+			return c, ok
+		}
+	}
+	return compressionCodec{}, false
+}
+
+// withContentEncoding decompresses request bodies sent with a
+// Content-Encoding, and compresses responses of at least minSize bytes
+// with the best coding of Accept-Encoding. A negative minSize disables
+// response compression.
+func withContentEncoding(next http.Handler, minSize int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if coding := r.Header.Get("Content-Encoding"); coding != "" && coding != "identity" {
+			c, ok := contentEncodingCodec(strings.ToLower(coding))
+			if !ok {
+				encodeError(r.Context(), ErrUnsupportedMediaType, w)
+				return
+			}
+			body, err := c.NewReader(r.Body)
+			if err != nil {
+				encodeError(r.Context(), malformedInputError{coding, err}, w)
+				return
+			}
+			defer body.Close()
+			r.Body = body
+			r.ContentLength = -1
+			r.Header.Del("Content-Encoding")
+			r.Header.Del("Content-Length")
+		}
+
+		// Upgraded connections, like /ws, write their own framing.
+		if r.Header.Get("Upgrade") != "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		token := acceptedEncoding(r.Header.Get("Accept-Encoding"))
+		if token == "" || minSize < 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressingWriter{ResponseWriter: w, token: token, minSize: minSize, status: http.StatusOK}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// acceptedEncoding returns the preferred content coding among those of
+// accept with a non-zero quality, or "" when none is acceptable.
+func acceptedEncoding(accept string) string {
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		token := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, param := range fields[1:] {
+			if v := strings.TrimSpace(param); strings.HasPrefix(v, "q=") {
+				q, _ = strconv.ParseFloat(v[2:], 64)
+			}
+		}
+		accepted[token] = q > 0
+	}
+	for _, e := range contentEncodings {
+		if accepted[e.Token] {
+			return e.Token
+		}
+	}
+	return ""
+}
+
+// compressingWriter holds back the first minSize bytes of a response to
+// decide whether to compress it. Flushing before that sends the response
+// uncompressed, so that streams are not delayed.
+type compressingWriter struct {
+	http.ResponseWriter
+	token   string
+	minSize int
+	status  int
+
+	buf     []byte
+	decided bool
+	cw      io.WriteCloser
+}
+
+func (w *compressingWriter) WriteHeader(status int) {
+	if w.decided {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.status = status
+}
+
+func (w *compressingWriter) Write(p []byte) (int, error) {
+	if w.decided {
+		if w.cw != nil {
+			return w.cw.Write(p)
+		}
+		return w.ResponseWriter.Write(p)
+	}
+	w.buf = append(w.buf, p...)
+	if len(w.buf) >= w.minSize {
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// decide sends the headers and the held back bytes, compressed or not.
+func (w *compressingWriter) decide(compress bool) error {
+	w.decided = true
+	h := w.Header()
+	if h.Get("Content-Encoding") != "" || w.status == http.StatusNoContent || w.status == http.StatusNotModified {
+		compress = false
+	}
+	if compress {
+		c, _ := contentEncodingCodec(w.token)
+		cw, err := c.NewWriter(w.ResponseWriter, 0)
+		if err != nil {
+			return err
+		}
+		w.cw = cw
+		h.Set("Content-Encoding", w.token)
+		h.Del("Content-Length")
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	buf := w.buf
+	w.buf = nil
+	_, err := w.Write(buf)
+	return err
+}
+
+// Unwrap lets http.ResponseController reach the connection.
+func (w *compressingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *compressingWriter) Flush() {
+	if !w.decided {
+		w.decide(false)
+	}
+	if f, ok := w.cw.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close sends what is still held back and ends the compressed stream.
+func (w *compressingWriter) Close() error {
+	if !w.decided {
+		if err := w.decide(false); err != nil {
+			return err
+		}
+	}
+	if w.cw != nil {
+		return w.cw.Close()
+	}
+	return nil
+}
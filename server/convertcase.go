@@ -0,0 +1,218 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+//
+// ──────────────────────────────────────────────────────────────── I ──────────
+//   :::::: C O N V E R T   C A S E : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────────────────────
+//
+
+// Case conventions understood by ConvertCase. The detected convention of a
+// single word without separators is reported as "lower" or "upper".
+const (
+	caseCamel          = "camel"
+	casePascal         = "pascal"
+	caseSnake          = "snake"
+	caseScreamingSnake = "screaming_snake"
+	caseKebab          = "kebab"
+	caseLower          = "lower"
+	caseUpper          = "upper"
+	caseMixed          = "mixed"
+)
+
+type convertCaseRequest struct {
+	S      string `json:"s" validate:"required"`
+	Target string `json:"target" validate:"max=64,charset=printable"`
+}
+
+type convertCaseResponse struct {
+	V        string `json:"v"`
+	Detected string `json:"detected,omitempty"`
+	Err      string `json:"err,omitempty"`
+}
+
+type convertCaseBatchRequest struct {
+	Items  []string `json:"items" validate:"max=1000"`
+	Target string   `json:"target" validate:"max=64,charset=printable"`
+}
+
+type convertCaseBatchResponse struct {
+	V   []convertCaseResponse `json:"v"`
+	Err string                `json:"err,omitempty"`
+}
+
+func (stringService) ConvertCase(ctx context.Context, s, target string) (output, detected string, err error) {
+	detected = detectCase(s)
+	words := splitWords(s)
+
+	for i, w := range words {
+		switch target {
+		case caseSnake, caseKebab:
+			words[i] = strings.ToLower(w)
+		case caseScreamingSnake:
+			words[i] = strings.ToUpper(w)
+		case caseCamel, casePascal:
+			w = strings.ToLower(w)
+			if i > 0 || target == casePascal {
+				w = titleWord(w)
+			}
+			words[i] = w
+		default:
+			return "", detected, errorf(CodeUnknownOption, "Unknown case convention %q", target)
+		}
+	}
+
+	switch target {
+	case caseSnake, caseScreamingSnake:
+		return strings.Join(words, "_"), detected, nil
+	case caseKebab:
+		return strings.Join(words, "-"), detected, nil
+	}
+	return strings.Join(words, ""), detected, nil
+}
+
+// detectCase reports which convention s is written in.
+func detectCase(s string) string {
+	hasUnderscore := strings.Contains(s, "_")
+	hasDash := strings.Contains(s, "-")
+	hasUpper := strings.IndexFunc(s, unicode.IsUpper) >= 0
+	hasLower := strings.IndexFunc(s, unicode.IsLower) >= 0
+
+	switch {
+	case hasUnderscore && hasDash, strings.ContainsAny(s, " \t."):
+		return caseMixed
+	case hasUnderscore && !hasLower:
+		return caseScreamingSnake
+	case hasUnderscore && !hasUpper:
+		return caseSnake
+	case hasDash && !hasUpper:
+		return caseKebab
+	case hasUnderscore, hasDash:
+		return caseMixed
+	case !hasUpper:
+		return caseLower
+	case !hasLower:
+		return caseUpper
+	case unicode.IsUpper([]rune(s)[0]):
+		return casePascal
+	}
+	return caseCamel
+}
+
+// splitWords breaks s into words on separators and on case changes. A run
+// of capitals is kept together as an acronym, so "HTTPServer" gives
+// ["HTTP", "Server"] and "userID" gives ["user", "ID"].
+func splitWords(s string) []string {
+	var words []string
+	for _, token := range strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		rs := []rune(token)
+		start := 0
+		for i := 1; i < len(rs); i++ {
+			prev, cur := rs[i-1], rs[i]
+			lowerToUpper := (unicode.IsLower(prev) || unicode.IsDigit(prev)) && unicode.IsUpper(cur)
+			acronymEnd := unicode.IsUpper(prev) && unicode.IsUpper(cur) && i+1 < len(rs) && unicode.IsLower(rs[i+1])
+			if lowerToUpper || acronymEnd {
+				words = append(words, string(rs[start:i]))
+				start = i
+			}
+		}
+		words = append(words, string(rs[start:]))
+	}
+	return words
+}
+
+func titleWord(w string) string {
+	rs := []rune(w)
+	if len(rs) == 0 {
+		return w
+	}
+	rs[0] = unicode.ToUpper(rs[0])
+	return string(rs)
+}
+
+func makeConvertCaseEndpoint(svc IStringService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(convertCaseRequest)
+		v, detected, err := svc.ConvertCase(ctx, req.S, req.Target)
+		if err != nil {
+			return convertCaseResponse{"", detected, err.Error()}, nil
+		}
+		return convertCaseResponse{v, detected, ""}, nil
+	}
+}
+
+func makeConvertCaseBatchEndpoint(svc IStringService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(convertCaseBatchRequest)
+		results := make([]convertCaseResponse, len(req.Items))
+		for i, s := range req.Items {
+			v, detected, err := svc.ConvertCase(ctx, s, req.Target)
+			if err != nil {
+				results[i] = convertCaseResponse{"", detected, err.Error()}
+				continue
+			}
+			results[i] = convertCaseResponse{v, detected, ""}
+		}
+		return convertCaseBatchResponse{results, ""}, nil
+	}
+}
+
+func decodeConvertCaseRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request convertCaseRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+func decodeConvertCaseBatchRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request convertCaseBatchRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+//
+// ─── LOGGING ────────────────────────────────────────────────────────────────────
+//
+
+func (mw loggingMiddleware) ConvertCase(ctx context.Context, s, target string) (output, detected string, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "convertcase",
+			"tenant", tenantFrom(ctx),
+			"input", s,
+			"target", target,
+			"detected", detected,
+			"output", output,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	output, detected, err = mw.next.ConvertCase(ctx, s, target)
+	return
+}
+
+//
+// ─── INSTRUMENTATION ────────────────────────────────────────────────────────────
+//
+
+func (mw instrumentingMiddleware) ConvertCase(ctx context.Context, s, target string) (output, detected string, err error) {
+	done := mw.begin(ctx, "convertcase", s)
+	defer func() { done(len(output), err) }()
+
+	output, detected, err = mw.next.ConvertCase(ctx, s, target)
+	return
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+//
+// ──────────────────────────────────────────────── I ──────────
+//   :::::: C O R S : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────
+//
+
+// Defaults of the "cors" config section.
+var (
+	defaultCORSMethods = []string{http.MethodGet, http.MethodPost}
+	defaultCORSHeaders = []string{"Content-Type", "Content-Encoding", "Authorization"}
+)
+
+// withCORS answers the preflight requests of browsers and adds the CORS
+// headers to the responses of next, for the origins allowed by cfg. It
+// returns next unchanged when no origin is allowed.
+func withCORS(next http.Handler, cfg corsConfig) http.Handler {
+	if len(cfg.AllowedOrigins) == 0 {
+		return next
+	}
+	methods := cfg.AllowedMethods
+	if len(methods) == 0 {
+		methods = defaultCORSMethods
+	}
+	headers := cfg.AllowedHeaders
+	if len(headers) == 0 {
+		headers = defaultCORSHeaders
+	}
+	allowedMethods := strings.Join(methods, ", ")
+	allowedHeaders := strings.Join(headers, ", ")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		origin := r.Header.Get("Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if origin == "" || !corsOriginAllowed(cfg.AllowedOrigins, origin) {
+			if preflight {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Credentials cannot be allowed for "*", so the origin is echoed.
+		if cfg.AllowCredentials {
+			h.Set("Access-Control-Allow-Origin", origin)
+			h.Set("Access-Control-Allow-Credentials", "true")
+		} else if contains(cfg.AllowedOrigins, "*") {
+			h.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			h.Set("Access-Control-Allow-Origin", origin)
+		}
+		if !preflight {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h.Add("Vary", "Access-Control-Request-Method")
+		h.Add("Vary", "Access-Control-Request-Headers")
+		if !containsFold(methods, r.Header.Get("Access-Control-Request-Method")) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		for _, name := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+			if name = strings.TrimSpace(name); name != "" && !containsFold(headers, name) {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+		}
+		h.Set("Access-Control-Allow-Methods", allowedMethods)
+		h.Set("Access-Control-Allow-Headers", allowedHeaders)
+		if cfg.MaxAgeSeconds > 0 {
+			h.Set("Access-Control-Max-Age", strconv.Itoa(cfg.MaxAgeSeconds))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// corsOriginAllowed reports whether origin matches one of allowed, where
+// "*" matches any origin and a "*." prefix of the host any subdomain.
+func corsOriginAllowed(allowed []string, origin string) bool {
+	for _, a := range allowed {
+		if a == "*" || strings.EqualFold(a, origin) {
+			return true
+		}
+		if i := strings.Index(a, "://*."); i >= 0 {
+			scheme, domain := a[:i+3], a[i+4:]
+			if strings.HasPrefix(origin, scheme) && strings.HasSuffix(origin, domain) && len(origin) > len(scheme)+len(domain) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/go-kit/kit/metrics"
+)
+
+//
+// ──────────────────────────────────────────────────── I ──────────
+//   :::::: C R E D E N T I A L S : :  :   :    :     :        :
+// ──────────────────────────────────────────────────────────────
+//
+
+// The transports other than HTTP authenticate their calls with the
+// credentials HTTP requests carry in their headers, checked by the same
+// code: the access token of the oidc section, and the API key that the
+// tenants and the roles of the authorization section are looked up by.
+
+// Credentials are those of a call over another transport than HTTP, which
+// a Transport keeps in the context of its calls with WithCredentials.
+type Credentials struct {
+	// AccessToken is sent over HTTP as "Authorization: Bearer <token>".
+	AccessToken string `json:"access_token"`
+	// APIKey is sent over HTTP in the X-API-Key header.
+	APIKey string `json:"api_key"`
+}
+
+type credentialsKey struct{}
+
+// WithCredentials returns ctx with the credentials c, for the calls of a
+// CallFunc.
+func WithCredentials(ctx context.Context, c Credentials) context.Context {
+	return context.WithValue(ctx, credentialsKey{}, c)
+}
+
+func credentialsFrom(ctx context.Context) Credentials {
+	c, _ := ctx.Value(credentialsKey{}).(Credentials)
+	return c
+}
+
+// callAuthenticator authenticates the calls of the transports other than
+// HTTP, like withAccessTokens, withTenancy and withIdentities do HTTP
+// requests.
+type callAuthenticator struct {
+	oidc     *oidcProvider
+	tenants  *tenancy
+	rejected metrics.Counter
+	authz    *authorizer
+}
+
+// authenticate returns ctx with the access token, the tenant and the
+// identity of the call to the operation name with the credentials of ctx,
+// or why they are rejected.
+func (a *callAuthenticator) authenticate(ctx context.Context, name string) (context.Context, error) {
+	c := credentialsFrom(ctx)
+	r := (&http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Path: "/" + name},
+		Header: make(http.Header),
+	}).WithContext(ctx)
+	if c.AccessToken != "" {
+		r.Header.Set("Authorization", "Bearer "+c.AccessToken)
+	}
+	if c.APIKey != "" {
+		r.Header.Set(apiKeyHeader, c.APIKey)
+	}
+
+	if a.oidc != nil {
+		token, _, err := a.oidc.authenticate(r)
+		if err != nil {
+			return ctx, err
+		}
+		if token != nil {
+			r = r.WithContext(context.WithValue(r.Context(), accessTokenKey{}, token))
+		}
+	}
+	ctx, err := a.tenants.admit(r, a.rejected)
+	if err != nil {
+		return ctx, err
+	}
+	if a.authz != nil {
+		ctx = context.WithValue(ctx, identityKey{}, a.authz.identity(r.WithContext(ctx)))
+	}
+	return ctx, nil
+}
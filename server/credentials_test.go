@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics/discard"
+)
+
+func TestAuthorized(t *testing.T) {
+	oidc, err := newOIDCProvider(oidcConfig{
+		Issuer: "https://issuer.example",
+		Scopes: map[string][]string{"encrypt": {"crypto"}},
+	}, discard.NewCounter(), log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	authz, err := newAuthorizer(authorizationConfig{
+		Operations: map[string][]string{"encrypt": {"admin"}},
+	}, nil, discard.NewCounter(), log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok := func(context.Context, interface{}) (interface{}, error) { return "ok", nil }
+
+	tests := []struct {
+		name     string
+		op       string
+		token    *accessToken
+		identity *Identity
+		wantErr  error
+	}{
+		{name: "open operation without credentials", op: "uppercase"},
+		{name: "no token", op: "encrypt", identity: &Identity{Roles: []string{"admin"}}, wantErr: ErrInvalidToken},
+		{
+			name:     "token without the scope",
+			op:       "encrypt",
+			token:    &accessToken{Scopes: map[string]bool{"other": true}},
+			identity: &Identity{Roles: []string{"admin"}},
+			wantErr:  ErrInsufficientScope,
+		},
+		{
+			name:    "no identity",
+			op:      "encrypt",
+			token:   &accessToken{Scopes: map[string]bool{"crypto": true}},
+			wantErr: ErrPermissionDenied,
+		},
+		{
+			name:     "identity without the role",
+			op:       "encrypt",
+			token:    &accessToken{Scopes: map[string]bool{"crypto": true}},
+			identity: &Identity{Roles: []string{"user"}},
+			wantErr:  ErrPermissionDenied,
+		},
+		{
+			name:     "allowed",
+			op:       "encrypt",
+			token:    &accessToken{Scopes: map[string]bool{"crypto": true}},
+			identity: &Identity{Roles: []string{"admin"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.token != nil {
+				ctx = context.WithValue(ctx, accessTokenKey{}, tt.token)
+			}
+			if tt.identity != nil {
+				ctx = context.WithValue(ctx, identityKey{}, tt.identity)
+			}
+			e := oidc.authorized(tt.op, authz.authorized(tt.op, ok))
+			if _, err := e(ctx, nil); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCallAuthenticator(t *testing.T) {
+	tenants, err := newTenancy(tenancyConfig{Tenants: map[string]tenantConfig{
+		"acme":   {APIKeys: []string{"acme-key"}},
+		"globex": {APIKeys: []string{"globex-key"}, Operations: map[string]bool{"encrypt": false}},
+	}}, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	authz, err := newAuthorizer(authorizationConfig{
+		Operations: map[string][]string{"encrypt": {"admin"}},
+		APIKeys:    []apiKeyRoles{{APIKey: "acme-key", Roles: []string{"admin"}}},
+	}, nil, discard.NewCounter(), log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	scoped, err := newOIDCProvider(oidcConfig{
+		Issuer: "https://issuer.example",
+		Scopes: map[string][]string{"*": {"text"}},
+	}, discard.NewCounter(), log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		oidc        *oidcProvider
+		credentials Credentials
+		wantTenant  string
+		wantRoles   []string
+		wantErr     error
+	}{
+		{name: "anonymous", wantTenant: defaultTenant},
+		{name: "API key", credentials: Credentials{APIKey: "acme-key"}, wantTenant: "acme", wantRoles: []string{"admin"}},
+		{name: "operation disabled for the tenant", credentials: Credentials{APIKey: "globex-key"}, wantErr: ErrOperationDisabled},
+		{name: "token required", oidc: scoped, credentials: Credentials{APIKey: "acme-key"}, wantErr: ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &callAuthenticator{oidc: tt.oidc, tenants: tenants, rejected: discard.NewCounter(), authz: authz}
+			ctx, err := a.authenticate(WithCredentials(context.Background(), tt.credentials), "encrypt")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if tenant := tenantFrom(ctx); tenant != tt.wantTenant {
+				t.Errorf("tenant = %q, want %q", tenant, tt.wantTenant)
+			}
+			id := identityFrom(ctx)
+			if id == nil || id.Tenant != tt.wantTenant || len(id.Roles) != len(tt.wantRoles) {
+				t.Fatalf("identity = %+v, want roles %v", id, tt.wantRoles)
+			}
+			for i, role := range tt.wantRoles {
+				if id.Roles[i] != role {
+					t.Errorf("roles = %v, want %v", id.Roles, tt.wantRoles)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,653 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+//
+// ────────────────────────────────────────────── I ──────────
+//   :::::: C S V : :  :   :    :     :        :          :
+// ────────────────────────────────────────────────────────
+//
+
+// maxCSVInput bounds the text parsed and written by CSVParse and
+// CSVFormat. The streaming routes have no limit.
+const maxCSVInput = 1 << 20
+
+// CSVDialect describes a CSV variant. The zero value is RFC 4180, except
+// that records are written ending with LF rather than CRLF.
+type CSVDialect struct {
+	// Delimiter separates fields, "," by default.
+	Delimiter string
+	// Quote encloses fields holding delimiters, quotes or line breaks, `"`
+	// by default. "none" disables quoting.
+	Quote string
+	// Comment starts lines that are skipped when parsing. Comments are
+	// not part of RFC 4180 and disabled by default.
+	Comment string
+	// FieldsPerRecord is the number of fields of every record. When 0,
+	// every record must have as many fields as the first, as RFC 4180
+	// requires; when negative, records can have any number of fields.
+	FieldsPerRecord int
+	// LazyQuotes accepts quotes in unquoted fields, and quotes in quoted
+	// fields that are not doubled.
+	LazyQuotes bool
+	// TrimLeadingSpace ignores the spaces starting fields.
+	TrimLeadingSpace bool
+	// CRLF ends written records with CRLF, as RFC 4180 requires.
+	CRLF bool
+}
+
+// runes returns the delimiter, quote and comment characters of d; quote
+// is 0 when quoting is disabled and comment is 0 when comments are.
+func (d CSVDialect) runes() (delimiter, quote, comment rune, err error) {
+	if delimiter, err = csvRune("Delimiter", d.Delimiter, ','); err != nil {
+		return
+	}
+	if d.Quote != "none" {
+		if quote, err = csvRune("Quote", d.Quote, '"'); err != nil {
+			return
+		}
+	}
+	if comment, err = csvRune("Comment", d.Comment, 0); err != nil {
+		return
+	}
+	if delimiter == quote || comment != 0 && (comment == delimiter || comment == quote) {
+		err = errors.New("Delimiter, quote and comment must be different characters")
+	}
+	return
+}
+
+func csvRune(name, s string, def rune) (rune, error) {
+	if s == "" {
+		return def, nil
+	}
+	r, size := utf8.DecodeRuneInString(s)
+	if size != len(s) || r == utf8.RuneError || r == '\r' || r == '\n' {
+		return 0, fmt.Errorf("%s must be a single character other than a line break", name)
+	}
+	return r, nil
+}
+
+// csvReader parses CSV records. Unlike encoding/csv, it supports any
+// quote character, and keeps the line breaks of quoted fields as they are.
+type csvReader struct {
+	r                         *bufio.Reader
+	delimiter, quote, comment rune
+	fieldsPerRecord           int
+	lazyQuotes, trimLeading   bool
+	// line is the number of line breaks read so far.
+	line int
+	// back holds the runes given back by unread, the last one first.
+	back []rune
+}
+
+func newCSVReader(r io.Reader, d CSVDialect) (*csvReader, error) {
+	delimiter, quote, comment, err := d.runes()
+	if err != nil {
+		return nil, err
+	}
+	return &csvReader{
+		r:               bufio.NewReaderSize(r, streamChunkSize),
+		delimiter:       delimiter,
+		quote:           quote,
+		comment:         comment,
+		fieldsPerRecord: d.FieldsPerRecord,
+		lazyQuotes:      d.LazyQuotes,
+		trimLeading:     d.TrimLeadingSpace,
+	}, nil
+}
+
+func (cr *csvReader) read() (rune, error) {
+	if n := len(cr.back); n > 0 {
+		r := cr.back[n-1]
+		cr.back = cr.back[:n-1]
+		return r, nil
+	}
+	r, _, err := cr.r.ReadRune()
+	return r, err
+}
+
+func (cr *csvReader) unread(r rune) {
+	cr.back = append(cr.back, r)
+}
+
+// newline tells whether r, just read, ends a line, reading the LF of a
+// CRLF.
+func (cr *csvReader) newline(r rune) bool {
+	switch r {
+	case '\n':
+		cr.line++
+		return true
+	case '\r':
+		next, err := cr.read()
+		if err == nil && next == '\n' {
+			cr.line++
+			return true
+		}
+		if err == nil {
+			cr.unread(next)
+		}
+	}
+	return false
+}
+
+func (cr *csvReader) errorf(format string, args ...interface{}) error {
+	return malformedInputError{"CSV", fmt.Errorf("line %d: "+format, append([]interface{}{cr.line + 1}, args...)...)}
+}
+
+// Read returns the next record, or io.EOF once there is none. Empty lines
+// and comments are skipped.
+func (cr *csvReader) Read() ([]string, error) {
+	for {
+		r, err := cr.read()
+		if err != nil {
+			return nil, err
+		}
+		if cr.newline(r) {
+			continue
+		}
+		if cr.comment == 0 || r != cr.comment {
+			cr.unread(r)
+			break
+		}
+		for {
+			if r, err = cr.read(); err != nil {
+				return nil, err
+			}
+			if cr.newline(r) {
+				break
+			}
+		}
+	}
+
+	line := cr.line + 1
+	var record []string
+	for {
+		var field strings.Builder
+		last, err := cr.readField(&field)
+		if err != nil {
+			return nil, err
+		}
+		record = append(record, field.String())
+		if last {
+			break
+		}
+	}
+	switch {
+	case cr.fieldsPerRecord == 0:
+		cr.fieldsPerRecord = len(record)
+	case cr.fieldsPerRecord > 0 && len(record) != cr.fieldsPerRecord:
+		return nil, malformedInputError{"CSV", fmt.Errorf("line %d: %d fields instead of %d", line, len(record), cr.fieldsPerRecord)}
+	}
+	return record, nil
+}
+
+// readField reads a field into b, and tells whether it was the last of
+// its record.
+func (cr *csvReader) readField(b *strings.Builder) (last bool, err error) {
+	r, err := cr.read()
+	for cr.trimLeading && err == nil && r != '\r' && r != '\n' && unicode.IsSpace(r) {
+		r, err = cr.read()
+	}
+	if err == io.EOF {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if cr.quote == 0 || r != cr.quote {
+		for ; ; r, err = cr.read() {
+			switch {
+			case err == io.EOF:
+				return true, nil
+			case err != nil:
+				return false, err
+			case r == cr.delimiter:
+				return false, nil
+			case cr.newline(r):
+				return true, nil
+			case r == cr.quote && cr.quote != 0 && !cr.lazyQuotes:
+				return false, cr.errorf("bare %q in unquoted field", r)
+			}
+			b.WriteRune(r)
+		}
+	}
+
+	for {
+		r, err := cr.read()
+		switch {
+		case err == io.EOF && cr.lazyQuotes:
+			return true, nil
+		case err == io.EOF:
+			return false, cr.errorf("quoted field not closed")
+		case err != nil:
+			return false, err
+		case r != cr.quote:
+			if r == '\n' {
+				cr.line++
+			}
+			b.WriteRune(r)
+			continue
+		}
+
+		r, err = cr.read()
+		switch {
+		case err == io.EOF:
+			return true, nil
+		case err != nil:
+			return false, err
+		case r == cr.quote:
+			b.WriteRune(r)
+		case r == cr.delimiter:
+			return false, nil
+		case cr.newline(r):
+			return true, nil
+		case cr.lazyQuotes:
+			b.WriteRune(cr.quote)
+			b.WriteRune(r)
+		default:
+			return false, cr.errorf("%q in quoted field not doubled", cr.quote)
+		}
+	}
+}
+
+// csvWriter writes CSV records, quoting only the fields that need it.
+type csvWriter struct {
+	w                io.Writer
+	delimiter, quote rune
+	lineBreak        string
+}
+
+func newCSVWriter(w io.Writer, d CSVDialect) (*csvWriter, error) {
+	delimiter, quote, _, err := d.runes()
+	if err != nil {
+		return nil, err
+	}
+	cw := &csvWriter{w: w, delimiter: delimiter, quote: quote, lineBreak: "\n"}
+	if d.CRLF {
+		cw.lineBreak = "\r\n"
+	}
+	return cw, nil
+}
+
+// needsQuotes tells whether field must be quoted to be read back as is.
+// A record made of a single empty field is quoted, or it would be read as
+// an empty line.
+func (cw *csvWriter) needsQuotes(field string, alone bool) bool {
+	if field == "" {
+		return alone
+	}
+	r, _ := utf8.DecodeRuneInString(field)
+	return unicode.IsSpace(r) || strings.ContainsAny(field, "\r\n") ||
+		strings.ContainsRune(field, cw.delimiter) || strings.ContainsRune(field, cw.quote)
+}
+
+func (cw *csvWriter) Write(record []string) (int, error) {
+	var b strings.Builder
+	for i, field := range record {
+		if i > 0 {
+			b.WriteRune(cw.delimiter)
+		}
+		if !cw.needsQuotes(field, len(record) == 1) {
+			b.WriteString(field)
+			continue
+		}
+		if cw.quote == 0 {
+			return 0, errorf(CodeUnsupportedInput, "Field %d of the record needs quotes, which are disabled", i+1)
+		}
+		q := string(cw.quote)
+		b.WriteString(q + strings.ReplaceAll(field, q, q+q) + q)
+	}
+	b.WriteString(cw.lineBreak)
+	return io.WriteString(cw.w, b.String())
+}
+
+type csvParseRequest struct {
+	S                string `json:"s"`
+	Delimiter        string `json:"delimiter"`
+	Quote            string `json:"quote"`
+	Comment          string `json:"comment"`
+	FieldsPerRecord  int    `json:"fields_per_record"`
+	LazyQuotes       bool   `json:"lazy_quotes"`
+	TrimLeadingSpace bool   `json:"trim_leading_space"`
+}
+
+type csvParseResponse struct {
+	V   [][]string `json:"v"`
+	Err string     `json:"err,omitempty"`
+}
+
+// CSVParse returns the records of the CSV text s.
+func (svc stringService) CSVParse(ctx context.Context, s string, dialect CSVDialect) ([][]string, error) {
+	if len(s) > maxCSVInput {
+		return nil, ErrTooLarge
+	}
+	records := [][]string{}
+	_, err := svc.CSVParseStream(ctx, strings.NewReader(s), dialect, func(record []string) error {
+		records = append(records, record)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+func makeCSVParseEndpoint(svc IStringService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(csvParseRequest)
+		v, err := svc.CSVParse(ctx, req.S, CSVDialect{
+			Delimiter:        req.Delimiter,
+			Quote:            req.Quote,
+			Comment:          req.Comment,
+			FieldsPerRecord:  req.FieldsPerRecord,
+			LazyQuotes:       req.LazyQuotes,
+			TrimLeadingSpace: req.TrimLeadingSpace,
+		})
+		if err != nil {
+			return csvParseResponse{nil, err.Error()}, nil
+		}
+		return csvParseResponse{v, ""}, nil
+	}
+}
+
+func decodeCSVParseRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request csvParseRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+type csvFormatRequest struct {
+	Rows      [][]string `json:"rows" validate:"required"`
+	Delimiter string     `json:"delimiter"`
+	Quote     string     `json:"quote"`
+	CRLF      bool       `json:"crlf"`
+}
+
+type csvFormatResponse struct {
+	V   string `json:"v"`
+	Err string `json:"err,omitempty"`
+}
+
+// CSVFormat writes rows as CSV text.
+func (stringService) CSVFormat(ctx context.Context, rows [][]string, dialect CSVDialect) (string, error) {
+	var b strings.Builder
+	cw, err := newCSVWriter(&b, dialect)
+	if err != nil {
+		return "", err
+	}
+	for _, row := range rows {
+		if _, err := cw.Write(row); err != nil {
+			return "", err
+		}
+		if b.Len() > maxCSVInput {
+			return "", ErrTooLarge
+		}
+	}
+	return b.String(), nil
+}
+
+func makeCSVFormatEndpoint(svc IStringService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(csvFormatRequest)
+		v, err := svc.CSVFormat(ctx, req.Rows, CSVDialect{Delimiter: req.Delimiter, Quote: req.Quote, CRLF: req.CRLF})
+		if err != nil {
+			return csvFormatResponse{"", err.Error()}, nil
+		}
+		return csvFormatResponse{v, ""}, nil
+	}
+}
+
+func decodeCSVFormatRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request csvFormatRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+//
+// ─── STREAMING ──────────────────────────────────────────────────────────────────
+//
+
+// CSVParseStream parses the CSV text read from r, calling record with each
+// record as soon as it is read, and returns the number of records.
+func (stringService) CSVParseStream(ctx context.Context, r io.Reader, dialect CSVDialect, record func([]string) error) (n int64, err error) {
+	cr, err := newCSVReader(contextReader{ctx, r}, dialect)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		fields, err := cr.Read()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		if err := record(fields); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+// CSVFormatStream writes the rows read from r, newline-delimited JSON
+// arrays of strings, to w as CSV text, and returns the number of rows.
+func (stringService) CSVFormatStream(ctx context.Context, r io.Reader, w io.Writer, dialect CSVDialect) (n int64, err error) {
+	bw := bufio.NewWriterSize(w, streamChunkSize)
+	cw, err := newCSVWriter(bw, dialect)
+	if err != nil {
+		return 0, err
+	}
+	dec := json.NewDecoder(contextReader{ctx, r})
+	for err == nil {
+		var row []string
+		if err = dec.Decode(&row); err == io.EOF {
+			err = nil
+			break
+		} else if err != nil {
+			err = malformedInputError{"NDJSON", err}
+		} else if _, err = cw.Write(row); err == nil {
+			n++
+		}
+	}
+	// The rows written before a failure are sent before the error.
+	if ferr := bw.Flush(); err == nil {
+		err = ferr
+	}
+	return n, err
+}
+
+// csvDialectFromQuery reads the dialect of the streaming routes from the
+// query string, whose parameters are named like the fields of the JSON
+// requests.
+func csvDialectFromQuery(q url.Values) (CSVDialect, error) {
+	d := CSVDialect{Delimiter: q.Get("delimiter"), Quote: q.Get("quote"), Comment: q.Get("comment")}
+	var err error
+	if v := q.Get("fields_per_record"); v != "" {
+		if d.FieldsPerRecord, err = strconv.Atoi(v); err != nil {
+			return d, errorf(CodeOutOfRange, "Invalid fields_per_record %q", v)
+		}
+	}
+	for name, flag := range map[string]*bool{
+		"lazy_quotes":        &d.LazyQuotes,
+		"trim_leading_space": &d.TrimLeadingSpace,
+		"crlf":               &d.CRLF,
+	} {
+		if v := q.Get(name); v != "" {
+			if *flag, err = strconv.ParseBool(v); err != nil {
+				return d, errorf(CodeOutOfRange, "Invalid %s %q", name, v)
+			}
+		}
+	}
+	return d, nil
+}
+
+// makeCSVParseStreamHandler serves /stream/csv/parse. The request body is
+// the raw CSV text, or a multipart upload, and the dialect is given in the
+// query string, like ?delimiter=%09&lazy_quotes=true; the response is a
+// stream of {"v": record} lines, ending with an {"err": ...} line on
+// failure.
+func makeCSVParseStreamHandler(svc IStringService) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nw := newNDJSONWriter(w)
+		dialect, err := csvDialectFromQuery(r.URL.Query())
+		var body io.Reader
+		if err == nil {
+			body, err = uploadBody(r)
+		}
+		if err == nil {
+			_, err = svc.CSVParseStream(r.Context(), body, dialect, func(record []string) error {
+				if err := nw.enc.Encode(struct {
+					V []string `json:"v"`
+				}{record}); err != nil {
+					return err
+				}
+				if f, ok := w.(http.Flusher); ok {
+					f.Flush()
+				}
+				return nil
+			})
+		}
+		if err != nil {
+			nw.fail(csvParseResponse{Err: err.Error()}, err)
+		}
+	})
+}
+
+// makeCSVFormatStreamHandler serves /stream/csv/format. The request body
+// holds one JSON array of strings per row, and the response is a stream
+// of {"v": chunk} lines of CSV text, like /stream/uppercase.
+func makeCSVFormatStreamHandler(svc IStringService) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nw := newNDJSONWriter(w)
+		dialect, err := csvDialectFromQuery(r.URL.Query())
+		var body io.Reader
+		if err == nil {
+			body, err = uploadBody(r)
+		}
+		if err == nil {
+			_, err = svc.CSVFormatStream(r.Context(), body, nw, dialect)
+		}
+		if err != nil {
+			nw.fail(csvFormatResponse{Err: err.Error()}, err)
+		}
+	})
+}
+
+//
+// ─── LOGGING ────────────────────────────────────────────────────────────────────
+//
+
+func (mw loggingMiddleware) CSVParse(ctx context.Context, s string, dialect CSVDialect) (records [][]string, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "csvparse",
+			"tenant", tenantFrom(ctx),
+			"input_size", len(s),
+			"records", len(records),
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	records, err = mw.next.CSVParse(ctx, s, dialect)
+	return
+}
+
+func (mw loggingMiddleware) CSVFormat(ctx context.Context, rows [][]string, dialect CSVDialect) (output string, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "csvformat",
+			"tenant", tenantFrom(ctx),
+			"rows", len(rows),
+			"output_size", len(output),
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	output, err = mw.next.CSVFormat(ctx, rows, dialect)
+	return
+}
+
+func (mw loggingMiddleware) CSVParseStream(ctx context.Context, r io.Reader, dialect CSVDialect, record func([]string) error) (n int64, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "csvparse_stream",
+			"tenant", tenantFrom(ctx),
+			"records", n,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	n, err = mw.next.CSVParseStream(ctx, r, dialect, record)
+	return
+}
+
+func (mw loggingMiddleware) CSVFormatStream(ctx context.Context, r io.Reader, w io.Writer, dialect CSVDialect) (n int64, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "csvformat_stream",
+			"tenant", tenantFrom(ctx),
+			"rows", n,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	n, err = mw.next.CSVFormatStream(ctx, r, w, dialect)
+	return
+}
+
+//
+// ─── INSTRUMENTATION ────────────────────────────────────────────────────────────
+//
+
+func (mw instrumentingMiddleware) CSVParse(ctx context.Context, s string, dialect CSVDialect) (records [][]string, err error) {
+	done := mw.begin(ctx, "csvparse", s)
+	defer func() { done(-1, err) }()
+
+	records, err = mw.next.CSVParse(ctx, s, dialect)
+	return
+}
+
+func (mw instrumentingMiddleware) CSVFormat(ctx context.Context, rows [][]string, dialect CSVDialect) (output string, err error) {
+	done := mw.begin(ctx, "csvformat", "")
+	defer func() { done(len(output), err) }()
+
+	output, err = mw.next.CSVFormat(ctx, rows, dialect)
+	return
+}
+
+func (mw instrumentingMiddleware) CSVParseStream(ctx context.Context, r io.Reader, dialect CSVDialect, record func([]string) error) (n int64, err error) {
+	done := mw.begin(ctx, "csvparse_stream", "")
+	defer func() { done(-1, err) }()
+
+	n, err = mw.next.CSVParseStream(ctx, r, dialect, record)
+	return
+}
+
+func (mw instrumentingMiddleware) CSVFormatStream(ctx context.Context, r io.Reader, w io.Writer, dialect CSVDialect) (n int64, err error) {
+	done := mw.begin(ctx, "csvformat_stream", "")
+	defer func() { done(-1, err) }()
+
+	n, err = mw.next.CSVFormatStream(ctx, r, w, dialect)
+	return
+}
@@ -0,0 +1,270 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+//
+// ──────────────────────────────────────────────── I ──────────
+//   :::::: D I F F : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────
+//
+
+// defaultDiffContext is the number of unchanged lines shown around each
+// change in the unified diff.
+const defaultDiffContext = 3
+
+// DiffSpan is a run of text that Diff found equal, inserted or deleted.
+type DiffSpan struct {
+	// Op is one of "equal", "insert" or "delete".
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// DiffResult is the difference between two texts, from Diff.
+type DiffResult struct {
+	Unified string     `json:"unified"`
+	Spans   []DiffSpan `json:"spans"`
+}
+
+type diffRequest struct {
+	A string `json:"a"`
+	B string `json:"b"`
+	// Granularity of the spans, "line" (the default) or "char". The unified
+	// diff is always line based.
+	Granularity string `json:"granularity" validate:"max=64,charset=printable"`
+	Context     *int   `json:"context" validate:"min=0"`
+}
+
+type diffResponse struct {
+	V   DiffResult `json:"v"`
+	Err string     `json:"err,omitempty"`
+}
+
+func (stringService) Diff(ctx context.Context, a, b, granularity string, contextLines int) (DiffResult, error) {
+	if err := ctx.Err(); err != nil {
+		return DiffResult{}, err
+	}
+	dmp := diffmatchpatch.New()
+	// Diffs give up on finding the shortest edit at DiffTimeout, which the
+	// deadline of ctx shortens.
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < dmp.DiffTimeout {
+		dmp.DiffTimeout = time.Until(deadline)
+	}
+	lineDiffs := diffLines(dmp, a, b)
+
+	var spanDiffs []diffmatchpatch.Diff
+	switch granularity {
+	case "", "line":
+		spanDiffs = lineDiffs
+	case "char":
+		spanDiffs = dmp.DiffCleanupSemantic(dmp.DiffMain(a, b, false))
+	default:
+		return DiffResult{}, errorf(CodeUnknownOption, "Unknown diff granularity %q", granularity)
+	}
+	if err := ctx.Err(); err != nil {
+		return DiffResult{}, err
+	}
+
+	result := DiffResult{Unified: unifiedDiff(lineDiffs, contextLines)}
+	for _, d := range spanDiffs {
+		result.Spans = append(result.Spans, DiffSpan{diffOps[d.Type], d.Text})
+	}
+	return result, nil
+}
+
+var diffOps = map[diffmatchpatch.Operation]string{
+	diffmatchpatch.DiffEqual:  "equal",
+	diffmatchpatch.DiffInsert: "insert",
+	diffmatchpatch.DiffDelete: "delete",
+}
+
+// diffLines diffs a and b line by line, by mapping every distinct line to
+// a single rune and diffing the resulting rune slices.
+func diffLines(dmp *diffmatchpatch.DiffMatchPatch, a, b string) []diffmatchpatch.Diff {
+	var lines []string
+	index := make(map[string]rune)
+	toRunes := func(s string) []rune {
+		var rs []rune
+		for _, l := range strings.SplitAfter(s, "\n") {
+			if l == "" {
+				continue
+			}
+			r, ok := index[l]
+			if !ok {
+				// Skip the surrogate range so every line maps to a valid rune.
+				r = rune(len(lines) + 1)
+				if r >= 0xD800 {
+					r += 0x800
+				}
+				index[l] = r
+				lines = append(lines, l)
+			}
+			rs = append(rs, r)
+		}
+		return rs
+	}
+	ra, rb := toRunes(a), toRunes(b)
+
+	diffs := dmp.DiffMainRunes(ra, rb, false)
+	for i, d := range diffs {
+		var text strings.Builder
+		for _, r := range d.Text {
+			if r > 0xD800 {
+				r -= 0x800
+			}
+			text.WriteString(lines[r-1])
+		}
+		diffs[i].Text = text.String()
+	}
+	return diffs
+}
+
+type diffLine struct {
+	op   byte // ' ', '-' or '+'
+	text string
+}
+
+// unifiedDiff renders line diffs in the unified format, grouping changes
+// that are less than 2*context lines apart into the same hunk.
+func unifiedDiff(diffs []diffmatchpatch.Diff, context int) string {
+	var lines []diffLine
+	for _, d := range diffs {
+		op := map[diffmatchpatch.Operation]byte{
+			diffmatchpatch.DiffEqual:  ' ',
+			diffmatchpatch.DiffDelete: '-',
+			diffmatchpatch.DiffInsert: '+',
+		}[d.Type]
+		for _, l := range strings.SplitAfter(d.Text, "\n") {
+			if l != "" {
+				lines = append(lines, diffLine{op, l})
+			}
+		}
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(lines); {
+		if lines[i].op == ' ' {
+			i++
+			continue
+		}
+		start, last := maxInt(0, i-context), i
+		for j := i; j < len(lines); j++ {
+			if lines[j].op != ' ' {
+				last = j
+			} else if j-last > 2*context {
+				break
+			}
+		}
+		stop := minInt(len(lines), last+context+1)
+
+		if b.Len() == 0 {
+			b.WriteString("--- a\n+++ b\n")
+		}
+		oldStart, newStart := 1, 1
+		for _, l := range lines[:start] {
+			if l.op != '+' {
+				oldStart++
+			}
+			if l.op != '-' {
+				newStart++
+			}
+		}
+		var oldCount, newCount int
+		for _, l := range lines[start:stop] {
+			if l.op != '+' {
+				oldCount++
+			}
+			if l.op != '-' {
+				newCount++
+			}
+		}
+		// By convention an empty range starts at the line before it.
+		if oldCount == 0 {
+			oldStart--
+		}
+		if newCount == 0 {
+			newStart--
+		}
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n", hunkRange(oldStart, oldCount), hunkRange(newStart, newCount))
+		for _, l := range lines[start:stop] {
+			b.WriteByte(l.op)
+			b.WriteString(l.text)
+			if !strings.HasSuffix(l.text, "\n") {
+				b.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		i = stop
+	}
+	return b.String()
+}
+
+func hunkRange(start, count int) string {
+	if count == 1 {
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+func makeDiffEndpoint(svc IStringService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(diffRequest)
+		contextLines := defaultDiffContext
+		if req.Context != nil {
+			contextLines = *req.Context
+		}
+		v, err := svc.Diff(ctx, req.A, req.B, req.Granularity, contextLines)
+		if err != nil {
+			return diffResponse{v, err.Error()}, nil
+		}
+		return diffResponse{v, ""}, nil
+	}
+}
+
+func decodeDiffRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request diffRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+//
+// ─── LOGGING ────────────────────────────────────────────────────────────────────
+//
+
+func (mw loggingMiddleware) Diff(ctx context.Context, a, b, granularity string, contextLines int) (output DiffResult, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "diff",
+			"tenant", tenantFrom(ctx),
+			"a_size", len(a),
+			"b_size", len(b),
+			"granularity", granularity,
+			"spans", len(output.Spans),
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	output, err = mw.next.Diff(ctx, a, b, granularity, contextLines)
+	return
+}
+
+//
+// ─── INSTRUMENTATION ────────────────────────────────────────────────────────────
+//
+
+func (mw instrumentingMiddleware) Diff(ctx context.Context, a, b, granularity string, contextLines int) (output DiffResult, err error) {
+	done := mw.begin(ctx, "diff", a+b)
+	defer func() { done(-1, err) }()
+
+	output, err = mw.next.Diff(ctx, a, b, granularity, contextLines)
+	return
+}
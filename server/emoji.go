@@ -0,0 +1,178 @@
+package server
+
+import (
+	"context"
+	_ "embed"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/rivo/uniseg"
+)
+
+//
+// ────────────────────────────────────────────────── I ──────────
+//   :::::: E M O J I : :  :   :    :     :        :          :
+// ────────────────────────────────────────────────────────────
+//
+
+// maxEmojiInput bounds the strings processed by Emoji.
+const maxEmojiInput = 1 << 20
+
+//go:embed emoji.txt
+var emojiData string
+
+// emojiShortcodes map emoji, without their variation selectors, to their
+// shortcodes, and emojiByShortcode map shortcodes back to fully-qualified
+// emoji.
+var emojiShortcodes, emojiByShortcode = parseEmojiData(emojiData)
+
+func parseEmojiData(data string) (shortcodes, byShortcode map[string]string) {
+	shortcodes, byShortcode = make(map[string]string), make(map[string]string)
+	for _, line := range strings.Split(data, "\n") {
+		// Comments start with "# ", unlike the keycap emoji "#️⃣".
+		emoji, code, ok := strings.Cut(line, " ")
+		if !ok || emoji == "#" {
+			continue
+		}
+		shortcodes[strings.ReplaceAll(emoji, "\uFE0F", "")] = code
+		byShortcode[code] = emoji
+	}
+	return shortcodes, byShortcode
+}
+
+// emojiShortcodePattern matches the shortcodes turned back into emoji.
+var emojiShortcodePattern = regexp.MustCompile(`:[a-z0-9_]+:`)
+
+// emojiOf tells whether the grapheme cluster is an emoji, and returns its
+// shortcode. Emoji are matched with or without their variation selectors,
+// except the characters that are text unless followed by one, like "©".
+// Sequences that are not recommended for general interchange, like ZWJ
+// sequences of newer emoji, are still emoji but have no shortcode.
+func emojiOf(cluster string) (shortcode string, ok bool) {
+	if code, ok := emojiShortcodes[strings.ReplaceAll(cluster, "\uFE0F", "")]; ok {
+		if utf8.RuneCountInString(cluster) == 1 && emojiByShortcode[code] != cluster {
+			return "", false
+		}
+		return code, true
+	}
+	r, size := utf8.DecodeRuneInString(cluster)
+	if _, ok := emojiShortcodes[string(r)]; ok && size < len(cluster) {
+		return "", strings.ContainsAny(cluster[size:], "\u200D\uFE0F\U0001F3FB\U0001F3FC\U0001F3FD\U0001F3FE\U0001F3FF")
+	}
+	return "", false
+}
+
+type emojiRequest struct {
+	S string `json:"s"`
+	// Op is one of "strip", "shortcodes", "emojize" or "count".
+	Op string `json:"op" validate:"max=64,charset=printable"`
+}
+
+type emojiResponse struct {
+	V string `json:"v"`
+	// Count is the number of emoji found, or of shortcodes replaced by
+	// "emojize".
+	Count int    `json:"count"`
+	Err   string `json:"err,omitempty"`
+}
+
+// Emoji strips the emoji of s, replaces them with shortcodes like
+// ":thumbs_up:" or back with "emojize", or counts them, leaving s as it
+// is. Emoji are whole grapheme clusters, so that ZWJ sequences, flags,
+// keycaps and emoji with skin tones count as one. Shortcodes are the CLDR
+// short names of Unicode Emoji 15.1, in snake case.
+func (stringService) Emoji(ctx context.Context, s, op string) (output string, count int, err error) {
+	if len(s) > maxEmojiInput {
+		return "", 0, ErrTooLarge
+	}
+	if op == "emojize" {
+		output = emojiShortcodePattern.ReplaceAllStringFunc(s, func(code string) string {
+			if emoji, ok := emojiByShortcode[strings.Trim(code, ":")]; ok {
+				count++
+				return emoji
+			}
+			return code
+		})
+		return output, count, nil
+	}
+	if op != "strip" && op != "shortcodes" && op != "count" {
+		return "", 0, errorf(CodeUnknownOption, "Unknown emoji operation %q", op)
+	}
+
+	var b strings.Builder
+	state := -1
+	for rest := s; rest != ""; {
+		var cluster string
+		cluster, rest, _, state = uniseg.FirstGraphemeClusterInString(rest, state)
+		code, ok := emojiOf(cluster)
+		if ok {
+			count++
+		}
+		switch {
+		case !ok, op == "shortcodes" && code == "":
+			b.WriteString(cluster)
+		case op == "shortcodes":
+			b.WriteString(":" + code + ":")
+		}
+	}
+	if op == "count" {
+		return s, count, nil
+	}
+	return b.String(), count, nil
+}
+
+func makeEmojiEndpoint(svc IStringService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(emojiRequest)
+		output, count, err := svc.Emoji(ctx, req.S, req.Op)
+		if err != nil {
+			return emojiResponse{"", 0, err.Error()}, nil
+		}
+		return emojiResponse{output, count, ""}, nil
+	}
+}
+
+func decodeEmojiRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request emojiRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+//
+// ─── LOGGING ────────────────────────────────────────────────────────────────────
+//
+
+func (mw loggingMiddleware) Emoji(ctx context.Context, s, op string) (output string, count int, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "emoji",
+			"tenant", tenantFrom(ctx),
+			"input_size", len(s),
+			"op", op,
+			"output_size", len(output),
+			"count", count,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	output, count, err = mw.next.Emoji(ctx, s, op)
+	return
+}
+
+//
+// ─── INSTRUMENTATION ────────────────────────────────────────────────────────────
+//
+
+func (mw instrumentingMiddleware) Emoji(ctx context.Context, s, op string) (output string, count int, err error) {
+	done := mw.begin(ctx, "emoji", s)
+	defer func() { done(len(output), err) }()
+
+	output, count, err = mw.next.Emoji(ctx, s, op)
+	return
+}
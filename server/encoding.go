@@ -0,0 +1,224 @@
+package server
+
+import (
+	"context"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+//
+// ──────────────────────────────────────────────────────── I ──────────
+//   :::::: E N C O D I N G : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────────────
+//
+
+// ErrUnknownEncoding is returned when the requested encoding is not one of
+// base64, base64url, base32 or hex.
+var ErrUnknownEncoding = errors.New("Unknown encoding")
+
+// malformedInputError reports input that could not be decoded. It is always
+// the caller's fault and is answered with a 400.
+type malformedInputError struct {
+	encoding string
+	err      error
+}
+
+func (e malformedInputError) Error() string {
+	return fmt.Sprintf("Malformed %s input: %v", e.encoding, e.err)
+}
+
+func (e malformedInputError) localize(l locale) string {
+	return l.Sprintf("Malformed %s input: %v", e.encoding, e.err)
+}
+
+func (e malformedInputError) Unwrap() error { return e.err }
+
+type encodeStringRequest struct {
+	S        string `json:"s"`
+	Encoding string `json:"encoding" validate:"max=64,charset=printable"`
+}
+
+type encodeStringResponse struct {
+	V   string `json:"v"`
+	Err string `json:"err,omitempty"`
+}
+
+type decodeStringRequest struct {
+	S        string `json:"s"`
+	Encoding string `json:"encoding" validate:"max=64,charset=printable"`
+	// Padding is either "strict" (the default) or "lenient". Lenient mode
+	// ignores whitespace and accepts missing or partial padding.
+	Padding string `json:"padding" validate:"max=64,charset=printable"`
+}
+
+type decodeStringResponse struct {
+	V   string `json:"v"`
+	Err string `json:"err,omitempty"`
+}
+
+func (stringService) Encode(ctx context.Context, s, encoding string) (string, error) {
+	switch encoding {
+	case "base64":
+		return base64.StdEncoding.EncodeToString([]byte(s)), nil
+	case "base64url":
+		return base64.URLEncoding.EncodeToString([]byte(s)), nil
+	case "base32":
+		return base32.StdEncoding.EncodeToString([]byte(s)), nil
+	case "hex":
+		return hex.EncodeToString([]byte(s)), nil
+	}
+	return "", ErrUnknownEncoding
+}
+
+func (stringService) Decode(ctx context.Context, s, encoding, padding string) (string, error) {
+	var lenient bool
+	switch padding {
+	case "", "strict":
+	case "lenient":
+		lenient = true
+	default:
+		return "", fmt.Errorf("%w: unsupported padding mode %q", ErrUnknownEncoding, padding)
+	}
+	if lenient {
+		s = strings.Join(strings.Fields(s), "")
+	}
+
+	var (
+		b   []byte
+		err error
+	)
+	switch encoding {
+	case "base64":
+		b, err = decodeBase64(s, base64.StdEncoding, lenient)
+	case "base64url":
+		b, err = decodeBase64(s, base64.URLEncoding, lenient)
+	case "base32":
+		if lenient {
+			b, err = base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(s, "="))
+		} else {
+			b, err = base32.StdEncoding.DecodeString(s)
+		}
+	case "hex":
+		b, err = hex.DecodeString(s)
+	default:
+		return "", ErrUnknownEncoding
+	}
+	if err != nil {
+		return "", malformedInputError{encoding, err}
+	}
+	if !utf8.Valid(b) {
+		return "", malformedInputError{encoding, errors.New("decoded data is not valid UTF-8")}
+	}
+	return string(b), nil
+}
+
+func decodeBase64(s string, enc *base64.Encoding, lenient bool) ([]byte, error) {
+	if lenient {
+		return enc.WithPadding(base64.NoPadding).DecodeString(strings.TrimRight(s, "="))
+	}
+	return enc.Strict().DecodeString(s)
+}
+
+func makeEncodeEndpoint(svc IStringService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(encodeStringRequest)
+		v, err := svc.Encode(ctx, req.S, req.Encoding)
+		if err != nil {
+			return nil, err
+		}
+		return encodeStringResponse{v, ""}, nil
+	}
+}
+
+func makeDecodeEndpoint(svc IStringService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(decodeStringRequest)
+		v, err := svc.Decode(ctx, req.S, req.Encoding, req.Padding)
+		if err != nil {
+			return nil, err
+		}
+		return decodeStringResponse{v, ""}, nil
+	}
+}
+
+func decodeEncodeStringRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request encodeStringRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, malformedInputError{"json", err}
+	}
+	return request, nil
+}
+
+func decodeDecodeStringRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request decodeStringRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, malformedInputError{"json", err}
+	}
+	return request, nil
+}
+
+//
+// ─── LOGGING ────────────────────────────────────────────────────────────────────
+//
+
+func (mw loggingMiddleware) Encode(ctx context.Context, s, encoding string) (output string, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "encode",
+			"tenant", tenantFrom(ctx),
+			"input", s,
+			"encoding", encoding,
+			"output", output,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	output, err = mw.next.Encode(ctx, s, encoding)
+	return
+}
+
+func (mw loggingMiddleware) Decode(ctx context.Context, s, encoding, padding string) (output string, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "decode",
+			"tenant", tenantFrom(ctx),
+			"input", s,
+			"encoding", encoding,
+			"padding", padding,
+			"output", output,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	output, err = mw.next.Decode(ctx, s, encoding, padding)
+	return
+}
+
+//
+// ─── INSTRUMENTATION ────────────────────────────────────────────────────────────
+//
+
+func (mw instrumentingMiddleware) Encode(ctx context.Context, s, encoding string) (output string, err error) {
+	done := mw.begin(ctx, "encode", s)
+	defer func() { done(len(output), err) }()
+
+	output, err = mw.next.Encode(ctx, s, encoding)
+	return
+}
+
+func (mw instrumentingMiddleware) Decode(ctx context.Context, s, encoding, padding string) (output string, err error) {
+	done := mw.begin(ctx, "decode", s)
+	defer func() { done(len(output), err) }()
+
+	output, err = mw.next.Decode(ctx, s, encoding, padding)
+	return
+}
@@ -0,0 +1,273 @@
+package server
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+//
+// ──────────────────────────────────────────────────────────── I ──────────
+//   :::::: E N C R Y P T I O N : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────────────────
+//
+
+// Ciphertexts produced by Encrypt are the URL-safe base64 encoding of
+//
+//	version (1 byte) | len(keyID) (1 byte) | keyID | nonce (12 bytes) | sealed data
+//
+// The version and key ID are authenticated as additional data, so they
+// cannot be swapped without the decryption failing.
+const ciphertextVersion = 1
+
+// maxKeyIDLength is the longest key ID the header can hold.
+const maxKeyIDLength = 255
+
+var (
+	// ErrNoEncryptionKey is returned when no key provider is configured.
+	ErrNoEncryptionKey = errors.New("Encryption is not configured")
+	// ErrInvalidCiphertext is returned for anything Decrypt cannot open,
+	// without telling the caller which check failed.
+	ErrInvalidCiphertext = errors.New("Invalid ciphertext")
+)
+
+// KeyProvider resolves key IDs to raw AES keys. Keys read from the config
+// file are served by staticKeyProvider; an external KMS is plugged in with
+// WithKeyProvider.
+type KeyProvider interface {
+	// ActiveKey returns the key new data must be encrypted with.
+	ActiveKey(ctx context.Context) (id string, key []byte, err error)
+	// Key returns the key with the given ID, active or not.
+	Key(ctx context.Context, id string) ([]byte, error)
+}
+
+type staticKeyProvider struct {
+	active string
+	keys   map[string][]byte
+	// unknown is returned for unknown key IDs.
+	unknown error
+}
+
+func newStaticKeyProvider(cfg cryptoConfig) (*staticKeyProvider, error) {
+	p := &staticKeyProvider{active: cfg.ActiveKey, keys: make(map[string][]byte), unknown: ErrInvalidCiphertext}
+	for id, encoded := range cfg.Keys {
+		if id == "" || len(id) > maxKeyIDLength {
+			return nil, fmt.Errorf("key ID %q must be between 1 and %d bytes", id, maxKeyIDLength)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %v", id, err)
+		}
+		if _, err := aes.NewCipher(key); err != nil {
+			return nil, fmt.Errorf("key %q: %v", id, err)
+		}
+		p.keys[id] = key
+	}
+	if _, ok := p.keys[p.active]; !ok {
+		return nil, fmt.Errorf("active key %q is not defined", p.active)
+	}
+	return p, nil
+}
+
+func (p *staticKeyProvider) ActiveKey(ctx context.Context) (string, []byte, error) {
+	return p.active, p.keys[p.active], nil
+}
+
+func (p *staticKeyProvider) Key(ctx context.Context, id string) ([]byte, error) {
+	key, ok := p.keys[id]
+	if !ok {
+		return nil, p.unknown
+	}
+	return key, nil
+}
+
+type encryptRequest struct {
+	S string `json:"s"`
+}
+
+type encryptResponse struct {
+	V     string `json:"v"`
+	KeyID string `json:"key_id,omitempty"`
+	Err   string `json:"err,omitempty"`
+}
+
+type decryptRequest struct {
+	S string `json:"s"`
+}
+
+type decryptResponse struct {
+	V     string `json:"v"`
+	KeyID string `json:"key_id,omitempty"`
+	Err   string `json:"err,omitempty"`
+}
+
+func (svc stringService) Encrypt(ctx context.Context, s string) (ciphertext, keyID string, err error) {
+	if svc.keys == nil {
+		return "", "", ErrNoEncryptionKey
+	}
+	keyID, key, err := svc.keys.ActiveKey(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	// The IDs of external providers are not checked when configured.
+	if keyID == "" || len(keyID) > maxKeyIDLength {
+		return "", "", fmt.Errorf("active key ID of %d bytes, must be between 1 and %d bytes", len(keyID), maxKeyIDLength)
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return "", "", err
+	}
+
+	header := append([]byte{ciphertextVersion, byte(len(keyID))}, keyID...)
+	// A fresh random nonce per message; with 96-bit nonces this is safe for
+	// well over the number of messages a single key should ever protect.
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", "", err
+	}
+	out := make([]byte, 0, len(header)+len(nonce)+len(s)+aead.Overhead())
+	out = append(append(out, header...), nonce...)
+	out = aead.Seal(out, nonce, []byte(s), header)
+	return base64.RawURLEncoding.EncodeToString(out), keyID, nil
+}
+
+func (svc stringService) Decrypt(ctx context.Context, s string) (plaintext, keyID string, err error) {
+	if svc.keys == nil {
+		return "", "", ErrNoEncryptionKey
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(raw) < 2 || raw[0] != ciphertextVersion {
+		return "", "", ErrInvalidCiphertext
+	}
+	headerLen := 2 + int(raw[1])
+	if len(raw) < headerLen {
+		return "", "", ErrInvalidCiphertext
+	}
+	header, body := raw[:headerLen], raw[headerLen:]
+	keyID = string(header[2:])
+
+	key, err := svc.keys.Key(ctx, keyID)
+	if err != nil {
+		return "", "", err
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return "", "", err
+	}
+	if len(body) < aead.NonceSize()+aead.Overhead() {
+		return "", "", ErrInvalidCiphertext
+	}
+	nonce, sealed := body[:aead.NonceSize()], body[aead.NonceSize():]
+	out, err := aead.Open(nil, nonce, sealed, header)
+	if err != nil {
+		return "", "", ErrInvalidCiphertext
+	}
+	return string(out), keyID, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func makeEncryptEndpoint(svc IStringService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(encryptRequest)
+		v, keyID, err := svc.Encrypt(ctx, req.S)
+		if err != nil {
+			return encryptResponse{"", "", err.Error()}, nil
+		}
+		return encryptResponse{v, keyID, ""}, nil
+	}
+}
+
+func makeDecryptEndpoint(svc IStringService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(decryptRequest)
+		v, keyID, err := svc.Decrypt(ctx, req.S)
+		if err != nil {
+			return decryptResponse{"", "", err.Error()}, nil
+		}
+		return decryptResponse{v, keyID, ""}, nil
+	}
+}
+
+func decodeEncryptRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request encryptRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+func decodeDecryptRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request decryptRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+//
+// ─── LOGGING ────────────────────────────────────────────────────────────────────
+//
+
+// Neither plaintexts nor ciphertexts are logged.
+func (mw loggingMiddleware) Encrypt(ctx context.Context, s string) (ciphertext, keyID string, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "encrypt",
+			"tenant", tenantFrom(ctx),
+			"key_id", keyID,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	ciphertext, keyID, err = mw.next.Encrypt(ctx, s)
+	return
+}
+
+func (mw loggingMiddleware) Decrypt(ctx context.Context, s string) (plaintext, keyID string, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "decrypt",
+			"tenant", tenantFrom(ctx),
+			"key_id", keyID,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	plaintext, keyID, err = mw.next.Decrypt(ctx, s)
+	return
+}
+
+//
+// ─── INSTRUMENTATION ────────────────────────────────────────────────────────────
+//
+
+func (mw instrumentingMiddleware) Encrypt(ctx context.Context, s string) (ciphertext, keyID string, err error) {
+	done := mw.begin(ctx, "encrypt", s)
+	defer func() { done(len(ciphertext), err) }()
+
+	ciphertext, keyID, err = mw.next.Encrypt(ctx, s)
+	return
+}
+
+func (mw instrumentingMiddleware) Decrypt(ctx context.Context, s string) (plaintext, keyID string, err error) {
+	done := mw.begin(ctx, "decrypt", s)
+	defer func() { done(len(plaintext), err) }()
+
+	plaintext, keyID, err = mw.next.Decrypt(ctx, s)
+	return
+}
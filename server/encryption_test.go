@@ -0,0 +1,77 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// kms serves the keys of an external key management service.
+type kms struct {
+	active string
+	keys   map[string][]byte
+}
+
+func (k *kms) ActiveKey(context.Context) (string, []byte, error) {
+	return k.active, k.keys[k.active], nil
+}
+
+func (k *kms) Key(_ context.Context, id string) ([]byte, error) {
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, ErrInvalidCiphertext
+	}
+	return key, nil
+}
+
+func TestKeyProvider(t *testing.T) {
+	tests := []struct {
+		name string
+		// keyID is that of the key encrypted with.
+		keyID string
+		// rotate makes a new key active after encrypting, and retire
+		// forgets the key encrypted with.
+		rotate, retire bool
+		wantEncryptErr bool
+		wantErr        error
+	}{
+		{name: "active key", keyID: "k1"},
+		{name: "rotated key", keyID: "k1", rotate: true},
+		{name: "retired key", keyID: "k1", rotate: true, retire: true, wantErr: ErrInvalidCiphertext},
+		{name: "longest key ID", keyID: strings.Repeat("k", maxKeyIDLength)},
+		{name: "key ID too long", keyID: strings.Repeat("k", maxKeyIDLength+1), wantEncryptErr: true},
+		{name: "empty key ID", keyID: "", wantEncryptErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys := &kms{active: tt.keyID, keys: map[string][]byte{tt.keyID: bytes.Repeat([]byte{1}, 32)}}
+			svc := stringService{keys: keys}
+			ciphertext, keyID, err := svc.Encrypt(context.Background(), "secret")
+			if tt.wantEncryptErr {
+				if err == nil {
+					t.Fatalf("Encrypt with a key ID of %d bytes succeeded", len(tt.keyID))
+				}
+				return
+			}
+			if err != nil || keyID != tt.keyID {
+				t.Fatalf("Encrypt = %q, %v, want key %q", keyID, err, tt.keyID)
+			}
+			if tt.rotate {
+				keys.keys["k2"] = bytes.Repeat([]byte{2}, 32)
+				keys.active = "k2"
+			}
+			if tt.retire {
+				delete(keys.keys, tt.keyID)
+			}
+			plaintext, keyID, err := svc.Decrypt(context.Background(), ciphertext)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Decrypt error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (plaintext != "secret" || keyID != tt.keyID) {
+				t.Errorf("Decrypt = %q with %q, want %q with %q", plaintext, keyID, "secret", tt.keyID)
+			}
+		})
+	}
+}
@@ -0,0 +1,309 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+)
+
+//
+// ────────────────────────────────────────────────────────────── I ──────────
+//   :::::: E R R O R   C O D E S : :  :   :    :     :        :          :
+// ────────────────────────────────────────────────────────────────────────
+//
+
+// ErrorCode identifies a kind of failure for programs, which can branch on
+// it rather than on messages, which change. Codes are answered in the
+// "code" field of failed responses, next to "err" or in the "error" object
+// of v2, label the request_errors metric and are logged with the errors.
+// Codes are never renamed nor reused.
+type ErrorCode string
+
+// The catalog of error codes.
+const (
+	// CodeEmptyInput is a request missing a required field, or with a blank
+	// one.
+	CodeEmptyInput ErrorCode = "ERR_EMPTY_INPUT"
+	// CodeValidation is a request breaking the other rules of its fields.
+	CodeValidation ErrorCode = "ERR_VALIDATION"
+	// CodeMalformedInput is an input that cannot be parsed or decoded: JSON,
+	// CSV, base64 and the like.
+	CodeMalformedInput ErrorCode = "ERR_MALFORMED_INPUT"
+	// CodeUnknownEncoding is an encoding or padding not supported.
+	CodeUnknownEncoding ErrorCode = "ERR_UNKNOWN_ENCODING"
+	// CodeUnknownOption is an option of an operation naming no algorithm,
+	// mode, language or the like known to the service.
+	CodeUnknownOption ErrorCode = "ERR_UNKNOWN_OPTION"
+	// CodeOutOfRange is a numeric option beyond its bounds.
+	CodeOutOfRange ErrorCode = "ERR_OUT_OF_RANGE"
+	// CodePatternInvalid is a template, placeholder, key or alphabet that
+	// does not follow its syntax.
+	CodePatternInvalid ErrorCode = "ERR_PATTERN_INVALID"
+	// CodeUnsupportedInput is an input well formed but that the operation
+	// cannot handle, like a number too large to spell.
+	CodeUnsupportedInput ErrorCode = "ERR_UNSUPPORTED_INPUT"
+	// CodeLimitExceeded is an operation whose work or result outgrows its
+	// limits, like the nesting of JSON or the output of templates.
+	CodeLimitExceeded ErrorCode = "ERR_LIMIT_EXCEEDED"
+	// CodeNotConfigured is an operation needing keys, models or stemmers the
+	// service is not configured with.
+	CodeNotConfigured ErrorCode = "ERR_NOT_CONFIGURED"
+	// CodeInvalidCiphertext is a ciphertext that cannot be decrypted.
+	CodeInvalidCiphertext ErrorCode = "ERR_INVALID_CIPHERTEXT"
+	// CodeUnknownKey is a key ID the service does not have.
+	CodeUnknownKey ErrorCode = "ERR_UNKNOWN_KEY"
+	// CodeUnknownOperation is an operation the service does not have.
+	CodeUnknownOperation ErrorCode = "ERR_UNKNOWN_OPERATION"
+	// CodeOperationFailed is a failure of an operation without a more
+	// specific code, like those of the registered operations.
+	CodeOperationFailed ErrorCode = "ERR_OPERATION_FAILED"
+	// CodeTimeout is an operation or a request that outlived its timeout.
+	CodeTimeout ErrorCode = "ERR_TIMEOUT"
+	// CodeTooLarge is a request body beyond the size limits.
+	CodeTooLarge ErrorCode = "ERR_TOO_LARGE"
+	// CodeUnsupportedMediaType is a body or an Accept header of no codec.
+	CodeUnsupportedMediaType ErrorCode = "ERR_UNSUPPORTED_MEDIA_TYPE"
+	// CodeUnauthorized is a request of the admin listener without its token.
+	CodeUnauthorized ErrorCode = "ERR_UNAUTHORIZED"
+	// CodeInvalidToken is a request without a valid OIDC access token.
+	CodeInvalidToken ErrorCode = "ERR_INVALID_TOKEN"
+	// CodeInsufficientScope is a call of an operation whose scopes the
+	// access token lacks.
+	CodeInsufficientScope ErrorCode = "ERR_INSUFFICIENT_SCOPE"
+	// CodePermissionDenied is a call of an operation denied to the roles of
+	// its identity.
+	CodePermissionDenied ErrorCode = "ERR_PERMISSION_DENIED"
+	// CodeAccessDenied is a request rejected by the access rules.
+	CodeAccessDenied ErrorCode = "ERR_ACCESS_DENIED"
+	// CodeUnknownTenant is an API key or tenant ID of no tenant.
+	CodeUnknownTenant ErrorCode = "ERR_UNKNOWN_TENANT"
+	// CodeRateLimited is a request beyond the rate limit of its tenant.
+	CodeRateLimited ErrorCode = "ERR_RATE_LIMITED"
+	// CodeQuotaExceeded is a request beyond the quota of its client.
+	CodeQuotaExceeded ErrorCode = "ERR_QUOTA_EXCEEDED"
+	// CodeDisabled is an operation turned off by a flag or for the tenant.
+	CodeDisabled ErrorCode = "ERR_DISABLED"
+	// CodeOverloaded is a call rejected for lack of capacity, to retry
+	// later.
+	CodeOverloaded ErrorCode = "ERR_OVERLOADED"
+	// CodeIdempotencyKeyReused is an idempotency key sent again with
+	// another request.
+	CodeIdempotencyKeyReused ErrorCode = "ERR_IDEMPOTENCY_KEY_REUSED"
+	// CodeConflict is a request conflicting with the state of its resource,
+	// like a job not finished yet.
+	CodeConflict ErrorCode = "ERR_CONFLICT"
+	// CodeNotFound is a job, flag or other resource that does not exist.
+	CodeNotFound ErrorCode = "ERR_NOT_FOUND"
+	// CodeCallbackNotAllowed is a callback URL out of the allowed hosts.
+	CodeCallbackNotAllowed ErrorCode = "ERR_CALLBACK_NOT_ALLOWED"
+	// CodeFaultInjected is a failure injected by the chaos middleware.
+	CodeFaultInjected ErrorCode = "ERR_FAULT_INJECTED"
+	// CodeInternal is any other failure of the service.
+	CodeInternal ErrorCode = "ERR_INTERNAL"
+)
+
+// codedError is an error of the catalog that no sentinel error identifies,
+// like those whose message quotes the request.
+type codedError struct {
+	code ErrorCode
+	err  error
+}
+
+func (e codedError) Error() string { return e.err.Error() }
+
+func (e codedError) Unwrap() error { return e.err }
+
+// errorf returns the error of fmt.Sprintf with code, translated into the
+// language of the request.
+func errorf(code ErrorCode, format string, args ...interface{}) error {
+	return codedError{code, localizedMessage{format, args}}
+}
+
+// withCode returns err with code.
+func withCode(code ErrorCode, err error) error {
+	return codedError{code, err}
+}
+
+// errorCodeOf returns the code of err, CodeInternal for unknown errors.
+func errorCodeOf(err error) ErrorCode {
+	var coded codedError
+	var invalid validationError
+	switch {
+	case errors.As(err, &coded):
+		return coded.code
+	case errors.As(err, new(*http.MaxBytesError)), errors.Is(err, ErrTooLarge):
+		return CodeTooLarge
+	case errors.Is(err, os.ErrDeadlineExceeded), errors.Is(err, context.DeadlineExceeded), errors.Is(err, ErrRenderTimeout):
+		return CodeTimeout
+	case errors.As(err, &invalid):
+		for _, v := range invalid.Violations {
+			if v.Rule != "required" && v.Rule != "notblank" {
+				return CodeValidation
+			}
+		}
+		return CodeEmptyInput
+	case errors.Is(err, ErrUnknownEncoding):
+		return CodeUnknownEncoding
+	case errors.As(err, new(malformedInputError)):
+		return CodeMalformedInput
+	case errors.Is(err, ErrUnsupportedMediaType):
+		return CodeUnsupportedMediaType
+	case errors.Is(err, ErrUnauthorized):
+		return CodeUnauthorized
+	case errors.Is(err, ErrInvalidToken):
+		return CodeInvalidToken
+	case errors.Is(err, ErrInsufficientScope):
+		return CodeInsufficientScope
+	case errors.Is(err, ErrPermissionDenied):
+		return CodePermissionDenied
+	case errors.Is(err, ErrAccessDenied):
+		return CodeAccessDenied
+	case errors.Is(err, ErrUnknownTenant):
+		return CodeUnknownTenant
+	case errors.Is(err, ErrRateLimited):
+		return CodeRateLimited
+	case errors.Is(err, ErrQuotaExceeded):
+		return CodeQuotaExceeded
+	case errors.Is(err, ErrOperationDisabled), errors.Is(err, ErrFeatureDisabled):
+		return CodeDisabled
+	case errors.Is(err, ErrOverloaded), errors.Is(err, ErrJobQueueFull), errors.Is(err, ErrJobQueueClosed):
+		return CodeOverloaded
+	case errors.Is(err, ErrIdempotencyKeyReused):
+		return CodeIdempotencyKeyReused
+	case errors.Is(err, ErrIdempotencyInFlight), errors.Is(err, ErrJobNotFinished), errors.Is(err, ErrNoCallback), errors.Is(err, ErrCallbackInProgress):
+		return CodeConflict
+	case errors.Is(err, ErrUnknownJob), errors.Is(err, ErrUnknownFlag):
+		return CodeNotFound
+	case errors.Is(err, ErrUnknownLogLevel):
+		return CodeUnknownOption
+	case errors.Is(err, ErrCallbackNotAllowed):
+		return CodeCallbackNotAllowed
+	case errors.Is(err, ErrNoEncryptionKey), errors.Is(err, ErrNoSigningKey):
+		return CodeNotConfigured
+	case errors.Is(err, ErrInvalidCiphertext):
+		return CodeInvalidCiphertext
+	case errors.Is(err, ErrUnknownSigningKey):
+		return CodeUnknownKey
+	case errors.Is(err, ErrRenderOutputTooLarge), errors.Is(err, ErrRenderTooManyIterations):
+		return CodeLimitExceeded
+	case errors.Is(err, errInjectedFault):
+		return CodeFaultInjected
+	case errors.As(err, new(operationError)):
+		return CodeOperationFailed
+	}
+	return CodeInternal
+}
+
+//
+// ─── RECORDED CODES ─────────────────────────────────────────────────────────────
+//
+
+// Operations report their failures in the "err" field of their responses,
+// which only hold the message. The code of the failure is recorded in the
+// context of the call by the instrumenting middleware, for the encoders of
+// the responses to answer it.
+
+type errorCodesKey struct{}
+
+// errorCodes is the code of the last failure of a call, and the failure.
+type errorCodes struct {
+	mu   sync.Mutex
+	code ErrorCode
+	err  error
+}
+
+// withErrorCodes returns ctx recording the codes of failures in codes.
+func withErrorCodes(ctx context.Context) (context.Context, *errorCodes) {
+	codes := new(errorCodes)
+	return context.WithValue(ctx, errorCodesKey{}, codes), codes
+}
+
+// recordErrorCodes is a ServerBefore function recording the codes of the
+// failures of each request.
+func recordErrorCodes(ctx context.Context, _ *http.Request) context.Context {
+	ctx, _ = withErrorCodes(ctx)
+	return ctx
+}
+
+// recordErrorCode records the code of err in ctx, if it records codes.
+func recordErrorCode(ctx context.Context, err error) {
+	if codes, ok := ctx.Value(errorCodesKey{}).(*errorCodes); ok && err != nil {
+		codes.mu.Lock()
+		codes.code = errorCodeOf(err)
+		codes.err = err
+		codes.mu.Unlock()
+	}
+}
+
+// Code returns the code of the last failure recorded, CodeOperationFailed
+// if none was.
+func (c *errorCodes) Code() ErrorCode {
+	if c == nil {
+		return CodeOperationFailed
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.code == "" {
+		return CodeOperationFailed
+	}
+	return c.code
+}
+
+// recordedErrorCode returns the code of the last failure recorded in ctx.
+func recordedErrorCode(ctx context.Context) ErrorCode {
+	codes, _ := ctx.Value(errorCodesKey{}).(*errorCodes)
+	return codes.Code()
+}
+
+// Err returns the last failure recorded, if any.
+func (c *errorCodes) Err() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// recordedError returns the last failure recorded in ctx.
+func recordedError(ctx context.Context) error {
+	codes, _ := ctx.Value(errorCodesKey{}).(*errorCodes)
+	return codes.Err()
+}
+
+// withErrorCode returns response, a struct with an "err" field, as a JSON
+// object with code in its "code" field.
+func withErrorCode(response interface{}, code ErrorCode) (map[string]interface{}, error) {
+	data, err := json.Marshal(response)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	if _, ok := m["code"]; !ok {
+		m["code"] = code
+	}
+	return m, nil
+}
+
+// errorCodeLogger logs the code of the errors of records after them.
+type errorCodeLogger struct {
+	next log.Logger
+}
+
+func (l errorCodeLogger) Log(keyvals ...interface{}) error {
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		if err, ok := keyvals[i+1].(error); ok && keyvals[i] == "err" {
+			keyvals = append(keyvals[:i+2:i+2], append([]interface{}{"code", errorCodeOf(err)}, keyvals[i+2:]...)...)
+			break
+		}
+	}
+	return l.next.Log(keyvals...)
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strings"
+)
+
+//
+// ────────────────────────────────────────────────── I ──────────
+//   :::::: E T A G S : :  :   :    :     :        :          :
+// ────────────────────────────────────────────────────────────
+//
+
+// defaultCacheControl lets caches keep the responses of deterministic
+// operations, as long as they revalidate them with their ETag.
+const defaultCacheControl = "no-cache"
+
+// withETags gives the successful responses of the cacheable operations a
+// strong ETag, and answers the requests whose If-None-Match has it with a
+// 304 instead. The request is still served by next, so that it is only
+// answered with a 304 once the flags, credentials and limits of the
+// operation let it through, the result cache sparing most of the work.
+// ETags are digests of the request:
+// route, tenant, negotiated headers and body, with version, the
+// fingerprint of the configuration, since it changes some results.
+func withETags(next http.Handler, cfg httpConfig, version string) http.Handler {
+	if cfg.DisableETags {
+		return next
+	}
+	routes := make(map[string]bool, 2*len(cacheableOperations))
+	for name := range cacheableOperations {
+		route := operationRoute(name)
+		routes[route] = true
+		routes["/"+currentAPIVersion+route] = true
+	}
+	cacheControl := cfg.CacheControl
+	if cacheControl == "" {
+		cacheControl = defaultCacheControl
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !routes[r.URL.Path] || (r.Method != http.MethodGet && r.Method != http.MethodPost) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			encodeError(r.Context(), err, w)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		h := sha256.New()
+		for _, part := range []string{
+			version,
+			r.URL.Path,
+			tenantFrom(r.Context()),
+			r.Header.Get("Accept"),
+			r.Header.Get("Accept-Encoding"),
+			r.Header.Get("Accept-Language"),
+			r.Header.Get("Content-Type"),
+		} {
+			io.WriteString(h, part)
+			h.Write([]byte{0})
+		}
+		h.Write(body)
+		etag := `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+
+		w.Header().Add("Vary", "Accept")
+		w.Header().Add("Vary", "Content-Type")
+		next.ServeHTTP(&etagWriter{
+			ResponseWriter: w,
+			etag:           etag,
+			cacheControl:   cacheControl,
+			notModified:    etagMatches(r.Header.Get("If-None-Match"), etag),
+		}, r)
+	})
+}
+
+// etagMatches tells whether the If-None-Match header ifNoneMatch lists
+// etag, comparing weakly as RFC 9110 requires.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// etagWriter sets the ETag and Cache-Control headers of successful
+// responses, and turns them into a 304 without body when notModified.
+type etagWriter struct {
+	http.ResponseWriter
+	etag         string
+	cacheControl string
+	notModified  bool
+	wroteHeader  bool
+	// discard drops the body of the 304.
+	discard bool
+}
+
+func (w *etagWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if status == http.StatusOK {
+		w.Header().Set("ETag", w.etag)
+		w.Header().Set("Cache-Control", w.cacheControl)
+		if w.notModified {
+			w.Header().Del("Content-Length")
+			w.discard = true
+			status = http.StatusNotModified
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *etagWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.discard {
+		return len(p), nil
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the connection.
+func (w *etagWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestETags(t *testing.T) {
+	// The operation is guarded, denying the requests without an API key.
+	h := withETags(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(apiKeyHeader) == "" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`{"v":"A"}`))
+	}), httpConfig{}, "v1")
+	request := func(apiKey, ifNoneMatch string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/uppercase", strings.NewReader(`{"s":"a"}`))
+		if apiKey != "" {
+			r.Header.Set(apiKeyHeader, apiKey)
+		}
+		if ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	etag := request("k1", "").Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag")
+	}
+
+	tests := []struct {
+		name        string
+		apiKey      string
+		ifNoneMatch string
+		wantStatus  int
+		wantBody    string
+	}{
+		{name: "no ETag", apiKey: "k1", wantStatus: 200, wantBody: `{"v":"A"}`},
+		{name: "same ETag", apiKey: "k1", ifNoneMatch: etag, wantStatus: 304},
+		{name: "weak ETag", apiKey: "k1", ifNoneMatch: "W/" + etag, wantStatus: 304},
+		{name: "any ETag", apiKey: "k1", ifNoneMatch: "*", wantStatus: 304},
+		{name: "other ETag", apiKey: "k1", ifNoneMatch: `"other"`, wantStatus: 200, wantBody: `{"v":"A"}`},
+		{name: "same ETag, denied", ifNoneMatch: etag, wantStatus: 403},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := request(tt.apiKey, tt.ifNoneMatch)
+			if w.Code != tt.wantStatus || w.Body.String() != tt.wantBody {
+				t.Fatalf("got %d %q, want %d %q", w.Code, w.Body, tt.wantStatus, tt.wantBody)
+			}
+			if got := w.Header().Get("ETag"); (tt.wantStatus == 403) != (got == "") {
+				t.Errorf("ETag %q with a %d", got, w.Code)
+			}
+		})
+	}
+}
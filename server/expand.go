@@ -0,0 +1,260 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+//
+// ──────────────────────────────────────────────────── I ──────────
+//   :::::: E X P A N D : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────────
+//
+
+// Limits of Expand. Nesting is bounded so that variables referring to each
+// other fail instead of looping, and the output so that a few nested
+// variables cannot blow up.
+const (
+	maxExpandDepth  = 32
+	maxExpandOutput = 1 << 20
+)
+
+type expandRequest struct {
+	S    string            `json:"s"`
+	Vars map[string]string `json:"vars" validate:"max=1000"`
+	// Missing is what unset variables without a default become: "empty"
+	// (the default, like envsubst), "keep" to leave the placeholder as is,
+	// or "error".
+	Missing string `json:"missing" validate:"max=64,charset=printable"`
+	// MaxDepth is how many levels of placeholders in the values of vars
+	// are expanded. It is 0 by default, inserting values as they are.
+	MaxDepth int `json:"max_depth" validate:"min=0"`
+}
+
+type expandResponse struct {
+	V string `json:"v"`
+	// Unset are the variables that were not set, in order of appearance.
+	Unset []string `json:"unset"`
+	Err   string   `json:"err,omitempty"`
+}
+
+// expander substitutes the placeholders of a string.
+type expander struct {
+	vars     map[string]string
+	missing  string
+	maxDepth int
+	unset    []string
+}
+
+// Expand substitutes the $VAR and ${VAR} placeholders of s with the values
+// of vars. ${VAR:-default} uses default when VAR is unset or empty and
+// ${VAR-default} only when it is unset; defaults can hold placeholders
+// themselves. $$ is a literal dollar sign, and a dollar sign that does not
+// start a placeholder is kept.
+func (stringService) Expand(ctx context.Context, s string, vars map[string]string, missing string, maxDepth int) (output string, unset []string, err error) {
+	switch missing {
+	case "":
+		missing = "empty"
+	case "empty", "keep", "error":
+	default:
+		return "", nil, errorf(CodeUnknownOption, "Unknown missing variable mode %q", missing)
+	}
+	if maxDepth < 0 || maxDepth > maxExpandDepth {
+		return "", nil, errorf(CodeOutOfRange, "Max depth must be between 0 and %d", maxExpandDepth)
+	}
+	e := &expander{vars: vars, missing: missing, maxDepth: maxDepth, unset: []string{}}
+	output, err = e.expand(s, 0)
+	if err != nil {
+		return "", nil, err
+	}
+	return output, e.unset, nil
+}
+
+func (e *expander) expand(s string, depth int) (string, error) {
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(s, '$')
+		if i < 0 {
+			b.WriteString(s)
+			break
+		}
+		b.WriteString(s[:i])
+		s = s[i:]
+
+		var name, def, placeholder string
+		hasDefault, emptyIsUnset := false, false
+		switch {
+		case strings.HasPrefix(s, "$$"):
+			b.WriteByte('$')
+			s = s[2:]
+			continue
+		case strings.HasPrefix(s, "${"):
+			end := closingBrace(s)
+			if end < 0 {
+				return "", errorf(CodePatternInvalid, "Unclosed placeholder %.20q", s)
+			}
+			placeholder = s[:end+1]
+			body := s[2:end]
+			name = body[:variableNameLength(body)]
+			switch rest := body[len(name):]; {
+			case name == "":
+				return "", errorf(CodePatternInvalid, "Invalid placeholder %.20q", placeholder)
+			case rest == "":
+			case strings.HasPrefix(rest, ":-"):
+				def, hasDefault, emptyIsUnset = rest[2:], true, true
+			case strings.HasPrefix(rest, "-"):
+				def, hasDefault = rest[1:], true
+			default:
+				return "", errorf(CodePatternInvalid, "Invalid placeholder %.20q", placeholder)
+			}
+		default:
+			n := variableNameLength(s[1:])
+			if n == 0 {
+				b.WriteByte('$')
+				s = s[1:]
+				continue
+			}
+			placeholder, name = s[:n+1], s[1:n+1]
+		}
+		s = s[len(placeholder):]
+
+		value, ok := e.vars[name]
+		switch {
+		case ok && !(emptyIsUnset && value == ""):
+			if depth < e.maxDepth {
+				var err error
+				if value, err = e.expand(value, depth+1); err != nil {
+					return "", err
+				}
+			} else if e.maxDepth > 0 && hasPlaceholder(value) {
+				return "", errorf(CodeLimitExceeded, "Variables nest beyond the max depth of %d", e.maxDepth)
+			}
+		case hasDefault:
+			var err error
+			if value, err = e.expand(def, depth); err != nil {
+				return "", err
+			}
+		default:
+			if !ok && !contains(e.unset, name) {
+				e.unset = append(e.unset, name)
+			}
+			switch e.missing {
+			case "keep":
+				value = placeholder
+			case "error":
+				return "", errorf(CodeUnsupportedInput, "Variable %q is not set", name)
+			}
+		}
+		b.WriteString(value)
+		if b.Len() > maxExpandOutput {
+			return "", ErrTooLarge
+		}
+	}
+	return b.String(), nil
+}
+
+// closingBrace returns the index of the brace closing the placeholder
+// starting s, skipping the braces of nested placeholders, or -1.
+func closingBrace(s string) int {
+	depth := 0
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			if depth--; depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// variableNameLength returns the length of the variable name starting s,
+// made of ASCII letters, digits and underscores, but not starting with a
+// digit.
+func variableNameLength(s string) int {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '_' && (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (i == 0 || c < '0' || c > '9') {
+			return i
+		}
+	}
+	return len(s)
+}
+
+// hasPlaceholder tells whether s holds a placeholder that Expand would
+// substitute.
+func hasPlaceholder(s string) bool {
+	for i := 0; i < len(s)-1; i++ {
+		if s[i] != '$' {
+			continue
+		}
+		if s[i+1] == '$' {
+			i++
+			continue
+		}
+		if s[i+1] == '{' || variableNameLength(s[i+1:]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func makeExpandEndpoint(svc IStringService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(expandRequest)
+		output, unset, err := svc.Expand(ctx, req.S, req.Vars, req.Missing, req.MaxDepth)
+		if err != nil {
+			return expandResponse{"", nil, err.Error()}, nil
+		}
+		return expandResponse{output, unset, ""}, nil
+	}
+}
+
+func decodeExpandRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request expandRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+//
+// ─── LOGGING ────────────────────────────────────────────────────────────────────
+//
+
+func (mw loggingMiddleware) Expand(ctx context.Context, s string, vars map[string]string, missing string, maxDepth int) (output string, unset []string, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "expand",
+			"tenant", tenantFrom(ctx),
+			"input_size", len(s),
+			"vars", len(vars),
+			"missing", missing,
+			"max_depth", maxDepth,
+			"output_size", len(output),
+			"unset", strings.Join(unset, ","),
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	output, unset, err = mw.next.Expand(ctx, s, vars, missing, maxDepth)
+	return
+}
+
+//
+// ─── INSTRUMENTATION ────────────────────────────────────────────────────────────
+//
+
+func (mw instrumentingMiddleware) Expand(ctx context.Context, s string, vars map[string]string, missing string, maxDepth int) (output string, unset []string, err error) {
+	done := mw.begin(ctx, "expand", s)
+	defer func() { done(len(output), err) }()
+
+	output, unset, err = mw.next.Expand(ctx, s, vars, missing, maxDepth)
+	return
+}
@@ -0,0 +1,365 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+//
+// ──────────────────────────────────────────────────────────── I ──────────
+//   :::::: F A S T   C O D E C : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────────────────
+//
+
+// Most of the time of small requests goes to decoding and encoding JSON.
+// Their bodies are read into pooled buffers, and the messages of the
+// busiest operations have a fast path: requests that are flat objects of
+// strings are parsed without reflection, and responses are appended to
+// pooled buffers as they are. Anything else goes to encoding/json, so the
+// fast path never changes what is accepted nor what is answered.
+// "stringsvc bench -codecs" compares both paths, like the benchmarks of
+// the package.
+
+const (
+	// maxFastRequestSize bounds the requests parsed by the fast path.
+	// Larger ones are dominated by the copy of their strings rather than by
+	// reflection.
+	maxFastRequestSize = 16 << 10
+	// maxPooledBufferSize bounds the buffers kept in the pool, so that a
+	// large request does not hold its memory.
+	maxPooledBufferSize = 64 << 10
+)
+
+var bufferPool = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+func getBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBufferSize {
+		return
+	}
+	buf.Reset()
+	bufferPool.Put(buf)
+}
+
+// fastDecodable is a request of string fields only, which the fast path
+// sets by the names of their JSON object. Like encoding/json, names match
+// case-insensitively and unknown ones are ignored.
+type fastDecodable interface {
+	setField(name, value string)
+}
+
+// fastEncodable is a response that appends its JSON encoding itself, the
+// same as that of encoding/json, or reports false when it cannot.
+type fastEncodable interface {
+	appendJSON(b []byte) ([]byte, bool)
+}
+
+// decodeJSONBody decodes the JSON body of r into v, like json.Decoder.
+func decodeJSONBody(r *http.Request, v interface{}) error {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if _, err := buf.ReadFrom(r.Body); err != nil {
+		return err
+	}
+	return decodeJSON(buf.Bytes(), v)
+}
+
+// decodeJSON decodes the first JSON value of data into v, by the fast path
+// when it can. Strings are copied out of data.
+func decodeJSON(data []byte, v interface{}) error {
+	if f, ok := v.(fastDecodable); ok && len(data) <= maxFastRequestSize {
+		if fields, ok := parseFlatObject(data); ok {
+			for i := 0; i < len(fields); i += 2 {
+				f.setField(fields[i], fields[i+1])
+			}
+			return nil
+		}
+	}
+	return json.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// parseFlatObject returns the names and values of data, alternately, when
+// it is an object of strings, optionally followed by more JSON values like
+// for json.Decoder. Other values, escapes of invalid UTF-16 and strings of
+// invalid UTF-8 are left to encoding/json.
+func parseFlatObject(data []byte) ([]string, bool) {
+	p := flatParser{data: data}
+	p.skipSpace()
+	if !p.consume('{') {
+		return nil, false
+	}
+	var fields []string
+	p.skipSpace()
+	if p.consume('}') {
+		return fields, true
+	}
+	for {
+		p.skipSpace()
+		name, ok := p.string()
+		if !ok {
+			return nil, false
+		}
+		p.skipSpace()
+		if !p.consume(':') {
+			return nil, false
+		}
+		p.skipSpace()
+		value, ok := p.string()
+		if !ok {
+			return nil, false
+		}
+		fields = append(fields, name, value)
+		p.skipSpace()
+		if p.consume('}') {
+			return fields, true
+		}
+		if !p.consume(',') {
+			return nil, false
+		}
+	}
+}
+
+type flatParser struct {
+	data []byte
+	i    int
+}
+
+func (p *flatParser) skipSpace() {
+	for p.i < len(p.data) {
+		switch p.data[p.i] {
+		case ' ', '\t', '\n', '\r':
+			p.i++
+		default:
+			return
+		}
+	}
+}
+
+func (p *flatParser) consume(c byte) bool {
+	if p.i < len(p.data) && p.data[p.i] == c {
+		p.i++
+		return true
+	}
+	return false
+}
+
+// string parses a JSON string. Strings without escapes are copied at once.
+func (p *flatParser) string() (string, bool) {
+	if !p.consume('"') {
+		return "", false
+	}
+	start := p.i
+	for p.i < len(p.data) {
+		switch c := p.data[p.i]; {
+		case c == '"':
+			raw := p.data[start:p.i]
+			p.i++
+			if !utf8.Valid(raw) {
+				return "", false
+			}
+			return string(raw), true
+		case c == '\\':
+			return p.escapedString(start)
+		case c < 0x20:
+			return "", false
+		default:
+			p.i++
+		}
+	}
+	return "", false
+}
+
+// escapedString parses the rest of a string starting at start, which has
+// escapes.
+func (p *flatParser) escapedString(start int) (string, bool) {
+	var b strings.Builder
+	b.Grow(p.i - start + 16)
+	b.Write(p.data[start:p.i])
+	for p.i < len(p.data) {
+		c := p.data[p.i]
+		switch {
+		case c == '"':
+			p.i++
+			s := b.String()
+			return s, utf8.ValidString(s)
+		case c < 0x20:
+			return "", false
+		case c != '\\':
+			b.WriteByte(c)
+			p.i++
+			continue
+		}
+		if p.i+1 >= len(p.data) {
+			return "", false
+		}
+		p.i += 2
+		switch p.data[p.i-1] {
+		case '"', '\\', '/':
+			b.WriteByte(p.data[p.i-1])
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'u':
+			r, ok := p.hex4()
+			if !ok {
+				return "", false
+			}
+			if utf16.IsSurrogate(r) {
+				if !p.consume('\\') || !p.consume('u') {
+					return "", false
+				}
+				low, ok := p.hex4()
+				if !ok {
+					return "", false
+				}
+				if r = utf16.DecodeRune(r, low); r == utf8.RuneError {
+					return "", false
+				}
+			}
+			b.WriteRune(r)
+		default:
+			return "", false
+		}
+	}
+	return "", false
+}
+
+func (p *flatParser) hex4() (rune, bool) {
+	if p.i+4 > len(p.data) {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(string(p.data[p.i:p.i+4]), 16, 16)
+	if err != nil {
+		return 0, false
+	}
+	p.i += 4
+	return rune(n), true
+}
+
+// jsonSafe tells the ASCII characters that encoding/json does not escape.
+var jsonSafe = func() (safe [utf8.RuneSelf]bool) {
+	for c := range safe {
+		safe[c] = c >= 0x20 && !strings.ContainsRune(`"\<>&`, rune(c))
+	}
+	return safe
+}()
+
+// appendJSONString appends s as a JSON string, escaped like encoding/json
+// does, with the HTML characters escaped. It reports false for invalid
+// UTF-8, which versions of encoding/json replace differently.
+func appendJSONString(b []byte, s string) ([]byte, bool) {
+	const hex = "0123456789abcdef"
+	b = append(b, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		for i < len(s) && s[i] < utf8.RuneSelf && jsonSafe[s[i]] {
+			i++
+		}
+		if i == len(s) {
+			break
+		}
+		if c := s[i]; c < utf8.RuneSelf {
+			b = append(b, s[start:i]...)
+			switch c {
+			case '"', '\\':
+				b = append(b, '\\', c)
+			case '\b':
+				b = append(b, '\\', 'b')
+			case '\f':
+				b = append(b, '\\', 'f')
+			case '\n':
+				b = append(b, '\\', 'n')
+			case '\r':
+				b = append(b, '\\', 'r')
+			case '\t':
+				b = append(b, '\\', 't')
+			default:
+				b = append(b, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xf])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			return b, false
+		}
+		if r == '\u2028' || r == '\u2029' {
+			b = append(b, s[start:i]...)
+			b = append(b, '\\', 'u', '2', '0', '2', hex[r&0xf])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	b = append(b, s[start:]...)
+	return append(b, '"'), true
+}
+
+// writeJSON writes the JSON encoding of response to w followed by a new
+// line, like json.Encoder.
+func writeJSON(w http.ResponseWriter, response interface{}) error {
+	f, ok := response.(fastEncodable)
+	if !ok {
+		return json.NewEncoder(w).Encode(response)
+	}
+	buf := getBuffer()
+	defer putBuffer(buf)
+	b, ok := f.appendJSON(buf.AvailableBuffer())
+	if !ok {
+		return json.NewEncoder(w).Encode(response)
+	}
+	buf.Write(append(b, '\n'))
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func (r *uppercaseRequest) setField(name, value string) {
+	if strings.EqualFold(name, "s") {
+		r.S = value
+	}
+}
+
+func (r *countRequest) setField(name, value string) {
+	if strings.EqualFold(name, "s") {
+		r.S = value
+	}
+}
+
+func (r uppercaseResponse) appendJSON(b []byte) ([]byte, bool) {
+	b = append(b, `{"v":`...)
+	b, ok := appendJSONString(b, r.V)
+	if ok && r.Err != "" {
+		b = append(b, `,"err":`...)
+		b, ok = appendJSONString(b, r.Err)
+	}
+	return append(b, '}'), ok
+}
+
+func (r countResponse) appendJSON(b []byte) ([]byte, bool) {
+	b = append(b, `{"v":`...)
+	b = strconv.AppendInt(b, int64(r.V), 10)
+	ok := true
+	if r.Err != "" {
+		b = append(b, `,"err":`...)
+		b, ok = appendJSONString(b, r.Err)
+	}
+	return append(b, '}'), ok
+}
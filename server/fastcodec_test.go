@@ -0,0 +1,153 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// The fast path must decode and encode like encoding/json, which it falls
+// back to for what it does not handle.
+
+func TestFastDecode(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		// wantFast tells whether the fast path parses body.
+		wantFast bool
+	}{
+		{name: "plain", body: `{"s":"hello"}`, wantFast: true},
+		{name: "spaces", body: " {\n\t\"s\" : \"hello\" } ", wantFast: true},
+		{name: "empty object", body: `{}`, wantFast: true},
+		{name: "escapes", body: `{"s":"a\"b\\c\/d\be\ff\ng\rh\ti"}`, wantFast: true},
+		{name: "unicode escape", body: `{"s":"caf\u00e9 \u00E9"}`, wantFast: true},
+		{name: "surrogate pair", body: `{"s":"\ud83d\ude00"}`, wantFast: true},
+		{name: "lone high surrogate", body: `{"s":"\ud83d"}`},
+		{name: "lone low surrogate", body: `{"s":"\ude00"}`},
+		{name: "reversed surrogates", body: `{"s":"\ude00\ud83d"}`},
+		{name: "surrogate then letter", body: `{"s":"\ud83dx"}`},
+		{name: "invalid UTF-8", body: "{\"s\":\"a\xffb\"}"},
+		{name: "invalid UTF-8 after an escape", body: "{\"s\":\"\\n\xc3\"}"},
+		{name: "invalid UTF-8 in a name", body: "{\"\xff\":\"a\"}"},
+		{name: "line separator", body: "{\"s\":\"a\u2028b\u2029c\"}", wantFast: true},
+		{name: "escaped line separator", body: `{"s":"a\u2028b"}`, wantFast: true},
+		{name: "control character", body: "{\"s\":\"a\x01b\"}"},
+		{name: "bad escape", body: `{"s":"\x41"}`},
+		{name: "short unicode escape", body: `{"s":"\u00e"}`},
+		{name: "name case", body: `{"S":"hello"}`, wantFast: true},
+		{name: "unknown field", body: `{"t":"x","s":"hello"}`, wantFast: true},
+		{name: "repeated field", body: `{"s":"a","s":"b"}`, wantFast: true},
+		{name: "number", body: `{"s":1}`},
+		{name: "nested", body: `{"s":"a","o":{"s":"b"}}`},
+		{name: "null", body: `{"s":null}`},
+		{name: "following values", body: `{"s":"a"} {"s":"b"}`, wantFast: true},
+		{name: "unterminated", body: `{"s":"a"`},
+		{name: "not an object", body: `"s"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, fast := parseFlatObject([]byte(tt.body)); fast != tt.wantFast {
+				t.Errorf("fast path %v, want %v", fast, tt.wantFast)
+			}
+			var got, want uppercaseRequest
+			err := decodeJSON([]byte(tt.body), &got)
+			wantErr := json.NewDecoder(strings.NewReader(tt.body)).Decode(&want)
+			if (err != nil) != (wantErr != nil) {
+				t.Fatalf("error %v, encoding/json %v", err, wantErr)
+			}
+			if got != want {
+				t.Errorf("decoded %q, encoding/json %q", got.S, want.S)
+			}
+		})
+	}
+}
+
+func TestFastEncode(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+	}{
+		{name: "empty", s: ""},
+		{name: "plain", s: "hello"},
+		{name: "escapes", s: "a\"b\\c/d\be\ff\ng\rh\ti"},
+		{name: "control characters", s: "\x00\x01\x1f\x7f"},
+		{name: "HTML", s: "<a href=\"x\">&amp;</a>"},
+		{name: "unicode", s: "café 😀 日本"},
+		{name: "line separators", s: "a\u2028b\u2029c"},
+		{name: "invalid UTF-8", s: "a\xffb"},
+		{name: "truncated rune", s: "a\xe6\x97"},
+		{name: "replacement character", s: "a\ufffdb"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, response := range []interface{}{
+				uppercaseResponse{V: tt.s},
+				uppercaseResponse{V: "A", Err: tt.s},
+				countResponse{V: 3, Err: tt.s},
+			} {
+				w := httptest.NewRecorder()
+				if err := writeJSON(w, response); err != nil {
+					t.Fatal(err)
+				}
+				var want bytes.Buffer
+				if err := json.NewEncoder(&want).Encode(response); err != nil {
+					t.Fatal(err)
+				}
+				if w.Body.String() != want.String() {
+					t.Errorf("encoded %q, encoding/json %q", w.Body, want.String())
+				}
+			}
+		})
+	}
+}
+
+// benchmarkBody is a typical request of the busiest operations.
+const benchmarkBody = `{"s":"The quick brown fox jumps over the lazy dog, caf\u00e9 \u00e0 la cr\u00e8me"}`
+
+func BenchmarkDecode(b *testing.B) {
+	data := []byte(benchmarkBody)
+	b.Run("fast", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var req uppercaseRequest
+			if err := decodeJSON(data, &req); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("std", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var req uppercaseRequest
+			if err := json.NewDecoder(bytes.NewReader(data)).Decode(&req); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkEncode(b *testing.B) {
+	response := uppercaseResponse{V: "THE QUICK BROWN FOX JUMPS OVER THE LAZY DOG, CAFÉ À LA CRÈME <&>"}
+	b.Run("fast", func(b *testing.B) {
+		b.ReportAllocs()
+		w := httptest.NewRecorder()
+		for i := 0; i < b.N; i++ {
+			w.Body.Reset()
+			if err := writeJSON(w, response); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("std", func(b *testing.B) {
+		b.ReportAllocs()
+		w := httptest.NewRecorder()
+		for i := 0; i < b.N; i++ {
+			w.Body.Reset()
+			if err := json.NewEncoder(w).Encode(response); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
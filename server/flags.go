@@ -0,0 +1,370 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/go-kit/kit/metrics"
+)
+
+//
+// ────────────────────────────────────────────────── I ──────────
+//   :::::: F L A G S : :  :   :    :     :        :          :
+// ────────────────────────────────────────────────────────────
+//
+
+// ErrFeatureDisabled is returned for the operations whose flag is off, with
+// a 403.
+var ErrFeatureDisabled = errors.New("Operation disabled")
+
+// ErrUnknownFlag is returned when setting flags that gate no operation or
+// middleware, with a 404.
+var ErrUnknownFlag = errors.New("Unknown flag")
+
+// Prefixes of the flags of operations, like "operation.diff", and of
+// middlewares, like "middleware.chaos".
+const (
+	operationFlagPrefix  = "operation."
+	middlewareFlagPrefix = "middleware."
+)
+
+const defaultFlagRefresh = 30 * time.Second
+
+// FlagProvider loads flags from a remote service.
+type FlagProvider interface {
+	Flags(ctx context.Context) (map[string]FlagConfig, error)
+}
+
+// flagProviders make the providers of the "flags" config section, by name.
+var flagProviders = map[string]func(FlagsConfig) (FlagProvider, error){
+	"http": newHTTPFlagProvider,
+}
+
+// RegisterFlagProvider makes the providers made by newProvider available as
+// the flag provider name.
+func RegisterFlagProvider(name string, newProvider func(FlagsConfig) (FlagProvider, error)) {
+	flagProviders[name] = newProvider
+}
+
+// httpFlagProvider reads flags as a JSON object by name from a URL.
+type httpFlagProvider struct {
+	url    string
+	client *http.Client
+}
+
+func newHTTPFlagProvider(cfg FlagsConfig) (FlagProvider, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("no URL to read flags from")
+	}
+	return httpFlagProvider{cfg.URL, &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+func (p httpFlagProvider) Flags(ctx context.Context) (map[string]FlagConfig, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("flags answered with %s", resp.Status)
+	}
+	var flags map[string]FlagConfig
+	err = json.NewDecoder(resp.Body).Decode(&flags)
+	return flags, err
+}
+
+// featureFlags tells whether features are on for a request. Flags set
+// from the admin listener take precedence over those of the provider,
+// which take precedence over those of the configuration. Unlisted flags
+// are on, so that features are only turned off on purpose.
+type featureFlags struct {
+	evaluations metrics.Counter
+	// stop ends the refreshes of the flags of the provider.
+	stop chan struct{}
+
+	mu        sync.Mutex
+	static    map[string]FlagConfig
+	remote    map[string]FlagConfig
+	overrides map[string]FlagConfig
+	// known are the flags gating operations and middlewares.
+	known   map[string]bool
+	current atomic.Pointer[map[string]FlagConfig]
+}
+
+// newFeatureFlags returns the flags of cfg, and keeps refreshing those of
+// its provider, if any, until closed.
+func newFeatureFlags(cfg FlagsConfig, evaluations metrics.Counter, logger log.Logger) (*featureFlags, error) {
+	f := &featureFlags{
+		evaluations: evaluations,
+		stop:        make(chan struct{}),
+		overrides:   make(map[string]FlagConfig),
+		known:       make(map[string]bool),
+	}
+	if err := f.setStatic(cfg.Flags); err != nil {
+		return nil, err
+	}
+	if cfg.Provider == "" {
+		return f, nil
+	}
+	newProvider, ok := flagProviders[cfg.Provider]
+	if !ok {
+		return nil, fmt.Errorf("unknown flag provider %q", cfg.Provider)
+	}
+	p, err := newProvider(cfg)
+	if err != nil {
+		return nil, err
+	}
+	interval := defaultFlagRefresh
+	if cfg.RefreshSeconds > 0 {
+		interval = time.Duration(cfg.RefreshSeconds) * time.Second
+	}
+	refresh := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		defer cancel()
+		flags, err := p.Flags(ctx)
+		if err == nil {
+			err = f.setRemote(flags)
+		}
+		if err != nil {
+			level.Error(logger).Log("msg", "cannot refresh flags", "provider", cfg.Provider, "err", err)
+		}
+	}
+	refresh()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				refresh()
+			case <-f.stop:
+				return
+			}
+		}
+	}()
+	return f, nil
+}
+
+// close stops refreshing the flags of the provider.
+func (f *featureFlags) close() {
+	close(f.stop)
+}
+
+func validateFlags(flags map[string]FlagConfig) error {
+	for name, flag := range flags {
+		if err := validateRequest(flag); err != nil {
+			return fmt.Errorf("invalid flag %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (f *featureFlags) setStatic(flags map[string]FlagConfig) error {
+	if err := validateFlags(flags); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.static = flags
+	f.merge()
+	return nil
+}
+
+func (f *featureFlags) setRemote(flags map[string]FlagConfig) error {
+	if err := validateFlags(flags); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.remote = flags
+	f.merge()
+	return nil
+}
+
+// setOverride sets the flag name until the service restarts, whatever the
+// configuration and the provider say, or forgets it when flag is nil. Only
+// the flags of operations and middlewares can be set.
+func (f *featureFlags) setOverride(name string, flag *FlagConfig) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.known[name] {
+		return fmt.Errorf("%w %q", ErrUnknownFlag, name)
+	}
+	if flag == nil {
+		delete(f.overrides, name)
+	} else {
+		if err := validateRequest(*flag); err != nil {
+			return err
+		}
+		f.overrides[name] = *flag
+	}
+	f.merge()
+	return nil
+}
+
+// flagStates are the flags of operations and middlewares, as answered by
+// /admin/flags.
+type flagStates struct {
+	// Flags are the flags in effect, those on by default included.
+	Flags map[string]FlagConfig `json:"flags"`
+	// Overrides are the flags set from the admin listener.
+	Overrides map[string]FlagConfig `json:"overrides"`
+}
+
+func (f *featureFlags) states() flagStates {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	current := *f.current.Load()
+	states := flagStates{make(map[string]FlagConfig, len(f.known)), make(map[string]FlagConfig, len(f.overrides))}
+	for name := range f.known {
+		flag, ok := current[name]
+		if !ok {
+			flag.Enabled = true
+		}
+		states.Flags[name] = flag
+	}
+	for name, flag := range f.overrides {
+		states.Overrides[name] = flag
+	}
+	return states
+}
+
+// register records the flag name as gating an operation or a middleware.
+func (f *featureFlags) register(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.known[name] = true
+}
+
+func (f *featureFlags) merge() {
+	merged := make(map[string]FlagConfig, len(f.static)+len(f.remote)+len(f.overrides))
+	for name, flag := range f.static {
+		merged[name] = flag
+	}
+	for name, flag := range f.remote {
+		merged[name] = flag
+	}
+	for name, flag := range f.overrides {
+		merged[name] = flag
+	}
+	f.current.Store(&merged)
+}
+
+// on tells whether the flag name is on for the request of ctx. Rolled out
+// flags are drawn per tenant, so that every request of a tenant sees the
+// same features, and at random for the requests of the default tenant.
+func (f *featureFlags) on(ctx context.Context, name string) bool {
+	flag, ok := (*f.current.Load())[name]
+	on := true
+	switch {
+	case !ok, flag.Enabled:
+	case flag.Percentage > 0:
+		on = rolloutBucket(name, tenantFrom(ctx)) < flag.Percentage
+	default:
+		on = false
+	}
+	result := "on"
+	if !on {
+		result = "off"
+	}
+	f.evaluations.With("flag", name, "result", result).Add(1)
+	return on
+}
+
+// rolloutBucket places tenant in [0, 100) for the rollout of flag.
+func rolloutBucket(flag, tenant string) float64 {
+	if tenant == defaultTenant {
+		return rand.Float64() * 100
+	}
+	h := fnv.New32a()
+	h.Write([]byte(flag + "/" + tenant))
+	return float64(h.Sum32()%10000) / 100
+}
+
+// flagCheckedKey marks the flags already checked for a request.
+type flagCheckedKey string
+
+// gated rejects the calls to the operation name while its flag is off. The
+// flag is checked once per call, however many times the operation is
+// gated, so that rollouts draw once.
+func (f *featureFlags) gated(name string, next endpoint.Endpoint) endpoint.Endpoint {
+	flag := operationFlagPrefix + name
+	f.register(flag)
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		if ctx.Value(flagCheckedKey(flag)) == nil {
+			if !f.on(ctx, flag) {
+				return nil, ErrFeatureDisabled
+			}
+			ctx = context.WithValue(ctx, flagCheckedKey(flag), true)
+		}
+		return next(ctx, request)
+	}
+}
+
+// middleware serves the requests with wrapped, next wrapped in the
+// middleware name, while its flag is on, and with next otherwise.
+func (f *featureFlags) middleware(name string, wrapped, next http.Handler) http.Handler {
+	flag := middlewareFlagPrefix + name
+	f.register(flag)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if f.on(r.Context(), flag) {
+			wrapped.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// makeFlagsHandler answers the flags of operations and middlewares on GET
+// /admin/flags, and sets or forgets the flag name on PUT or DELETE
+// /admin/flags/{name}, with a body like {"enabled":false} for PUT.
+func makeFlagsHandler(f *featureFlags) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/admin/flags"), "/")
+		switch {
+		case name == "" && r.Method == http.MethodGet:
+		case name != "" && r.Method == http.MethodPut:
+			var flag FlagConfig
+			dec := json.NewDecoder(r.Body)
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(&flag); err != nil {
+				encodeError(r.Context(), malformedInputError{"request", err}, w)
+				return
+			}
+			if err := f.setOverride(name, &flag); err != nil {
+				encodeError(r.Context(), err, w)
+				return
+			}
+		case name != "" && r.Method == http.MethodDelete:
+			if err := f.setOverride(name, nil); err != nil {
+				encodeError(r.Context(), err, w)
+				return
+			}
+		case name == "":
+			w.Header().Set("Allow", "GET")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		default:
+			w.Header().Set("Allow", "PUT, DELETE")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		encodeResponse(r.Context(), w, f.states())
+	})
+}
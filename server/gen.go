@@ -0,0 +1,564 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"unicode/utf8"
+)
+
+//
+// ────────────────────────────────────────────── I ──────────
+//   :::::: G E N : :  :   :    :     :        :          :
+// ────────────────────────────────────────────────────────
+//
+
+// genUsage is printed by "stringsvc gen -h".
+const genUsage = `Usage: stringsvc gen [flags] -name <operation>
+
+Adds an operation to the source of stringsvc: a file holding its request
+and response, a stub of its method, its endpoint and decoder, and its
+logging and instrumenting middlewares, and the lines declaring the method
+in IStringService, adding the operation to newOperations and serving it
+over HTTP. Fields are given as name:type[:validate], e.g.
+  stringsvc gen -name reverse-words -request s:string:required,keep_punctuation:bool
+
+The method returns an error until it is implemented. The client, caching
+and bench are left to do by hand.
+
+Flags:
+`
+
+// genField is a field of a generated request or response.
+type genField struct {
+	JSON     string // name in JSON, like "max_length"
+	Name     string // name of the struct field, like "MaxLength"
+	Var      string // name of the variable, like "maxLength"
+	Type     string
+	Validate string
+}
+
+// LogKey is the key of the field in the records of the logging middleware,
+// which calls the text of operations their input and output.
+func (f genField) LogKey() string {
+	switch f.Var {
+	case "s":
+		return "input"
+	case "output":
+		return "output"
+	}
+	return f.JSON
+}
+
+// Zero is the zero value of the field, as Go source.
+func (f genField) Zero() string {
+	switch {
+	case f.Type == "string":
+		return `""`
+	case f.Type == "bool":
+		return "false"
+	case strings.HasPrefix(f.Type, "int"), strings.HasPrefix(f.Type, "uint"), strings.HasPrefix(f.Type, "float"):
+		return "0"
+	case strings.HasPrefix(f.Type, "[]"), strings.HasPrefix(f.Type, "map["), strings.HasPrefix(f.Type, "*"):
+		return "nil"
+	}
+	return f.Type + "{}"
+}
+
+// genOperation is what the templates of gen are executed with.
+type genOperation struct {
+	Name     string // name of the operation, like "reverse-words"
+	Route    string
+	Label    string // method label of logs and metrics, like "reverse_words"
+	Method   string // like "ReverseWords"
+	Var      string // prefix of the identifiers, like "reverseWords"
+	Banner   string
+	Request  []genField
+	Response []genField
+}
+
+// Params is the parameter list of the method.
+func (op genOperation) Params() string {
+	params := []string{"ctx context.Context"}
+	for _, f := range op.Request {
+		params = append(params, f.Var+" "+f.Type)
+	}
+	return strings.Join(params, ", ")
+}
+
+// Results is the result list of the method, named when asked.
+func (op genOperation) Results(named bool) string {
+	if len(op.Response) == 1 && !named {
+		return "(" + op.Response[0].Type + ", error)"
+	}
+	var results []string
+	for _, f := range op.Response {
+		results = append(results, f.Var+" "+f.Type)
+	}
+	return "(" + strings.Join(append(results, "err error"), ", ") + ")"
+}
+
+// Args is the argument list of calls to the method, prefixed by from.
+func (op genOperation) Args(from string) string {
+	args := []string{"ctx"}
+	for _, f := range op.Request {
+		if from == "" {
+			args = append(args, f.Var)
+		} else {
+			args = append(args, from+f.Name)
+		}
+	}
+	return strings.Join(args, ", ")
+}
+
+// Outputs lists the variables of the results, and err.
+func (op genOperation) Outputs() string {
+	var outputs []string
+	for _, f := range op.Response {
+		outputs = append(outputs, f.Var)
+	}
+	return strings.Join(append(outputs, "err"), ", ")
+}
+
+// Input is the text measured as the input of the operation.
+func (op genOperation) Input() string {
+	for _, f := range op.Request {
+		if f.Type == "string" {
+			return f.Var
+		}
+	}
+	return `""`
+}
+
+// OutputBytes is the size measured as the output of the operation.
+func (op genOperation) OutputBytes() string {
+	for _, f := range op.Response {
+		if f.Type == "string" {
+			return "len(" + f.Var + ")"
+		}
+	}
+	return "-1"
+}
+
+var genTemplate = template.Must(template.New("operation").Parse(`package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+{{.Banner}}
+
+type {{.Var}}Request struct {
+{{- range .Request}}
+	{{.Name}} {{.Type}} ` + "`" + `json:"{{.JSON}}"{{if .Validate}} validate:"{{.Validate}}"{{end}}` + "`" + `
+{{- end}}
+}
+
+type {{.Var}}Response struct {
+{{- range .Response}}
+	{{.Name}} {{.Type}} ` + "`" + `json:"{{.JSON}}"` + "`" + `
+{{- end}}
+	Err string ` + "`" + `json:"err,omitempty"` + "`" + `
+}
+
+// {{.Method}} is not implemented yet.
+func (stringService) {{.Method}}({{.Params}}) {{.Results false}} {
+	return {{range .Response}}{{.Zero}}, {{end}}errors.New("Not implemented")
+}
+
+func make{{.Method}}Endpoint(svc IStringService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.({{.Var}}Request)
+		{{.Outputs}} := svc.{{.Method}}({{.Args "req."}})
+		if err != nil {
+			return {{.Var}}Response{ {{- range .Response}}{{.Zero}}, {{end}}err.Error()}, nil
+		}
+		return {{.Var}}Response{ {{- range .Response}}{{.Var}}, {{end}}""}, nil
+	}
+}
+
+func decode{{.Method}}Request(_ context.Context, r *http.Request) (interface{}, error) {
+	var request {{.Var}}Request
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+//
+// ─── LOGGING ────────────────────────────────────────────────────────────────────
+//
+
+func (mw loggingMiddleware) {{.Method}}({{.Params}}) {{.Results true}} {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "{{.Label}}",
+			"tenant", tenantFrom(ctx),
+{{- range .Request}}
+			"{{.LogKey}}", {{.Var}},
+{{- end}}
+{{- range .Response}}
+			"{{.LogKey}}", {{.Var}},
+{{- end}}
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	{{.Outputs}} = mw.next.{{.Method}}({{.Args ""}})
+	return
+}
+
+//
+// ─── INSTRUMENTATION ────────────────────────────────────────────────────────────
+//
+
+func (mw instrumentingMiddleware) {{.Method}}({{.Params}}) {{.Results true}} {
+	done := mw.begin(ctx, "{{.Label}}", {{.Input}})
+	defer func() { done({{.OutputBytes}}, err) }()
+
+	{{.Outputs}} = mw.next.{{.Method}}({{.Args ""}})
+	return
+}
+`))
+
+// RunGen implements the gen subcommand, and returns its exit code.
+func RunGen(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("gen", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, genUsage)
+		fs.PrintDefaults()
+	}
+	name := fs.String("name", "", "name of the operation, like reverse-words")
+	request := fs.String("request", "s:string:required", "fields of the request, as name:type[:validate],...")
+	response := fs.String("response", "v:string", "fields of the response, besides err, as name:type,...")
+	dir := fs.String("dir", "server", "directory of the server package of stringsvc")
+	dryRun := fs.Bool("n", false, "print the new file instead of changing the source")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *name == "" || fs.NArg() > 0 {
+		fs.Usage()
+		return 2
+	}
+
+	op, err := newGenOperation(*name, *request, *response)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	var src bytes.Buffer
+	if err := genTemplate.Execute(&src, op); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	formatted, err := format.Source(src.Bytes())
+	if err != nil {
+		fmt.Fprintf(stderr, "generated invalid source: %v\n", err)
+		return 1
+	}
+	if *dryRun {
+		stdout.Write(formatted)
+		return 0
+	}
+
+	path := filepath.Join(*dir, strings.ReplaceAll(op.Name, "-", "")+".go")
+	if _, err := os.Stat(path); err == nil {
+		fmt.Fprintf(stderr, "%s exists already\n", path)
+		return 1
+	}
+	if err := wireGenOperation(*dir, op); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	if err := os.WriteFile(path, formatted, 0o644); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "wrote %s, and wired %s in service.go, server.go and operations.go\n", path, op.Method)
+	return 0
+}
+
+func newGenOperation(name, request, response string) (genOperation, error) {
+	for _, r := range name {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' {
+			return genOperation{}, fmt.Errorf("invalid operation name %q: use lowercase letters, digits and dashes", name)
+		}
+	}
+	words := strings.Split(name, "-")
+	op := genOperation{
+		Name:   name,
+		Route:  operationRoute(name),
+		Label:  strings.Join(words, "_"),
+		Method: camel(words, true),
+		Var:    camel(words, false),
+		Banner: majorBanner(strings.Join(words, " ")),
+	}
+	var err error
+	if op.Request, err = parseGenFields(request, true); err != nil {
+		return op, err
+	}
+	if op.Response, err = parseGenFields(response, false); err != nil {
+		return op, err
+	}
+	if len(op.Response) == 0 {
+		return op, errors.New("the response has no field")
+	}
+	if op.Response[0].Var == "v" {
+		op.Response[0].Var = "output"
+	}
+	if _, builtin := newOperations(stringService{})[name]; builtin {
+		return op, fmt.Errorf("operation %s exists already", name)
+	}
+	return op, nil
+}
+
+func parseGenFields(spec string, validate bool) ([]genField, error) {
+	var fields []genField
+	seen := make(map[string]bool)
+	for _, item := range strings.Split(spec, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, ":", 3)
+		if len(parts) < 2 || parts[0] == "" || parts[1] == "" || (len(parts) == 3 && !validate) {
+			return nil, fmt.Errorf("invalid field %q", item)
+		}
+		if parts[0] == "err" || parts[0] == "ctx" || seen[parts[0]] {
+			return nil, fmt.Errorf("invalid field name %q", parts[0])
+		}
+		seen[parts[0]] = true
+		words := strings.Split(parts[0], "_")
+		f := genField{JSON: parts[0], Name: camel(words, true), Var: camel(words, false), Type: parts[1]}
+		if len(parts) == 3 {
+			f.Validate = parts[2]
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// genInitialisms are written in capitals in identifiers.
+var genInitialisms = map[string]string{"id": "ID", "url": "URL", "html": "HTML", "json": "JSON", "uuid": "UUID"}
+
+// camel joins words in camel case, starting with a capital if exported.
+func camel(words []string, exported bool) string {
+	var b strings.Builder
+	for i, w := range words {
+		if w == "" {
+			continue
+		}
+		switch initialism, ok := genInitialisms[w]; {
+		case i == 0 && !exported:
+			b.WriteString(w)
+		case ok:
+			b.WriteString(initialism)
+		default:
+			b.WriteString(strings.ToUpper(w[:1]) + w[1:])
+		}
+	}
+	return b.String()
+}
+
+// majorBanner returns the banner that opens the files of operations.
+func majorBanner(title string) string {
+	spaced := strings.Join(strings.Split(strings.ToUpper(title), ""), " ")
+	line := "//   :::::: " + spaced + " : :  :   :    :     :        :          :"
+	width := utf8.RuneCountInString(line) - 3
+	return "//\n// " + strings.Repeat("─", width-10) + " I " + strings.Repeat("─", 10) + "\n" +
+		line + "\n// " + strings.Repeat("─", width) + "\n//"
+}
+
+// wireGenOperation declares the method of op in IStringService, adds op to
+// newOperations, and serves it over HTTP after the last operation of New.
+func wireGenOperation(dir string, op genOperation) error {
+	err := editGoFile(filepath.Join(dir, "service.go"), func(file *ast.File, fset *token.FileSet) (map[token.Pos]string, error) {
+		iface := findInterface(file, "IStringService")
+		if iface == nil {
+			return nil, errors.New("no IStringService in service.go")
+		}
+		return map[token.Pos]string{
+			iface.Methods.Closing: fmt.Sprintf("\t%s(%s) %s\n", op.Method, op.Params(), op.Results(len(op.Response) > 1)),
+		}, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = editGoFile(filepath.Join(dir, "server.go"), func(file *ast.File, fset *token.FileSet) (map[token.Pos]string, error) {
+		edits := make(map[token.Pos]string)
+		var lastHandler, lastRoute ast.Node
+		ast.Inspect(findFunc(file, "New"), func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.AssignStmt:
+				if isOperationHandler(n) {
+					lastHandler = n
+				}
+			case *ast.ExprStmt:
+				if isOperationRoute(n) {
+					lastRoute = n
+				}
+			}
+			return true
+		})
+		if lastHandler == nil || lastRoute == nil {
+			return nil, errors.New("no operation handlers in New")
+		}
+		edits[lastHandler.End()] = fmt.Sprintf(`
+
+	%sHandler := httptransport.NewServer(
+		guarded(%q, validated(make%sEndpoint(svc))),
+		decode%sRequest,
+		encodeResponse,
+		serverOptions...,
+	)`, op.Var, op.Name, op.Method, op.Method)
+		edits[lastRoute.End()] = fmt.Sprintf("\n\tmux.Handle(%q, %sHandler)", op.Route, op.Var)
+		return edits, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return editGoFile(filepath.Join(dir, "operations.go"), func(file *ast.File, fset *token.FileSet) (map[token.Pos]string, error) {
+		var last ast.Node
+		ast.Inspect(findFunc(file, "newOperations"), func(n ast.Node) bool {
+			if kv, ok := n.(*ast.KeyValueExpr); ok {
+				if lit, ok := kv.Value.(*ast.CompositeLit); ok && len(lit.Elts) > 0 && callsMakeEndpoint(lit.Elts[0]) {
+					last = kv
+				}
+			}
+			return true
+		})
+		if last == nil {
+			return nil, errors.New("no operations in newOperations")
+		}
+		return map[token.Pos]string{
+			last.End() + 1: fmt.Sprintf("\n\t\t%q: {make%sEndpoint(svc), %sRequest{}, %sResponse{}},", op.Name, op.Method, op.Var, op.Var),
+		}, nil
+	})
+}
+
+// editGoFile inserts the texts returned by edit at their positions in the Go
+// file at path, and formats it.
+func editGoFile(path string, edit func(*ast.File, *token.FileSet) (map[token.Pos]string, error)) error {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, path, src, parser.ParseComments)
+	if err != nil {
+		return err
+	}
+	edits, err := edit(file, fset)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	offsets := make([]int, 0, len(edits))
+	texts := make(map[int]string, len(edits))
+	for pos, text := range edits {
+		offset := fset.Position(pos).Offset
+		offsets = append(offsets, offset)
+		texts[offset] = text
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(offsets)))
+	for _, offset := range offsets {
+		src = append(src[:offset], append([]byte(texts[offset]), src[offset:]...)...)
+	}
+	formatted, err := format.Source(src)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return os.WriteFile(path, formatted, 0o644)
+}
+
+func findInterface(file *ast.File, name string) *ast.InterfaceType {
+	for _, decl := range file.Decls {
+		if gen, ok := decl.(*ast.GenDecl); ok {
+			for _, spec := range gen.Specs {
+				if ts, ok := spec.(*ast.TypeSpec); ok && ts.Name.Name == name {
+					iface, _ := ts.Type.(*ast.InterfaceType)
+					return iface
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func findFunc(file *ast.File, name string) ast.Node {
+	for _, decl := range file.Decls {
+		if fn, ok := decl.(*ast.FuncDecl); ok && fn.Recv == nil && fn.Name.Name == name {
+			return fn
+		}
+	}
+	return &ast.BadStmt{}
+}
+
+// isOperationHandler tells whether n declares the handler of an operation
+// of the service, like uppercaseHandler := httptransport.NewServer(...).
+func isOperationHandler(n *ast.AssignStmt) bool {
+	if len(n.Lhs) != 1 || len(n.Rhs) != 1 || n.Tok != token.DEFINE {
+		return false
+	}
+	id, ok := n.Lhs[0].(*ast.Ident)
+	call, isCall := n.Rhs[0].(*ast.CallExpr)
+	if !ok || !isCall || !strings.HasSuffix(id.Name, "Handler") || len(call.Args) == 0 {
+		return false
+	}
+	found := false
+	ast.Inspect(call.Args[0], func(n ast.Node) bool {
+		if c, ok := n.(*ast.CallExpr); ok {
+			if fn, ok := c.Fun.(*ast.Ident); ok && fn.Name == "validated" {
+				found = true
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+// isOperationRoute tells whether n serves the handler of an operation, like
+// mux.Handle("/uppercase", uppercaseHandler).
+func isOperationRoute(n *ast.ExprStmt) bool {
+	call, ok := n.X.(*ast.CallExpr)
+	if !ok || len(call.Args) != 2 {
+		return false
+	}
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok || sel.Sel.Name != "Handle" {
+		return false
+	}
+	if pkg, ok := sel.X.(*ast.Ident); !ok || pkg.Name != "mux" {
+		return false
+	}
+	handler, ok := call.Args[1].(*ast.Ident)
+	return ok && strings.HasSuffix(handler.Name, "Handler")
+}
+
+// callsMakeEndpoint tells whether e is like makeUppercaseEndpoint(svc).
+func callsMakeEndpoint(e ast.Expr) bool {
+	call, ok := e.(*ast.CallExpr)
+	if !ok || len(call.Args) != 1 {
+		return false
+	}
+	fn, ok := call.Fun.(*ast.Ident)
+	arg, isIdent := call.Args[0].(*ast.Ident)
+	return ok && isIdent && arg.Name == "svc" && strings.HasPrefix(fn.Name, "make") && strings.HasSuffix(fn.Name, "Endpoint")
+}
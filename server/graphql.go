@@ -0,0 +1,297 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+//
+// ────────────────────────────────────────────────────── I ──────────
+//   :::::: G R A P H Q L : :  :   :    :     :        :          :
+// ────────────────────────────────────────────────────────────────
+//
+
+// graphqlMutations are the operations exposed as mutations rather than
+// queries: their results change on every call, so they must not be cached
+// or merged by clients.
+var graphqlMutations = map[string]bool{"random": true, "id": true, "encrypt": true}
+
+// graphqlJSON carries values without a fixed shape, like the data of
+// /render or raw operation outputs.
+var graphqlJSON = graphql.NewScalar(graphql.ScalarConfig{
+	Name:         "JSON",
+	Description:  "Any JSON value.",
+	Serialize:    func(v interface{}) interface{} { return v },
+	ParseValue:   func(v interface{}) interface{} { return v },
+	ParseLiteral: parseGraphQLLiteral,
+})
+
+// graphqlSchemaBuilder generates the schema from the request and response
+// types of the operations. Each operation is a field taking the fields of
+// its request as arguments and returning its response, "err" excepted.
+// Responses with a string "v" also get a field per query operation, taking
+// "v" as "s", which is how operations are chained:
+//
+//	{ uppercase(s: "hello") { v count { v } } }
+type graphqlSchemaBuilder struct {
+	ops     map[string]operation
+	objects map[reflect.Type]*graphql.Object
+}
+
+func newGraphQLSchema(ops map[string]operation) (graphql.Schema, error) {
+	b := &graphqlSchemaBuilder{ops: ops, objects: make(map[reflect.Type]*graphql.Object)}
+	query, mutation := graphql.Fields{}, graphql.Fields{}
+	for _, name := range b.names() {
+		if graphqlMutations[name] {
+			mutation[graphqlName(name)] = b.field(name, false)
+		} else {
+			query[graphqlName(name)] = b.field(name, false)
+		}
+	}
+	return graphql.NewSchema(graphql.SchemaConfig{
+		Query:    graphql.NewObject(graphql.ObjectConfig{Name: "Query", Fields: query}),
+		Mutation: graphql.NewObject(graphql.ObjectConfig{Name: "Mutation", Fields: mutation}),
+	})
+}
+
+func (b *graphqlSchemaBuilder) names() []string {
+	names := make([]string, 0, len(b.ops))
+	for name := range b.ops {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// field returns the field of an operation. Chained fields take their "s"
+// from the "v" of the parent object instead of an argument.
+func (b *graphqlSchemaBuilder) field(name string, chained bool) *graphql.Field {
+	op := b.ops[name]
+	args := graphql.FieldConfigArgument{}
+	reqType := reflect.TypeOf(op.Request)
+	for i := 0; i < reqType.NumField(); i++ {
+		f := reqType.Field(i)
+		arg := jsonFieldName(f)
+		if arg == "" || (chained && arg == "s") {
+			continue
+		}
+		args[arg] = &graphql.ArgumentConfig{Type: graphqlInputType(f.Type)}
+	}
+
+	return &graphql.Field{
+		Type: b.object(reflect.TypeOf(op.Response)),
+		Args: args,
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			if chained {
+				p.Args["s"] = p.Source.(map[string]interface{})["v"]
+			}
+			return resolveGraphQLOperation(p.Context, op, p.Args)
+		},
+	}
+}
+
+func (b *graphqlSchemaBuilder) object(t reflect.Type) *graphql.Object {
+	if obj, ok := b.objects[t]; ok {
+		return obj
+	}
+	obj := graphql.NewObject(graphql.ObjectConfig{
+		Name: strings.ToUpper(t.Name()[:1]) + t.Name()[1:],
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			fields := graphql.Fields{}
+			chainable := false
+			for i := 0; i < t.NumField(); i++ {
+				f := t.Field(i)
+				name := jsonFieldName(f)
+				if name == "" || name == "err" {
+					continue
+				}
+				fields[name] = &graphql.Field{Type: b.outputType(f.Type)}
+				chainable = chainable || (name == "v" && f.Type.Kind() == reflect.String)
+			}
+			if chainable {
+				for _, name := range b.names() {
+					if _, taken := fields[graphqlName(name)]; !taken && !graphqlMutations[name] && b.takesString(name) {
+						fields[graphqlName(name)] = b.field(name, true)
+					}
+				}
+			}
+			return fields
+		}),
+	})
+	b.objects[t] = obj
+	return obj
+}
+
+func (b *graphqlSchemaBuilder) takesString(name string) bool {
+	f, ok := reflect.TypeOf(b.ops[name].Request).FieldByName("S")
+	return ok && f.Type.Kind() == reflect.String
+}
+
+func (b *graphqlSchemaBuilder) outputType(t reflect.Type) graphql.Output {
+	switch {
+	case t == reflect.TypeOf(json.RawMessage{}):
+		return graphqlJSON
+	case t.Kind() == reflect.Ptr:
+		return b.outputType(t.Elem())
+	case t.Kind() == reflect.Slice:
+		return graphql.NewList(b.outputType(t.Elem()))
+	case t.Kind() == reflect.Struct:
+		return b.object(t)
+	}
+	return graphqlScalar(t)
+}
+
+func graphqlInputType(t reflect.Type) graphql.Input {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return graphqlInputType(t.Elem())
+	case reflect.Slice:
+		return graphql.NewList(graphqlInputType(t.Elem()))
+	}
+	return graphqlScalar(t)
+}
+
+func graphqlScalar(t reflect.Type) *graphql.Scalar {
+	switch t.Kind() {
+	case reflect.String:
+		return graphql.String
+	case reflect.Int, reflect.Int64:
+		return graphql.Int
+	case reflect.Float64:
+		return graphql.Float
+	case reflect.Bool:
+		return graphql.Boolean
+	}
+	return graphqlJSON
+}
+
+// graphqlName turns operation names into valid field names:
+// "detect-language" becomes "detectLanguage".
+func graphqlName(op string) string {
+	parts := strings.Split(op, "-")
+	for i := 1; i < len(parts); i++ {
+		parts[i] = titleWord(parts[i])
+	}
+	return strings.Join(parts, "")
+}
+
+func jsonFieldName(f reflect.StructField) string {
+	name := strings.Split(f.Tag.Get("json"), ",")[0]
+	if name == "-" || f.PkgPath != "" {
+		return ""
+	}
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
+
+// graphQLError is an error of a field, with its code of the catalog in the
+// "extensions" of the error.
+type graphQLError struct {
+	message string
+	code    ErrorCode
+}
+
+func (e graphQLError) Error() string { return e.message }
+
+func (e graphQLError) Extensions() map[string]interface{} {
+	return map[string]interface{}{"code": e.code}
+}
+
+// resolveGraphQLOperation calls op through its endpoint and returns its
+// response as a map, for the default resolvers to pick fields from. An
+// "err" in the response becomes the error of the field.
+func resolveGraphQLOperation(ctx context.Context, op operation, args map[string]interface{}) (interface{}, error) {
+	ctx, codes := withErrorCodes(ctx)
+	data, err := json.Marshal(args)
+	if err != nil {
+		return nil, err
+	}
+	req, err := op.decode(data)
+	if err != nil {
+		return nil, graphQLError{err.Error(), CodeMalformedInput}
+	}
+	resp, err := op.Endpoint(ctx, req)
+	if err != nil {
+		return nil, graphQLError{err.Error(), errorCodeOf(err)}
+	}
+	if data, err = json.Marshal(resp); err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	if msg, _ := m["err"].(string); msg != "" {
+		return nil, graphQLError{msg, codes.Code()}
+	}
+	return m, nil
+}
+
+func parseGraphQLLiteral(v ast.Value) interface{} {
+	switch v := v.(type) {
+	case *ast.ObjectValue:
+		m := make(map[string]interface{}, len(v.Fields))
+		for _, f := range v.Fields {
+			m[f.Name.Value] = parseGraphQLLiteral(f.Value)
+		}
+		return m
+	case *ast.ListValue:
+		l := make([]interface{}, len(v.Values))
+		for i, item := range v.Values {
+			l[i] = parseGraphQLLiteral(item)
+		}
+		return l
+	case *ast.IntValue:
+		n, _ := strconv.ParseInt(v.Value, 10, 64)
+		return n
+	case *ast.FloatValue:
+		f, _ := strconv.ParseFloat(v.Value, 64)
+		return f
+	case *ast.StringValue:
+		return v.Value
+	case *ast.BooleanValue:
+		return v.Value
+	}
+	return nil
+}
+
+type graphqlRequest struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables"`
+	OperationName string                 `json:"operationName"`
+}
+
+// makeGraphQLHandler serves /graphql, with the query either in a JSON POST
+// body or in the "query" parameter of a GET.
+func makeGraphQLHandler(schema graphql.Schema) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req graphqlRequest
+		if r.Method == http.MethodGet {
+			req.Query = r.URL.Query().Get("query")
+			req.OperationName = r.URL.Query().Get("operationName")
+		} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			encodeError(r.Context(), malformedInputError{"json", err}, w)
+			return
+		}
+
+		result := graphql.Do(graphql.Params{
+			Schema:         schema,
+			RequestString:  req.Query,
+			VariableValues: req.Variables,
+			OperationName:  req.OperationName,
+			Context:        r.Context(),
+		})
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(result)
+	})
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/blake2s"
+)
+
+//
+// ──────────────────────────────────────────────── I ──────────
+//   :::::: H A S H : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────
+//
+
+type hashRequest struct {
+	S         string `json:"s"`
+	Algorithm string `json:"algorithm" validate:"max=64,charset=printable"`
+	Encoding  string `json:"encoding" validate:"max=64,charset=printable"`
+}
+
+type hashResponse struct {
+	V   string `json:"v"`
+	Err string `json:"err,omitempty"`
+}
+
+// hashAlgorithms holds every digest the Hash method knows about, keyed by
+// the name clients send in the "algorithm" field.
+var hashAlgorithms = map[string]func() hash.Hash{
+	"md5":         md5.New,
+	"sha256":      sha256.New,
+	"sha512":      sha512.New,
+	"blake2b-256": func() hash.Hash { h, _ := blake2b.New256(nil); return h },
+	"blake2b-512": func() hash.Hash { h, _ := blake2b.New512(nil); return h },
+	"blake2s-256": func() hash.Hash { h, _ := blake2s.New256(nil); return h },
+}
+
+// RegisterHash makes a new algorithm available to the /hash endpoint. It is
+// meant to be called from init functions, before the server starts.
+func RegisterHash(name string, fn func() hash.Hash) {
+	hashAlgorithms[name] = fn
+}
+
+func (stringService) Hash(ctx context.Context, s, algorithm, encoding string) (string, error) {
+	if algorithm == "" {
+		algorithm = "sha256"
+	}
+	newHash, ok := hashAlgorithms[algorithm]
+	if !ok {
+		return "", errorf(CodeUnknownOption, "Unknown hash algorithm %q", algorithm)
+	}
+	h := newHash()
+	h.Write([]byte(s))
+	sum := h.Sum(nil)
+
+	switch encoding {
+	case "", "hex":
+		return hex.EncodeToString(sum), nil
+	case "base64":
+		return base64.StdEncoding.EncodeToString(sum), nil
+	default:
+		return "", errorf(CodeUnknownOption, "Unknown digest encoding %q", encoding)
+	}
+}
+
+func makeHashEndpoint(svc IStringService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(hashRequest)
+		v, err := svc.Hash(ctx, req.S, req.Algorithm, req.Encoding)
+		if err != nil {
+			return hashResponse{"", err.Error()}, nil
+		}
+		return hashResponse{v, ""}, nil
+	}
+}
+
+func decodeHashRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request hashRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+//
+// ─── LOGGING ────────────────────────────────────────────────────────────────────
+//
+
+func (mw loggingMiddleware) Hash(ctx context.Context, s, algorithm, encoding string) (output string, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "hash",
+			"tenant", tenantFrom(ctx),
+			"input", s,
+			"algorithm", algorithm,
+			"encoding", encoding,
+			"output", output,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	output, err = mw.next.Hash(ctx, s, algorithm, encoding)
+	return
+}
+
+//
+// ─── INSTRUMENTATION ────────────────────────────────────────────────────────────
+//
+
+func (mw instrumentingMiddleware) Hash(ctx context.Context, s, algorithm, encoding string) (output string, err error) {
+	done := mw.begin(ctx, "hash", s)
+	defer func() { done(len(output), err) }()
+
+	output, err = mw.next.Hash(ctx, s, algorithm, encoding)
+	return
+}
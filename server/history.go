@@ -0,0 +1,320 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+//
+// ────────────────────────────────────────────────────── I ──────────
+//   :::::: H I S T O R Y : :  :   :    :     :        :          :
+// ────────────────────────────────────────────────────────────────
+//
+
+// The history keeps the audit records of requests in a SQL database, for
+// support investigations: what a client called, when, and how it went. It
+// is written like an audit sink, and queried with GET /admin/history on
+// the admin listener.
+
+// Defaults of the "history" config section.
+const (
+	defaultHistoryBufferSize = 1000
+	defaultHistoryPageSize   = 100
+	maxHistoryPageSize       = 1000
+)
+
+// ErrHistoryBufferFull is returned when records come faster than they can
+// be written, the ones over the buffer being dropped.
+var ErrHistoryBufferFull = errors.New("history buffer full, record dropped")
+
+// historyDialect tells the differences of the databases the history can be
+// kept in.
+type historyDialect struct {
+	// driver is the database/sql driver of the database.
+	driver string
+	// id is the type of the auto-incremented primary key.
+	id string
+	// placeholder returns the placeholder of the nth argument, from 1.
+	placeholder func(n int) string
+}
+
+var historyDialects = map[string]historyDialect{
+	"sqlite": {"sqlite", "INTEGER PRIMARY KEY AUTOINCREMENT", func(int) string { return "?" }},
+	"postgres": {"pgx", "BIGSERIAL PRIMARY KEY", func(n int) string {
+		return "$" + strconv.Itoa(n)
+	}},
+}
+
+// historyRecord is an audit record as kept in the history, with the ID
+// pages are cut at.
+type historyRecord struct {
+	ID int64 `json:"id"`
+	AuditRecord
+}
+
+// historyQuery selects records of the history, newest first. Its zero
+// fields do not filter.
+type historyQuery struct {
+	From, To time.Time
+	// Client matches the tenant, the address or the API key hash of the
+	// client.
+	Client string
+	Route  string
+	// Before is the ID of the last record of the previous page.
+	Before int64
+	Limit  int
+}
+
+// historyStore writes records in the background, so that the database does
+// not slow requests down.
+type historyStore struct {
+	db        *sql.DB
+	dialect   historyDialect
+	records   chan AuditRecord
+	retention time.Duration
+	logger    log.Logger
+	done      chan struct{}
+}
+
+// newHistoryStore opens the database of cfg and creates its table, or
+// returns nil when the history is disabled.
+func newHistoryStore(cfg historyConfig, logger log.Logger) (*historyStore, error) {
+	if cfg.Driver == "" {
+		return nil, nil
+	}
+	dialect, ok := historyDialects[cfg.Driver]
+	if !ok {
+		return nil, fmt.Errorf("unknown history driver %q", cfg.Driver)
+	}
+	db, err := sql.Open(dialect.driver, cfg.DSN)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS request_history (
+		id ` + dialect.id + `,
+		time_us BIGINT NOT NULL,
+		tenant TEXT NOT NULL,
+		client_addr TEXT NOT NULL,
+		api_key_hash TEXT NOT NULL,
+		method TEXT NOT NULL,
+		route TEXT NOT NULL,
+		input_bytes BIGINT NOT NULL,
+		input_hash TEXT NOT NULL,
+		status INTEGER NOT NULL,
+		duration_ms DOUBLE PRECISION NOT NULL
+	)`); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS request_history_time ON request_history (time_us)`); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	bufferSize := cfg.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = defaultHistoryBufferSize
+	}
+	s := &historyStore{
+		db:        db,
+		dialect:   dialect,
+		records:   make(chan AuditRecord, bufferSize),
+		retention: time.Duration(cfg.RetentionDays) * 24 * time.Hour,
+		logger:    logger,
+		done:      make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+func (s *historyStore) Write(rec AuditRecord) error {
+	select {
+	case s.records <- rec:
+		return nil
+	default:
+		return ErrHistoryBufferFull
+	}
+}
+
+// Close writes the buffered records and closes the database.
+func (s *historyStore) Close() error {
+	close(s.records)
+	<-s.done
+	return s.db.Close()
+}
+
+// run inserts the records written, and deletes those older than the
+// retention every hour.
+func (s *historyStore) run() {
+	defer close(s.done)
+	p := s.dialect.placeholder
+	insert := fmt.Sprintf(`INSERT INTO request_history (time_us, tenant, client_addr, api_key_hash,
+		method, route, input_bytes, input_hash, status, duration_ms)
+		VALUES (%s, %s, %s, %s, %s, %s, %s, %s, %s, %s)`,
+		p(1), p(2), p(3), p(4), p(5), p(6), p(7), p(8), p(9), p(10))
+	cleanup := time.NewTicker(time.Hour)
+	defer cleanup.Stop()
+	for {
+		select {
+		case rec, ok := <-s.records:
+			if !ok {
+				return
+			}
+			if _, err := s.db.Exec(insert, rec.Time.UnixMicro(), rec.Tenant, rec.ClientAddr, rec.APIKeyHash,
+				rec.Method, rec.Route, rec.InputBytes, rec.InputHash, rec.Status, rec.DurationMS); err != nil {
+				level.Error(s.logger).Log("msg", "cannot write history record", "err", err)
+			}
+		case now := <-cleanup.C:
+			if s.retention <= 0 {
+				continue
+			}
+			cutoff := now.Add(-s.retention).UnixMicro()
+			if _, err := s.db.Exec(`DELETE FROM request_history WHERE time_us < `+p(1), cutoff); err != nil {
+				level.Error(s.logger).Log("msg", "cannot delete old history records", "err", err)
+			}
+		}
+	}
+}
+
+// Query returns the records selected by q, newest first.
+func (s *historyStore) Query(ctx context.Context, q historyQuery) ([]historyRecord, error) {
+	var (
+		where []string
+		args  []interface{}
+	)
+	filter := func(cond string, values ...interface{}) {
+		for _, v := range values {
+			args = append(args, v)
+			cond = strings.Replace(cond, "?", s.dialect.placeholder(len(args)), 1)
+		}
+		where = append(where, cond)
+	}
+	if !q.From.IsZero() {
+		filter("time_us >= ?", q.From.UnixMicro())
+	}
+	if !q.To.IsZero() {
+		filter("time_us < ?", q.To.UnixMicro())
+	}
+	if q.Client != "" {
+		filter("(tenant = ? OR client_addr = ? OR api_key_hash = ?)", q.Client, q.Client, q.Client)
+	}
+	if q.Route != "" {
+		filter("route = ?", q.Route)
+	}
+	if q.Before > 0 {
+		filter("id < ?", q.Before)
+	}
+	query := `SELECT id, time_us, tenant, client_addr, api_key_hash, method, route,
+		input_bytes, input_hash, status, duration_ms FROM request_history`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += fmt.Sprintf(" ORDER BY id DESC LIMIT %d", q.Limit)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	records := []historyRecord{}
+	for rows.Next() {
+		var (
+			rec    historyRecord
+			timeUS int64
+		)
+		if err := rows.Scan(&rec.ID, &timeUS, &rec.Tenant, &rec.ClientAddr, &rec.APIKeyHash, &rec.Method, &rec.Route,
+			&rec.InputBytes, &rec.InputHash, &rec.Status, &rec.DurationMS); err != nil {
+			return nil, err
+		}
+		rec.Time = time.UnixMicro(timeUS).UTC()
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
+
+type historyResponse struct {
+	V []historyRecord `json:"v"`
+	// Next is the cursor of the next page, when there may be one.
+	Next string `json:"next,omitempty"`
+}
+
+// makeHistoryHandler answers GET /admin/history with a page of records,
+// newest first. The query string filters them:
+//
+//	from, to   RFC 3339 times, to excluded
+//	client     tenant, client address or API key hash
+//	op         operation, or route with its leading slash
+//	limit      page size, 100 by default and 1000 at most
+//	cursor     the "next" of the previous page
+func makeHistoryHandler(s *historyStore) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		q, err := historyQueryFrom(r)
+		if err != nil {
+			encodeError(r.Context(), err, w)
+			return
+		}
+		records, err := s.Query(r.Context(), q)
+		if err != nil {
+			encodeError(r.Context(), err, w)
+			return
+		}
+		resp := historyResponse{V: records}
+		if len(records) == q.Limit {
+			resp.Next = strconv.FormatInt(records[len(records)-1].ID, 10)
+		}
+		encodeResponse(r.Context(), w, resp)
+	})
+}
+
+func historyQueryFrom(r *http.Request) (historyQuery, error) {
+	values := r.URL.Query()
+	q := historyQuery{Client: values.Get("client"), Limit: defaultHistoryPageSize}
+	for _, t := range []struct {
+		name string
+		dst  *time.Time
+	}{{"from", &q.From}, {"to", &q.To}} {
+		if v := values.Get(t.name); v != "" {
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return q, malformedInputError{t.name, err}
+			}
+			*t.dst = parsed
+		}
+	}
+	if op := values.Get("op"); strings.HasPrefix(op, "/") {
+		q.Route = op
+	} else if op != "" {
+		q.Route = operationRoute(op)
+	}
+	if v := values.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxHistoryPageSize {
+			return q, operationError(fmt.Sprintf("Limit must be between 1 and %d", maxHistoryPageSize))
+		}
+		q.Limit = limit
+	}
+	if v := values.Get("cursor"); v != "" {
+		before, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return q, malformedInputError{"cursor", err}
+		}
+		q.Before = before
+	}
+	return q, nil
+}
@@ -0,0 +1,249 @@
+package server
+
+import (
+	"context"
+	stdhtml "html"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"golang.org/x/net/html"
+)
+
+//
+// ──────────────────────────────────────────────── I ──────────
+//   :::::: H T M L : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────
+//
+
+// SanitizePolicy is an allow-list: any tag that is not a key of Tags is
+// removed (its text content is kept) and any attribute that is not listed
+// for its tag is dropped.
+type SanitizePolicy struct {
+	Tags map[string][]string `json:"tags"`
+	// URLSchemes restricts the schemes accepted in href and src attributes.
+	// Relative URLs are always accepted.
+	URLSchemes []string `json:"url_schemes"`
+}
+
+// sanitizePolicies holds the named policies clients can pick with the
+// "policy" field. "strict" is used when none is given.
+var sanitizePolicies = map[string]SanitizePolicy{
+	"strict": {},
+	"basic": {
+		Tags: map[string][]string{
+			"a": {"href", "title"}, "b": nil, "blockquote": nil, "br": nil,
+			"code": nil, "em": nil, "i": nil, "li": nil, "ol": nil, "p": nil,
+			"pre": nil, "strong": nil, "u": nil, "ul": nil,
+		},
+		URLSchemes: []string{"http", "https", "mailto"},
+	},
+}
+
+// RegisterSanitizePolicy makes a new policy available under name. It is
+// meant to be called from init functions, before the server starts.
+func RegisterSanitizePolicy(name string, policy SanitizePolicy) {
+	sanitizePolicies[name] = policy
+}
+
+// dropContentTags are removed together with everything they contain, even
+// when a policy allows them.
+var dropContentTags = map[string]bool{
+	"script": true, "style": true, "iframe": true, "object": true,
+	"embed": true, "noscript": true, "template": true, "textarea": true,
+}
+
+type htmlRequest struct {
+	S string `json:"s"`
+	// Op is one of "escape", "unescape" or "sanitize".
+	Op     string `json:"op" validate:"max=64,charset=printable"`
+	Policy string `json:"policy" validate:"max=64,charset=printable"`
+}
+
+type htmlResponse struct {
+	V   string `json:"v"`
+	Err string `json:"err,omitempty"`
+}
+
+func (stringService) HTML(ctx context.Context, s, op, policy string) (string, error) {
+	switch op {
+	case "escape":
+		return stdhtml.EscapeString(s), nil
+	case "unescape":
+		return stdhtml.UnescapeString(s), nil
+	case "sanitize":
+		if policy == "" {
+			policy = "strict"
+		}
+		p, ok := sanitizePolicies[policy]
+		if !ok {
+			return "", errorf(CodeUnknownOption, "Unknown sanitize policy %q", policy)
+		}
+		return p.sanitize(s)
+	}
+	return "", errorf(CodeUnknownOption, "Unknown HTML operation %q", op)
+}
+
+func (p SanitizePolicy) sanitize(s string) (string, error) {
+	var (
+		b    strings.Builder
+		open []string
+		skip int
+	)
+	z := html.NewTokenizer(strings.NewReader(s))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if err := z.Err(); err != io.EOF {
+				return "", err
+			}
+			break
+		}
+		tok := z.Token()
+
+		switch tt {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if dropContentTags[tok.Data] {
+				if tt == html.StartTagToken {
+					skip++
+				}
+				continue
+			}
+			attrs, ok := p.Tags[tok.Data]
+			if skip > 0 || !ok {
+				continue
+			}
+			b.WriteString("<" + tok.Data)
+			for _, a := range tok.Attr {
+				if a.Namespace != "" || !contains(attrs, a.Key) {
+					continue
+				}
+				if (a.Key == "href" || a.Key == "src") && !p.allowedURL(a.Val) {
+					continue
+				}
+				b.WriteString(" " + a.Key + `="` + stdhtml.EscapeString(a.Val) + `"`)
+			}
+			if tt == html.SelfClosingTagToken || isVoidTag(tok.Data) {
+				b.WriteString(" />")
+				continue
+			}
+			b.WriteString(">")
+			open = append(open, tok.Data)
+
+		case html.EndTagToken:
+			if dropContentTags[tok.Data] {
+				if skip > 0 {
+					skip--
+				}
+				continue
+			}
+			if skip > 0 {
+				continue
+			}
+			// Close every tag opened after the matching one, and ignore end
+			// tags that were never opened, so the output is always balanced.
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i] != tok.Data {
+					continue
+				}
+				for j := len(open) - 1; j >= i; j-- {
+					b.WriteString("</" + open[j] + ">")
+				}
+				open = open[:i]
+				break
+			}
+
+		case html.TextToken:
+			if skip == 0 {
+				b.WriteString(stdhtml.EscapeString(tok.Data))
+			}
+		}
+	}
+	for i := len(open) - 1; i >= 0; i-- {
+		b.WriteString("</" + open[i] + ">")
+	}
+	return b.String(), nil
+}
+
+func (p SanitizePolicy) allowedURL(raw string) bool {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return false
+	}
+	if u.Scheme == "" {
+		return true
+	}
+	return contains(p.URLSchemes, strings.ToLower(u.Scheme))
+}
+
+func isVoidTag(tag string) bool {
+	switch tag {
+	case "area", "base", "br", "col", "hr", "img", "input", "link", "meta", "source", "track", "wbr":
+		return true
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func makeHTMLEndpoint(svc IStringService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(htmlRequest)
+		v, err := svc.HTML(ctx, req.S, req.Op, req.Policy)
+		if err != nil {
+			return htmlResponse{"", err.Error()}, nil
+		}
+		return htmlResponse{v, ""}, nil
+	}
+}
+
+func decodeHTMLRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request htmlRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+//
+// ─── LOGGING ────────────────────────────────────────────────────────────────────
+//
+
+func (mw loggingMiddleware) HTML(ctx context.Context, s, op, policy string) (output string, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "html",
+			"tenant", tenantFrom(ctx),
+			"input", s,
+			"op", op,
+			"policy", policy,
+			"output", output,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	output, err = mw.next.HTML(ctx, s, op, policy)
+	return
+}
+
+//
+// ─── INSTRUMENTATION ────────────────────────────────────────────────────────────
+//
+
+func (mw instrumentingMiddleware) HTML(ctx context.Context, s, op, policy string) (output string, err error) {
+	done := mw.begin(ctx, "html", s)
+	defer func() { done(len(output), err) }()
+
+	output, err = mw.next.HTML(ctx, s, op, policy)
+	return
+}
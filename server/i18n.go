@@ -0,0 +1,175 @@
+package server
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+//
+// ────────────────────────────────────────────── I ──────────
+//   :::::: I 1 8 N : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────
+//
+
+// Messages are written in English, and translated into the languages of
+// the Accept-Language header of requests. The catalogs of the other
+// languages are the JSON objects of locales/LANG.json, which map the
+// English format of each message, as passed to errorf, to its translation.
+// Translations may reorder their arguments with explicit indexes, like
+// "%[2]s". Messages missing from a catalog fall back to the next language
+// of the header, and then to English.
+
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// catalog is the translations of the messages into a language.
+type catalog map[string]string
+
+// catalogs are the catalogs by language, and languages the languages of
+// the service, English first.
+var catalogs, languages = loadCatalogs()
+
+var languageMatcher = language.NewMatcher(languages)
+
+func loadCatalogs() (map[language.Tag]catalog, []language.Tag) {
+	byLanguage := make(map[language.Tag]catalog)
+	tags := []language.Tag{language.English}
+	files, err := localeFiles.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+	for _, f := range files {
+		tag := language.MustParse(strings.TrimSuffix(f.Name(), path.Ext(f.Name())))
+		data, err := localeFiles.ReadFile("locales/" + f.Name())
+		if err != nil {
+			panic(err)
+		}
+		var c catalog
+		if err := json.Unmarshal(data, &c); err != nil {
+			panic(fmt.Sprintf("locales/%s: %v", f.Name(), err))
+		}
+		byLanguage[tag] = c
+		tags = append(tags, tag)
+	}
+	return byLanguage, tags
+}
+
+// locale is the fallback chain of the catalogs of a request. The zero
+// locale is English.
+type locale []catalog
+
+// localeOf returns the locale of an Accept-Language header: the catalogs
+// of the languages it accepts, by preference, up to English.
+func localeOf(acceptLanguage string) locale {
+	tags, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil {
+		return nil
+	}
+	var l locale
+	seen := make(map[language.Tag]bool)
+	for _, tag := range tags {
+		_, i, confidence := languageMatcher.Match(tag)
+		if confidence == language.No || seen[languages[i]] {
+			continue
+		}
+		if languages[i] == language.English {
+			break
+		}
+		seen[languages[i]] = true
+		l = append(l, catalogs[languages[i]])
+	}
+	return l
+}
+
+type localeKey struct{}
+
+// withLocales serves the requests to next with the locale of their
+// Accept-Language header, for the messages of their responses.
+func withLocales(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Language")
+		if l := localeOf(r.Header.Get("Accept-Language")); l != nil {
+			r = r.WithContext(context.WithValue(r.Context(), localeKey{}, l))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// localeFrom returns the locale of the request of ctx.
+func localeFrom(ctx context.Context) locale {
+	l, _ := ctx.Value(localeKey{}).(locale)
+	return l
+}
+
+// translate returns the translation of the English message s, or s.
+func (l locale) translate(s string) string {
+	for _, c := range l {
+		if translated, ok := c[s]; ok {
+			return translated
+		}
+	}
+	return s
+}
+
+// Sprintf formats the translation of format with args, errors among them
+// being translated as well.
+func (l locale) Sprintf(format string, args ...interface{}) string {
+	if len(l) == 0 {
+		return fmt.Sprintf(format, args...)
+	}
+	translated := make([]interface{}, len(args))
+	for i, arg := range args {
+		if err, ok := arg.(error); ok {
+			arg = l.Error(err)
+		}
+		translated[i] = arg
+	}
+	return fmt.Sprintf(l.translate(format), translated...)
+}
+
+// localizedError is an error whose message can be translated.
+type localizedError interface {
+	error
+	localize(l locale) string
+}
+
+// Error returns the message of err in the language of l.
+func (l locale) Error(err error) string {
+	if len(l) == 0 {
+		return err.Error()
+	}
+	var localized localizedError
+	if errors.As(err, &localized) && localized.Error() == err.Error() {
+		return localized.localize(l)
+	}
+	return l.translate(err.Error())
+}
+
+// localizedMessage is a message formatted with fmt.Sprintf, and translated
+// before. errorf returns them.
+type localizedMessage struct {
+	format string
+	args   []interface{}
+}
+
+func (m localizedMessage) Error() string { return fmt.Sprintf(m.format, m.args...) }
+
+func (m localizedMessage) localize(l locale) string { return l.Sprintf(m.format, m.args...) }
+
+// localizedFailure returns message, of a failure reported in the "err"
+// field of a response, in the language of the request of ctx. Only the
+// message of the failure recorded in ctx is translated.
+func localizedFailure(ctx context.Context, message string) string {
+	if err := recordedError(ctx); err != nil && err.Error() == message {
+		return localeFrom(ctx).Error(err)
+	}
+	return message
+}
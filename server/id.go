@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/google/uuid"
+	"github.com/oklog/ulid/v2"
+)
+
+//
+// ──────────────────────────────────────────── I ──────────
+//   :::::: I D : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────
+//
+
+// maxIDCount caps the number of identifiers returned by a single request.
+const maxIDCount = 1000
+
+// ulidEntropy is shared by every request so that ULIDs generated within
+// the same millisecond are strictly increasing across the whole process,
+// not just within one batch. MonotonicEntropy is not safe for concurrent
+// use, hence the lock.
+var ulidEntropy = struct {
+	sync.Mutex
+	*ulid.MonotonicEntropy
+}{MonotonicEntropy: ulid.Monotonic(rand.Reader, 0)}
+
+// idGenerators are the kinds of identifier clients can ask for.
+var idGenerators = map[string]func() (string, error){
+	"uuidv4": func() (string, error) {
+		id, err := uuid.NewRandom()
+		return id.String(), err
+	},
+	// uuid.NewV7 already serializes callers and keeps the values ordered.
+	"uuidv7": func() (string, error) {
+		id, err := uuid.NewV7()
+		return id.String(), err
+	},
+	"ulid": func() (string, error) {
+		ulidEntropy.Lock()
+		defer ulidEntropy.Unlock()
+		id, err := ulid.New(ulid.Now(), ulidEntropy.MonotonicEntropy)
+		return id.String(), err
+	},
+}
+
+type idRequest struct {
+	Kind  string `json:"kind" validate:"max=64,charset=printable"`
+	Count int    `json:"count"`
+}
+
+type idResponse struct {
+	V   []string `json:"v"`
+	Err string   `json:"err,omitempty"`
+}
+
+func (stringService) ID(ctx context.Context, kind string, count int) ([]string, error) {
+	if kind == "" {
+		kind = "uuidv4"
+	}
+	if count == 0 {
+		count = 1
+	}
+	if count < 0 || count > maxIDCount {
+		return nil, errorf(CodeOutOfRange, "Count must be between 1 and %d", maxIDCount)
+	}
+	gen, ok := idGenerators[kind]
+	if !ok {
+		return nil, errorf(CodeUnknownOption, "Unknown identifier kind %q", kind)
+	}
+
+	out := make([]string, count)
+	for i := range out {
+		id, err := gen()
+		if err != nil {
+			return nil, err
+		}
+		out[i] = id
+	}
+	return out, nil
+}
+
+func makeIDEndpoint(svc IStringService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(idRequest)
+		v, err := svc.ID(ctx, req.Kind, req.Count)
+		if err != nil {
+			return idResponse{nil, err.Error()}, nil
+		}
+		return idResponse{v, ""}, nil
+	}
+}
+
+func decodeIDRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request idRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+//
+// ─── LOGGING ────────────────────────────────────────────────────────────────────
+//
+
+func (mw loggingMiddleware) ID(ctx context.Context, kind string, count int) (output []string, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "id",
+			"tenant", tenantFrom(ctx),
+			"kind", kind,
+			"count", count,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	output, err = mw.next.ID(ctx, kind, count)
+	return
+}
+
+//
+// ─── INSTRUMENTATION ────────────────────────────────────────────────────────────
+//
+
+func (mw instrumentingMiddleware) ID(ctx context.Context, kind string, count int) (output []string, err error) {
+	done := mw.begin(ctx, "id", "")
+	defer func() { done(-1, err) }()
+
+	output, err = mw.next.ID(ctx, kind, count)
+	return
+}
@@ -0,0 +1,292 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+//
+// ────────────────────────────────────────────────────────────── I ──────────
+//   :::::: I D E M P O T E N C Y : :  :   :    :     :        :          :
+// ────────────────────────────────────────────────────────────────────────
+//
+
+// idempotencyKeyHeader carries the key clients send with the requests they
+// may retry. Requests with the same key, route, tenant and caller are
+// executed once, later ones getting the response of the first.
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// defaultIdempotencyTTL is how long responses are kept for replay.
+const defaultIdempotencyTTL = 24 * time.Hour
+
+var (
+	// ErrIdempotencyKeyReused is returned when a key comes back with another
+	// request than the one it was first used with, with a 422.
+	ErrIdempotencyKeyReused = errors.New("Idempotency key reused with a different request")
+	// ErrIdempotencyInFlight is returned while the first request with a key
+	// is still executing on another instance, with a 409.
+	ErrIdempotencyInFlight = errors.New("A request with this idempotency key is in progress")
+)
+
+// StoredResponse is the response recorded for an idempotency key.
+type StoredResponse struct {
+	// Fingerprint is the hash of the request body.
+	Fingerprint string `json:"fingerprint"`
+	// Pending marks keys whose request is still executing.
+	Pending     bool   `json:"pending,omitempty"`
+	Status      int    `json:"status"`
+	ContentType string `json:"content_type"`
+	Body        []byte `json:"body"`
+}
+
+// IdempotencyStore records the responses of idempotency keys.
+type IdempotencyStore interface {
+	// Reserve marks key as pending unless it is known already, in which case
+	// it returns what was stored for it, completed or pending.
+	Reserve(ctx context.Context, key, fingerprint string, ttl time.Duration) (existing *StoredResponse, err error)
+	// Complete records the response of a reserved key.
+	Complete(ctx context.Context, key string, resp StoredResponse, ttl time.Duration) error
+	// Release forgets a reserved key, so that its request can be retried.
+	Release(ctx context.Context, key string) error
+}
+
+// newIdempotencyStore returns a store in Redis when cfg names a server,
+// shared by every instance, and in memory otherwise.
+func newIdempotencyStore(cfg idempotencyConfig) IdempotencyStore {
+	if cfg.RedisAddr != "" {
+		return redisIdempotencyStore{redis.NewClient(&redis.Options{Addr: cfg.RedisAddr})}
+	}
+	return &memoryIdempotencyStore{entries: make(map[string]memoryIdempotencyEntry)}
+}
+
+//
+// ─── IN MEMORY ──────────────────────────────────────────────────────────────────
+//
+
+type memoryIdempotencyStore struct {
+	mu          sync.Mutex
+	entries     map[string]memoryIdempotencyEntry
+	lastCleanup time.Time
+}
+
+type memoryIdempotencyEntry struct {
+	resp    StoredResponse
+	expires time.Time
+}
+
+func (s *memoryIdempotencyStore) Reserve(_ context.Context, key, fingerprint string, ttl time.Duration) (*StoredResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if now.Sub(s.lastCleanup) > time.Minute {
+		for k, e := range s.entries {
+			if now.After(e.expires) {
+				delete(s.entries, k)
+			}
+		}
+		s.lastCleanup = now
+	}
+	if e, ok := s.entries[key]; ok && now.Before(e.expires) {
+		resp := e.resp
+		return &resp, nil
+	}
+	s.entries[key] = memoryIdempotencyEntry{StoredResponse{Fingerprint: fingerprint, Pending: true}, now.Add(ttl)}
+	return nil, nil
+}
+
+func (s *memoryIdempotencyStore) Complete(_ context.Context, key string, resp StoredResponse, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key] = memoryIdempotencyEntry{resp, time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *memoryIdempotencyStore) Release(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	return nil
+}
+
+//
+// ─── REDIS ──────────────────────────────────────────────────────────────────────
+//
+
+const idempotencyKeyPrefix = "stringsvc:idempotency:"
+
+type redisIdempotencyStore struct {
+	client *redis.Client
+}
+
+func (s redisIdempotencyStore) Reserve(ctx context.Context, key, fingerprint string, ttl time.Duration) (*StoredResponse, error) {
+	pending, err := json.Marshal(StoredResponse{Fingerprint: fingerprint, Pending: true})
+	if err != nil {
+		return nil, err
+	}
+	reserved, err := s.client.SetNX(ctx, idempotencyKeyPrefix+key, pending, ttl).Result()
+	if err != nil || reserved {
+		return nil, err
+	}
+	data, err := s.client.Get(ctx, idempotencyKeyPrefix+key).Bytes()
+	if err == redis.Nil {
+		// Expired in between; reserve it again.
+		return s.Reserve(ctx, key, fingerprint, ttl)
+	} else if err != nil {
+		return nil, err
+	}
+	var resp StoredResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (s redisIdempotencyStore) Complete(ctx context.Context, key string, resp StoredResponse, ttl time.Duration) error {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	return s.client.Set(ctx, idempotencyKeyPrefix+key, data, ttl).Err()
+}
+
+func (s redisIdempotencyStore) Release(ctx context.Context, key string) error {
+	return s.client.Del(ctx, idempotencyKeyPrefix+key).Err()
+}
+
+//
+// ─── MIDDLEWARE ─────────────────────────────────────────────────────────────────
+//
+
+// withIdempotency executes the POST requests with an Idempotency-Key once
+// per key, route, tenant and caller, replaying the recorded response to
+// the retries with an Idempotent-Replayed header. Retries arriving while
+// the first request executes on this instance wait for its response.
+// Server errors are not recorded, so that they can be retried. Streams are
+// left alone, since their bodies cannot be held back.
+func withIdempotency(next http.Handler, store IdempotencyStore, ttl time.Duration) http.Handler {
+	var (
+		mu       sync.Mutex
+		inflight = make(map[string]chan struct{})
+	)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" || r.Method != http.MethodPost || isStreamRoute(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key = idempotencyStoreKey(r, key)
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			encodeError(r.Context(), err, w)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(body)
+		fingerprint := hex.EncodeToString(sum[:])
+
+		mu.Lock()
+		for done, ok := inflight[key]; ok; done, ok = inflight[key] {
+			mu.Unlock()
+			select {
+			case <-done:
+			case <-r.Context().Done():
+				return
+			}
+			mu.Lock()
+		}
+		done := make(chan struct{})
+		inflight[key] = done
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			delete(inflight, key)
+			mu.Unlock()
+			close(done)
+		}()
+
+		existing, err := store.Reserve(r.Context(), key, fingerprint, ttl)
+		if err != nil {
+			encodeError(r.Context(), err, w)
+			return
+		}
+		if existing != nil {
+			switch {
+			case existing.Fingerprint != fingerprint:
+				encodeError(r.Context(), ErrIdempotencyKeyReused, w)
+			case existing.Pending:
+				encodeError(r.Context(), ErrIdempotencyInFlight, w)
+			default:
+				w.Header().Set("Content-Type", existing.ContentType)
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(existing.Status)
+				w.Write(existing.Body)
+			}
+			return
+		}
+
+		// The key is released unless its response is recorded, when the
+		// handler fails or panics, so that it is not left pending.
+		recorded := false
+		defer func() {
+			if !recorded {
+				store.Release(context.Background(), key)
+			}
+		}()
+		rec := &recordingWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		if rec.status >= http.StatusInternalServerError {
+			return
+		}
+		recorded = store.Complete(context.Background(), key, StoredResponse{
+			Fingerprint: fingerprint,
+			Status:      rec.status,
+			ContentType: w.Header().Get("Content-Type"),
+			Body:        rec.body.Bytes(),
+		}, ttl) == nil
+	})
+}
+
+// idempotencyStoreKey returns the key of the store for the Idempotency-Key
+// key of r, scoped to the route, the tenant and the caller of r so that
+// callers cannot read each other's responses by reusing a key.
+func idempotencyStoreKey(r *http.Request, key string) string {
+	h := sha256.New()
+	for _, part := range []string{tenantFrom(r.Context()), callerOf(r), key} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return r.URL.Path + ":" + hex.EncodeToString(h.Sum(nil))
+}
+
+// recordingWriter keeps a copy of the response it writes.
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	w.body.Write(p)
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the connection.
+func (w *recordingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
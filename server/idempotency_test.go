@@ -0,0 +1,142 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// idempotencyCall is a request of TestIdempotency, with what the handler
+// does with it and what the client should get.
+type idempotencyCall struct {
+	// route is "/random" when empty.
+	route                string
+	tenant, apiKey, body string
+	// fail makes the handler answer with a 500, panic makes it panic.
+	fail, panic bool
+	wantStatus  int
+	wantReplay  bool
+	wantCalls   int
+}
+
+func TestIdempotency(t *testing.T) {
+	tests := []struct {
+		name  string
+		calls []idempotencyCall
+	}{
+		{
+			name: "retry replays",
+			calls: []idempotencyCall{
+				{body: "a", wantStatus: 200, wantCalls: 1},
+				{body: "a", wantStatus: 200, wantReplay: true, wantCalls: 1},
+			},
+		},
+		{
+			name: "other body",
+			calls: []idempotencyCall{
+				{body: "a", wantStatus: 200, wantCalls: 1},
+				{body: "b", wantStatus: http.StatusUnprocessableEntity, wantCalls: 1},
+			},
+		},
+		{
+			name: "other tenant",
+			calls: []idempotencyCall{
+				{tenant: "acme", body: "a", wantStatus: 200, wantCalls: 1},
+				{tenant: "globex", body: "a", wantStatus: 200, wantCalls: 2},
+				{tenant: "acme", body: "a", wantStatus: 200, wantReplay: true, wantCalls: 2},
+			},
+		},
+		{
+			name: "other API key",
+			calls: []idempotencyCall{
+				{apiKey: "k1", body: "a", wantStatus: 200, wantCalls: 1},
+				{apiKey: "k2", body: "a", wantStatus: 200, wantCalls: 2},
+				{body: "a", wantStatus: 200, wantCalls: 3},
+			},
+		},
+		{
+			name: "server error is not recorded",
+			calls: []idempotencyCall{
+				{body: "a", fail: true, wantStatus: 500, wantCalls: 1},
+				{body: "a", wantStatus: 200, wantCalls: 2},
+			},
+		},
+		{
+			name: "panic releases the key",
+			calls: []idempotencyCall{
+				{body: "a", panic: true, wantCalls: 1},
+				{body: "a", wantStatus: 200, wantCalls: 2},
+			},
+		},
+		{
+			name: "streams are not recorded",
+			calls: []idempotencyCall{
+				{route: "/stream/count", body: "a", wantStatus: 200, wantCalls: 1},
+				{route: "/stream/count", body: "a", wantStatus: 200, wantCalls: 2},
+				{route: "/v2/stream/count", body: "a", wantStatus: 200, wantCalls: 3},
+				{route: "/v2/stream/count", body: "a", wantStatus: 200, wantCalls: 4},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			var current idempotencyCall
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				switch {
+				case current.panic:
+					panic(http.ErrAbortHandler)
+				case current.fail:
+					w.WriteHeader(http.StatusInternalServerError)
+				default:
+					w.Header().Set("Content-Type", "application/json")
+					fmt.Fprintf(w, `{"v":%d}`, calls)
+				}
+			})
+			store := newIdempotencyStore(idempotencyConfig{})
+			h := withIdempotency(next, store, time.Hour)
+
+			for i, c := range tt.calls {
+				current = c
+				route := c.route
+				if route == "" {
+					route = "/random"
+				}
+				r := httptest.NewRequest(http.MethodPost, route, strings.NewReader(c.body))
+				r.Header.Set(idempotencyKeyHeader, "key")
+				if c.apiKey != "" {
+					r.Header.Set(apiKeyHeader, c.apiKey)
+				}
+				if c.tenant != "" {
+					r = r.WithContext(context.WithValue(r.Context(), tenantKey{}, c.tenant))
+				}
+				w := httptest.NewRecorder()
+				func() {
+					defer func() {
+						if p := recover(); p != nil && !c.panic {
+							panic(p)
+						}
+					}()
+					h.ServeHTTP(w, r)
+				}()
+				if calls != c.wantCalls {
+					t.Errorf("call %d: handler called %d times, want %d", i, calls, c.wantCalls)
+				}
+				if c.panic {
+					continue
+				}
+				if w.Code != c.wantStatus {
+					t.Errorf("call %d: status %d, want %d", i, w.Code, c.wantStatus)
+				}
+				if replayed := w.Header().Get("Idempotent-Replayed") == "true"; replayed != c.wantReplay {
+					t.Errorf("call %d: replayed %v, want %v", i, replayed, c.wantReplay)
+				}
+			}
+		})
+	}
+}
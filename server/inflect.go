@@ -0,0 +1,278 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+//
+// ────────────────────────────────────────────────────── I ──────────
+//   :::::: I N F L E C T : :  :   :    :     :        :          :
+// ────────────────────────────────────────────────────────────────
+//
+
+type inflectionRule struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// pluralRules and singularRules are tried from the last to the first, so
+// rules registered later take precedence over the generic ones below.
+var pluralRules, singularRules []inflectionRule
+
+// irregulars maps irregular singulars to their plural, and
+// irregularSingulars is its reverse. Both are lower case.
+var irregulars, irregularSingulars = map[string]string{}, map[string]string{}
+
+// uncountables are words that are the same in singular and plural.
+var uncountables = map[string]bool{}
+
+func init() {
+	for _, r := range [][2]string{
+		{`$`, `s`},
+		{`s$`, `s`},
+		{`^(ax|test)is$`, `${1}es`},
+		{`(octop|vir)us$`, `${1}i`},
+		{`(alias|status)$`, `${1}es`},
+		{`(bu)s$`, `${1}ses`},
+		{`(buffal|tomat)o$`, `${1}oes`},
+		{`([ti])um$`, `${1}a`},
+		{`sis$`, `ses`},
+		{`(?:([^f])fe|([lr])f)$`, `${1}${2}ves`},
+		{`(hive)$`, `${1}s`},
+		{`([^aeiouy]|qu)y$`, `${1}ies`},
+		{`(x|ch|ss|sh)$`, `${1}es`},
+		{`(matr|vert|ind)(?:ix|ex)$`, `${1}ices`},
+		{`^(m|l)ouse$`, `${1}ice`},
+		{`^(ox)$`, `${1}en`},
+		{`(quiz)$`, `${1}zes`},
+	} {
+		RegisterPluralRule(r[0], r[1])
+	}
+	for _, r := range [][2]string{
+		{`s$`, ``},
+		{`(ss)$`, `${1}`},
+		{`(n)ews$`, `${1}ews`},
+		{`([ti])a$`, `${1}um`},
+		{`((a)naly|(b)a|(d)iagno|(p)arenthe|(p)rogno|(s)ynop|(t)he)(sis|ses)$`, `${1}sis`},
+		{`(^analy)(sis|ses)$`, `${1}sis`},
+		{`([^f])ves$`, `${1}fe`},
+		{`(hive)s$`, `${1}`},
+		{`(tive)s$`, `${1}`},
+		{`([lr])ves$`, `${1}f`},
+		{`([^aeiouy]|qu)ies$`, `${1}y`},
+		{`(s)eries$`, `${1}eries`},
+		{`(m)ovies$`, `${1}ovie`},
+		{`(x|ch|ss|sh)es$`, `${1}`},
+		{`^(m|l)ice$`, `${1}ouse`},
+		{`(bus)(es)?$`, `${1}`},
+		{`(o)es$`, `${1}`},
+		{`(shoe)s$`, `${1}`},
+		{`(cris|test)(is|es)$`, `${1}is`},
+		{`^(a)x[ie]s$`, `${1}xis`},
+		{`(octop|vir)(us|i)$`, `${1}us`},
+		{`(alias|status)(es)?$`, `${1}`},
+		{`^(ox)en`, `${1}`},
+		{`(vert|ind)ices$`, `${1}ex`},
+		{`(matr)ices$`, `${1}ix`},
+		{`(quiz)zes$`, `${1}`},
+	} {
+		RegisterSingularRule(r[0], r[1])
+	}
+	for singular, plural := range map[string]string{
+		"person": "people",
+		"man":    "men",
+		"woman":  "women",
+		"child":  "children",
+		"foot":   "feet",
+		"tooth":  "teeth",
+		"goose":  "geese",
+		"sex":    "sexes",
+		"move":   "moves",
+		"zombie": "zombies",
+	} {
+		RegisterIrregular(singular, plural)
+	}
+	for _, w := range []string{
+		"equipment", "information", "rice", "money", "species", "series",
+		"fish", "sheep", "jeans", "police", "news", "metadata",
+	} {
+		RegisterUncountable(w)
+	}
+}
+
+// RegisterPluralRule adds a case-insensitive rule turning words matching
+// pattern into their plural. Like the other Register functions of this
+// file, it is meant to be called before the server starts.
+func RegisterPluralRule(pattern, replacement string) {
+	pluralRules = append(pluralRules, inflectionRule{regexp.MustCompile("(?i)" + pattern), replacement})
+}
+
+// RegisterSingularRule is the counterpart of RegisterPluralRule.
+func RegisterSingularRule(pattern, replacement string) {
+	singularRules = append(singularRules, inflectionRule{regexp.MustCompile("(?i)" + pattern), replacement})
+}
+
+// RegisterIrregular overrides the rules for a word in both directions.
+func RegisterIrregular(singular, plural string) {
+	singular, plural = strings.ToLower(singular), strings.ToLower(plural)
+	irregulars[singular] = plural
+	irregularSingulars[plural] = singular
+}
+
+// RegisterUncountable marks a word as having no distinct plural.
+func RegisterUncountable(word string) {
+	uncountables[strings.ToLower(word)] = true
+}
+
+type inflectRequest struct {
+	S  string `json:"s"`
+	Op string `json:"op" validate:"max=64,charset=printable"`
+}
+
+type inflectResponse struct {
+	V   string `json:"v"`
+	Err string `json:"err,omitempty"`
+}
+
+// Inflect applies op, one of "pluralize", "singularize" or "ordinalize",
+// to s. Only the last word of s is inflected, so "user account" becomes
+// "user accounts".
+func (stringService) Inflect(ctx context.Context, s, op string) (string, error) {
+	switch op {
+	case "pluralize":
+		return inflectLastWord(s, pluralRules, irregulars, irregularSingulars), nil
+	case "singularize":
+		return inflectLastWord(s, singularRules, irregularSingulars, irregulars), nil
+	case "ordinalize":
+		return ordinalize(s)
+	}
+	return "", errorf(CodeUnknownOption, "Unknown inflection %q", op)
+}
+
+func inflectLastWord(s string, rules []inflectionRule, overrides, reverse map[string]string) string {
+	start := 0
+	if i := strings.LastIndexFunc(s, isWordSeparator); i >= 0 {
+		_, size := utf8.DecodeRuneInString(s[i:])
+		start = i + size
+	}
+	prefix, word := s[:start], s[start:]
+	lower := strings.ToLower(word)
+	if word == "" || uncountables[lower] {
+		return s
+	}
+	if w, ok := overrides[lower]; ok {
+		return prefix + matchCase(word, w)
+	}
+	// Irregular words already in the target form are left alone.
+	if _, ok := reverse[lower]; ok {
+		return s
+	}
+	for i := len(rules) - 1; i >= 0; i-- {
+		if rules[i].pattern.MatchString(word) {
+			return prefix + rules[i].pattern.ReplaceAllString(word, rules[i].replacement)
+		}
+	}
+	return s
+}
+
+func isWordSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// matchCase returns w with the casing of model: all upper case, title case
+// or unchanged.
+func matchCase(model, w string) string {
+	switch {
+	case model == strings.ToUpper(model) && utf8.RuneCountInString(model) > 1:
+		return strings.ToUpper(w)
+	case unicode.IsUpper([]rune(model)[0]):
+		r, size := utf8.DecodeRuneInString(w)
+		return string(unicode.ToUpper(r)) + w[size:]
+	}
+	return w
+}
+
+// ordinalize turns an integer into its English ordinal form: 1st, 2nd,
+// 11th, 101st.
+func ordinalize(s string) (string, error) {
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return "", errorf(CodeUnsupportedInput, "Cannot ordinalize %q: not an integer", s)
+	}
+	abs := n
+	if abs < 0 {
+		abs = -abs
+	}
+	suffix := "th"
+	if abs%100 < 11 || abs%100 > 13 {
+		switch abs % 10 {
+		case 1:
+			suffix = "st"
+		case 2:
+			suffix = "nd"
+		case 3:
+			suffix = "rd"
+		}
+	}
+	return strconv.Itoa(n) + suffix, nil
+}
+
+func makeInflectEndpoint(svc IStringService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(inflectRequest)
+		v, err := svc.Inflect(ctx, req.S, req.Op)
+		if err != nil {
+			return inflectResponse{"", err.Error()}, nil
+		}
+		return inflectResponse{v, ""}, nil
+	}
+}
+
+func decodeInflectRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request inflectRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+//
+// ─── LOGGING ────────────────────────────────────────────────────────────────────
+//
+
+func (mw loggingMiddleware) Inflect(ctx context.Context, s, op string) (output string, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "inflect",
+			"tenant", tenantFrom(ctx),
+			"op", op,
+			"input", s,
+			"output", output,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	output, err = mw.next.Inflect(ctx, s, op)
+	return
+}
+
+//
+// ─── INSTRUMENTATION ────────────────────────────────────────────────────────────
+//
+
+func (mw instrumentingMiddleware) Inflect(ctx context.Context, s, op string) (output string, err error) {
+	done := mw.begin(ctx, "inflect", s)
+	defer func() { done(len(output), err) }()
+
+	output, err = mw.next.Inflect(ctx, s, op)
+	return
+}
@@ -0,0 +1,483 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+//
+// ──────────────────────────────────────────────── I ──────────
+//   :::::: J O B S : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────
+//
+
+// The operations too long for a synchronous request, such as huge batches,
+// are submitted as jobs to POST /jobs and run in the background. Their
+// status and result are polled with GET /jobs/{id}, by the caller that
+// submitted them only: the same subject of an access token or API key, in
+// the same tenant. A job runs on the instance it was submitted to; a shared
+// store only makes its status readable from every instance.
+
+// Defaults of the "jobs" config section.
+const (
+	defaultJobTTL       = 24 * time.Hour
+	defaultJobTimeout   = time.Hour
+	defaultJobQueueSize = 100
+)
+
+var (
+	// ErrUnknownJob is returned for job IDs that are not known, or whose
+	// job expired, with a 404.
+	ErrUnknownJob = errors.New("Unknown job")
+	// ErrJobQueueFull is returned to the submissions that find every worker
+	// busy and the queue full, with a 503.
+	ErrJobQueueFull = errors.New("Too many jobs waiting, retry later")
+	// ErrJobQueueClosed is returned to the submissions made while the
+	// server shuts down, with a 503.
+	ErrJobQueueClosed = errors.New("Job queue closed, retry later")
+)
+
+// Statuses of jobs.
+const (
+	jobPending = "pending"
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+)
+
+type jobRequest struct {
+	// Op is the operation to run: one of the operations, "batch" or
+	// "pipeline".
+	Op string `json:"op" validate:"max=64,charset=printable"`
+	// Options is the request of the operation, "s" included.
+	Options map[string]interface{} `json:"options"`
+	// CallbackURL is notified once the job is finished, if set.
+	CallbackURL string `json:"callback_url" validate:"max=2048"`
+}
+
+// Job is the state of a submitted operation.
+type Job struct {
+	ID     string `json:"id"`
+	Op     string `json:"op"`
+	Status string `json:"status"`
+	// Result is the response of the operation once done. Its "err" field
+	// tells whether the operation itself failed, as with synchronous calls.
+	Result json.RawMessage `json:"result,omitempty"`
+	// Err is why a failed job did not get a response: its request was
+	// invalid, it was rejected or it timed out.
+	Err         string     `json:"err,omitempty"`
+	Code        ErrorCode  `json:"code,omitempty"`
+	SubmittedAt time.Time  `json:"submitted_at"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	// Callback is the state of the notification of the job, when submitted
+	// with a callback URL.
+	Callback *jobCallback `json:"callback,omitempty"`
+	// Tenant and Owner are those of the request submitting the job, which
+	// only they can read, Owner as told by callerOf. They are not answered
+	// but stores keep them.
+	Tenant string `json:"-"`
+	Owner  string `json:"-"`
+}
+
+// JobStore keeps the state of jobs, all of its fields.
+type JobStore interface {
+	// Save records j, replacing its previous state.
+	Save(ctx context.Context, j Job, ttl time.Duration) error
+	// Load returns the job id, or ErrUnknownJob.
+	Load(ctx context.Context, id string) (Job, error)
+}
+
+// newJobStore returns a store in Redis when cfg names a server, shared by
+// every instance, and in memory otherwise.
+func newJobStore(cfg jobsConfig) JobStore {
+	if cfg.RedisAddr != "" {
+		return redisJobStore{redis.NewClient(&redis.Options{Addr: cfg.RedisAddr})}
+	}
+	return &memoryJobStore{jobs: make(map[string]memoryJob)}
+}
+
+// jobQueue runs the submitted jobs with a pool of workers.
+type jobQueue struct {
+	ops      map[string]operation
+	store    JobStore
+	queue    chan queuedJob
+	timeout  time.Duration
+	ttl      time.Duration
+	notifier *webhookNotifier
+	logger   log.Logger
+
+	// ctx is that of the jobs, canceled when close gives up waiting for
+	// them.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// mu guards queue against the submissions once closed.
+	mu      sync.RWMutex
+	closed  bool
+	workers sync.WaitGroup
+}
+
+type queuedJob struct {
+	Job
+	tenant string
+	// token is the access token of the request submitting the job, whose
+	// scopes the job keeps.
+	token *accessToken
+	// identity is that of the request submitting the job, whose roles the
+	// job keeps.
+	identity *Identity
+	request  interface{}
+}
+
+// newJobQueue starts the workers running the jobs of ops, kept in store.
+// These are run with the timeout of jobs rather than their own. notifier
+// delivers the notifications of the jobs with a callback URL.
+func newJobQueue(cfg jobsConfig, store JobStore, ops map[string]operation, notifier *webhookNotifier, logger log.Logger) *jobQueue {
+	workers := cfg.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	queueSize := cfg.QueueSize
+	if queueSize <= 0 {
+		queueSize = defaultJobQueueSize
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &jobQueue{
+		ops:      ops,
+		store:    store,
+		queue:    make(chan queuedJob, queueSize),
+		timeout:  secondsOr(cfg.TimeoutSeconds, defaultJobTimeout),
+		ttl:      secondsOr(cfg.TTLSeconds, defaultJobTTL),
+		notifier: notifier,
+		logger:   logger,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	q.workers.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer q.workers.Done()
+			for qj := range q.queue {
+				q.run(qj)
+			}
+		}()
+	}
+	return q
+}
+
+// close stops taking jobs, and waits for the workers to finish those
+// queued. Once ctx is done, the jobs left are canceled, and close returns
+// the error of ctx as soon as the workers have stopped.
+func (q *jobQueue) close(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.queue)
+	}
+	q.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		q.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		q.cancel()
+		return nil
+	case <-ctx.Done():
+		q.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// secondsOr returns s seconds, or def when s is not positive.
+func secondsOr(s int, def time.Duration) time.Duration {
+	if s <= 0 {
+		return def
+	}
+	return time.Duration(s) * time.Second
+}
+
+// Submit records a pending job running req for owner and queues it.
+// Invalid requests and options that cannot be decoded are rejected right
+// away; the options are validated when the job runs.
+func (q *jobQueue) Submit(ctx context.Context, req jobRequest, owner string) (Job, error) {
+	if err := validateRequest(req); err != nil {
+		return Job{}, err
+	}
+	op, ok := q.ops[req.Op]
+	if !ok {
+		return Job{}, withCode(CodeUnknownOperation, operationError(fmt.Sprintf("Unknown operation %q", req.Op)))
+	}
+	data, err := json.Marshal(req.Options)
+	if err != nil {
+		return Job{}, malformedInputError{"options", err}
+	}
+	request, err := op.decode(data)
+	if err != nil {
+		return Job{}, malformedInputError{"options", err}
+	}
+	var callback *jobCallback
+	if req.CallbackURL != "" {
+		if err := q.notifier.check(req.CallbackURL); err != nil {
+			return Job{}, err
+		}
+		callback = &jobCallback{URL: req.CallbackURL, Status: callbackPending}
+	}
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return Job{}, err
+	}
+
+	// The job is saved before being queued, so that the worker picking it
+	// up cannot be overwritten.
+	j := Job{
+		ID: id.String(), Op: req.Op, Status: jobPending, SubmittedAt: time.Now().UTC(), Callback: callback,
+		Tenant: tenantFrom(ctx), Owner: owner,
+	}
+	if err := q.store.Save(ctx, j, q.ttl); err != nil {
+		return Job{}, err
+	}
+	if err := q.enqueue(queuedJob{j, tenantFrom(ctx), accessTokenFrom(ctx), identityFrom(ctx), request}); err != nil {
+		j.Status, j.Err = jobFailed, err.Error()
+		q.store.Save(ctx, j, q.ttl)
+		return Job{}, err
+	}
+	return j, nil
+}
+
+// enqueue queues qj, unless the queue is full or closed.
+func (q *jobQueue) enqueue(qj queuedJob) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return ErrJobQueueClosed
+	}
+	select {
+	case q.queue <- qj:
+		return nil
+	default:
+		return ErrJobQueueFull
+	}
+}
+
+// Job returns the job id, unknown to the callers other than its owner, in
+// the tenant of ctx.
+func (q *jobQueue) Job(ctx context.Context, id, owner string) (Job, error) {
+	j, err := q.store.Load(ctx, id)
+	if err != nil {
+		return Job{}, err
+	}
+	if j.Tenant != tenantFrom(ctx) || j.Owner != owner {
+		return Job{}, ErrUnknownJob
+	}
+	return j, nil
+}
+
+func (q *jobQueue) run(qj queuedJob) {
+	j := qj.Job
+	started := time.Now().UTC()
+	j.Status, j.StartedAt = jobRunning, &started
+	q.save(j)
+
+	ctx := context.WithValue(q.ctx, tenantKey{}, qj.tenant)
+	ctx = context.WithValue(ctx, accessTokenKey{}, qj.token)
+	ctx = context.WithValue(ctx, identityKey{}, qj.identity)
+	ctx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	ctx, codes := withErrorCodes(ctx)
+	response, err := q.ops[j.Op].Endpoint(ctx, qj.request)
+	if err == nil && hasErr(response) {
+		response, err = withErrorCode(response, codes.Code())
+	}
+	if err == nil {
+		j.Result, err = json.Marshal(response)
+	}
+
+	finished := time.Now().UTC()
+	j.Status, j.FinishedAt = jobDone, &finished
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = errorf(CodeTimeout, "Job timed out after %v", q.timeout)
+		}
+		j.Status, j.Result, j.Err, j.Code = jobFailed, nil, err.Error(), errorCodeOf(err)
+	}
+	q.save(j)
+	// A redelivery requested once the job was saved delivers it already.
+	if j.Callback != nil && q.notifier.claim(j.ID) {
+		go q.notifier.deliver(j, q.save)
+	}
+}
+
+// Redeliver delivers the notification of the finished job id again, from
+// its first attempt.
+func (q *jobQueue) Redeliver(ctx context.Context, id string) (Job, error) {
+	j, err := q.store.Load(ctx, id)
+	if err != nil {
+		return Job{}, err
+	}
+	if j.Callback == nil {
+		return Job{}, ErrNoCallback
+	}
+	if j.Status != jobDone && j.Status != jobFailed {
+		return Job{}, ErrJobNotFinished
+	}
+	if !q.notifier.claim(j.ID) {
+		return Job{}, ErrCallbackInProgress
+	}
+	j.Callback = &jobCallback{URL: j.Callback.URL, Status: callbackPending}
+	if err := q.store.Save(ctx, j, q.ttl); err != nil {
+		q.notifier.release(j.ID)
+		return Job{}, err
+	}
+	go q.notifier.deliver(j, q.save)
+	return j, nil
+}
+
+// save records the jobs run by the workers, which have no client to
+// report errors to.
+func (q *jobQueue) save(j Job) {
+	if err := q.store.Save(context.Background(), j, q.ttl); err != nil {
+		q.logger.Log("msg", "cannot save job", "job", j.ID, "status", j.Status, "err", err)
+	}
+}
+
+//
+// ─── IN MEMORY ──────────────────────────────────────────────────────────────────
+//
+
+type memoryJobStore struct {
+	mu          sync.Mutex
+	jobs        map[string]memoryJob
+	lastCleanup time.Time
+}
+
+type memoryJob struct {
+	Job
+	expires time.Time
+}
+
+func (s *memoryJobStore) Save(_ context.Context, j Job, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if now.Sub(s.lastCleanup) > time.Minute {
+		for id, mj := range s.jobs {
+			if now.After(mj.expires) {
+				delete(s.jobs, id)
+			}
+		}
+		s.lastCleanup = now
+	}
+	s.jobs[j.ID] = memoryJob{j, now.Add(ttl)}
+	return nil
+}
+
+func (s *memoryJobStore) Load(_ context.Context, id string) (Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mj, ok := s.jobs[id]
+	if !ok || time.Now().After(mj.expires) {
+		return Job{}, ErrUnknownJob
+	}
+	return mj.Job, nil
+}
+
+//
+// ─── REDIS ──────────────────────────────────────────────────────────────────────
+//
+
+const jobKeyPrefix = "stringsvc:jobs:"
+
+type redisJobStore struct {
+	client *redis.Client
+}
+
+// redisJob is a job as kept in Redis, with the fields it does not answer.
+type redisJob struct {
+	Job
+	Tenant string `json:"tenant"`
+	Owner  string `json:"owner"`
+}
+
+func (s redisJobStore) Save(ctx context.Context, j Job, ttl time.Duration) error {
+	data, err := json.Marshal(redisJob{j, j.Tenant, j.Owner})
+	if err != nil {
+		return err
+	}
+	return s.client.Set(ctx, jobKeyPrefix+j.ID, data, ttl).Err()
+}
+
+func (s redisJobStore) Load(ctx context.Context, id string) (Job, error) {
+	var rj redisJob
+	data, err := s.client.Get(ctx, jobKeyPrefix+id).Bytes()
+	if err == redis.Nil {
+		return Job{}, ErrUnknownJob
+	} else if err != nil {
+		return Job{}, err
+	}
+	if err := json.Unmarshal(data, &rj); err != nil {
+		return Job{}, err
+	}
+	rj.Job.Tenant, rj.Job.Owner = rj.Tenant, rj.Owner
+	return rj.Job, nil
+}
+
+//
+// ─── HANDLER ────────────────────────────────────────────────────────────────────
+//
+
+// makeJobsHandler submits jobs on POST /jobs, answering with a 202 and the
+// pending job, and returns them on GET /jobs/{id}.
+func makeJobsHandler(q *jobQueue) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/jobs"), "/")
+		switch {
+		case id == "" && r.Method == http.MethodPost:
+			var req jobRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				encodeError(r.Context(), malformedInputError{"request", err}, w)
+				return
+			}
+			j, err := q.Submit(r.Context(), req, callerOf(r))
+			if err != nil {
+				encodeError(r.Context(), err, w)
+				return
+			}
+			w.Header().Set("Location", "/jobs/"+j.ID)
+			if mediaType, c, ok := responseCodec(r.Context()); ok {
+				writeWithCodec(w, mediaType, c, http.StatusAccepted, j)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(j)
+		case id != "" && r.Method == http.MethodGet:
+			j, err := q.Job(r.Context(), id, callerOf(r))
+			if err != nil {
+				encodeError(r.Context(), err, w)
+				return
+			}
+			encodeResponse(r.Context(), w, j)
+		case id == "":
+			w.Header().Set("Allow", "POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		default:
+			w.Header().Set("Allow", "GET")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+}
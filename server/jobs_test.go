@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestJobOwnership(t *testing.T) {
+	ops := map[string]operation{
+		"uppercase": {
+			Endpoint: func(context.Context, interface{}) (interface{}, error) { return uppercaseResponse{V: "A"}, nil },
+			Request:  uppercaseRequest{},
+			Response: uppercaseResponse{},
+		},
+	}
+	q := newJobQueue(jobsConfig{Workers: 1}, newJobStore(jobsConfig{}), ops, nil, log.NewNopLogger())
+	h := makeJobsHandler(q)
+
+	// caller makes the requests of a tenant with an access token or an API
+	// key.
+	type caller struct {
+		tenant, subject, apiKey string
+	}
+	request := func(method, path, body string, c caller) *http.Request {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		ctx := context.WithValue(r.Context(), tenantKey{}, c.tenant)
+		if c.subject != "" {
+			ctx = context.WithValue(ctx, accessTokenKey{}, &accessToken{Subject: c.subject})
+		}
+		if c.apiKey != "" {
+			r.Header.Set(apiKeyHeader, c.apiKey)
+		}
+		return r.WithContext(ctx)
+	}
+
+	tests := []struct {
+		name       string
+		owner      caller
+		reader     caller
+		wantStatus int
+	}{
+		{name: "same subject", owner: caller{tenant: "acme", subject: "alice"}, reader: caller{tenant: "acme", subject: "alice"}, wantStatus: 200},
+		{name: "other subject", owner: caller{tenant: "acme", subject: "alice"}, reader: caller{tenant: "acme", subject: "bob"}, wantStatus: 404},
+		{name: "other tenant", owner: caller{tenant: "acme", subject: "alice"}, reader: caller{tenant: "globex", subject: "alice"}, wantStatus: 404},
+		{name: "anonymous reader", owner: caller{tenant: "acme", subject: "alice"}, reader: caller{tenant: "acme"}, wantStatus: 404},
+		{name: "same API key", owner: caller{tenant: "acme", apiKey: "k1"}, reader: caller{tenant: "acme", apiKey: "k1"}, wantStatus: 200},
+		{name: "other API key", owner: caller{tenant: "acme", apiKey: "k1"}, reader: caller{tenant: "acme", apiKey: "k2"}, wantStatus: 404},
+		{name: "anonymous", owner: caller{tenant: defaultTenant}, reader: caller{tenant: defaultTenant}, wantStatus: 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, request(http.MethodPost, "/jobs", `{"op":"uppercase","options":{"s":"a"}}`, tt.owner))
+			if w.Code != http.StatusAccepted {
+				t.Fatalf("submission status %d: %s", w.Code, w.Body)
+			}
+			var j Job
+			if err := json.Unmarshal(w.Body.Bytes(), &j); err != nil {
+				t.Fatal(err)
+			}
+
+			w = httptest.NewRecorder()
+			h.ServeHTTP(w, request(http.MethodGet, "/jobs/"+j.ID, "", tt.reader))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if strings.Contains(w.Body.String(), "owner") || strings.Contains(w.Body.String(), "tenant") {
+				t.Errorf("the owner of the job is answered: %s", w.Body)
+			}
+		})
+	}
+}
+
+func TestJobQueueClose(t *testing.T) {
+	tests := []struct {
+		name   string
+		submit bool
+		// block makes the job wait for its context, until the deadline of
+		// close cancels it.
+		block   bool
+		wantErr error
+	}{
+		{name: "idle"},
+		{name: "job finished", submit: true},
+		{name: "job canceled", submit: true, block: true, wantErr: context.DeadlineExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := runtime.NumGoroutine()
+			started := make(chan struct{}, 1)
+			ops := map[string]operation{
+				"uppercase": {
+					Endpoint: func(ctx context.Context, _ interface{}) (interface{}, error) {
+						started <- struct{}{}
+						if tt.block {
+							<-ctx.Done()
+							return nil, ctx.Err()
+						}
+						return uppercaseResponse{V: "A"}, nil
+					},
+					Request:  uppercaseRequest{},
+					Response: uppercaseResponse{},
+				},
+			}
+			store := newJobStore(jobsConfig{})
+			q := newJobQueue(jobsConfig{Workers: 4}, store, ops, nil, log.NewNopLogger())
+			var j Job
+			if tt.submit {
+				var err error
+				j, err = q.Submit(context.Background(), jobRequest{Op: "uppercase", Options: map[string]interface{}{"s": "a"}}, "")
+				if err != nil {
+					t.Fatal(err)
+				}
+				<-started
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			if err := q.close(ctx); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("close error = %v, want %v", err, tt.wantErr)
+			}
+			if j.ID != "" {
+				if saved, err := store.Load(context.Background(), j.ID); err != nil || (saved.Status != jobDone && saved.Status != jobFailed) {
+					t.Errorf("job %+v left unfinished: %v", saved, err)
+				}
+			}
+			if _, err := q.Submit(context.Background(), jobRequest{Op: "uppercase", Options: map[string]interface{}{"s": "a"}}, ""); !errors.Is(err, ErrJobQueueClosed) {
+				t.Errorf("Submit after close error = %v, want %v", err, ErrJobQueueClosed)
+			}
+
+			// The workers are gone once close returns, though the runtime
+			// may take a moment to count them out.
+			for deadline := time.Now().Add(time.Second); runtime.NumGoroutine() > before; time.Sleep(10 * time.Millisecond) {
+				if time.Now().After(deadline) {
+					t.Fatalf("%d goroutines left, %d before", runtime.NumGoroutine(), before)
+				}
+			}
+		})
+	}
+}
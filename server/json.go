@@ -0,0 +1,167 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+//
+// ──────────────────────────────────────────────── I ──────────
+//   :::::: J S O N : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────
+//
+
+// Limits of the JSON operations. Documents nested deeper than
+// maxJSONDepth are rejected before being parsed.
+const (
+	maxJSONInput  = 1 << 20
+	maxJSONDepth  = 100
+	maxJSONIndent = 8
+)
+
+type jsonRequest struct {
+	S string `json:"s"`
+	// Op is one of "escape", "unescape", "minify" or "pretty".
+	Op string `json:"op" validate:"max=64,charset=printable"`
+	// Indent is the indentation of "pretty", two spaces by default.
+	Indent string `json:"indent"`
+}
+
+type jsonResponse struct {
+	V   string `json:"v"`
+	Err string `json:"err,omitempty"`
+}
+
+// JSON escapes s into a JSON string literal, quotes included, or unescapes
+// one, whose quotes are optional, so that double-encoded payloads can be
+// peeled one layer at a time. It also minifies and pretty-prints JSON
+// documents.
+func (stringService) JSON(ctx context.Context, s, op, indent string) (string, error) {
+	if len(s) > maxJSONInput {
+		return "", ErrTooLarge
+	}
+	switch op {
+	case "escape":
+		var b bytes.Buffer
+		enc := json.NewEncoder(&b)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(s); err != nil {
+			return "", err
+		}
+		return strings.TrimSuffix(b.String(), "\n"), nil
+	case "unescape":
+		if !strings.HasPrefix(s, `"`) {
+			s = `"` + s + `"`
+		}
+		var out string
+		if err := json.Unmarshal([]byte(s), &out); err != nil {
+			return "", malformedInputError{"JSON string", err}
+		}
+		return out, nil
+	case "minify", "pretty":
+		if err := checkJSONDepth(s); err != nil {
+			return "", err
+		}
+		var b bytes.Buffer
+		var err error
+		if op == "minify" {
+			err = json.Compact(&b, []byte(s))
+		} else {
+			if indent == "" {
+				indent = "  "
+			}
+			if len(indent) > maxJSONIndent || strings.Trim(indent, " \t") != "" {
+				return "", errorf(CodeOutOfRange, "Indent must be at most %d spaces or tabs", maxJSONIndent)
+			}
+			err = json.Indent(&b, []byte(s), "", indent)
+		}
+		if err != nil {
+			return "", malformedInputError{"JSON", err}
+		}
+		return b.String(), nil
+	}
+	return "", errorf(CodeUnknownOption, "Unknown JSON operation %q", op)
+}
+
+// checkJSONDepth rejects documents nesting more than maxJSONDepth objects
+// and arrays. It only looks at brackets out of strings, and leaves syntax
+// errors to the parser.
+func checkJSONDepth(s string) error {
+	depth, inString, escaped := 0, false, false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case escaped:
+			escaped = false
+		case inString && c == '\\':
+			escaped = true
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '{' || c == '[':
+			if depth++; depth > maxJSONDepth {
+				return errorf(CodeLimitExceeded, "JSON nested deeper than %d levels", maxJSONDepth)
+			}
+		case c == '}' || c == ']':
+			depth--
+		}
+	}
+	return nil
+}
+
+func makeJSONEndpoint(svc IStringService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(jsonRequest)
+		v, err := svc.JSON(ctx, req.S, req.Op, req.Indent)
+		if err != nil {
+			return jsonResponse{"", err.Error()}, nil
+		}
+		return jsonResponse{v, ""}, nil
+	}
+}
+
+func decodeJSONRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request jsonRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+//
+// ─── LOGGING ────────────────────────────────────────────────────────────────────
+//
+
+func (mw loggingMiddleware) JSON(ctx context.Context, s, op, indent string) (output string, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "json",
+			"tenant", tenantFrom(ctx),
+			"input_size", len(s),
+			"op", op,
+			"output_size", len(output),
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	output, err = mw.next.JSON(ctx, s, op, indent)
+	return
+}
+
+//
+// ─── INSTRUMENTATION ────────────────────────────────────────────────────────────
+//
+
+func (mw instrumentingMiddleware) JSON(ctx context.Context, s, op, indent string) (output string, err error) {
+	done := mw.begin(ctx, "json", s)
+	defer func() { done(len(output), err) }()
+
+	output, err = mw.next.JSON(ctx, s, op, indent)
+	return
+}
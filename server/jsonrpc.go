@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-kit/kit/transport/http/jsonrpc"
+)
+
+//
+// ──────────────────────────────────────────────────────── I ──────────
+//   :::::: J S O N - R P C : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────────────
+//
+
+// jsonrpcOperationError is the code of errors reported by the operations
+// themselves, like the "err" field of the HTTP responses. JSON-RPC reserves
+// -32000 to -32099 for such implementation-defined errors.
+const jsonrpcOperationError = -32000
+
+// jsonrpcErrorData is the data of the JSON-RPC errors, with the code of
+// the catalog.
+type jsonrpcErrorData struct {
+	Code ErrorCode `json:"code"`
+}
+
+// newJSONRPCServer serves every operation over JSON-RPC 2.0, the method
+// being the operation name and the params its JSON request object. The
+// result is the response object without its "err" field; operations that
+// fail are answered with a -32000 error instead.
+func newJSONRPCServer(ops map[string]operation) *jsonrpc.Server {
+	codecs := make(jsonrpc.EndpointCodecMap)
+	for name, op := range ops {
+		codecs[name] = jsonrpc.EndpointCodec{
+			Endpoint: op.Endpoint,
+			Decode:   makeJSONRPCRequestDecoder(op),
+			Encode:   encodeJSONRPCResponse,
+		}
+	}
+	return jsonrpc.NewServer(
+		codecs,
+		jsonrpc.ServerErrorEncoder(encodeJSONRPCError),
+		jsonrpc.ServerBefore(recordErrorCodes),
+		jsonrpc.ServerBeforeCodec(recordJSONRPCRequestID),
+	)
+}
+
+func makeJSONRPCRequestDecoder(op operation) jsonrpc.DecodeRequestFunc {
+	return func(_ context.Context, params json.RawMessage) (interface{}, error) {
+		if len(params) == 0 {
+			params = json.RawMessage("{}")
+		}
+		req, err := op.decode(params)
+		if err != nil {
+			return nil, jsonrpc.Error{Code: jsonrpc.InvalidParamsError, Message: err.Error(), Data: jsonrpcErrorData{CodeMalformedInput}}
+		}
+		return req, nil
+	}
+}
+
+func encodeJSONRPCResponse(ctx context.Context, response interface{}) (json.RawMessage, error) {
+	data, err := json.Marshal(response)
+	if err != nil {
+		return nil, err
+	}
+	var result operationResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	if result.Err != "" {
+		return nil, jsonrpc.Error{Code: jsonrpcOperationError, Message: localizedFailure(ctx, result.Err), Data: jsonrpcErrorData{recordedErrorCode(ctx)}}
+	}
+	return data, nil
+}
+
+type jsonrpcRequestIDKey struct{}
+
+// recordJSONRPCRequestID keeps the ID of the request, for
+// encodeJSONRPCError to answer it.
+func recordJSONRPCRequestID(ctx context.Context, _ *http.Request, req jsonrpc.Request) context.Context {
+	return context.WithValue(ctx, jsonrpcRequestIDKey{}, req.ID)
+}
+
+// encodeJSONRPCError gives errors that are HTTP 400s or 422s elsewhere the
+// invalid params code, and every error its code of the catalog in its
+// data. Unlike jsonrpc.DefaultErrorEncoder, it keeps the data of errors.
+func encodeJSONRPCError(ctx context.Context, err error, w http.ResponseWriter) {
+	rpcErr := jsonrpc.Error{Code: jsonrpc.InternalError, Message: localeFrom(ctx).Error(err)}
+	var coder jsonrpc.ErrorCoder
+	switch {
+	case errors.As(err, &rpcErr):
+	case errors.As(err, &coder):
+		rpcErr.Code = coder.ErrorCode()
+	case codeFrom(err) == http.StatusBadRequest, codeFrom(err) == http.StatusUnprocessableEntity:
+		rpcErr.Code = jsonrpc.InvalidParamsError
+	}
+	if rpcErr.Data == nil {
+		code := errorCodeOf(err)
+		switch rpcErr.Code {
+		case jsonrpc.ParseError, jsonrpc.InvalidRequestError:
+			code = CodeMalformedInput
+		case jsonrpc.MethodNotFoundError:
+			code = CodeUnknownOperation
+		}
+		rpcErr.Data = jsonrpcErrorData{code}
+	}
+	id, _ := ctx.Value(jsonrpcRequestIDKey{}).(*jsonrpc.RequestID)
+	w.Header().Set("Content-Type", jsonrpc.ContentType)
+	json.NewEncoder(w).Encode(jsonrpc.Response{JSONRPC: jsonrpc.Version, Error: &rpcErr, ID: id})
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/segmentio/kafka-go"
+)
+
+//
+// ────────────────────────────────────────────────── I ──────────
+//   :::::: K A F K A : :  :   :    :     :        :          :
+// ────────────────────────────────────────────────────────────
+//
+
+// Defaults of the "kafka" config section.
+const (
+	defaultKafkaGroupID      = "stringsvc"
+	defaultKafkaBatchSize    = 100
+	defaultKafkaBatchTimeout = time.Second
+)
+
+// kafkaMetrics are updated after every batch.
+type kafkaMetrics struct {
+	Lag      metrics.Gauge
+	Messages metrics.Counter
+}
+
+// kafkaProcessor applies one operation to every message of the input
+// topic and produces the results to the output topic.
+type kafkaProcessor struct {
+	cfg     kafkaConfig
+	op      operation
+	reader  *kafka.Reader
+	writer  *kafka.Writer
+	metrics kafkaMetrics
+}
+
+func newKafkaProcessor(cfg kafkaConfig, ops map[string]operation, m kafkaMetrics) (*kafkaProcessor, error) {
+	if cfg.InputTopic == "" || cfg.OutputTopic == "" {
+		return nil, errors.New("input_topic and output_topic are required")
+	}
+	op, ok := ops[cfg.Op]
+	if !ok {
+		return nil, fmt.Errorf("Unknown operation %q", cfg.Op)
+	}
+	if cfg.GroupID == "" {
+		cfg.GroupID = defaultKafkaGroupID
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultKafkaBatchSize
+	}
+
+	return &kafkaProcessor{
+		cfg: cfg,
+		op:  op,
+		reader: kafka.NewReader(kafka.ReaderConfig{
+			Brokers: cfg.Brokers,
+			GroupID: cfg.GroupID,
+			Topic:   cfg.InputTopic,
+		}),
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(cfg.Brokers...),
+			Topic:        cfg.OutputTopic,
+			Balancer:     &kafka.Hash{},
+			BatchSize:    cfg.BatchSize,
+			RequiredAcks: kafka.RequireAll,
+		},
+		metrics: m,
+	}, nil
+}
+
+// Run processes batches until ctx is done. Each input message value is the
+// "s" of a request built from the configured options; the output message
+// has the same key and the JSON response as value. Offsets are committed
+// once the results of a batch are written, so every message is processed
+// at least once.
+func (p *kafkaProcessor) Run(ctx context.Context) error {
+	defer p.reader.Close()
+	defer p.writer.Close()
+	for {
+		batch, err := p.fetchBatch(ctx)
+		if err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			continue
+		}
+
+		out := make([]kafka.Message, len(batch))
+		for i, m := range batch {
+			result := runBatchItem(ctx, p.op, string(m.Value), p.cfg.Options)
+			value, err := json.Marshal(result)
+			if err != nil {
+				return err
+			}
+			out[i] = kafka.Message{Key: m.Key, Value: value, Headers: m.Headers}
+			p.metrics.Messages.With("error", fmt.Sprint(result.Err != "")).Add(1)
+		}
+		if err := p.writer.WriteMessages(ctx, out...); err != nil {
+			return err
+		}
+		if err := p.reader.CommitMessages(ctx, batch...); err != nil {
+			return err
+		}
+		p.metrics.Lag.Set(float64(p.reader.Stats().Lag))
+	}
+}
+
+// fetchBatch returns up to BatchSize messages, fewer when no new message
+// arrives within the batch timeout.
+func (p *kafkaProcessor) fetchBatch(ctx context.Context) ([]kafka.Message, error) {
+	timeout := defaultKafkaBatchTimeout
+	if p.cfg.BatchTimeoutMS > 0 {
+		timeout = time.Duration(p.cfg.BatchTimeoutMS) * time.Millisecond
+	}
+	fetchCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var batch []kafka.Message
+	for len(batch) < p.cfg.BatchSize {
+		m, err := p.reader.FetchMessage(fetchCtx)
+		if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		batch = append(batch, m)
+	}
+	return batch, nil
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/pemistahl/lingua-go"
+)
+
+//
+// ────────────────────────────────────────────────────────────────────── I ──────────
+//   :::::: D E T E C T   L A N G U A G E : :  :   :    :     :        :          :
+// ────────────────────────────────────────────────────────────────────────────────
+//
+
+// defaultLanguageResults is the number of candidates returned when the
+// request does not ask for a specific number.
+const defaultLanguageResults = 3
+
+// newLanguageDetector builds a detector for every language lingua knows,
+// with all models loaded up front so the first requests are not slowed down
+// by lazy loading.
+func newLanguageDetector() lingua.LanguageDetector {
+	return lingua.NewLanguageDetectorBuilder().
+		FromAllLanguages().
+		WithPreloadedLanguageModels().
+		Build()
+}
+
+// LanguageConfidence is a language of a text, from DetectLanguage, with
+// the confidence of the detection from 0 to 1.
+type LanguageConfidence struct {
+	Code       string  `json:"code"`
+	Language   string  `json:"language"`
+	Confidence float64 `json:"confidence"`
+}
+
+type detectLanguageRequest struct {
+	S          string `json:"s" validate:"notblank"`
+	MaxResults int    `json:"max_results" validate:"min=0"`
+}
+
+type detectLanguageResponse struct {
+	V   []LanguageConfidence `json:"v"`
+	Err string               `json:"err,omitempty"`
+}
+
+// DetectLanguage returns the most likely languages of s, ordered by
+// decreasing confidence. Languages with zero confidence are left out.
+func (svc stringService) DetectLanguage(ctx context.Context, s string, maxResults int) ([]LanguageConfidence, error) {
+	if svc.languageDetector == nil {
+		return nil, errorf(CodeNotConfigured, "Language detection is not available")
+	}
+	if maxResults <= 0 {
+		maxResults = defaultLanguageResults
+	}
+
+	var out []LanguageConfidence
+	for _, c := range svc.languageDetector.ComputeLanguageConfidenceValues(s) {
+		if len(out) == maxResults || c.Value() == 0 {
+			break
+		}
+		out = append(out, LanguageConfidence{
+			Code:       strings.ToLower(c.Language().IsoCode639_1().String()),
+			Language:   c.Language().String(),
+			Confidence: c.Value(),
+		})
+	}
+	return out, nil
+}
+
+func makeDetectLanguageEndpoint(svc IStringService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(detectLanguageRequest)
+		v, err := svc.DetectLanguage(ctx, req.S, req.MaxResults)
+		if err != nil {
+			return detectLanguageResponse{nil, err.Error()}, nil
+		}
+		return detectLanguageResponse{v, ""}, nil
+	}
+}
+
+func decodeDetectLanguageRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request detectLanguageRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+//
+// ─── LOGGING ────────────────────────────────────────────────────────────────────
+//
+
+func (mw loggingMiddleware) DetectLanguage(ctx context.Context, s string, maxResults int) (output []LanguageConfidence, err error) {
+	defer func(begin time.Time) {
+		var top string
+		if len(output) > 0 {
+			top = output[0].Code
+		}
+		mw.logger.Log(
+			"method", "detectlanguage",
+			"tenant", tenantFrom(ctx),
+			"input", s,
+			"output", top,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	output, err = mw.next.DetectLanguage(ctx, s, maxResults)
+	return
+}
+
+//
+// ─── INSTRUMENTATION ────────────────────────────────────────────────────────────
+//
+
+func (mw instrumentingMiddleware) DetectLanguage(ctx context.Context, s string, maxResults int) (output []LanguageConfidence, err error) {
+	done := mw.begin(ctx, "detectlanguage", s)
+	defer func() { done(-1, err) }()
+
+	output, err = mw.next.DetectLanguage(ctx, s, maxResults)
+	return
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"net/http"
+	"time"
+)
+
+//
+// ──────────────────────────────────────────────────── I ──────────
+//   :::::: L I M I T S : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────────
+//
+
+// Defaults of the limits of the "http" config section.
+const (
+	defaultMaxBodyBytes      = 8 << 20
+	defaultReadHeaderTimeout = 10 * time.Second
+	defaultReadTimeout       = 30 * time.Second
+	defaultWriteTimeout      = 60 * time.Second
+	defaultIdleTimeout       = 120 * time.Second
+)
+
+// defaultRouteLimits lift the limits of the routes that stream or hold
+// connections open, which a fixed bound would cut short. The websocket
+// sessions of /ws manage their own deadlines.
+var defaultRouteLimits = map[string]routeLimits{
+	"/stream/uppercase":  {MaxBodyBytes: -1, ReadTimeoutMS: -1, WriteTimeoutMS: -1},
+	"/stream/count":      {MaxBodyBytes: -1, ReadTimeoutMS: -1, WriteTimeoutMS: -1},
+	"/v2/stream/count":   {MaxBodyBytes: -1, ReadTimeoutMS: -1, WriteTimeoutMS: -1},
+	"/stream/csv/parse":  {MaxBodyBytes: -1, ReadTimeoutMS: -1, WriteTimeoutMS: -1},
+	"/stream/csv/format": {MaxBodyBytes: -1, ReadTimeoutMS: -1, WriteTimeoutMS: -1},
+	"/ws":                {ReadTimeoutMS: -1, WriteTimeoutMS: -1},
+}
+
+// newHTTPServer returns the server of handler, with the timeouts of cfg.
+func newHTTPServer(addr string, handler http.Handler, cfg httpConfig) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: defaultReadHeaderTimeout,
+		ReadTimeout:       millisecondsOr(cfg.ReadTimeoutMS, defaultReadTimeout),
+		WriteTimeout:      millisecondsOr(cfg.WriteTimeoutMS, defaultWriteTimeout),
+		IdleTimeout:       millisecondsOr(cfg.IdleTimeoutMS, defaultIdleTimeout),
+	}
+}
+
+// millisecondsOr returns ms milliseconds, def when ms is 0, and no timeout
+// at all when ms is negative.
+func millisecondsOr(ms int, def time.Duration) time.Duration {
+	switch {
+	case ms < 0:
+		return 0
+	case ms == 0:
+		return def
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// withLimits bounds the size of request bodies, once decompressed, and of
+// uploaded files, and applies the timeouts overridden for the route of
+// each request. Reading
+// past the limit fails with an *http.MaxBytesError, answered with a 413,
+// and reading past the read deadline with a 408.
+func withLimits(next http.Handler, cfg httpConfig) http.Handler {
+	routes := make(map[string]routeLimits, len(defaultRouteLimits)+len(cfg.Routes))
+	for route, l := range defaultRouteLimits {
+		routes[route] = l
+	}
+	for route, l := range cfg.Routes {
+		routes[route] = routes[route].merge(l)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l := routes[r.URL.Path]
+		maxBodyBytes := l.MaxBodyBytes
+		if maxBodyBytes == 0 {
+			maxBodyBytes = cfg.MaxBodyBytes
+		}
+		if maxBodyBytes == 0 {
+			maxBodyBytes = defaultMaxBodyBytes
+		}
+		if maxBodyBytes > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+		}
+		maxUploadBytes := l.MaxUploadBytes
+		if maxUploadBytes == 0 {
+			maxUploadBytes = cfg.MaxUploadBytes
+		}
+		if maxUploadBytes > 0 {
+			r = r.WithContext(withUploadLimit(r.Context(), maxUploadBytes))
+		}
+
+		rc := http.NewResponseController(w)
+		if l.ReadTimeoutMS != 0 {
+			rc.SetReadDeadline(deadline(l.ReadTimeoutMS))
+		}
+		if l.WriteTimeoutMS != 0 {
+			rc.SetWriteDeadline(deadline(l.WriteTimeoutMS))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// deadline returns the time ms milliseconds from now, or no deadline when
+// ms is negative.
+func deadline(ms int) time.Time {
+	if ms < 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(time.Duration(ms) * time.Millisecond)
+}
+
+// merge returns l with the non-zero limits of override.
+func (l routeLimits) merge(override routeLimits) routeLimits {
+	if override.MaxBodyBytes != 0 {
+		l.MaxBodyBytes = override.MaxBodyBytes
+	}
+	if override.MaxUploadBytes != 0 {
+		l.MaxUploadBytes = override.MaxUploadBytes
+	}
+	if override.ReadTimeoutMS != 0 {
+		l.ReadTimeoutMS = override.ReadTimeoutMS
+	}
+	if override.WriteTimeoutMS != 0 {
+		l.WriteTimeoutMS = override.WriteTimeoutMS
+	}
+	return l
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+//
+// ──────────────────────────────────────────────────── I ──────────
+//   :::::: L I S T E N : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────────
+//
+
+// HTTP and the binary transport can listen on a Unix domain socket rather
+// than on TCP, for a proxy running beside the service, as a sidecar in the
+// same pod, which then needs no port and skips the TCP stack.
+
+// defaultUnixSocketMode lets the owner and the group of the service use its
+// sockets.
+const defaultUnixSocketMode fs.FileMode = 0o660
+
+// unixSocketMode parses the octal permissions of cfg, the default ones
+// when empty.
+func unixSocketMode(cfg unixSocketConfig) (fs.FileMode, error) {
+	if cfg.Mode == "" {
+		return defaultUnixSocketMode, nil
+	}
+	mode, err := strconv.ParseUint(cfg.Mode, 8, 32)
+	if err != nil || mode&^uint64(fs.ModePerm) != 0 {
+		return 0, fmt.Errorf("invalid mode %q of unix socket %s", cfg.Mode, cfg.Path)
+	}
+	return fs.FileMode(mode), nil
+}
+
+// listenUnix listens on the Unix domain socket at path with mode. The socket
+// file left by an instance that did not stop cleanly is removed, but not
+// the one of an instance still serving on it. The file is removed when the
+// listener is closed.
+//
+// net.Listen creates the socket file with the permissions left by the
+// umask, and changing them afterwards would let anyone connect in between.
+// The socket is rather created in a directory only the service can enter,
+// given its mode there, and then moved to path.
+func listenUnix(path string, mode fs.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode().Type() != fs.ModeSocket {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	// MkdirTemp creates the directory with mode 0700. Its name is short,
+	// as the path of a socket is.
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".s")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	tmp := filepath.Join(dir, "s")
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmp, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	// The file is moved, so the listener must not remove it by its old name.
+	l.SetUnlinkOnClose(false)
+	if err := os.Chmod(tmp, mode); err != nil {
+		l.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return &unixListener{UnixListener: l, path: path}, nil
+}
+
+// unixListener is a listener on a Unix domain socket moved to path, which
+// it reports as its address and removes when closed.
+type unixListener struct {
+	*net.UnixListener
+	path string
+}
+
+func (l *unixListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: l.path, Net: "unix"}
+}
+
+// Close removes the file only the first time, when the listener closes
+// without error, not to remove the socket of a later instance.
+func (l *unixListener) Close() error {
+	if err := l.UnixListener.Close(); err != nil {
+		return err
+	}
+	if err := os.Remove(l.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// listen listens on the Unix domain socket of cfg when it has a path, and
+// on the TCP address addr otherwise.
+func listen(addr string, cfg unixSocketConfig) (net.Listener, error) {
+	if cfg.Path == "" {
+		return net.Listen("tcp", addr)
+	}
+	mode, err := unixSocketMode(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return listenUnix(cfg.Path, mode)
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListenUnix(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    fs.FileMode
+		setup   func(t *testing.T, path string)
+		wantErr bool
+	}{
+		{name: "owner only", mode: 0o600},
+		{name: "owner and group", mode: 0o660},
+		{name: "everyone", mode: 0o666},
+		{
+			name: "stale socket",
+			mode: 0o660,
+			setup: func(t *testing.T, path string) {
+				l, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+				if err != nil {
+					t.Fatal(err)
+				}
+				l.SetUnlinkOnClose(false)
+				l.Close()
+			},
+		},
+		{
+			name: "socket in use",
+			mode: 0o660,
+			setup: func(t *testing.T, path string) {
+				l, err := net.Listen("unix", path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() { l.Close() })
+			},
+			wantErr: true,
+		},
+		{
+			name: "not a socket",
+			mode: 0o660,
+			setup: func(t *testing.T, path string) {
+				if err := os.WriteFile(path, nil, 0o600); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "svc.sock")
+			if tt.setup != nil {
+				tt.setup(t, path)
+			}
+
+			l, err := listenUnix(path, tt.mode)
+			if tt.wantErr {
+				if err == nil {
+					l.Close()
+					t.Fatal("no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			info, err := os.Lstat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Type() != fs.ModeSocket || info.Mode().Perm() != tt.mode {
+				t.Errorf("mode %v, want socket %v", info.Mode(), tt.mode)
+			}
+			if got := l.Addr().String(); got != path {
+				t.Errorf("address %s, want %s", got, path)
+			}
+			if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+				t.Errorf("%d files left in the directory, want the socket only", len(entries))
+			}
+
+			go func() {
+				if c, err := l.Accept(); err == nil {
+					c.Close()
+				}
+			}()
+			conn, err := net.Dial("unix", path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn.Close()
+
+			if err := l.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Lstat(path); !os.IsNotExist(err) {
+				t.Errorf("socket left after Close: %v", err)
+			}
+		})
+	}
+}
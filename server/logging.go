@@ -0,0 +1,244 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+//
+// ────────────────────────────────────────────────────── I ──────────
+//   :::::: L O G G I N G : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────────────
+//
+
+// logLevels are the levels of log records, by increasing severity. Records
+// logged without a level are at "info".
+var logLevels = []string{"debug", "info", "warn", "error"}
+
+// ErrUnknownLogLevel is returned for levels not in logLevels, with a 400.
+var ErrUnknownLogLevel = errors.New("Unknown log level")
+
+// logLevel is the minimum level of the records that are logged. It can be
+// changed while logging.
+type logLevel struct {
+	min atomic.Int32
+}
+
+func severity(name string) (int, bool) {
+	for i, l := range logLevels {
+		if l == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func (l *logLevel) Set(name string) error {
+	s, ok := severity(name)
+	if !ok {
+		return ErrUnknownLogLevel
+	}
+	l.min.Store(int32(s))
+	return nil
+}
+
+func (l *logLevel) String() string {
+	return logLevels[l.min.Load()]
+}
+
+// leveledLogger drops the records under the level of l.
+type leveledLogger struct {
+	next log.Logger
+	l    *logLevel
+}
+
+func (lg leveledLogger) Log(keyvals ...interface{}) error {
+	s, _ := severity("info")
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		if keyvals[i] == level.Key() {
+			if v, ok := keyvals[i+1].(level.Value); ok {
+				s, _ = severity(v.String())
+			}
+			break
+		}
+	}
+	if int32(s) < lg.l.min.Load() {
+		return nil
+	}
+	return lg.next.Log(keyvals...)
+}
+
+// newLogger returns a logger writing to w in the format of cfg, "logfmt" by
+// default or "json", and the level it filters records with.
+func newLogger(cfg logConfig, w io.Writer) (log.Logger, *logLevel, error) {
+	var logger log.Logger
+	switch cfg.Format {
+	case "", "logfmt":
+		logger = log.NewLogfmtLogger(log.NewSyncWriter(w))
+	case "json":
+		logger = log.NewJSONLogger(log.NewSyncWriter(w))
+	default:
+		return nil, nil, fmt.Errorf("unknown log format %q", cfg.Format)
+	}
+	l := new(logLevel)
+	name := cfg.Level
+	if name == "" {
+		name = "info"
+	}
+	if err := l.Set(name); err != nil {
+		return nil, nil, fmt.Errorf("%v %q", err, name)
+	}
+	logger = log.With(logger, "ts", log.DefaultTimestampUTC)
+	return leveledLogger{logger, l}, l, nil
+}
+
+// makeLogLevelHandler answers the current level of l on GET, and changes it
+// on PUT with a body like {"level":"debug"}.
+func makeLogLevelHandler(l *logLevel) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var req struct {
+				Level string `json:"level"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				encodeError(r.Context(), malformedInputError{"request", err}, w)
+				return
+			}
+			if err := l.Set(req.Level); err != nil {
+				encodeError(r.Context(), err, w)
+				return
+			}
+		default:
+			w.Header().Set("Allow", "GET, PUT")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		encodeResponse(r.Context(), w, map[string]string{"level": l.String()})
+	})
+}
+
+//
+// ─── REQUEST LOGS ───────────────────────────────────────────────────────────────
+//
+
+// Defaults of the request log settings of the "log" config section.
+const defaultLogTruncateLength = 32
+
+var defaultLogRedactFields = []string{"input", "output", "a", "b"}
+
+// requestLogger filters and redacts the records of loggingMiddleware, which
+// log the inputs and outputs of every request. Records with an error are
+// always logged, whatever the sampling and slow-request settings.
+type requestLogger struct {
+	next           log.Logger
+	redact         map[string]bool
+	redactMode     string
+	truncateLength int
+	sampleRates    map[string]float64
+	slowThreshold  time.Duration
+}
+
+func newRequestLogger(cfg logConfig, next log.Logger) (log.Logger, error) {
+	switch cfg.RedactMode {
+	case "", "none":
+		if len(cfg.SampleRates) == 0 && cfg.SlowThresholdMS <= 0 {
+			return next, nil
+		}
+	case "hash", "truncate", "omit":
+	default:
+		return nil, fmt.Errorf("unknown redact mode %q", cfg.RedactMode)
+	}
+	l := requestLogger{
+		next:           next,
+		redact:         make(map[string]bool),
+		redactMode:     cfg.RedactMode,
+		truncateLength: cfg.TruncateLength,
+		sampleRates:    cfg.SampleRates,
+		slowThreshold:  time.Duration(cfg.SlowThresholdMS) * time.Millisecond,
+	}
+	fields := cfg.RedactFields
+	if len(fields) == 0 {
+		fields = defaultLogRedactFields
+	}
+	for _, f := range fields {
+		l.redact[f] = true
+	}
+	if l.truncateLength <= 0 {
+		l.truncateLength = defaultLogTruncateLength
+	}
+	return l, nil
+}
+
+func (l requestLogger) Log(keyvals ...interface{}) error {
+	var (
+		method string
+		took   time.Duration
+		failed bool
+	)
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		switch keyvals[i] {
+		case "method":
+			method, _ = keyvals[i+1].(string)
+		case "took":
+			took, _ = keyvals[i+1].(time.Duration)
+		case "err":
+			failed = keyvals[i+1] != nil
+		}
+	}
+	if !failed {
+		if took < l.slowThreshold {
+			return nil
+		}
+		if r, ok := l.sampleRates[method]; ok && rand.Float64() >= r {
+			return nil
+		}
+	}
+
+	redacted := make([]interface{}, 0, len(keyvals))
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		k, v := keyvals[i], keyvals[i+1]
+		if name, ok := k.(string); ok && l.redact[name] {
+			if l.redactMode == "omit" {
+				continue
+			}
+			v = l.redactValue(v)
+		}
+		redacted = append(redacted, k, v)
+	}
+	return l.next.Log(redacted...)
+}
+
+// redactValue returns the hash of v, or its first characters followed by
+// its size.
+func (l requestLogger) redactValue(v interface{}) interface{} {
+	s, ok := v.(string)
+	if !ok {
+		s = fmt.Sprint(v)
+	}
+	switch l.redactMode {
+	case "hash":
+		sum := sha256.Sum256([]byte(s))
+		return "sha256:" + hex.EncodeToString(sum[:8])
+	case "truncate":
+		if utf8.RuneCountInString(s) <= l.truncateLength {
+			return s
+		}
+		runes := []rune(s)
+		return fmt.Sprintf("%s… (%d bytes)", string(runes[:l.truncateLength]), len(s))
+	}
+	return v
+}
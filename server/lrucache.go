@@ -0,0 +1,93 @@
+package server
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+)
+
+//
+// ────────────────────────────────────────────────────────── I ──────────
+//   :::::: L R U   C A C H E : :  :   :    :     :        :          :
+// ────────────────────────────────────────────────────────────────────
+//
+
+// lruStore is a CacheStore in memory, for deployments without Redis. It
+// evicts the least recently used entries beyond maxEntries entries or
+// maxBytes bytes of keys and values, a zero bound being no bound.
+type lruStore struct {
+	maxEntries int
+	maxBytes   int64
+	evictions  metrics.Counter
+
+	mu      sync.Mutex
+	entries *list.List // of *lruEntry, most recently used first
+	byKey   map[string]*list.Element
+	size    int64
+}
+
+type lruEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+func (e *lruEntry) size() int64 {
+	return int64(len(e.key) + len(e.value))
+}
+
+func newLRUStore(maxEntries int, maxBytes int64, evictions metrics.Counter) *lruStore {
+	return &lruStore{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		evictions:  evictions,
+		entries:    list.New(),
+		byKey:      make(map[string]*list.Element),
+	}
+}
+
+func (s *lruStore) Get(_ context.Context, key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.byKey[key]
+	if !ok {
+		return nil, false, nil
+	}
+	e := el.Value.(*lruEntry)
+	if time.Now().After(e.expires) {
+		s.remove(el)
+		return nil, false, nil
+	}
+	s.entries.MoveToFront(el)
+	return e.value, true, nil
+}
+
+func (s *lruStore) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	e := &lruEntry{key, value, time.Now().Add(ttl)}
+	// An entry larger than the whole cache would only evict everything.
+	if s.maxBytes > 0 && e.size() > s.maxBytes {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.byKey[key]; ok {
+		s.remove(el)
+	}
+	s.byKey[key] = s.entries.PushFront(e)
+	s.size += e.size()
+	for (s.maxEntries > 0 && s.entries.Len() > s.maxEntries) || (s.maxBytes > 0 && s.size > s.maxBytes) {
+		s.remove(s.entries.Back())
+		s.evictions.Add(1)
+	}
+	return nil
+}
+
+func (s *lruStore) remove(el *list.Element) {
+	e := s.entries.Remove(el).(*lruEntry)
+	delete(s.byKey, e.key)
+	s.size -= e.size()
+}
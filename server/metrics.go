@@ -0,0 +1,405 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
+	"github.com/go-kit/kit/metrics/multi"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+)
+
+//
+// ────────────────────────────────────────────────────── I ──────────
+//   :::::: M E T R I C S : :  :   :    :     :        :          :
+// ────────────────────────────────────────────────────────────────
+//
+
+// Defaults of the "metrics" config section.
+const (
+	defaultMetricsNamespace = "my_group"
+	defaultMetricsSubsystem = "string_service"
+	// nativeHistogramBucketFactor bounds the growth from one native bucket
+	// to the next, here to 10%.
+	nativeHistogramBucketFactor = 1.1
+)
+
+var (
+	defaultLatencyBuckets = []float64{
+		.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5,
+	}
+	defaultMetricsExporters = []string{"prometheus"}
+	// sizeBuckets range from 64B to 16MB, twice the default limit of request
+	// bodies.
+	sizeBuckets = stdprometheus.ExponentialBuckets(64, 4, 10)
+)
+
+// withDefaults returns cfg with the defaults of its empty settings.
+func (cfg metricsConfig) withDefaults() metricsConfig {
+	if cfg.Namespace == "" {
+		cfg.Namespace = defaultMetricsNamespace
+	}
+	if cfg.Subsystem == "" {
+		cfg.Subsystem = defaultMetricsSubsystem
+	}
+	if len(cfg.LatencyBuckets) == 0 {
+		cfg.LatencyBuckets = defaultLatencyBuckets
+	}
+	if len(cfg.Exporters) == 0 {
+		cfg.Exporters = defaultMetricsExporters
+	}
+	return cfg
+}
+
+// exports tells whether metrics are exported to exporter.
+func (cfg metricsConfig) exports(exporter string) bool {
+	return contains(cfg.withDefaults().Exporters, exporter)
+}
+
+// MetricsProvider makes the metrics of the service, whatever they are
+// exported to. Names are given without namespace and subsystem.
+type MetricsProvider interface {
+	NewCounter(name, help string, labels []string) metrics.Counter
+	NewGauge(name, help string, labels []string) metrics.Gauge
+	// NewLatencyHistogram returns a histogram of durations in seconds.
+	// Unlike summaries, histograms can be aggregated across instances.
+	NewLatencyHistogram(name, help string, labels []string) metrics.Histogram
+	// NewSizeHistogram returns a histogram of sizes in bytes.
+	NewSizeHistogram(name, help string, labels []string) metrics.Histogram
+	NewSummary(name, help string, labels []string) metrics.Histogram
+}
+
+// newMetricsProvider returns the provider of the exporters of cfg. With
+// several exporters, every metric is exported to each of them.
+func newMetricsProvider(cfg metricsConfig) (MetricsProvider, error) {
+	cfg = cfg.withDefaults()
+	var providers multiProvider
+	for _, exporter := range cfg.Exporters {
+		switch exporter {
+		case "prometheus":
+			providers = append(providers, prometheusProvider{cfg})
+		case "otlp":
+			p, err := newOTLPProvider(cfg)
+			if err != nil {
+				return nil, err
+			}
+			providers = append(providers, p)
+		default:
+			return nil, fmt.Errorf("unknown metrics exporter %q", exporter)
+		}
+	}
+	if len(providers) == 1 {
+		return providers[0], nil
+	}
+	return providers, nil
+}
+
+// multiProvider makes metrics recording to all of its providers.
+type multiProvider []MetricsProvider
+
+func (ps multiProvider) NewCounter(name, help string, labels []string) metrics.Counter {
+	counters := make([]metrics.Counter, len(ps))
+	for i, p := range ps {
+		counters[i] = p.NewCounter(name, help, labels)
+	}
+	return multi.NewCounter(counters...)
+}
+
+func (ps multiProvider) NewGauge(name, help string, labels []string) metrics.Gauge {
+	gauges := make([]metrics.Gauge, len(ps))
+	for i, p := range ps {
+		gauges[i] = p.NewGauge(name, help, labels)
+	}
+	return multi.NewGauge(gauges...)
+}
+
+func (ps multiProvider) NewLatencyHistogram(name, help string, labels []string) metrics.Histogram {
+	histograms := make([]metrics.Histogram, len(ps))
+	for i, p := range ps {
+		histograms[i] = p.NewLatencyHistogram(name, help, labels)
+	}
+	return multi.NewHistogram(histograms...)
+}
+
+func (ps multiProvider) NewSizeHistogram(name, help string, labels []string) metrics.Histogram {
+	histograms := make([]metrics.Histogram, len(ps))
+	for i, p := range ps {
+		histograms[i] = p.NewSizeHistogram(name, help, labels)
+	}
+	return multi.NewHistogram(histograms...)
+}
+
+func (ps multiProvider) NewSummary(name, help string, labels []string) metrics.Histogram {
+	summaries := make([]metrics.Histogram, len(ps))
+	for i, p := range ps {
+		summaries[i] = p.NewSummary(name, help, labels)
+	}
+	return multi.NewHistogram(summaries...)
+}
+
+// DiscardMetrics returns a MetricsProvider whose metrics record nothing,
+// for the servers embedded in tests: the Prometheus exporter registers its
+// metrics globally, once per process.
+func DiscardMetrics() MetricsProvider {
+	return discardProvider{}
+}
+
+type discardProvider struct{}
+
+func (discardProvider) NewCounter(string, string, []string) metrics.Counter {
+	return discard.NewCounter()
+}
+
+func (discardProvider) NewGauge(string, string, []string) metrics.Gauge {
+	return discard.NewGauge()
+}
+
+func (discardProvider) NewLatencyHistogram(string, string, []string) metrics.Histogram {
+	return discard.NewHistogram()
+}
+
+func (discardProvider) NewSizeHistogram(string, string, []string) metrics.Histogram {
+	return discard.NewHistogram()
+}
+
+func (discardProvider) NewSummary(string, string, []string) metrics.Histogram {
+	return discard.NewHistogram()
+}
+
+//
+// ─── PROMETHEUS ─────────────────────────────────────────────────────────────────
+//
+
+// prometheusProvider registers metrics with the default Prometheus
+// registry, scraped at /metrics.
+type prometheusProvider struct {
+	cfg metricsConfig
+}
+
+func (p prometheusProvider) NewCounter(name, help string, labels []string) metrics.Counter {
+	return kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: p.cfg.Namespace,
+		Subsystem: p.cfg.Subsystem,
+		Name:      name,
+		Help:      help,
+	}, labels)
+}
+
+func (p prometheusProvider) NewGauge(name, help string, labels []string) metrics.Gauge {
+	return kitprometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+		Namespace: p.cfg.Namespace,
+		Subsystem: p.cfg.Subsystem,
+		Name:      name,
+		Help:      help,
+	}, labels)
+}
+
+func (p prometheusProvider) NewLatencyHistogram(name, help string, labels []string) metrics.Histogram {
+	opts := stdprometheus.HistogramOpts{
+		Namespace: p.cfg.Namespace,
+		Subsystem: p.cfg.Subsystem,
+		Name:      name,
+		Help:      help,
+		Buckets:   p.cfg.LatencyBuckets,
+	}
+	if p.cfg.NativeHistograms {
+		opts.NativeHistogramBucketFactor = nativeHistogramBucketFactor
+	}
+	return kitprometheus.NewHistogramFrom(opts, labels)
+}
+
+func (p prometheusProvider) NewSizeHistogram(name, help string, labels []string) metrics.Histogram {
+	return kitprometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+		Namespace: p.cfg.Namespace,
+		Subsystem: p.cfg.Subsystem,
+		Name:      name,
+		Help:      help,
+		Buckets:   sizeBuckets,
+	}, labels)
+}
+
+func (p prometheusProvider) NewSummary(name, help string, labels []string) metrics.Histogram {
+	return kitprometheus.NewSummaryFrom(stdprometheus.SummaryOpts{
+		Namespace: p.cfg.Namespace,
+		Subsystem: p.cfg.Subsystem,
+		Name:      name,
+		Help:      help,
+	}, labels)
+}
+
+//
+// ─── OTLP ───────────────────────────────────────────────────────────────────────
+//
+
+// otlpProvider pushes metrics to an OpenTelemetry collector over OTLP/HTTP.
+// Metrics keep the names they have in Prometheus, so that dashboards work
+// with either. Summaries become histograms, OTLP having no quantiles.
+//
+// The OTEL_EXPORTER_OTLP_* and OTEL_RESOURCE_ATTRIBUTES environment
+// variables apply, like OTEL_SERVICE_NAME.
+type otlpProvider struct {
+	cfg   metricsConfig
+	meter metric.Meter
+}
+
+func newOTLPProvider(cfg metricsConfig) (otlpProvider, error) {
+	var opts []otlpmetrichttp.Option
+	if cfg.OTLP.Endpoint != "" {
+		opts = append(opts, otlpmetrichttp.WithEndpoint(cfg.OTLP.Endpoint))
+	}
+	if cfg.OTLP.Insecure {
+		opts = append(opts, otlpmetrichttp.WithInsecure())
+	}
+	if len(cfg.OTLP.Headers) > 0 {
+		opts = append(opts, otlpmetrichttp.WithHeaders(cfg.OTLP.Headers))
+	}
+	exporter, err := otlpmetrichttp.New(context.Background(), opts...)
+	if err != nil {
+		return otlpProvider{}, err
+	}
+	var readerOpts []sdkmetric.PeriodicReaderOption
+	if cfg.OTLP.IntervalSeconds > 0 {
+		readerOpts = append(readerOpts, sdkmetric.WithInterval(time.Duration(cfg.OTLP.IntervalSeconds)*time.Second))
+	}
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exporter, readerOpts...)))
+	return otlpProvider{cfg, mp.Meter("github.com/anhle128/gokit-stringsvc")}, nil
+}
+
+func (p otlpProvider) name(name string) string {
+	return p.cfg.Namespace + "_" + p.cfg.Subsystem + "_" + name
+}
+
+func (p otlpProvider) NewCounter(name, help string, labels []string) metrics.Counter {
+	c, err := p.meter.Float64Counter(p.name(name), metric.WithDescription(help))
+	if err != nil {
+		panic(err)
+	}
+	return otlpCounter{c: c}
+}
+
+func (p otlpProvider) NewGauge(name, help string, labels []string) metrics.Gauge {
+	g, err := p.meter.Float64Gauge(p.name(name), metric.WithDescription(help))
+	if err != nil {
+		panic(err)
+	}
+	return otlpGauge{values: &otlpGaugeValues{g: g, values: make(map[attribute.Distinct]float64)}}
+}
+
+func (p otlpProvider) NewLatencyHistogram(name, help string, labels []string) metrics.Histogram {
+	h, err := p.meter.Float64Histogram(p.name(name),
+		metric.WithDescription(help),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(p.cfg.LatencyBuckets...),
+	)
+	if err != nil {
+		panic(err)
+	}
+	return otlpHistogram{h: h}
+}
+
+func (p otlpProvider) NewSizeHistogram(name, help string, labels []string) metrics.Histogram {
+	h, err := p.meter.Float64Histogram(p.name(name),
+		metric.WithDescription(help),
+		metric.WithUnit("By"),
+		metric.WithExplicitBucketBoundaries(sizeBuckets...),
+	)
+	if err != nil {
+		panic(err)
+	}
+	return otlpHistogram{h: h}
+}
+
+func (p otlpProvider) NewSummary(name, help string, labels []string) metrics.Histogram {
+	h, err := p.meter.Float64Histogram(p.name(name), metric.WithDescription(help))
+	if err != nil {
+		panic(err)
+	}
+	return otlpHistogram{h: h}
+}
+
+// otlpAttributes turns label names and values, as given to With, into
+// attributes. A name without value gets "unknown", like in go-kit.
+func otlpAttributes(labelValues []string) attribute.Set {
+	kvs := make([]attribute.KeyValue, 0, (len(labelValues)+1)/2)
+	for i := 0; i < len(labelValues); i += 2 {
+		value := "unknown"
+		if i+1 < len(labelValues) {
+			value = labelValues[i+1]
+		}
+		kvs = append(kvs, attribute.String(labelValues[i], value))
+	}
+	return attribute.NewSet(kvs...)
+}
+
+// withLabels returns lvs followed by more, without sharing memory with lvs.
+func withLabels(lvs, more []string) []string {
+	return append(append(make([]string, 0, len(lvs)+len(more)), lvs...), more...)
+}
+
+type otlpCounter struct {
+	c   metric.Float64Counter
+	lvs []string
+}
+
+func (c otlpCounter) With(labelValues ...string) metrics.Counter {
+	return otlpCounter{c.c, withLabels(c.lvs, labelValues)}
+}
+
+func (c otlpCounter) Add(delta float64) {
+	c.c.Add(context.Background(), delta, metric.WithAttributeSet(otlpAttributes(c.lvs)))
+}
+
+type otlpHistogram struct {
+	h   metric.Float64Histogram
+	lvs []string
+}
+
+func (h otlpHistogram) With(labelValues ...string) metrics.Histogram {
+	return otlpHistogram{h.h, withLabels(h.lvs, labelValues)}
+}
+
+func (h otlpHistogram) Observe(value float64) {
+	h.h.Record(context.Background(), value, metric.WithAttributeSet(otlpAttributes(h.lvs)))
+}
+
+// otlpGauge keeps the last value of every attribute set, since OTLP gauges
+// can only be set, while go-kit ones can also be added to.
+type otlpGauge struct {
+	values *otlpGaugeValues
+	lvs    []string
+}
+
+type otlpGaugeValues struct {
+	g      metric.Float64Gauge
+	mu     sync.Mutex
+	values map[attribute.Distinct]float64
+}
+
+func (g otlpGauge) With(labelValues ...string) metrics.Gauge {
+	return otlpGauge{g.values, withLabels(g.lvs, labelValues)}
+}
+
+func (g otlpGauge) Set(value float64) {
+	g.update(func(float64) float64 { return value })
+}
+
+func (g otlpGauge) Add(delta float64) {
+	g.update(func(value float64) float64 { return value + delta })
+}
+
+func (g otlpGauge) update(f func(float64) float64) {
+	attrs := otlpAttributes(g.lvs)
+	g.values.mu.Lock()
+	defer g.values.mu.Unlock()
+	value := f(g.values.values[attrs.Equivalent()])
+	g.values.values[attrs.Equivalent()] = value
+	g.values.g.Record(context.Background(), value, metric.WithAttributeSet(attrs))
+}
@@ -0,0 +1,232 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+//
+// ──────────────────────────────────────────────────────── I ──────────
+//   :::::: M O D E R A T E : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────────────
+//
+
+// Severities of the wordlist terms.
+const (
+	severityNone = iota
+	severityMild
+	severityModerate
+	severitySevere
+)
+
+// profanity maps languages to their wordlist, each term having a severity.
+// The built-in lists are deliberately short; deployments extend them with
+// the "moderation" section of the config file.
+var profanity = map[string]map[string]int{
+	"en": {
+		"damn": severityMild, "hell": severityMild, "crap": severityMild,
+		"shit": severityModerate, "bastard": severityModerate, "bitch": severityModerate,
+		"fuck": severitySevere, "asshole": severitySevere, "motherfucker": severitySevere,
+	},
+	"es": {
+		"mierda": severityModerate, "joder": severityModerate, "cabron": severityModerate,
+		"puta": severitySevere, "gilipollas": severitySevere,
+	},
+	"vi": {
+		"vãi": severityMild, "đéo": severityModerate, "địt": severitySevere, "đụ": severitySevere,
+	},
+}
+
+// profanitySuffixes are the endings under which a term is still matched, so
+// that "fucking" or "bastards" are caught but "hello" is not.
+var profanitySuffixes = []string{"", "s", "es", "ed", "er", "ers", "ing", "in", "y"}
+
+// leetspeak maps the substitutions commonly used to dodge filters back to
+// the letters they stand for.
+var leetspeak = strings.NewReplacer(
+	"4", "a", "@", "a", "3", "e", "1", "i", "0", "o", "5", "s", "$", "s", "7", "t",
+)
+
+// RegisterProfanity adds term to the wordlist of language, replacing the
+// severity of an existing term. It is meant to be called before the server
+// starts.
+func RegisterProfanity(language, term string, severity int) {
+	if profanity[language] == nil {
+		profanity[language] = make(map[string]int)
+	}
+	profanity[language][strings.ToLower(term)] = severity
+}
+
+// ModerationResult is a text censored by Moderate.
+type ModerationResult struct {
+	Censored string `json:"censored"`
+	// Severity is the highest severity matched, 0 when the text is clean.
+	Severity int `json:"severity"`
+	// Matches lists the wordlist terms found, without duplicates.
+	Matches []string `json:"matches"`
+}
+
+type moderateRequest struct {
+	S string `json:"s"`
+	// Languages restricts the wordlists used. All of them by default.
+	Languages []string `json:"languages" validate:"max=64"`
+	Mask      string   `json:"mask" validate:"max=16"`
+}
+
+type moderateResponse struct {
+	V   ModerationResult `json:"v"`
+	Err string           `json:"err,omitempty"`
+}
+
+// Moderate looks for wordlist terms in every word of s, after undoing
+// leetspeak and collapsing repeated letters on both sides, so "f4aaack"
+// style spellings match. Matched words keep their first letter and have
+// the rest replaced with mask ("*" by default).
+func (stringService) Moderate(ctx context.Context, s string, languages []string, mask string) (ModerationResult, error) {
+	if mask == "" {
+		mask = "*"
+	}
+	if len(languages) == 0 {
+		for lang := range profanity {
+			languages = append(languages, lang)
+		}
+	}
+	terms := make(map[string]string)
+	severities := make(map[string]int)
+	for _, lang := range languages {
+		list, ok := profanity[lang]
+		if !ok {
+			return ModerationResult{}, errorf(CodeUnknownOption, "Unknown wordlist language %q", lang)
+		}
+		for term, severity := range list {
+			key := normalizeProfanity(term)
+			if severity >= severities[key] {
+				terms[key], severities[key] = term, severity
+			}
+		}
+	}
+
+	result := ModerationResult{Matches: []string{}}
+	seen := make(map[string]bool)
+	var b strings.Builder
+	runes := []rune(s)
+	for i := 0; i < len(runes); {
+		if !isModerationRune(runes[i]) {
+			b.WriteRune(runes[i])
+			i++
+			continue
+		}
+		j := i
+		for j < len(runes) && isModerationRune(runes[j]) {
+			j++
+		}
+		word := runes[i:j]
+		i = j
+
+		key, ok := matchProfanity(normalizeProfanity(string(word)), terms)
+		if !ok {
+			b.WriteString(string(word))
+			continue
+		}
+		b.WriteRune(word[0])
+		b.WriteString(strings.Repeat(mask, len(word)-1))
+		if severities[key] > result.Severity {
+			result.Severity = severities[key]
+		}
+		if !seen[key] {
+			seen[key] = true
+			result.Matches = append(result.Matches, terms[key])
+		}
+	}
+	sort.Strings(result.Matches)
+	result.Censored = b.String()
+	return result, nil
+}
+
+func matchProfanity(word string, terms map[string]string) (string, bool) {
+	for _, suffix := range profanitySuffixes {
+		stem := strings.TrimSuffix(word, suffix)
+		if len(stem) == len(word)-len(suffix) {
+			if _, ok := terms[stem]; ok {
+				return stem, true
+			}
+		}
+	}
+	return "", false
+}
+
+// normalizeProfanity lower-cases s, undoes leetspeak and collapses runs of
+// the same letter.
+func normalizeProfanity(s string) string {
+	s = leetspeak.Replace(strings.ToLower(s))
+	var b strings.Builder
+	var prev rune
+	for _, r := range s {
+		if r != prev {
+			b.WriteRune(r)
+		}
+		prev = r
+	}
+	return b.String()
+}
+
+func isModerationRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r) || r == '@' || r == '$'
+}
+
+func makeModerateEndpoint(svc IStringService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(moderateRequest)
+		v, err := svc.Moderate(ctx, req.S, req.Languages, req.Mask)
+		if err != nil {
+			return moderateResponse{v, err.Error()}, nil
+		}
+		return moderateResponse{v, ""}, nil
+	}
+}
+
+func decodeModerateRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request moderateRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+//
+// ─── LOGGING ────────────────────────────────────────────────────────────────────
+//
+
+func (mw loggingMiddleware) Moderate(ctx context.Context, s string, languages []string, mask string) (output ModerationResult, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "moderate",
+			"tenant", tenantFrom(ctx),
+			"input_size", len(s),
+			"severity", output.Severity,
+			"matches", strings.Join(output.Matches, ","),
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	output, err = mw.next.Moderate(ctx, s, languages, mask)
+	return
+}
+
+//
+// ─── INSTRUMENTATION ────────────────────────────────────────────────────────────
+//
+
+func (mw instrumentingMiddleware) Moderate(ctx context.Context, s string, languages []string, mask string) (output ModerationResult, err error) {
+	done := mw.begin(ctx, "moderate", s)
+	defer func() { done(-1, err) }()
+
+	output, err = mw.next.Moderate(ctx, s, languages, mask)
+	return
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"context"
+
+	"github.com/go-kit/kit/log"
+	natstransport "github.com/go-kit/kit/transport/nats"
+	"github.com/nats-io/nats.go"
+)
+
+//
+// ──────────────────────────────────────────────── I ──────────
+//   :::::: N A T S : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────
+//
+
+// Defaults of the "nats" config section.
+const (
+	defaultNATSSubjectPrefix = "stringsvc"
+	defaultNATSQueue         = "stringsvc"
+)
+
+// serveNATS subscribes every operation to its subject, the operation name
+// behind the configured prefix, so a request for /uppercase is published
+// on "stringsvc.uppercase". Requests and replies have the same JSON bodies
+// as over HTTP. All instances join the same queue group, so each request is
+// handled once.
+func serveNATS(cfg natsConfig, ops map[string]operation, logger log.Logger) (*nats.Conn, error) {
+	prefix, queue := cfg.SubjectPrefix, cfg.Queue
+	if prefix == "" {
+		prefix = defaultNATSSubjectPrefix
+	}
+	if queue == "" {
+		queue = defaultNATSQueue
+	}
+
+	nc, err := nats.Connect(cfg.URL, nats.Name("stringsvc"))
+	if err != nil {
+		return nil, err
+	}
+	for name, op := range ops {
+		sub := natstransport.NewSubscriber(
+			op.Endpoint,
+			makeNATSRequestDecoder(op),
+			natstransport.EncodeJSONResponse,
+			natstransport.SubscriberErrorLogger(log.With(logger, "transport", "nats", "op", name)),
+		)
+		if _, err := nc.QueueSubscribe(prefix+"."+name, queue, sub.ServeMsg(nc)); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	return nc, nil
+}
+
+// makeNATSRequestDecoder decodes JSON message bodies into the request type
+// of op.
+func makeNATSRequestDecoder(op operation) natstransport.DecodeRequestFunc {
+	return func(_ context.Context, msg *nats.Msg) (interface{}, error) {
+		return op.decode(msg.Data)
+	}
+}
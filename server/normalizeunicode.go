@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"golang.org/x/text/unicode/norm"
+)
+
+//
+// ────────────────────────────────────────────────────────────────────────── I ──────────
+//   :::::: N O R M A L I Z E   U N I C O D E : :  :   :    :     :        :          :
+// ────────────────────────────────────────────────────────────────────────────────────
+//
+
+// normalizationForms are the Unicode normalization forms, by name.
+var normalizationForms = map[string]norm.Form{
+	"NFC":  norm.NFC,
+	"NFD":  norm.NFD,
+	"NFKC": norm.NFKC,
+	"NFKD": norm.NFKD,
+}
+
+type normalizeUnicodeRequest struct {
+	S string `json:"s"`
+	// Form is "NFC" (the default), "NFD", "NFKC" or "NFKD".
+	Form string `json:"form" validate:"max=64,charset=printable"`
+}
+
+type normalizeUnicodeResponse struct {
+	V          string `json:"v"`
+	Normalized bool   `json:"normalized"`
+	Err        string `json:"err,omitempty"`
+}
+
+// NormalizeUnicode returns s in the normalization form, and whether s was
+// in that form already. Strings equal once normalized, like an "é" made of
+// one rune or of "e" and a combining accent, are then equal byte for byte.
+func (stringService) NormalizeUnicode(ctx context.Context, s, form string) (output string, normalized bool, err error) {
+	if form == "" {
+		form = "NFC"
+	}
+	f, ok := normalizationForms[strings.ToUpper(form)]
+	if !ok {
+		return "", false, errorf(CodeUnknownOption, "Unknown normalization form %q", form)
+	}
+	return f.String(s), f.IsNormalString(s), nil
+}
+
+func makeNormalizeUnicodeEndpoint(svc IStringService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(normalizeUnicodeRequest)
+		output, normalized, err := svc.NormalizeUnicode(ctx, req.S, req.Form)
+		if err != nil {
+			return normalizeUnicodeResponse{"", false, err.Error()}, nil
+		}
+		return normalizeUnicodeResponse{output, normalized, ""}, nil
+	}
+}
+
+func decodeNormalizeUnicodeRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request normalizeUnicodeRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+//
+// ─── LOGGING ────────────────────────────────────────────────────────────────────
+//
+
+func (mw loggingMiddleware) NormalizeUnicode(ctx context.Context, s, form string) (output string, normalized bool, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "normalizeunicode",
+			"tenant", tenantFrom(ctx),
+			"input", s,
+			"form", form,
+			"output", output,
+			"normalized", normalized,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	output, normalized, err = mw.next.NormalizeUnicode(ctx, s, form)
+	return
+}
+
+//
+// ─── INSTRUMENTATION ────────────────────────────────────────────────────────────
+//
+
+func (mw instrumentingMiddleware) NormalizeUnicode(ctx context.Context, s, form string) (output string, normalized bool, err error) {
+	done := mw.begin(ctx, "normalizeunicode", s)
+	defer func() { done(len(output), err) }()
+
+	output, normalized, err = mw.next.NormalizeUnicode(ctx, s, form)
+	return
+}
@@ -0,0 +1,374 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"golang.org/x/text/number"
+)
+
+//
+// ────────────────────────────────────────────────────────── I ──────────
+//   :::::: N U M W O R D S : :  :   :    :     :        :          :
+// ────────────────────────────────────────────────────────────────────
+//
+
+// numberWords spells numbers in a language.
+type numberWords struct {
+	// integer spells a whole number.
+	integer func(n uint64) string
+	// point is read between the integer and the digits of the fraction,
+	// which are spelled one by one, and minus before negative numbers.
+	point, minus string
+}
+
+// numberWordLanguages are the languages numbers are spelled in, by base
+// language.
+var numberWordLanguages = map[string]numberWords{
+	"en": {englishNumberWords, "point", "minus"},
+	"es": {spanishNumberWords, "coma", "menos"},
+	"vi": {vietnameseNumberWords, "phẩy", "âm"},
+}
+
+var englishOnes = []string{
+	"zero", "one", "two", "three", "four", "five", "six", "seven", "eight", "nine",
+	"ten", "eleven", "twelve", "thirteen", "fourteen", "fifteen", "sixteen",
+	"seventeen", "eighteen", "nineteen",
+}
+
+var englishTens = []string{
+	"", "", "twenty", "thirty", "forty", "fifty", "sixty", "seventy", "eighty", "ninety",
+}
+
+var englishScales = []string{
+	"", "thousand", "million", "billion", "trillion", "quadrillion", "quintillion",
+}
+
+func englishNumberWords(n uint64) string {
+	if n == 0 {
+		return englishOnes[0]
+	}
+	groups := thousands(n)
+	var words []string
+	for i, c := range groups {
+		if c == 0 {
+			continue
+		}
+		if h := c / 100; h > 0 {
+			words = append(words, englishOnes[h], "hundred")
+		}
+		switch r := c % 100; {
+		case r == 0:
+		case r < 20:
+			words = append(words, englishOnes[r])
+		case r%10 == 0:
+			words = append(words, englishTens[r/10])
+		default:
+			words = append(words, englishTens[r/10]+"-"+englishOnes[r%10])
+		}
+		if scale := englishScales[len(groups)-1-i]; scale != "" {
+			words = append(words, scale)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// thousands splits n in groups of three digits, the most significant
+// first.
+func thousands(n uint64) []uint64 {
+	var groups []uint64
+	for ; n > 0; n /= 1000 {
+		groups = append([]uint64{n % 1000}, groups...)
+	}
+	return groups
+}
+
+var spanishUnits = []string{
+	"cero", "uno", "dos", "tres", "cuatro", "cinco", "seis", "siete", "ocho", "nueve",
+	"diez", "once", "doce", "trece", "catorce", "quince", "dieciséis", "diecisiete",
+	"dieciocho", "diecinueve", "veinte", "veintiuno", "veintidós", "veintitrés",
+	"veinticuatro", "veinticinco", "veintiséis", "veintisiete", "veintiocho", "veintinueve",
+}
+
+var spanishTens = []string{
+	"", "", "", "treinta", "cuarenta", "cincuenta", "sesenta", "setenta", "ochenta", "noventa",
+}
+
+var spanishHundreds = []string{
+	"", "ciento", "doscientos", "trescientos", "cuatrocientos", "quinientos",
+	"seiscientos", "setecientos", "ochocientos", "novecientos",
+}
+
+// spanishScales are the long scale names of the powers of a million, in
+// the singular and the plural.
+var spanishScales = [][2]string{
+	{"", ""}, {"millón", "millones"}, {"billón", "billones"}, {"trillón", "trillones"},
+}
+
+func spanishNumberWords(n uint64) string {
+	if n == 0 {
+		return spanishUnits[0]
+	}
+	var groups []uint64
+	for ; n > 0; n /= 1000000 {
+		groups = append([]uint64{n % 1000000}, groups...)
+	}
+	var words []string
+	for i, g := range groups {
+		scale := spanishScales[len(groups)-1-i]
+		switch {
+		case g == 0:
+		case scale[0] == "":
+			words = append(words, spanishBelowMillion(g))
+		case g == 1:
+			words = append(words, "un", scale[0])
+		default:
+			words = append(words, spanishApocope(spanishBelowMillion(g)), scale[1])
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+func spanishBelowMillion(n uint64) string {
+	var words []string
+	switch t := n / 1000; {
+	case t == 1:
+		words = append(words, "mil")
+	case t > 1:
+		words = append(words, spanishApocope(spanishBelowThousand(t)), "mil")
+	}
+	if u := n % 1000; u > 0 {
+		words = append(words, spanishBelowThousand(u))
+	}
+	return strings.Join(words, " ")
+}
+
+func spanishBelowThousand(n uint64) string {
+	if n == 100 {
+		return "cien"
+	}
+	var words []string
+	if h := n / 100; h > 0 {
+		words = append(words, spanishHundreds[h])
+	}
+	switch r := n % 100; {
+	case r == 0:
+	case r < 30:
+		words = append(words, spanishUnits[r])
+	case r%10 == 0:
+		words = append(words, spanishTens[r/10])
+	default:
+		words = append(words, spanishTens[r/10], "y", spanishUnits[r%10])
+	}
+	return strings.Join(words, " ")
+}
+
+// spanishApocope shortens the "uno" ending the multiplier of a scale, as
+// in "veintiún mil" or "treinta y un millones".
+func spanishApocope(s string) string {
+	switch {
+	case strings.HasSuffix(s, "veintiuno"):
+		return strings.TrimSuffix(s, "veintiuno") + "veintiún"
+	case strings.HasSuffix(s, "uno"):
+		return strings.TrimSuffix(s, "uno") + "un"
+	}
+	return s
+}
+
+var vietnameseDigits = []string{
+	"không", "một", "hai", "ba", "bốn", "năm", "sáu", "bảy", "tám", "chín",
+}
+
+var vietnameseScales = []string{
+	"", "nghìn", "triệu", "tỷ", "nghìn tỷ", "triệu tỷ", "tỷ tỷ",
+}
+
+func vietnameseNumberWords(n uint64) string {
+	if n == 0 {
+		return vietnameseDigits[0]
+	}
+	groups := thousands(n)
+	var words []string
+	for i, c := range groups {
+		if c == 0 {
+			continue
+		}
+		words = append(words, vietnameseBelowThousand(c, i > 0))
+		if scale := vietnameseScales[len(groups)-1-i]; scale != "" {
+			words = append(words, scale)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// vietnameseBelowThousand spells n, with its hundreds even when there are
+// none if it follows a higher group.
+func vietnameseBelowThousand(n uint64, full bool) string {
+	h, t, u := n/100, n/10%10, n%10
+	var words []string
+	if h > 0 || full {
+		words = append(words, vietnameseDigits[h], "trăm")
+	}
+	switch {
+	case t == 0 && u == 0:
+	case t == 0 && len(words) > 0:
+		words = append(words, "linh", vietnameseDigits[u])
+	case t == 0:
+		words = append(words, vietnameseDigits[u])
+	case t == 1:
+		words = append(words, "mười")
+	default:
+		words = append(words, vietnameseDigits[t], "mươi")
+	}
+	if t > 0 {
+		switch {
+		case u == 0:
+		case u == 1 && t > 1:
+			words = append(words, "mốt")
+		case u == 4 && t > 1:
+			words = append(words, "tư")
+		case u == 5:
+			words = append(words, "lăm")
+		default:
+			words = append(words, vietnameseDigits[u])
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// parseDecimal splits the decimal number n, like "-1234.50", into its
+// sign, integer and the digits of its fraction.
+func parseDecimal(n string) (negative bool, integer uint64, fraction string, err error) {
+	s := strings.TrimPrefix(n, "+")
+	if strings.HasPrefix(s, "-") {
+		negative, s = true, s[1:]
+	}
+	whole, fraction, _ := strings.Cut(s, ".")
+	if whole == "" || strings.Trim(whole, "0123456789") != "" || strings.Trim(fraction, "0123456789") != "" {
+		return false, 0, "", errorf(CodeMalformedInput, "Invalid number %q", n)
+	}
+	integer, err = strconv.ParseUint(whole, 10, 64)
+	if err != nil {
+		return false, 0, "", errorf(CodeUnsupportedInput, "Number %q is too large", n)
+	}
+	return negative, integer, fraction, nil
+}
+
+type numWordsRequest struct {
+	// N is a decimal number, without exponent. It can be a JSON number or
+	// string.
+	N json.Number `json:"n" validate:"required"`
+	// Locale is a BCP 47 tag, "en" by default.
+	Locale string `json:"locale" validate:"max=64,charset=printable"`
+}
+
+type numWordsResponse struct {
+	V         string `json:"v"`
+	Formatted string `json:"formatted"`
+	Err       string `json:"err,omitempty"`
+}
+
+// NumWords spells n in the language of locale, and formats it with the
+// digit grouping and decimal separator of locale, keeping the digits of
+// its fraction. Numbers are spelled in English, Spanish and Vietnamese,
+// and formatted in any locale; numbers with a sign or a fraction are
+// formatted exactly up to 15 significant digits.
+func (stringService) NumWords(ctx context.Context, n, locale string) (words, formatted string, err error) {
+	if locale == "" {
+		locale = "en"
+	}
+	tag, err := language.Parse(locale)
+	if err != nil {
+		return "", "", errorf(CodeUnknownOption, "Invalid locale %q", locale)
+	}
+	base, _ := tag.Base()
+	spell, ok := numberWordLanguages[base.String()]
+	if !ok {
+		return "", "", errorf(CodeUnsupportedInput, "Numbers are not spelled in %q", base.String())
+	}
+	negative, integer, fraction, err := parseDecimal(n)
+	if err != nil {
+		return "", "", err
+	}
+
+	var w []string
+	if negative {
+		w = append(w, spell.minus)
+	}
+	w = append(w, spell.integer(integer))
+	if fraction != "" {
+		w = append(w, spell.point)
+		for _, d := range fraction {
+			w = append(w, spell.integer(uint64(d-'0')))
+		}
+	}
+
+	// Whole numbers are formatted exactly, others as float64.
+	var value interface{} = integer
+	if negative || fraction != "" {
+		if value, err = strconv.ParseFloat(n, 64); err != nil {
+			return "", "", errorf(CodeUnsupportedInput, "Number out of range")
+		}
+	}
+	formatted = message.NewPrinter(tag).Sprint(number.Decimal(value, number.Scale(len(fraction))))
+	return strings.Join(w, " "), formatted, nil
+}
+
+func makeNumWordsEndpoint(svc IStringService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(numWordsRequest)
+		words, formatted, err := svc.NumWords(ctx, req.N.String(), req.Locale)
+		if err != nil {
+			return numWordsResponse{"", "", err.Error()}, nil
+		}
+		return numWordsResponse{words, formatted, ""}, nil
+	}
+}
+
+func decodeNumWordsRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request numWordsRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+//
+// ─── LOGGING ────────────────────────────────────────────────────────────────────
+//
+
+func (mw loggingMiddleware) NumWords(ctx context.Context, n, locale string) (words, formatted string, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "numwords",
+			"tenant", tenantFrom(ctx),
+			"input", n,
+			"locale", locale,
+			"output", words,
+			"formatted", formatted,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	words, formatted, err = mw.next.NumWords(ctx, n, locale)
+	return
+}
+
+//
+// ─── INSTRUMENTATION ────────────────────────────────────────────────────────────
+//
+
+func (mw instrumentingMiddleware) NumWords(ctx context.Context, n, locale string) (words, formatted string, err error) {
+	done := mw.begin(ctx, "numwords", n)
+	defer func() { done(len(words), err) }()
+
+	words, formatted, err = mw.next.NumWords(ctx, n, locale)
+	return
+}
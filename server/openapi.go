@@ -0,0 +1,210 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//
+// ────────────────────────────────────────────────────── I ──────────
+//   :::::: O P E N A P I : :  :   :    :     :        :          :
+// ────────────────────────────────────────────────────────────────
+//
+
+// The OpenAPI document is generated from the request and response types of
+// the operations, so it follows them without being edited by hand. Field
+// descriptions come from an optional `doc` struct tag.
+
+// openapiDocument builds the document of routes, keyed by operation name.
+// Only the routes registered on mux are documented,
+// which leaves out pipeline-only operations like "trim".
+func openapiDocument(routes map[string]operation, mux *http.ServeMux) map[string]interface{} {
+	g := openapiGenerator{schemas: make(map[string]interface{})}
+	paths := make(map[string]interface{})
+	names := make([]string, 0, len(routes))
+	for name := range routes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		route := operationRoute(name)
+		req, _ := http.NewRequest(http.MethodPost, route, nil)
+		if _, pattern := mux.Handler(req); pattern == "" {
+			continue
+		}
+		op := routes[name]
+		paths[route] = map[string]interface{}{
+			"post": map[string]interface{}{
+				"operationId": graphqlName(name),
+				"parameters": []interface{}{map[string]interface{}{
+					"name":        "Accept-Language",
+					"in":          "header",
+					"description": "The languages of the messages of failures, like \"vi, es;q=0.8\". English when none is supported.",
+					"schema":      map[string]interface{}{"type": "string"},
+				}},
+				"requestBody": map[string]interface{}{
+					"required": true,
+					"content":  openapiJSONContent(g.schema(reflect.TypeOf(op.Request))),
+				},
+				"responses": map[string]interface{}{
+					"200": map[string]interface{}{
+						"description": "The result, or an error in \"err\" and its \"code\" for failures of the operation itself.",
+						"content":     openapiJSONContent(g.schema(reflect.TypeOf(op.Response))),
+					},
+					"400": openapiErrorResponse("The request could not be decoded."),
+					"500": openapiErrorResponse("The operation failed."),
+				},
+			},
+		}
+	}
+	g.schemas["Error"] = map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"err": map[string]interface{}{"type": "string"},
+			"code": map[string]interface{}{
+				"type":        "string",
+				"description": "The code of the failure, like ERR_EMPTY_INPUT, which unlike err never changes.",
+			},
+		},
+		"required": []string{"err", "code"},
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "stringsvc",
+			"version": "1.0.0",
+		},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": g.schemas},
+	}
+}
+
+func openapiJSONContent(schema interface{}) map[string]interface{} {
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+}
+
+func openapiErrorResponse(description string) map[string]interface{} {
+	return map[string]interface{}{
+		"description": description,
+		"content":     openapiJSONContent(map[string]interface{}{"$ref": "#/components/schemas/Error"}),
+	}
+}
+
+// openapiGenerator turns Go types into schemas. Named structs become
+// components, referenced from wherever they are used.
+type openapiGenerator struct {
+	schemas map[string]interface{}
+}
+
+func (g openapiGenerator) schema(t reflect.Type) map[string]interface{} {
+	switch {
+	case t == reflect.TypeOf(json.RawMessage{}):
+		return map[string]interface{}{}
+	case t.Kind() == reflect.Ptr:
+		s := g.schema(t.Elem())
+		s["nullable"] = true
+		return s
+	case t.Kind() == reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": g.schema(t.Elem())}
+	case t.Kind() == reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case t.Kind() == reflect.Interface:
+		return map[string]interface{}{}
+	case t.Kind() == reflect.Struct:
+		name := strings.ToUpper(t.Name()[:1]) + t.Name()[1:]
+		if _, ok := g.schemas[name]; !ok {
+			g.schemas[name] = nil // placeholder for recursive types
+			g.schemas[name] = g.object(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	}
+	return map[string]interface{}{}
+}
+
+// object returns the schema of a struct. Response fields without omitempty
+// are always sent, so they are listed as required; request fields all have
+// defaults.
+func (g openapiGenerator) object(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := jsonFieldName(f)
+		if name == "" {
+			continue
+		}
+		s := g.schema(f.Type)
+		if doc := f.Tag.Get("doc"); doc != "" {
+			if _, isRef := s["$ref"]; isRef {
+				s = map[string]interface{}{"allOf": []interface{}{s}}
+			}
+			s["description"] = doc
+		}
+		properties[name] = s
+		if !strings.Contains(f.Tag.Get("json"), "omitempty") && f.Type.Kind() != reflect.Ptr {
+			required = append(required, name)
+		}
+	}
+	s := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 && strings.HasSuffix(t.Name(), "Response") {
+		s["required"] = required
+	}
+	return s
+}
+
+// makeOpenAPIHandler serves the document of routes at /openapi.json. It is
+// generated on the first request, once every route is registered on mux.
+func makeOpenAPIHandler(routes map[string]operation, mux *http.ServeMux) http.Handler {
+	var (
+		once sync.Once
+		doc  []byte
+	)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() {
+			doc, _ = json.Marshal(openapiDocument(routes, mux))
+		})
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write(doc)
+	})
+}
+
+// swaggerUIPage loads Swagger UI and points it at /openapi.json.
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>stringsvc API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>
+    window.ui = SwaggerUIBundle({url: "/openapi.json", dom_id: "#swagger-ui"});
+  </script>
+</body>
+</html>
+`
+
+// serveSwaggerUI serves /docs.
+func serveSwaggerUI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(swaggerUIPage))
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+//
+// ──────────────────────────────────────────────────────────── I ──────────
+//   :::::: O P E R A T I O N S : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────────────────
+//
+
+// operation is an endpoint that can be invoked by name. Request is the zero
+// value of the request type the endpoint expects; it tells invoke what to
+// decode JSON options into. Response is the zero value of the response type
+// the endpoint returns, for transports that describe their outputs.
+type operation struct {
+	Endpoint endpoint.Endpoint
+	Request  interface{}
+	Response interface{}
+}
+
+// newOperations returns every operation of svc keyed by name, which is
+// usually the route without its leading slash (see operationRoute).
+// Requests are validated before reaching the endpoints.
+func newOperations(svc IStringService) map[string]operation {
+	ops := map[string]operation{
+		"uppercase":         {makeUppercaseEndpoint(svc), uppercaseRequest{}, uppercaseResponse{}},
+		"count":             {makeCountEndpoint(svc), countRequest{}, countResponse{}},
+		"hash":              {makeHashEndpoint(svc), hashRequest{}, hashResponse{}},
+		"encode":            {makeEncodeEndpoint(svc), encodeStringRequest{}, encodeStringResponse{}},
+		"decode":            {makeDecodeEndpoint(svc), decodeStringRequest{}, decodeStringResponse{}},
+		"urlencode":         {makeURLEncodeEndpoint(svc), urlEncodeRequest{}, urlEncodeResponse{}},
+		"urldecode":         {makeURLDecodeEndpoint(svc), urlDecodeRequest{}, urlDecodeResponse{}},
+		"html":              {makeHTMLEndpoint(svc), htmlRequest{}, htmlResponse{}},
+		"slugify":           {makeSlugifyEndpoint(svc), slugifyRequest{}, slugifyResponse{}},
+		"convertcase":       {makeConvertCaseEndpoint(svc), convertCaseRequest{}, convertCaseResponse{}},
+		"similarity":        {makeSimilarityEndpoint(svc), similarityRequest{}, similarityResponse{}},
+		"palindrome":        {makePalindromeEndpoint(svc), palindromeRequest{}, palindromeResponse{}},
+		"anagram":           {makeAnagramEndpoint(svc), anagramRequest{}, anagramResponse{}},
+		"stats":             {makeStatsEndpoint(svc), statsRequest{}, statsResponse{}},
+		"detect-language":   {makeDetectLanguageEndpoint(svc), detectLanguageRequest{}, detectLanguageResponse{}},
+		"transliterate":     {makeTransliterateEndpoint(svc), transliterateRequest{}, transliterateResponse{}},
+		"random":            {makeRandomEndpoint(svc), randomRequest{}, randomResponse{}},
+		"id":                {makeIDEndpoint(svc), idRequest{}, idResponse{}},
+		"cipher":            {makeCipherEndpoint(svc), cipherRequest{}, cipherResponse{}},
+		"encrypt":           {makeEncryptEndpoint(svc), encryptRequest{}, encryptResponse{}},
+		"decrypt":           {makeDecryptEndpoint(svc), decryptRequest{}, decryptResponse{}},
+		"compress":          {makeCompressEndpoint(svc), compressRequest{}, compressResponse{}},
+		"decompress":        {makeDecompressEndpoint(svc), decompressRequest{}, decompressResponse{}},
+		"render":            {makeRenderEndpoint(svc), renderRequest{}, renderResponse{}},
+		"diff":              {makeDiffEndpoint(svc), diffRequest{}, diffResponse{}},
+		"inflect":           {makeInflectEndpoint(svc), inflectRequest{}, inflectResponse{}},
+		"truncate":          {makeTruncateEndpoint(svc), truncateRequest{}, truncateResponse{}},
+		"pad":               {makePadEndpoint(svc), padRequest{}, padResponse{}},
+		"redact":            {makeRedactEndpoint(svc), redactRequest{}, redactResponse{}},
+		"moderate":          {makeModerateEndpoint(svc), moderateRequest{}, moderateResponse{}},
+		"wordfreq":          {makeWordFreqEndpoint(svc), wordFreqRequest{}, wordFreqResponse{}},
+		"tokenize":          {makeTokenizeEndpoint(svc), tokenizeRequest{}, tokenizeResponse{}},
+		"stem":              {makeStemEndpoint(svc), stemRequest{}, stemResponse{}},
+		"normalize-unicode": {makeNormalizeUnicodeEndpoint(svc), normalizeUnicodeRequest{}, normalizeUnicodeResponse{}},
+		"recode":            {makeRecodeEndpoint(svc), recodeRequest{}, recodeResponse{}},
+		"numwords":          {makeNumWordsEndpoint(svc), numWordsRequest{}, numWordsResponse{}},
+		"sign":              {makeSignEndpoint(svc), signRequest{}, signResponse{}},
+		"verify":            {makeVerifyEndpoint(svc), verifyRequest{}, verifyResponse{}},
+		"json":              {makeJSONEndpoint(svc), jsonRequest{}, jsonResponse{}},
+		"csv-parse":         {makeCSVParseEndpoint(svc), csvParseRequest{}, csvParseResponse{}},
+		"csv-format":        {makeCSVFormatEndpoint(svc), csvFormatRequest{}, csvFormatResponse{}},
+		"expand":            {makeExpandEndpoint(svc), expandRequest{}, expandResponse{}},
+		"emoji":             {makeEmojiEndpoint(svc), emojiRequest{}, emojiResponse{}},
+		"wrap":              {makeWrapEndpoint(svc), wrapRequest{}, wrapResponse{}},
+		"phonetic":          {makePhoneticEndpoint(svc), phoneticRequest{}, phoneticResponse{}},
+
+		// Trivial transformations that are only useful as pipeline steps.
+		"trim":      {makeTextEndpoint(strings.TrimSpace), textRequest{}, uppercaseResponse{}},
+		"lowercase": {makeTextEndpoint(strings.ToLower), textRequest{}, uppercaseResponse{}},
+	}
+	for name, op := range ops {
+		op.Endpoint = validated(op.Endpoint)
+		ops[name] = op
+	}
+	return ops
+}
+
+// operationRoutes are the routes of the operations that are not served at
+// their name.
+var operationRoutes = map[string]string{
+	"palindrome": "/analyze/palindrome",
+	"anagram":    "/analyze/anagram",
+	"csv-parse":  "/csv/parse",
+	"csv-format": "/csv/format",
+}
+
+// operationStreamRoutes are the stream routes of the operations that have
+// some, which the tenants disabling them turn off as well.
+var operationStreamRoutes = map[string][]string{
+	"uppercase":  {"/stream/uppercase"},
+	"count":      {"/stream/count", "/" + currentAPIVersion + "/stream/count"},
+	"csv-parse":  {"/stream/csv/parse"},
+	"csv-format": {"/stream/csv/format"},
+}
+
+// operationRoute returns the HTTP route of the operation name.
+func operationRoute(name string) string {
+	if route, ok := operationRoutes[name]; ok {
+		return route
+	}
+	return "/" + name
+}
+
+// textRequest is the request of the trivial transformations, which accept
+// empty strings unlike /uppercase.
+type textRequest struct {
+	S string `json:"s"`
+}
+
+// makeTextEndpoint adapts a plain string function to textRequest and the
+// response type of /uppercase.
+func makeTextEndpoint(fn func(string) string) endpoint.Endpoint {
+	return func(_ context.Context, request interface{}) (interface{}, error) {
+		return uppercaseResponse{fn(request.(textRequest).S), ""}, nil
+	}
+}
+
+// operationResult is the part shared by the responses of every operation.
+type operationResult struct {
+	V    json.RawMessage `json:"v,omitempty"`
+	Err  string          `json:"err,omitempty"`
+	Code ErrorCode       `json:"code,omitempty"`
+}
+
+// invoke calls op with a request decoded from the JSON object options, and
+// returns the "v" and "err" fields of its response, with the code of the
+// failure if any. The failure is in the language of the request.
+func (op operation) invoke(ctx context.Context, options map[string]interface{}) (operationResult, error) {
+	var result operationResult
+	ctx, codes := withErrorCodes(ctx)
+	data, err := json.Marshal(options)
+	if err != nil {
+		return result, err
+	}
+	req, err := op.decode(data)
+	if err != nil {
+		return result, err
+	}
+
+	resp, err := op.Endpoint(ctx, req)
+	if err != nil {
+		return result, err
+	}
+	if data, err = json.Marshal(resp); err != nil {
+		return result, err
+	}
+	if err = json.Unmarshal(data, &result); err == nil && result.Err != "" && result.Code == "" {
+		result.Code = codes.Code()
+	}
+	result.Err = localizedFailure(ctx, result.Err)
+	return result, err
+}
+
+// decode unmarshals a JSON request body into the request type of op.
+func (op operation) decode(data []byte) (interface{}, error) {
+	req := reflect.New(reflect.TypeOf(op.Request))
+	if err := json.Unmarshal(data, req.Interface()); err != nil {
+		return nil, err
+	}
+	return req.Elem().Interface(), nil
+}
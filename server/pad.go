@@ -0,0 +1,130 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/mattn/go-runewidth"
+)
+
+//
+// ────────────────────────────────────────────── I ──────────
+//   :::::: P A D : :  :   :    :     :        :          :
+// ────────────────────────────────────────────────────────
+//
+
+// maxPadWidth bounds the size of padded outputs.
+const maxPadWidth = 4096
+
+// displayWidth measures strings in terminal columns: wide and fullwidth
+// East Asian characters count as two, combining marks as zero. Ambiguous
+// characters count as one, whatever the locale of the server.
+var displayWidth = func() *runewidth.Condition {
+	c := runewidth.NewCondition()
+	c.EastAsianWidth = false
+	return c
+}()
+
+type padRequest struct {
+	S     string `json:"s"`
+	Width int    `json:"width"`
+	// Align is "left" (the default), "right" or "center".
+	Align string `json:"align" validate:"max=64,charset=printable"`
+	Fill  string `json:"fill" validate:"max=16"`
+}
+
+type padResponse struct {
+	V   string `json:"v"`
+	Err string `json:"err,omitempty"`
+}
+
+// Pad pads s with fill up to width display columns. Strings already wider
+// than width are returned unchanged. When fill is two columns wide and the
+// padding is odd, the last column is padded with a space.
+func (stringService) Pad(ctx context.Context, s string, width int, align, fill string) (string, error) {
+	if width < 0 || width > maxPadWidth {
+		return "", fmt.Errorf("width must be between 0 and %d", maxPadWidth)
+	}
+	if fill == "" {
+		fill = " "
+	}
+	fillWidth := displayWidth.StringWidth(fill)
+	if fillWidth < 1 || fillWidth > 2 || len([]rune(fill)) != 1 {
+		return "", errors.New("fill must be a single character, one or two columns wide")
+	}
+
+	padding := width - displayWidth.StringWidth(s)
+	if padding <= 0 {
+		return s, nil
+	}
+	pad := func(n int) string {
+		return strings.Repeat(fill, n/fillWidth) + strings.Repeat(" ", n%fillWidth)
+	}
+
+	switch align {
+	case "", "left":
+		return s + pad(padding), nil
+	case "right":
+		return pad(padding) + s, nil
+	case "center":
+		return pad(padding/2) + s + pad(padding-padding/2), nil
+	}
+	return "", errorf(CodeUnknownOption, "Unknown alignment %q", align)
+}
+
+func makePadEndpoint(svc IStringService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(padRequest)
+		v, err := svc.Pad(ctx, req.S, req.Width, req.Align, req.Fill)
+		if err != nil {
+			return padResponse{"", err.Error()}, nil
+		}
+		return padResponse{v, ""}, nil
+	}
+}
+
+func decodePadRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request padRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+//
+// ─── LOGGING ────────────────────────────────────────────────────────────────────
+//
+
+func (mw loggingMiddleware) Pad(ctx context.Context, s string, width int, align, fill string) (output string, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "pad",
+			"tenant", tenantFrom(ctx),
+			"input", s,
+			"width", width,
+			"align", align,
+			"output", output,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	output, err = mw.next.Pad(ctx, s, width, align, fill)
+	return
+}
+
+//
+// ─── INSTRUMENTATION ────────────────────────────────────────────────────────────
+//
+
+func (mw instrumentingMiddleware) Pad(ctx context.Context, s string, width int, align, fill string) (output string, err error) {
+	done := mw.begin(ctx, "pad", s)
+	defer func() { done(len(output), err) }()
+
+	output, err = mw.next.Pad(ctx, s, width, align, fill)
+	return
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/antzucaro/matchr"
+	"github.com/dotcypress/phonetics"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/mozillazg/go-unidecode"
+)
+
+//
+// ──────────────────────────────────────────────────────── I ──────────
+//   :::::: P H O N E T I C : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────────────
+//
+
+// phoneticAlgorithms encode a word, made of ASCII letters, by how it
+// sounds in English. Only Double Metaphone has alternate codes, for the
+// names whose spelling allows two readings.
+var phoneticAlgorithms = map[string]func(word string) (code, alternate string){
+	"soundex":          func(w string) (string, string) { return matchr.Soundex(w), "" },
+	"metaphone":        func(w string) (string, string) { return phonetics.EncodeMetaphone(w), "" },
+	"double-metaphone": matchr.DoubleMetaphone,
+	"nysiis":           func(w string) (string, string) { return matchr.NYSIIS(w), "" },
+}
+
+type phoneticRequest struct {
+	S string `json:"s"`
+	// Algorithm is "soundex" (the default), "metaphone", "double-metaphone"
+	// or "nysiis".
+	Algorithm string `json:"algorithm" validate:"max=64,charset=printable"`
+}
+
+type phoneticResponse struct {
+	V string `json:"v"`
+	// Alternate is the alternate Double Metaphone code, when it differs.
+	Alternate string `json:"alternate,omitempty"`
+	Err       string `json:"err,omitempty"`
+}
+
+type phoneticBatchRequest struct {
+	Items     []string `json:"items" validate:"max=1000"`
+	Algorithm string   `json:"algorithm" validate:"max=64,charset=printable"`
+}
+
+type phoneticBatchResponse struct {
+	V   []phoneticResponse `json:"v"`
+	Err string             `json:"err,omitempty"`
+}
+
+// Phonetic returns the phonetic code of every word of s, separated by
+// spaces, so that names sounding alike get the same codes. Letters are
+// transliterated to ASCII first, and other characters separate words.
+func (stringService) Phonetic(ctx context.Context, s, algorithm string) (code, alternate string, err error) {
+	if algorithm == "" {
+		algorithm = "soundex"
+	}
+	encode, ok := phoneticAlgorithms[algorithm]
+	if !ok {
+		return "", "", errorf(CodeUnknownOption, "Unknown phonetic algorithm %q", algorithm)
+	}
+	// Apostrophes are dropped rather than split on, as in "O'Brien".
+	ascii := strings.ReplaceAll(unidecode.Unidecode(s), "'", "")
+	words := strings.FieldsFunc(ascii, func(r rune) bool {
+		return (r < 'a' || r > 'z') && (r < 'A' || r > 'Z')
+	})
+	codes, alternates := make([]string, len(words)), make([]string, len(words))
+	for i, w := range words {
+		codes[i], alternates[i] = encode(strings.ToUpper(w))
+		if alternates[i] == "" {
+			alternates[i] = codes[i]
+		}
+	}
+	code, alternate = strings.Join(codes, " "), strings.Join(alternates, " ")
+	if alternate == code {
+		alternate = ""
+	}
+	return code, alternate, nil
+}
+
+func makePhoneticEndpoint(svc IStringService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(phoneticRequest)
+		v, alternate, err := svc.Phonetic(ctx, req.S, req.Algorithm)
+		if err != nil {
+			return phoneticResponse{"", "", err.Error()}, nil
+		}
+		return phoneticResponse{v, alternate, ""}, nil
+	}
+}
+
+func makePhoneticBatchEndpoint(svc IStringService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(phoneticBatchRequest)
+		if len(req.Items) > maxBatchItems {
+			return phoneticBatchResponse{Err: fmt.Sprintf("A batch has at most %d items", maxBatchItems)}, nil
+		}
+		results := make([]phoneticResponse, len(req.Items))
+		for i, s := range req.Items {
+			v, alternate, err := svc.Phonetic(ctx, s, req.Algorithm)
+			if err != nil {
+				results[i] = phoneticResponse{"", "", err.Error()}
+				continue
+			}
+			results[i] = phoneticResponse{v, alternate, ""}
+		}
+		return phoneticBatchResponse{results, ""}, nil
+	}
+}
+
+func decodePhoneticRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request phoneticRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+func decodePhoneticBatchRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request phoneticBatchRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+//
+// ─── LOGGING ────────────────────────────────────────────────────────────────────
+//
+
+func (mw loggingMiddleware) Phonetic(ctx context.Context, s, algorithm string) (code, alternate string, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "phonetic",
+			"tenant", tenantFrom(ctx),
+			"input", s,
+			"algorithm", algorithm,
+			"output", code,
+			"alternate", alternate,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	code, alternate, err = mw.next.Phonetic(ctx, s, algorithm)
+	return
+}
+
+//
+// ─── INSTRUMENTATION ────────────────────────────────────────────────────────────
+//
+
+func (mw instrumentingMiddleware) Phonetic(ctx context.Context, s, algorithm string) (code, alternate string, err error) {
+	done := mw.begin(ctx, "phonetic", s)
+	defer func() { done(len(code), err) }()
+
+	code, alternate, err = mw.next.Phonetic(ctx, s, algorithm)
+	return
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/metrics"
+)
+
+//
+// ──────────────────────────────────────────────────────── I ──────────
+//   :::::: P I P E L I N E : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────────────
+//
+
+// maxPipelineSteps bounds the work a single pipeline request can ask for.
+const maxPipelineSteps = 32
+
+type pipelineStep struct {
+	Op string `json:"op"`
+	// Options are the request fields of the operation, "s" excepted.
+	Options map[string]interface{} `json:"options"`
+}
+
+type pipelineRequest struct {
+	S     string         `json:"s"`
+	Steps []pipelineStep `json:"steps"`
+}
+
+type pipelineStepResult struct {
+	Op   string          `json:"op"`
+	V    json.RawMessage `json:"v,omitempty"`
+	Err  string          `json:"err,omitempty"`
+	Code ErrorCode       `json:"code,omitempty"`
+}
+
+// pipelineResponse holds the output of the last step that succeeded, so a
+// failing pipeline still reports how far it got.
+type pipelineResponse struct {
+	V     json.RawMessage      `json:"v"`
+	Steps []pipelineStepResult `json:"steps"`
+	Err   string               `json:"err,omitempty"`
+}
+
+// makePipelineEndpoint runs the steps of a request in order, feeding the
+// "v" of each step as the "s" of the next one. Execution stops at the first
+// failing step. Every step goes through the service middlewares like a
+// direct call would; stepLatency additionally records each step by name.
+func makePipelineEndpoint(ops map[string]operation, stepLatency metrics.Histogram) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(pipelineRequest)
+		if len(req.Steps) > maxPipelineSteps {
+			err := errorf(CodeLimitExceeded, "A pipeline has at most %d steps", maxPipelineSteps)
+			recordErrorCode(ctx, err)
+			return pipelineResponse{Err: err.Error()}, nil
+		}
+
+		cur, _ := json.Marshal(req.S)
+		resp := pipelineResponse{V: cur, Steps: []pipelineStepResult{}}
+		for i, step := range req.Steps {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			result, err := runPipelineStep(ctx, ops, step, cur, stepLatency)
+			if errors.Is(err, context.DeadlineExceeded) {
+				return nil, err
+			}
+			if err == nil && result.Err != "" {
+				err = withCode(result.Code, errors.New(result.Err))
+			}
+			if err != nil {
+				// The pipeline fails with the code of its failing step.
+				failure := withCode(errorCodeOf(err), localizedMessage{"Step %d (%s) failed: %v", []interface{}{i + 1, step.Op, err}})
+				recordErrorCode(ctx, failure)
+				resp.Steps = append(resp.Steps, pipelineStepResult{Op: step.Op, Err: localeFrom(ctx).Error(err), Code: errorCodeOf(err)})
+				resp.Err = failure.Error()
+				return resp, nil
+			}
+			resp.Steps = append(resp.Steps, pipelineStepResult{Op: step.Op, V: result.V})
+			cur, resp.V = result.V, result.V
+		}
+		return resp, nil
+	}
+}
+
+func runPipelineStep(ctx context.Context, ops map[string]operation, step pipelineStep, input json.RawMessage, stepLatency metrics.Histogram) (result operationResult, err error) {
+	defer func(begin time.Time) {
+		failed := err != nil || result.Err != ""
+		stepLatency.With("op", step.Op, "error", fmt.Sprint(failed)).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	op, ok := ops[step.Op]
+	if !ok {
+		return result, errorf(CodeUnknownOperation, "Unknown operation %q", step.Op)
+	}
+	var s string
+	if err := json.Unmarshal(input, &s); err != nil {
+		return result, errorf(CodeUnsupportedInput, "Input is not a string: %s", input)
+	}
+
+	options := map[string]interface{}{}
+	for k, v := range step.Options {
+		options[k] = v
+	}
+	options["s"] = s
+	return op.invoke(ctx, options)
+}
+
+func decodePipelineRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request pipelineRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"plugin"
+
+	"github.com/anhle128/gokit-stringsvc/registry"
+	"github.com/go-kit/kit/endpoint"
+)
+
+//
+// ────────────────────────────────────────────────────── I ──────────
+//   :::::: P L U G I N S : :  :   :    :     :        :          :
+// ────────────────────────────────────────────────────────────────
+//
+
+// loadPlugins opens the Go plugins at paths, whose init functions register
+// their operations. Plugins must be built with the same Go version and
+// dependencies as the service.
+func loadPlugins(paths []string) error {
+	for _, path := range paths {
+		if _, err := plugin.Open(path); err != nil {
+			return fmt.Errorf("cannot load plugin %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// addRegisteredOperations adds the operations of the registry to ops,
+// validated and instrumented by mw. They cannot replace built-in ones.
+func addRegisteredOperations(ops map[string]operation, mw instrumentingMiddleware) error {
+	for name, op := range registry.Operations() {
+		if _, ok := ops[name]; ok {
+			return fmt.Errorf("registered operation %s is a built-in one", name)
+		}
+		ops[name] = operation{
+			mw.instrumented(name, validated(endpoint.Endpoint(op.Handler))),
+			op.Codec.Request,
+			op.Codec.Response,
+		}
+	}
+	return nil
+}
+
+// instrumented records the calls to the operation name like those of the
+// methods of the service, for the operations that are not. Their sizes are
+// those of their requests and responses in JSON.
+func (mw instrumentingMiddleware) instrumented(name string, next endpoint.Endpoint) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		input, _ := json.Marshal(request)
+		done := mw.begin(ctx, name, string(input))
+		defer func() {
+			output, _ := json.Marshal(response)
+			done(len(output), err)
+		}()
+		return next(ctx, request)
+	}
+}
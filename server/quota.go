@@ -0,0 +1,217 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	bolt "go.etcd.io/bbolt"
+)
+
+//
+// ──────────────────────────────────────────────────── I ──────────
+//   :::::: Q U O T A S : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────────
+//
+
+// apiKeyHeader identifies the client of a request. Requests without a key,
+// or with an unknown one, are metered together as anonymousClient.
+const (
+	apiKeyHeader    = "X-API-Key"
+	anonymousClient = "anonymous"
+)
+
+// ErrQuotaExceeded is returned to the clients over their daily or monthly
+// quota, with a 429.
+var ErrQuotaExceeded = errors.New("Quota exceeded")
+
+var quotaBucket = []byte("usage")
+
+// quotaPeriods are the periods usage is metered over, in UTC.
+var quotaPeriods = []struct {
+	Name   string
+	Layout string
+	// Next returns the start of the period after the one of t.
+	Next func(t time.Time) time.Time
+}{
+	{"day", "2006-01-02", func(t time.Time) time.Time {
+		return time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+	}},
+	{"month", "2006-01", func(t time.Time) time.Time {
+		return time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+	}},
+}
+
+// quotaUsage is the usage of a client over a period, as answered by
+// /usage. Limits of zero are not enforced.
+type quotaUsage struct {
+	Period       string `json:"period"`
+	Start        string `json:"start"`
+	Requests     int64  `json:"requests"`
+	Bytes        int64  `json:"bytes"`
+	RequestLimit int64  `json:"request_limit,omitempty"`
+	ByteLimit    int64  `json:"byte_limit,omitempty"`
+}
+
+// quotas meters the requests and request bytes of every client in a bbolt
+// database, so that usage survives restarts.
+type quotas struct {
+	db      *bolt.DB
+	clients map[string]quotaClient // by API key
+	limits  quotaLimits            // of anonymousClient
+}
+
+func newQuotas(cfg quotaConfig) (*quotas, error) {
+	db, err := bolt.Open(cfg.Path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(quotaBucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, err
+	}
+	q := &quotas{db: db, clients: make(map[string]quotaClient, len(cfg.Clients)), limits: cfg.Anonymous}
+	for _, c := range cfg.Clients {
+		q.clients[c.APIKey] = c
+	}
+	return q, nil
+}
+
+// Close closes the database of the usage.
+func (q *quotas) Close() error {
+	return q.db.Close()
+}
+
+// client returns the name and limits of the client of r.
+func (q *quotas) client(r *http.Request) (string, quotaLimits) {
+	if c, ok := q.clients[r.Header.Get(apiKeyHeader)]; ok && c.APIKey != "" {
+		return c.Name, c.quotaLimits
+	}
+	return anonymousClient, q.limits
+}
+
+// usage returns the usage of client over the periods including now.
+func (q *quotas) usage(client string, limits quotaLimits, now time.Time) ([]quotaUsage, error) {
+	usage := make([]quotaUsage, len(quotaPeriods))
+	err := q.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(quotaBucket)
+		for i, p := range quotaPeriods {
+			u := quotaUsage{Period: p.Name, Start: now.Format(p.Layout)}
+			if data := b.Get(quotaKey(client, p.Name, u.Start)); data != nil {
+				if err := json.Unmarshal(data, &u); err != nil {
+					return err
+				}
+			}
+			u.RequestLimit, u.ByteLimit = limits.of(p.Name)
+			usage[i] = u
+		}
+		return nil
+	})
+	return usage, err
+}
+
+// record adds a request of n bytes to the usage of client.
+func (q *quotas) record(client string, n int64, now time.Time) error {
+	return q.db.Batch(func(tx *bolt.Tx) error {
+		b := tx.Bucket(quotaBucket)
+		for _, p := range quotaPeriods {
+			key := quotaKey(client, p.Name, now.Format(p.Layout))
+			u := quotaUsage{Period: p.Name, Start: now.Format(p.Layout)}
+			if data := b.Get(key); data != nil {
+				if err := json.Unmarshal(data, &u); err != nil {
+					return err
+				}
+			}
+			u.Requests++
+			u.Bytes += n
+			data, err := json.Marshal(u)
+			if err != nil {
+				return err
+			}
+			if err := b.Put(key, data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func quotaKey(client, period, start string) []byte {
+	return []byte(client + "|" + period + "|" + start)
+}
+
+// of returns the request and byte limits of the period named period.
+func (l quotaLimits) of(period string) (requests, bytes int64) {
+	if period == "day" {
+		return l.DailyRequests, l.DailyBytes
+	}
+	return l.MonthlyRequests, l.MonthlyBytes
+}
+
+type quotaClientKey struct{}
+
+// quotaClientFrom returns the name of the client metered by withQuotas.
+func quotaClientFrom(ctx context.Context) (string, bool) {
+	client, ok := ctx.Value(quotaClientKey{}).(string)
+	return client, ok
+}
+
+// withQuotas rejects the requests of clients over their quota, with a
+// Retry-After at the end of the exceeded period, and meters the others
+// once served. /usage answers the usage of the client itself and is not
+// metered.
+func withQuotas(next http.Handler, q *quotas, logger log.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		client, limits := q.client(r)
+		now := time.Now().UTC()
+		usage, err := q.usage(client, limits, now)
+		if err != nil {
+			encodeError(r.Context(), err, w)
+			return
+		}
+		if r.URL.Path == "/usage" {
+			encodeResponse(r.Context(), w, map[string]interface{}{"client": client, "usage": usage})
+			return
+		}
+		for i, u := range usage {
+			if (u.RequestLimit > 0 && u.Requests >= u.RequestLimit) || (u.ByteLimit > 0 && u.Bytes >= u.ByteLimit) {
+				retryAfter := quotaPeriods[i].Next(now).Sub(now)
+				w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+				encodeError(r.Context(), ErrQuotaExceeded, w)
+				return
+			}
+		}
+
+		body := &countingReader{ReadCloser: r.Body}
+		r.Body = body
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), quotaClientKey{}, client)))
+		if err := q.record(client, body.n, now); err != nil {
+			level.Error(logger).Log("client", client, "msg", "cannot record usage", "err", err)
+		}
+	})
+}
+
+// countingReader counts the bytes read from a request body.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
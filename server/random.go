@@ -0,0 +1,174 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+//
+// ──────────────────────────────────────────────────── I ──────────
+//   :::::: R A N D O M : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────────
+//
+
+// Per-request limits for Random, so a single call cannot keep the CPU busy
+// generating megabytes of random data.
+const (
+	maxRandomLength     = 1024
+	maxRandomCount      = 100
+	defaultRandomLength = 32
+)
+
+// randomClasses are the character classes that can be combined in the
+// "classes" field. "alnum" is used when none is given.
+var randomClasses = map[string]string{
+	"lower":   "abcdefghijklmnopqrstuvwxyz",
+	"upper":   "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	"digits":  "0123456789",
+	"alnum":   "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789",
+	"hex":     "0123456789abcdef",
+	"symbols": "!#$%&()*+,-./:;<=>?@[]^_{|}~",
+}
+
+type randomRequest struct {
+	Length  int      `json:"length"`
+	Classes []string `json:"classes" validate:"max=16"`
+	Count   int      `json:"count"`
+}
+
+type randomResponse struct {
+	V   []string `json:"v"`
+	Err string   `json:"err,omitempty"`
+}
+
+// Random returns count strings of the given length, drawn uniformly from the
+// union of the requested character classes using crypto/rand.
+func (stringService) Random(ctx context.Context, length int, classes []string, count int) ([]string, error) {
+	if length == 0 {
+		length = defaultRandomLength
+	}
+	if count == 0 {
+		count = 1
+	}
+	if length < 0 || length > maxRandomLength {
+		return nil, errorf(CodeOutOfRange, "Length must be between 1 and %d", maxRandomLength)
+	}
+	if count < 0 || count > maxRandomCount {
+		return nil, errorf(CodeOutOfRange, "Count must be between 1 and %d", maxRandomCount)
+	}
+	if len(classes) == 0 {
+		classes = []string{"alnum"}
+	}
+
+	var alphabet []byte
+	seen := make(map[byte]bool)
+	for _, c := range classes {
+		chars, ok := randomClasses[c]
+		if !ok {
+			return nil, errorf(CodeUnknownOption, "Unknown character class %q", c)
+		}
+		for i := 0; i < len(chars); i++ {
+			if !seen[chars[i]] {
+				seen[chars[i]] = true
+				alphabet = append(alphabet, chars[i])
+			}
+		}
+	}
+
+	out := make([]string, count)
+	for i := range out {
+		s, err := randomString(alphabet, length)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = s
+	}
+	return out, nil
+}
+
+// randomString draws length bytes from alphabet. Random bytes that would
+// bias the distribution (those past the largest multiple of len(alphabet))
+// are rejected and redrawn.
+func randomString(alphabet []byte, length int) (string, error) {
+	if len(alphabet) == 0 || len(alphabet) > 256 {
+		return "", errorf(CodePatternInvalid, "Invalid alphabet")
+	}
+	limit := 256 - 256%len(alphabet)
+	var (
+		b   strings.Builder
+		buf = make([]byte, length)
+	)
+	b.Grow(length)
+	for b.Len() < length {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, x := range buf {
+			if int(x) >= limit {
+				continue
+			}
+			b.WriteByte(alphabet[int(x)%len(alphabet)])
+			if b.Len() == length {
+				break
+			}
+		}
+	}
+	return b.String(), nil
+}
+
+func makeRandomEndpoint(svc IStringService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(randomRequest)
+		v, err := svc.Random(ctx, req.Length, req.Classes, req.Count)
+		if err != nil {
+			return randomResponse{nil, err.Error()}, nil
+		}
+		return randomResponse{v, ""}, nil
+	}
+}
+
+func decodeRandomRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request randomRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+//
+// ─── LOGGING ────────────────────────────────────────────────────────────────────
+//
+
+// The generated strings are secrets and are deliberately not logged.
+func (mw loggingMiddleware) Random(ctx context.Context, length int, classes []string, count int) (output []string, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "random",
+			"tenant", tenantFrom(ctx),
+			"length", length,
+			"classes", strings.Join(classes, ","),
+			"count", count,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	output, err = mw.next.Random(ctx, length, classes, count)
+	return
+}
+
+//
+// ─── INSTRUMENTATION ────────────────────────────────────────────────────────────
+//
+
+func (mw instrumentingMiddleware) Random(ctx context.Context, length int, classes []string, count int) (output []string, err error) {
+	done := mw.begin(ctx, "random", "")
+	defer func() { done(-1, err) }()
+
+	output, err = mw.next.Random(ctx, length, classes, count)
+	return
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/saintfish/chardet"
+	"golang.org/x/text/encoding/ianaindex"
+)
+
+//
+// ──────────────────────────────────────────────────── I ──────────
+//   :::::: R E C O D E : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────────
+//
+
+// charsetDetector guesses the charset of text from the byte patterns of
+// the languages usually written with each charset. It is safe for
+// concurrent use.
+var charsetDetector = chardet.NewTextDetector()
+
+type recodeRequest struct {
+	// S is the standard base64 encoding of the text to convert.
+	S string `json:"s"`
+	// Charset is the IANA name of the charset of the text, like
+	// "windows-1252" or "Shift_JIS". It is detected when empty.
+	Charset string `json:"charset" validate:"max=64,charset=printable"`
+}
+
+type recodeResponse struct {
+	V string `json:"v"`
+	// Charset is the IANA name of the charset converted from.
+	Charset string `json:"charset"`
+	// Confidence is between 0 and 1, and 1 when the charset was given.
+	Confidence float64 `json:"confidence"`
+	Err        string  `json:"err,omitempty"`
+}
+
+// Recode converts s, standard base64, from charset to UTF-8. The charset
+// is detected when empty; detection needs a few dozen bytes of text to be
+// reliable, and cannot tell apart charsets that encode the text the same.
+func (stringService) Recode(ctx context.Context, s, charset string) (output, detected string, confidence float64, err error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", "", 0, malformedInputError{"base64", err}
+	}
+	detected, confidence = charset, 1
+	if charset == "" {
+		r, err := charsetDetector.DetectBest(b)
+		if err != nil {
+			return "", "", 0, errorf(CodeUnsupportedInput, "Cannot detect the charset")
+		}
+		detected, confidence = r.Charset, float64(r.Confidence)/100
+	}
+
+	if strings.EqualFold(detected, "UTF-8") {
+		if !utf8.Valid(b) {
+			return "", "", 0, malformedInputError{detected, errors.New("invalid UTF-8")}
+		}
+		return string(b), detected, confidence, nil
+	}
+	enc, err := ianaindex.IANA.Encoding(detected)
+	if err != nil || enc == nil {
+		return "", "", 0, errorf(CodeUnsupportedInput, "Unsupported charset %q", detected)
+	}
+	out, err := enc.NewDecoder().Bytes(b)
+	if err != nil {
+		return "", "", 0, malformedInputError{detected, err}
+	}
+	return string(out), detected, confidence, nil
+}
+
+func makeRecodeEndpoint(svc IStringService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(recodeRequest)
+		v, charset, confidence, err := svc.Recode(ctx, req.S, req.Charset)
+		if err != nil {
+			return recodeResponse{"", "", 0, err.Error()}, nil
+		}
+		return recodeResponse{v, charset, confidence, ""}, nil
+	}
+}
+
+func decodeRecodeRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request recodeRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+//
+// ─── LOGGING ────────────────────────────────────────────────────────────────────
+//
+
+func (mw loggingMiddleware) Recode(ctx context.Context, s, charset string) (output, detected string, confidence float64, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "recode",
+			"tenant", tenantFrom(ctx),
+			"input_size", len(s),
+			"charset", charset,
+			"detected", detected,
+			"confidence", confidence,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	output, detected, confidence, err = mw.next.Recode(ctx, s, charset)
+	return
+}
+
+//
+// ─── INSTRUMENTATION ────────────────────────────────────────────────────────────
+//
+
+func (mw instrumentingMiddleware) Recode(ctx context.Context, s, charset string) (output, detected string, confidence float64, err error) {
+	done := mw.begin(ctx, "recode", s)
+	defer func() { done(len(output), err) }()
+
+	output, detected, confidence, err = mw.next.Recode(ctx, s, charset)
+	return
+}
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+//
+// ──────────────────────────────────────────────────── I ──────────
+//   :::::: R E D A C T : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────────
+//
+
+// RedactionDetector finds one kind of sensitive data. Matches of Pattern
+// are only reported when Validate, if set, accepts them.
+type RedactionDetector struct {
+	Pattern  *regexp.Regexp
+	Validate func(match string) bool
+}
+
+// redactionDetectors holds every kind of data Redact looks for, keyed by
+// the name reported in findings and accepted in the "types" field.
+var redactionDetectors = map[string]RedactionDetector{
+	"email": {
+		Pattern: regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9\-]+(?:\.[A-Za-z0-9\-]+)*\.[A-Za-z]{2,}`),
+	},
+	"phone": {
+		Pattern:  regexp.MustCompile(`(?:\+\d{1,3}[ .\-]?)?(?:\(\d{1,4}\)[ .\-]?)?\d{2,4}(?:[ .\-]?\d{2,4}){1,3}\b`),
+		Validate: func(m string) bool { n := countDigits(m); return n >= 7 && n <= 15 },
+	},
+	"credit_card": {
+		Pattern:  regexp.MustCompile(`\b(?:\d[ \-]?){12,18}\d\b`),
+		Validate: luhnValid,
+	},
+}
+
+// RegisterRedactionDetector makes a new kind of data known to /redact. It
+// is meant to be called before the server starts, from init functions or
+// with the custom patterns of the config file.
+func RegisterRedactionDetector(name string, d RedactionDetector) {
+	redactionDetectors[name] = d
+}
+
+// RedactionFinding is a sensitive value that Redact masked.
+type RedactionFinding struct {
+	Type string `json:"type"`
+	// Start and End are byte offsets in the original string.
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+type redactRequest struct {
+	S string `json:"s"`
+	// Types restricts the detectors to run. All of them run by default.
+	Types []string `json:"types" validate:"max=64"`
+	Mask  string   `json:"mask" validate:"max=16"`
+}
+
+type redactResponse struct {
+	V        string             `json:"v"`
+	Findings []RedactionFinding `json:"findings"`
+	Err      string             `json:"err,omitempty"`
+}
+
+// Redact replaces every rune of each finding with mask ("*" by default).
+// When findings overlap, the one starting first wins, then the longest.
+func (stringService) Redact(ctx context.Context, s string, types []string, mask string) (string, []RedactionFinding, error) {
+	if mask == "" {
+		mask = "*"
+	}
+	if len(types) == 0 {
+		for name := range redactionDetectors {
+			types = append(types, name)
+		}
+	}
+
+	var found []RedactionFinding
+	for _, name := range types {
+		d, ok := redactionDetectors[name]
+		if !ok {
+			return "", nil, errorf(CodeUnknownOption, "Unknown redaction type %q", name)
+		}
+		for _, loc := range d.Pattern.FindAllStringIndex(s, -1) {
+			if d.Validate == nil || d.Validate(s[loc[0]:loc[1]]) {
+				found = append(found, RedactionFinding{name, loc[0], loc[1]})
+			}
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].Start != found[j].Start {
+			return found[i].Start < found[j].Start
+		}
+		if found[i].End != found[j].End {
+			return found[i].End > found[j].End
+		}
+		return found[i].Type < found[j].Type
+	})
+
+	var b strings.Builder
+	findings := []RedactionFinding{}
+	last := 0
+	for _, f := range found {
+		if f.Start < last {
+			continue
+		}
+		b.WriteString(s[last:f.Start])
+		b.WriteString(strings.Repeat(mask, utf8.RuneCountInString(s[f.Start:f.End])))
+		findings = append(findings, f)
+		last = f.End
+	}
+	b.WriteString(s[last:])
+	return b.String(), findings, nil
+}
+
+func countDigits(s string) int {
+	n := 0
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			n++
+		}
+	}
+	return n
+}
+
+// luhnValid reports whether the digits of s form a card number, 13 to 19
+// digits long with a valid Luhn check digit.
+func luhnValid(s string) bool {
+	var sum, n int
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n >= 13 && n <= 19 && sum%10 == 0
+}
+
+func makeRedactEndpoint(svc IStringService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(redactRequest)
+		v, findings, err := svc.Redact(ctx, req.S, req.Types, req.Mask)
+		if err != nil {
+			return redactResponse{"", nil, err.Error()}, nil
+		}
+		return redactResponse{v, findings, ""}, nil
+	}
+}
+
+func decodeRedactRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request redactRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+//
+// ─── LOGGING ────────────────────────────────────────────────────────────────────
+//
+
+// The input is never logged, only what was found in it.
+func (mw loggingMiddleware) Redact(ctx context.Context, s string, types []string, mask string) (output string, findings []RedactionFinding, err error) {
+	defer func(begin time.Time) {
+		found := make([]string, len(findings))
+		for i, f := range findings {
+			found[i] = f.Type
+		}
+		mw.logger.Log(
+			"method", "redact",
+			"tenant", tenantFrom(ctx),
+			"input_size", len(s),
+			"types", strings.Join(types, ","),
+			"findings", strings.Join(found, ","),
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	output, findings, err = mw.next.Redact(ctx, s, types, mask)
+	return
+}
+
+//
+// ─── INSTRUMENTATION ────────────────────────────────────────────────────────────
+//
+
+func (mw instrumentingMiddleware) Redact(ctx context.Context, s string, types []string, mask string) (output string, findings []RedactionFinding, err error) {
+	done := mw.begin(ctx, "redact", s)
+	defer func() { done(len(output), err) }()
+
+	output, findings, err = mw.next.Redact(ctx, s, types, mask)
+	return
+}
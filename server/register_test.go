@@ -0,0 +1,139 @@
+package server_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
+
+	"github.com/anhle128/gokit-stringsvc/server"
+)
+
+// upperService only serves Uppercase.
+type upperService struct {
+	server.IStringService
+}
+
+func (upperService) Uppercase(_ context.Context, s string) (string, error) {
+	return strings.ToUpper(s), nil
+}
+
+// discardMetrics makes metrics that record nothing.
+type discardMetrics struct{}
+
+func (discardMetrics) NewCounter(string, string, []string) metrics.Counter {
+	return discard.NewCounter()
+}
+
+func (discardMetrics) NewGauge(string, string, []string) metrics.Gauge {
+	return discard.NewGauge()
+}
+
+func (discardMetrics) NewLatencyHistogram(string, string, []string) metrics.Histogram {
+	return discard.NewHistogram()
+}
+
+func (discardMetrics) NewSizeHistogram(string, string, []string) metrics.Histogram {
+	return discard.NewHistogram()
+}
+
+func (discardMetrics) NewSummary(string, string, []string) metrics.Histogram {
+	return discard.NewHistogram()
+}
+
+// memoryAuditSink keeps the records written.
+type memoryAuditSink struct {
+	mu      sync.Mutex
+	records []server.AuditRecord
+}
+
+func (s *memoryAuditSink) Write(rec server.AuditRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, rec)
+	return nil
+}
+
+func (*memoryAuditSink) Close() error { return nil }
+
+// staticFlagProvider serves the same flags every time.
+type staticFlagProvider map[string]server.FlagConfig
+
+func (p staticFlagProvider) Flags(context.Context) (map[string]server.FlagConfig, error) {
+	return p, nil
+}
+
+// The extension points are registered from outside of the package, as
+// their callers do.
+func TestRegister(t *testing.T) {
+	sink := &memoryAuditSink{}
+	server.RegisterAuditSink("memory", func(server.AuditConfig) (server.AuditSink, error) {
+		return sink, nil
+	})
+	server.RegisterFlagProvider("static", func(server.FlagsConfig) (server.FlagProvider, error) {
+		return staticFlagProvider{"operation.uppercase": {Enabled: false}}, nil
+	})
+	server.RegisterBodyCodec("text/plain", server.BodyCodec{
+		Marshal: func(v interface{}) ([]byte, error) {
+			return []byte(fmt.Sprint(v.(map[string]interface{})["v"])), nil
+		},
+	})
+	server.RegisterSanitizePolicy("headings", server.SanitizePolicy{
+		Tags: map[string][]string{"h1": nil, "h2": nil},
+	})
+	server.RegisterRedactionDetector("ticket", server.RedactionDetector{
+		Pattern: regexp.MustCompile(`\bTICKET-\d+\b`),
+	})
+
+	tests := []struct {
+		name       string
+		config     string
+		accept     string
+		wantStatus int
+		wantBody   string
+		wantAudit  int
+	}{
+		{name: "body codec", config: `{}`, accept: "text/plain", wantStatus: 200, wantBody: "A"},
+		{name: "audit sink", config: `{"audit": {"sink": "memory"}}`, wantStatus: 200, wantAudit: 1},
+		{name: "flag provider", config: `{"flags": {"provider": "static"}}`, wantStatus: 403},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink.records = nil
+			s, err := server.New(
+				server.WithConfigJSON([]byte(tt.config)),
+				server.WithService(upperService{}),
+				server.WithLogOutput(io.Discard),
+				server.WithMetricsProvider(discardMetrics{}),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Shutdown(context.Background())
+
+			r := httptest.NewRequest(http.MethodPost, "/uppercase", strings.NewReader(`{"s":"a"}`))
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			s.Handler().ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Errorf("body %q, want %q", w.Body, tt.wantBody)
+			}
+			if len(sink.records) != tt.wantAudit {
+				t.Errorf("%d audit records, want %d", len(sink.records), tt.wantAudit)
+			}
+		})
+	}
+}
@@ -0,0 +1,233 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/go-kit/kit/metrics"
+)
+
+//
+// ──────────────────────────────────────────────────── I ──────────
+//   :::::: R E L O A D : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────────
+//
+
+// reloadStep checks the part of a new configuration that can change without
+// restart, and returns the function applying it. Steps do not change
+// anything themselves, so that a configuration is applied whole or not at
+// all.
+type reloadStep func(cfg config) (apply func(), err error)
+
+// reloader applies the configuration file again on SIGHUP, and when it
+// changes if watching. A configuration failing a step is rejected, and the
+// current one stays active.
+type reloader struct {
+	path    string
+	steps   []reloadStep
+	reloads metrics.Counter
+	logger  log.Logger
+	// stop ends run.
+	stop chan struct{}
+}
+
+func newReloader(path string, reloads metrics.Counter, logger log.Logger, steps ...reloadStep) *reloader {
+	return &reloader{path: path, steps: steps, reloads: reloads, logger: logger, stop: make(chan struct{})}
+}
+
+func (r *reloader) reload() error {
+	cfg, err := loadConfig(r.path)
+	if err != nil {
+		return err
+	}
+	applies := make([]func(), 0, len(r.steps))
+	for _, step := range r.steps {
+		apply, err := step(cfg)
+		if err != nil {
+			return err
+		}
+		applies = append(applies, apply)
+	}
+	for _, apply := range applies {
+		apply()
+	}
+	return nil
+}
+
+// run reloads on SIGHUP, and every time the modification time of the file
+// changes when watch is positive, until closed.
+func (r *reloader) run(watch time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	modified := r.modified()
+	if watch > 0 {
+		ticker := time.NewTicker(watch)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-hup:
+		case <-tick:
+			m := r.modified()
+			if m.Equal(modified) {
+				continue
+			}
+			modified = m
+		}
+		if err := r.reload(); err != nil {
+			r.reloads.With("result", "rejected").Add(1)
+			level.Error(r.logger).Log("msg", "configuration rejected, keeping the current one", "path", r.path, "err", err)
+			continue
+		}
+		r.reloads.With("result", "applied").Add(1)
+		level.Info(r.logger).Log("msg", "configuration reloaded", "path", r.path)
+	}
+}
+
+// close stops run.
+func (r *reloader) close() {
+	close(r.stop)
+}
+
+func (r *reloader) modified() time.Time {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// reloadLogLevel sets the level of l to that of the configuration, which
+// overrides the one set at /admin/loglevel.
+func reloadLogLevel(l *logLevel) reloadStep {
+	return func(cfg config) (func(), error) {
+		name := cfg.Log.Level
+		if name == "" {
+			name = "info"
+		}
+		if _, ok := severity(name); !ok {
+			return nil, fmt.Errorf("%v %q", ErrUnknownLogLevel, name)
+		}
+		return func() { l.Set(name) }, nil
+	}
+}
+
+// reloadTenants replaces the tenants of t with those of the configuration,
+// keeping their rate limiters. Tenants read from a file are reloaded from
+// the file named by the new configuration, which keeps the reload interval
+// of the first one.
+func reloadTenants(t *tenancy, logger log.Logger) reloadStep {
+	return func(cfg config) (func(), error) {
+		var store tenantStore = staticTenantStore(cfg.Tenancy.Tenants)
+		if cfg.Tenancy.File != "" {
+			store = fileTenantStore(cfg.Tenancy.File)
+		}
+		if _, err := store.Tenants(); err != nil {
+			return nil, fmt.Errorf("cannot load tenants: %w", err)
+		}
+		return func() {
+			if err := t.setStore(store); err != nil {
+				level.Error(logger).Log("msg", "cannot reload tenants", "err", err)
+			}
+		}, nil
+	}
+}
+
+// reloadChaos replaces the faults injected by c, which overrides those set
+// at /admin/chaos.
+func reloadChaos(c *chaos) reloadStep {
+	return func(cfg config) (func(), error) {
+		if err := validateRequest(cfg.Chaos); err != nil {
+			return nil, fmt.Errorf("invalid chaos config: %w", err)
+		}
+		return func() { c.set(cfg.Chaos) }, nil
+	}
+}
+
+// reloadFlags replaces the flags of the configuration of f.
+func reloadFlags(f *featureFlags) reloadStep {
+	return func(cfg config) (func(), error) {
+		if err := validateFlags(cfg.Flags.Flags); err != nil {
+			return nil, err
+		}
+		return func() { f.setStatic(cfg.Flags.Flags) }, nil
+	}
+}
+
+// reloadRouting replaces the weights of the implementations of r.
+func reloadRouting(r *implementationRouter) reloadStep {
+	return func(cfg config) (func(), error) {
+		if _, err := r.parseWeights(cfg.Routing); err != nil {
+			return nil, fmt.Errorf("invalid routing config: %w", err)
+		}
+		return func() { r.set(cfg.Routing) }, nil
+	}
+}
+
+// reloadEffectiveConfig keeps the configuration applied last in p, for the
+// admin listener to show.
+func reloadEffectiveConfig(p *atomic.Pointer[config]) reloadStep {
+	return func(cfg config) (func(), error) {
+		return func() { p.Store(&cfg) }, nil
+	}
+}
+
+// certificate is the certificate of the TLS listener, which can be
+// replaced while serving.
+type certificate struct {
+	current atomic.Pointer[tls.Certificate]
+}
+
+func loadCertificate(cfg tlsConfig) (*tls.Certificate, error) {
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return nil, errors.New("TLS needs both a cert_file and a key_file")
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+func newCertificate(cfg tlsConfig) (*certificate, error) {
+	cert, err := loadCertificate(cfg)
+	if err != nil {
+		return nil, err
+	}
+	c := new(certificate)
+	c.current.Store(cert)
+	return c, nil
+}
+
+// GetCertificate is the tls.Config callback of the listener.
+func (c *certificate) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.current.Load(), nil
+}
+
+// reloadCertificate reads the certificate and key files of the
+// configuration again, so that renewed certificates are served to new
+// connections. The listener cannot be switched between TLS and plain HTTP
+// without restart.
+func reloadCertificate(c *certificate) reloadStep {
+	return func(cfg config) (func(), error) {
+		cert, err := loadCertificate(cfg.HTTP.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TLS config: %w", err)
+		}
+		return func() { c.current.Store(cert) }, nil
+	}
+}
@@ -0,0 +1,400 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+//
+// ──────────────────────────────────────────────────── I ──────────
+//   :::::: R E N D E R : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────────
+//
+
+// Limits applied to every template execution.
+const (
+	maxTemplateSize = 64 << 10
+	maxRenderOutput = 1 << 20
+	renderTimeout   = 2 * time.Second
+	// maxRenderIterations bounds the iterations of ranges and the calls of
+	// templates of an execution, together.
+	maxRenderIterations = 100000
+	// maxRangeDepth bounds the nesting of ranges.
+	maxRangeDepth = 3
+	// maxFormatWidth bounds the widths and precisions of the verbs of
+	// printf, which fmt pads up to a million bytes each, and for every
+	// element of the slices and maps formatted, before the output is
+	// written anywhere.
+	maxFormatWidth = 1 << 10
+)
+
+var (
+	// ErrRenderTimeout is returned when a template runs for too long.
+	ErrRenderTimeout = errors.New("Template execution timed out")
+	// ErrRenderOutputTooLarge is returned when a template produces more
+	// than maxRenderOutput bytes.
+	ErrRenderOutputTooLarge = errors.New("Template output too large")
+	// ErrRenderTooManyIterations is returned when a template loops more than
+	// maxRenderIterations times.
+	ErrRenderTooManyIterations = errors.New("Template loops too many times")
+)
+
+// renderTickFunc is the function called by the templates at every iteration
+// of their ranges and at every call of a template, see boundTemplate.
+const renderTickFunc = "renderTick"
+
+// renderTick is the action calling renderTickFunc.
+var renderTick = template.Must(template.New("tick").Funcs(template.FuncMap{
+	renderTickFunc: func() string { return "" },
+}).Parse("{{" + renderTickFunc + "}}")).Tree.Root.Nodes[0]
+
+// templateFuncs is the allow-list of functions templates may call on top
+// of the text/template builtins. The builtins are harmless here: data comes
+// from JSON, so there are no function values for "call" to invoke. Only
+// printf and its siblings are replaced, so that their output is bounded.
+var templateFuncs = template.FuncMap{
+	"printf": func(format string, args ...interface{}) (string, error) {
+		if err := checkFormatWidths(format, args); err != nil {
+			return "", err
+		}
+		return boundedOutput(fmt.Sprintf(format, args...))
+	},
+	"print": func(args ...interface{}) (string, error) {
+		return boundedOutput(fmt.Sprint(args...))
+	},
+	"println": func(args ...interface{}) (string, error) {
+		return boundedOutput(fmt.Sprintln(args...))
+	},
+	"upper":     strings.ToUpper,
+	"lower":     strings.ToLower,
+	"trim":      strings.TrimSpace,
+	"replace":   strings.ReplaceAll,
+	"contains":  strings.Contains,
+	"hasPrefix": strings.HasPrefix,
+	"hasSuffix": strings.HasSuffix,
+	"repeat": func(s string, n int) (string, error) {
+		if n < 0 || len(s)*n > maxRenderOutput {
+			return "", ErrRenderOutputTooLarge
+		}
+		return strings.Repeat(s, n), nil
+	},
+	"join": func(items []interface{}, sep string) string {
+		parts := make([]string, len(items))
+		for i, v := range items {
+			parts[i] = fmt.Sprint(v)
+		}
+		return strings.Join(parts, sep)
+	},
+	"default": func(def, v interface{}) interface{} {
+		if v == nil || v == "" {
+			return def
+		}
+		return v
+	},
+}
+
+// boundedOutput fails when s is longer than the output of a template may
+// be.
+func boundedOutput(s string) (string, error) {
+	if len(s) > maxRenderOutput {
+		return "", ErrRenderOutputTooLarge
+	}
+	return s, nil
+}
+
+// checkFormatWidths rejects the formats of printf with widths or precisions
+// over maxFormatWidth, given in the format or as arguments with "*", and
+// those that would pad args past maxRenderOutput.
+func checkFormatWidths(format string, args []interface{}) error {
+	padding, arg := 0, 0
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		i++
+		for i < len(format) && strings.IndexByte("+-# 0", format[i]) >= 0 {
+			i++
+		}
+		// The width, then the precision after a '.'.
+		widest := 0
+		for part := 0; part < 2 && i < len(format); part++ {
+			if part == 1 {
+				if format[i] != '.' {
+					break
+				}
+				i++
+			}
+			arg, i = formatArgIndex(format, i, arg)
+			n := 0
+			if i < len(format) && format[i] == '*' {
+				if arg < len(args) {
+					n = formatIntArg(args[arg])
+				}
+				i, arg = i+1, arg+1
+			}
+			for ; i < len(format) && '0' <= format[i] && format[i] <= '9' && n <= maxFormatWidth; i++ {
+				n = n*10 + int(format[i]-'0')
+			}
+			if n > maxFormatWidth || n < -maxFormatWidth {
+				return errorf(CodeLimitExceeded, "Widths and precisions of printf cannot exceed %d", maxFormatWidth)
+			}
+			widest = maxInt(widest, maxInt(n, -n))
+		}
+		arg, i = formatArgIndex(format, i, arg)
+		if i >= len(format) || format[i] == '%' {
+			continue
+		}
+		if widest > 0 && arg < len(args) {
+			if padding += widest * formatLeaves(args[arg], maxRenderOutput/widest+1); padding > maxRenderOutput {
+				return ErrRenderOutputTooLarge
+			}
+		}
+		arg++
+	}
+	return nil
+}
+
+// formatArgIndex skips the explicit argument index at format[i], like
+// "[2]", returning the index of the argument it names, and arg when there
+// is none.
+func formatArgIndex(format string, i, arg int) (int, int) {
+	if i >= len(format) || format[i] != '[' {
+		return arg, i
+	}
+	end := strings.IndexByte(format[i:], ']')
+	if end < 0 {
+		return arg, i
+	}
+	n, err := strconv.Atoi(format[i+1 : i+end])
+	if err != nil || n < 1 {
+		return arg, i + end + 1
+	}
+	return n - 1, i + end + 1
+}
+
+// formatIntArg returns the width or precision given by v to a "*", which fmt
+// takes from integers only.
+func formatIntArg(v interface{}) int {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n := rv.Int(); n > maxFormatWidth || n < -maxFormatWidth {
+			return maxFormatWidth + 1
+		}
+		return int(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if rv.Uint() > maxFormatWidth {
+			return maxFormatWidth + 1
+		}
+		return int(rv.Uint())
+	}
+	return 0
+}
+
+// formatLeaves counts the values fmt pads when formatting v, up to limit:
+// the keys and elements of its maps and slices, and v itself otherwise.
+func formatLeaves(v interface{}, limit int) int {
+	rv := reflect.ValueOf(v)
+	n := 0
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len() && n < limit; i++ {
+			n += formatLeaves(rv.Index(i).Interface(), limit-n)
+		}
+	case reflect.Map:
+		for iter := rv.MapRange(); iter.Next() && n < limit; {
+			n += formatLeaves(iter.Key().Interface(), limit-n) + formatLeaves(iter.Value().Interface(), limit-n)
+		}
+	default:
+		n = 1
+	}
+	return n
+}
+
+type renderRequest struct {
+	S    string                 `json:"s"`
+	Data map[string]interface{} `json:"data"`
+}
+
+type renderResponse struct {
+	V   string `json:"v"`
+	Err string `json:"err,omitempty"`
+}
+
+// Render executes the text/template s against data. Execution is bounded in
+// time, output size and iterations; text/template cannot be interrupted, so
+// the output writer and the ticks of boundTemplate are what abort a run
+// once a limit is reached.
+func (stringService) Render(ctx context.Context, s string, data map[string]interface{}) (string, error) {
+	if len(s) > maxTemplateSize {
+		return "", ErrTooLarge
+	}
+	ctx, cancel := context.WithTimeout(ctx, renderTimeout)
+	defer cancel()
+
+	// The ticks all run on the goroutine of Execute.
+	iterations := 0
+	tick := func() (string, error) {
+		if iterations++; iterations > maxRenderIterations {
+			return "", ErrRenderTooManyIterations
+		}
+		return "", ctx.Err()
+	}
+	tmpl, err := template.New("render").Funcs(templateFuncs).Funcs(template.FuncMap{renderTickFunc: tick}).Option("missingkey=zero").Parse(s)
+	if err != nil {
+		return "", err
+	}
+	for _, t := range tmpl.Templates() {
+		if err := boundTemplate(t.Tree.Root, 0); err != nil {
+			return "", err
+		}
+		t.Tree.Root.Nodes = append([]parse.Node{renderTick.Copy()}, t.Tree.Root.Nodes...)
+	}
+
+	w := &boundedWriter{ctx: ctx, limit: maxRenderOutput}
+	done := make(chan error, 1)
+	go func() { done <- tmpl.Execute(w, data) }()
+
+	select {
+	case err := <-done:
+		switch {
+		case errors.Is(err, context.DeadlineExceeded):
+			return "", ErrRenderTimeout
+		case errors.Is(err, ErrRenderTooManyIterations):
+			return "", ErrRenderTooManyIterations
+		case err != nil:
+			return "", err
+		}
+		return w.b.String(), nil
+	case <-ctx.Done():
+		return "", ErrRenderTimeout
+	}
+}
+
+// boundTemplate rejects ranges over integer literals and ranges nested
+// deeper than maxRangeDepth, and makes every iteration of the other ranges
+// call renderTickFunc first. Templates can loop without writing anything,
+// over the integers or the data they compute, over nested ranges or by
+// calling themselves, so that the ticks, with one at the start of every
+// template, are what stops them.
+func boundTemplate(node parse.Node, depth int) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, c := range n.Nodes {
+			if err := boundTemplate(c, depth); err != nil {
+				return err
+			}
+		}
+	case *parse.RangeNode:
+		for _, cmd := range n.Pipe.Cmds {
+			for _, arg := range cmd.Args {
+				if _, ok := arg.(*parse.NumberNode); ok {
+					return errorf(CodePatternInvalid, "Ranging over a number is not allowed")
+				}
+			}
+		}
+		if depth+1 > maxRangeDepth {
+			return errorf(CodeLimitExceeded, "Ranges cannot be nested more than %d deep", maxRangeDepth)
+		}
+		if n.List == nil {
+			n.List = &parse.ListNode{NodeType: parse.NodeList}
+		}
+		if err := boundTemplate(n.List, depth+1); err != nil {
+			return err
+		}
+		n.List.Nodes = append([]parse.Node{renderTick.Copy()}, n.List.Nodes...)
+		return boundTemplate(n.ElseList, depth)
+	case *parse.IfNode:
+		if err := boundTemplate(n.List, depth); err != nil {
+			return err
+		}
+		return boundTemplate(n.ElseList, depth)
+	case *parse.WithNode:
+		if err := boundTemplate(n.List, depth); err != nil {
+			return err
+		}
+		return boundTemplate(n.ElseList, depth)
+	}
+	return nil
+}
+
+// boundedWriter collects template output and fails once the limit or the
+// context deadline is reached, which makes the template execution stop.
+type boundedWriter struct {
+	ctx   context.Context
+	limit int
+	b     strings.Builder
+}
+
+func (w *boundedWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	if w.b.Len()+len(p) > w.limit {
+		return 0, ErrRenderOutputTooLarge
+	}
+	return w.b.Write(p)
+}
+
+func makeRenderEndpoint(svc IStringService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(renderRequest)
+		v, err := svc.Render(ctx, req.S, req.Data)
+		if err != nil {
+			return renderResponse{"", err.Error()}, nil
+		}
+		return renderResponse{v, ""}, nil
+	}
+}
+
+func decodeRenderRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request renderRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+//
+// ─── LOGGING ────────────────────────────────────────────────────────────────────
+//
+
+func (mw loggingMiddleware) Render(ctx context.Context, s string, data map[string]interface{}) (output string, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "render",
+			"tenant", tenantFrom(ctx),
+			"input", s,
+			"output_size", len(output),
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	output, err = mw.next.Render(ctx, s, data)
+	return
+}
+
+//
+// ─── INSTRUMENTATION ────────────────────────────────────────────────────────────
+//
+
+func (mw instrumentingMiddleware) Render(ctx context.Context, s string, data map[string]interface{}) (output string, err error) {
+	done := mw.begin(ctx, "render", s)
+	defer func() { done(len(output), err) }()
+
+	output, err = mw.next.Render(ctx, s, data)
+	return
+}
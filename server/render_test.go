@@ -0,0 +1,113 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderBounds(t *testing.T) {
+	long := strings.Repeat("a", 5000)
+	items := make([]interface{}, 5000)
+	for i := range items {
+		items[i] = i
+	}
+	tests := []struct {
+		name    string
+		tmpl    string
+		data    map[string]interface{}
+		want    string
+		wantErr error
+	}{
+		{
+			name: "range over data",
+			tmpl: `{{range .items}}{{.}},{{end}}`,
+			data: map[string]interface{}{"items": []interface{}{"a", "b"}},
+			want: "a,b,",
+		},
+		{
+			name: "nested ranges within bounds",
+			tmpl: `{{range .rows}}{{range .}}{{.}}{{end}};{{end}}`,
+			data: map[string]interface{}{"rows": []interface{}{[]interface{}{1, 2}, []interface{}{3}}},
+			want: "12;3;",
+		},
+		{
+			name:    "silent nested ranges over a computed length",
+			tmpl:    `{{range len .s}}{{range len $.s}}{{range len $.s}}{{end}}{{end}}{{end}}`,
+			data:    map[string]interface{}{"s": long},
+			wantErr: ErrRenderTooManyIterations,
+		},
+		{
+			name:    "silent nested ranges over data",
+			tmpl:    `{{range .items}}{{range $.items}}{{end}}{{end}}`,
+			data:    map[string]interface{}{"items": items},
+			wantErr: ErrRenderTooManyIterations,
+		},
+		{
+			name: "recursive templates",
+			tmpl: `{{define "a"}}{{template "a" .}}{{template "a" .}}{{end}}{{template "a" .}}`,
+		},
+		{
+			name: "ranges nested too deep",
+			tmpl: `{{range .a}}{{range .}}{{range .}}{{range .}}{{end}}{{end}}{{end}}{{end}}`,
+		},
+		{
+			name: "range over a number",
+			tmpl: `{{range 1000000000}}{{end}}`,
+		},
+		{
+			name: "printf within bounds",
+			tmpl: `{{printf "%05d|%-4s|%.2f|%*d" 42 "ab" 3.14159 3 7}}`,
+			want: "00042|ab  |3.14|  7",
+		},
+		{
+			name: "wide verb",
+			tmpl: `{{printf "%0999999d" 0}}`,
+		},
+		{
+			name: "wide verb past the limits of fmt",
+			tmpl: `{{printf "%0999999999d" 0}}`,
+		},
+		{
+			name: "wide precision",
+			tmpl: `{{$x := printf "%.999999f" 1.0}}`,
+		},
+		{
+			name: "width as an argument",
+			tmpl: `{{printf "%*d" 999999 1}}`,
+		},
+		{
+			name: "width as an indexed argument",
+			tmpl: `{{printf "%[2]*[1]d" 1 999999}}`,
+		},
+		{
+			name:    "width repeated over data",
+			tmpl:    `{{$x := printf "%1000v" .items}}`,
+			data:    map[string]interface{}{"items": items},
+			wantErr: ErrRenderOutputTooLarge,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			begin := time.Now()
+			got, err := stringService{}.Render(context.Background(), tt.tmpl, tt.data)
+			if took := time.Since(begin); took > renderTimeout {
+				t.Fatalf("Render took %v", took)
+			}
+			switch {
+			case tt.want != "":
+				if err != nil || got != tt.want {
+					t.Fatalf("Render = %q, %v, want %q", got, err, tt.want)
+				}
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Render error = %v, want %v", err, tt.wantErr)
+				}
+			case err == nil:
+				t.Fatalf("Render = %q, want an error", got)
+			}
+		})
+	}
+}
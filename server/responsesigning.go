@@ -0,0 +1,214 @@
+package server
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+//
+// ──────────────────────────────────────────────────────────────────────── I ──────────
+//   :::::: R E S P O N S E   S I G N I N G : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────────────────────────────
+//
+
+// Responses are signed so that clients can tell they were not changed by
+// proxies and other intermediaries. The X-Signature header holds a JWS
+// with detached payload (RFC 7515, appendix F), "HEADER..SIGNATURE", whose
+// protected header names the algorithm, EdDSA, and the ID of the key. The
+// payload is the canonical body: JSON bodies without insignificant white
+// space and with the fields of objects sorted, other bodies as they are.
+// So clients verify
+//
+//	ed25519.Verify(key, HEADER + "." + base64url(canonical body), SIGNATURE)
+//
+// with the key of the JWKS of /.well-known/jwks.json whose "kid" is that
+// of the header. Streams, flushed before their end, are not signed.
+
+// signatureHeader holds the signature of the responses.
+const signatureHeader = "X-Signature"
+
+// jwksRoute publishes the public keys signing the responses.
+const jwksRoute = "/.well-known/jwks.json"
+
+// responseSigner signs the responses with its active key.
+type responseSigner struct {
+	// header is the encoded protected header of the active key.
+	header string
+	key    ed25519.PrivateKey
+	public map[string]ed25519.PublicKey
+}
+
+// newResponseSigner returns the signer of cfg, nil when responses are not
+// signed.
+func newResponseSigner(cfg responseSigningConfig) (*responseSigner, error) {
+	if len(cfg.Keys) == 0 {
+		return nil, nil
+	}
+	s := &responseSigner{public: make(map[string]ed25519.PublicKey, len(cfg.Keys))}
+	for id, encoded := range cfg.Keys {
+		if id == "" {
+			return nil, errors.New("response signing key without ID")
+		}
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("response signing key %q: %v", id, err)
+		}
+		var key ed25519.PrivateKey
+		switch len(data) {
+		case ed25519.SeedSize:
+			key = ed25519.NewKeyFromSeed(data)
+		case ed25519.PrivateKeySize:
+			key = ed25519.PrivateKey(data)
+		default:
+			return nil, fmt.Errorf("response signing key %q is not a %d-byte seed nor a %d-byte key", id, ed25519.SeedSize, ed25519.PrivateKeySize)
+		}
+		s.public[id] = key.Public().(ed25519.PublicKey)
+		if id == cfg.ActiveKey {
+			s.key = key
+		}
+	}
+	if s.key == nil {
+		return nil, fmt.Errorf("unknown active response signing key %q", cfg.ActiveKey)
+	}
+	header, _ := json.Marshal(map[string]interface{}{"alg": "EdDSA", "kid": cfg.ActiveKey})
+	s.header = base64.RawURLEncoding.EncodeToString(header)
+	return s, nil
+}
+
+// Sign returns the detached JWS of body.
+func (s *responseSigner) Sign(body []byte) string {
+	input := s.header + "." + base64.RawURLEncoding.EncodeToString(canonicalBody(body))
+	return s.header + ".." + base64.RawURLEncoding.EncodeToString(ed25519.Sign(s.key, []byte(input)))
+}
+
+// canonicalBody returns body without insignificant white space and with
+// the fields of its objects sorted, if it is JSON.
+func canonicalBody(body []byte) []byte {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil || dec.More() {
+		return body
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return body
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+}
+
+// jwk is a public key of a JWKS (RFC 8037).
+type jwk struct {
+	KeyType string `json:"kty"`
+	Curve   string `json:"crv"`
+	KeyID   string `json:"kid"`
+	X       string `json:"x"`
+	Use     string `json:"use"`
+	Alg     string `json:"alg"`
+}
+
+// jwks answers the public keys of s, sorted by ID.
+func (s *responseSigner) jwks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	ids := make([]string, 0, len(s.public))
+	for id := range s.public {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	keys := make([]jwk, len(ids))
+	for i, id := range ids {
+		keys[i] = jwk{"OKP", "Ed25519", id, base64.RawURLEncoding.EncodeToString(s.public[id]), "sig", "EdDSA"}
+	}
+	w.Header().Set("Content-Type", "application/jwk-set+json")
+	w.Header().Set("Cache-Control", "max-age=3600")
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+}
+
+// withResponseSigning signs the responses of next with s. Responses are
+// held back until complete, so that their signature is sent in a header
+// before them.
+func withResponseSigning(next http.Handler, s *responseSigner) http.Handler {
+	if s == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Upgraded connections, like /ws, write their own framing.
+		if r.Header.Get("Upgrade") != "" || r.Method == http.MethodHead || isStreamRoute(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		sw := &signingWriter{ResponseWriter: w, signer: s, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+		sw.Close()
+	})
+}
+
+// signingWriter holds back a response to sign it. Flushing sends it
+// unsigned.
+type signingWriter struct {
+	http.ResponseWriter
+	signer *responseSigner
+	status int
+
+	buf  bytes.Buffer
+	sent bool
+}
+
+func (w *signingWriter) WriteHeader(status int) {
+	if w.sent {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.status = status
+}
+
+func (w *signingWriter) Write(p []byte) (int, error) {
+	if w.sent {
+		return w.ResponseWriter.Write(p)
+	}
+	return w.buf.Write(p)
+}
+
+// send writes the held back response, with its signature if sign.
+func (w *signingWriter) send(sign bool) {
+	w.sent = true
+	if sign && w.status != http.StatusNoContent && w.status != http.StatusNotModified {
+		w.Header().Set(signatureHeader, w.signer.Sign(w.buf.Bytes()))
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(w.buf.Bytes())
+	w.buf.Reset()
+}
+
+// Unwrap lets http.ResponseController reach the connection.
+func (w *signingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *signingWriter) Flush() {
+	if !w.sent {
+		w.send(false)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close sends the response, signed unless it was flushed.
+func (w *signingWriter) Close() {
+	if !w.sent {
+		w.send(true)
+	}
+}
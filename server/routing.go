@@ -0,0 +1,230 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/anhle128/gokit-stringsvc/registry"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/go-kit/kit/metrics"
+)
+
+//
+// ────────────────────────────────────────────────────── I ──────────
+//   :::::: R O U T I N G : :  :   :    :     :        :          :
+// ────────────────────────────────────────────────────────────────
+//
+
+// Operations can have alternate implementations, built in or registered
+// with registry.RegisterImplementation, like the rune count of "count".
+// The calls of an operation are split between its implementations by the
+// weights of the "routing" config section, and requests can choose theirs
+// with the X-Implementation header: "runes" calls the implementation
+// "runes" of every operation having one, "count=runes, hash=default" names
+// them by operation. Results are cached whichever implementation computed
+// them, so that requests choosing theirs should also skip the cache with
+// X-Cache-Bypass.
+
+// implementationHeader overrides the implementations of the operations
+// called by a request.
+const implementationHeader = "X-Implementation"
+
+// implementationMetrics are the metrics of the calls by operation and
+// implementation.
+type implementationMetrics struct {
+	// Calls counts the calls, and whether they failed in "error".
+	Calls   metrics.Counter
+	Latency metrics.Histogram
+}
+
+// weightedImplementation is an implementation and the upper bound of its
+// share of the cumulated weights of its operation.
+type weightedImplementation struct {
+	name  string
+	bound float64
+}
+
+// implementationRouter routes the calls of operations to their
+// implementations.
+type implementationRouter struct {
+	// alternates are the implementations besides the default one, by
+	// operation and name.
+	alternates map[string]map[string]endpoint.Endpoint
+	weights    atomic.Pointer[map[string][]weightedImplementation]
+	metrics    implementationMetrics
+	logger     log.Logger
+}
+
+// newImplementationRouter returns the router of the alternate
+// implementations of the built-in operations, alternates, and of the
+// registered ones, split by the weights of cfg.
+func newImplementationRouter(cfg routingConfig, alternates map[string]map[string]endpoint.Endpoint, mw instrumentingMiddleware, m implementationMetrics, logger log.Logger) (*implementationRouter, error) {
+	r := &implementationRouter{
+		alternates: make(map[string]map[string]endpoint.Endpoint),
+		metrics:    m,
+		logger:     logger,
+	}
+	for op, impls := range alternates {
+		r.alternates[op] = make(map[string]endpoint.Endpoint, len(impls))
+		for name, e := range impls {
+			r.alternates[op][name] = e
+		}
+	}
+	for op, impls := range registry.Implementations() {
+		if r.alternates[op] == nil {
+			r.alternates[op] = make(map[string]endpoint.Endpoint, len(impls))
+		}
+		for name, h := range impls {
+			if _, ok := r.alternates[op][name]; ok {
+				return nil, fmt.Errorf("registered implementation %s of %s is a built-in one", name, op)
+			}
+			r.alternates[op][name] = mw.instrumented(op, validated(endpoint.Endpoint(h)))
+		}
+	}
+	return r, r.set(cfg)
+}
+
+// set replaces the weights of r with those of cfg.
+func (r *implementationRouter) set(cfg routingConfig) error {
+	weights, err := r.parseWeights(cfg)
+	if err != nil {
+		return err
+	}
+	r.weights.Store(&weights)
+	for op, impls := range weights {
+		level.Info(r.logger).Log("msg", "routing calls", "op", op, "implementations", len(impls))
+	}
+	return nil
+}
+
+// parseWeights returns the weighted implementations of each operation of
+// cfg, in the order of their names.
+func (r *implementationRouter) parseWeights(cfg routingConfig) (map[string][]weightedImplementation, error) {
+	weights := make(map[string][]weightedImplementation, len(cfg.Weights))
+	for op, byName := range cfg.Weights {
+		names := make([]string, 0, len(byName))
+		var total float64
+		for name, weight := range byName {
+			if _, ok := r.alternates[op][name]; !ok && name != registry.DefaultImplementation {
+				return nil, fmt.Errorf("unknown implementation %s of %s", name, op)
+			}
+			if weight < 0 {
+				return nil, fmt.Errorf("negative weight of implementation %s of %s", name, op)
+			}
+			names = append(names, name)
+			total += weight
+		}
+		if total == 0 {
+			return nil, fmt.Errorf("no implementation of %s has a weight", op)
+		}
+		sort.Strings(names)
+		var bound float64
+		for _, name := range names {
+			bound += byName[name] / total
+			weights[op] = append(weights[op], weightedImplementation{name, bound})
+		}
+	}
+	return weights, nil
+}
+
+// pick draws an implementation of op by its weights.
+func (r *implementationRouter) pick(op string) string {
+	impls := (*r.weights.Load())[op]
+	draw := rand.Float64()
+	for _, impl := range impls {
+		if draw < impl.bound {
+			return impl.name
+		}
+	}
+	return registry.DefaultImplementation
+}
+
+type implementationOverridesKey struct{}
+
+// implementationOverrides are the implementations named by the
+// X-Implementation header of a request: by operation, and under "" for
+// every operation.
+type implementationOverrides map[string]string
+
+// withImplementationOverrides keeps the implementations named by the
+// X-Implementation header of the requests in their context.
+func withImplementationOverrides(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get(implementationHeader)
+		if header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		overrides := make(implementationOverrides)
+		for _, item := range strings.Split(header, ",") {
+			op, impl, ok := strings.Cut(strings.TrimSpace(item), "=")
+			if !ok {
+				op, impl = "", op
+			}
+			overrides[strings.TrimSpace(op)] = strings.TrimSpace(impl)
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), implementationOverridesKey{}, overrides)))
+	})
+}
+
+// override returns the implementation of op named by the request of ctx.
+func (r *implementationRouter) override(ctx context.Context, op string) (string, bool) {
+	overrides, _ := ctx.Value(implementationOverridesKey{}).(implementationOverrides)
+	for _, key := range []string{op, ""} {
+		impl, ok := overrides[key]
+		if !ok {
+			continue
+		}
+		if _, known := r.alternates[op][impl]; known || impl == registry.DefaultImplementation {
+			return impl, true
+		}
+	}
+	return "", false
+}
+
+// routed calls the implementation of the operation name chosen by the
+// request or drawn by weight, next being the default one.
+func (r *implementationRouter) routed(name string, next endpoint.Endpoint) endpoint.Endpoint {
+	alternates := r.alternates[name]
+	if len(alternates) == 0 {
+		return next
+	}
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		impl, ok := r.override(ctx, name)
+		if !ok {
+			impl = r.pick(name)
+		}
+		e, ok := alternates[impl]
+		if !ok {
+			e = next
+		}
+		defer func(begin time.Time) {
+			failed := err != nil || hasErr(response)
+			r.metrics.Calls.With("op", name, "implementation", impl, "error", strconv.FormatBool(failed)).Add(1)
+			r.metrics.Latency.With("op", name, "implementation", impl).Observe(time.Since(begin).Seconds())
+		}(time.Now())
+		return e(ctx, request)
+	}
+}
+
+// builtinImplementations are the alternate implementations of the built-in
+// operations, by operation and name.
+func builtinImplementations(svc IStringService) map[string]map[string]endpoint.Endpoint {
+	return map[string]map[string]endpoint.Endpoint{
+		// /count counts bytes and /v2/count characters, so that either can
+		// move to the other.
+		"count": {
+			"bytes": validated(makeCountEndpoint(svc)),
+			"runes": validated(makeCountRunesEndpoint(svc)),
+		},
+	}
+}
@@ -0,0 +1,1311 @@
+// Package server is the string service: its operations, its HTTP API and
+// the transports, middlewares and stores of its configuration. The service
+// binary runs the Server of its configuration file, and other binaries and
+// tests can embed one:
+//
+//	srv, err := server.New(
+//		server.WithConfigJSON([]byte(`{"cache": {"max_entries": 1000}}`)),
+//		server.WithMetricsProvider(server.DiscardMetrics()),
+//	)
+//	if err != nil {
+//		return err
+//	}
+//	ts := httptest.NewServer(srv.Handler())
+//
+// The words, patterns and operations registered with RegisterIrregular,
+// RegisterRedactionDetector, registry.Register and the like are shared by
+// every Server of the process.
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"sync/atomic"
+	"time"
+
+	"github.com/anhle128/gokit-stringsvc/registry"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+//
+// ──────────────────────────────────────────────────── I ──────────
+//   :::::: S E R V E R : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────────
+//
+
+// defaultAddr is the address of the HTTP listener without WithAddr.
+const defaultAddr = ":8080"
+
+// Server serves the string service over HTTP, and over the other
+// transports of its configuration.
+type Server struct {
+	handler    http.Handler
+	http       *http.Server
+	unixSocket unixSocketConfig
+	closers    closers
+}
+
+// Option configures a Server.
+type Option func(*options)
+
+type options struct {
+	configPath       string
+	configJSON       []byte
+	addr             string
+	logOutput        io.Writer
+	metrics          MetricsProvider
+	service          IStringService
+	cacheStore       CacheStore
+	idempotencyStore IdempotencyStore
+	jobStore         JobStore
+	policyEngine     PolicyEngine
+	keys             KeyProvider
+	transports       []Transport
+	middlewares      []func(http.Handler) http.Handler
+}
+
+// config returns the configuration of the options, the empty one by
+// default.
+func (o options) config() (config, error) {
+	if o.configJSON != nil {
+		return decodeConfig(bytes.NewReader(o.configJSON))
+	}
+	return loadConfig(o.configPath)
+}
+
+// WithConfigFile configures the server with the JSON file at path, which is
+// applied again on SIGHUP and, if configured, when it changes.
+func WithConfigFile(path string) Option {
+	return func(o *options) { o.configPath = path }
+}
+
+// WithConfigJSON configures the server with data, in the format of the
+// configuration file.
+func WithConfigJSON(data []byte) Option {
+	return func(o *options) { o.configJSON = data }
+}
+
+// WithAddr sets the address of the HTTP listener, ":8080" by default.
+func WithAddr(addr string) Option {
+	return func(o *options) { o.addr = addr }
+}
+
+// WithLogOutput sets where the logs are written, the standard error by
+// default.
+func WithLogOutput(w io.Writer) Option {
+	return func(o *options) { o.logOutput = w }
+}
+
+// WithMetricsProvider makes the metrics with p rather than with the
+// exporters of the "metrics" config section. The /metrics route is then
+// not served.
+func WithMetricsProvider(p MetricsProvider) Option {
+	return func(o *options) { o.metrics = p }
+}
+
+// WithService serves svc rather than the built-in implementation of the
+// operations. It is still wrapped with the logging and instrumenting
+// middlewares.
+func WithService(svc IStringService) Option {
+	return func(o *options) { o.service = svc }
+}
+
+// WithCacheStore keeps the cached results in store, whatever the "cache"
+// config section says of Redis and of the in-memory cache.
+func WithCacheStore(store CacheStore) Option {
+	return func(o *options) { o.cacheStore = store }
+}
+
+// WithIdempotencyStore records the responses of idempotency keys in store.
+func WithIdempotencyStore(store IdempotencyStore) Option {
+	return func(o *options) { o.idempotencyStore = store }
+}
+
+// WithJobStore keeps the state of jobs in store.
+func WithJobStore(store JobStore) Option {
+	return func(o *options) { o.jobStore = store }
+}
+
+// WithPolicyEngine authorizes the calls of operations with engine, rather
+// than with the roles of the "authorization" config section.
+func WithPolicyEngine(engine PolicyEngine) Option {
+	return func(o *options) { o.policyEngine = engine }
+}
+
+// WithKeyProvider encrypts and decrypts with the keys of p, rather than
+// with those of the "crypto" config section.
+func WithKeyProvider(p KeyProvider) Option {
+	return func(o *options) { o.keys = p }
+}
+
+// WithTransport serves the operations over t too.
+func WithTransport(t Transport) Option {
+	return func(o *options) { o.transports = append(o.transports, t) }
+}
+
+// WithMiddleware wraps the HTTP handler with mw, outside of the built-in
+// middlewares. The middlewares of several options wrap in order, the
+// first one outermost.
+func WithMiddleware(mw func(http.Handler) http.Handler) Option {
+	return func(o *options) { o.middlewares = append(o.middlewares, mw) }
+}
+
+// Transport serves the operations over another protocol than HTTP, like
+// the NATS and AMQP transports of the configuration.
+type Transport interface {
+	// Start serves call in the background, and returns once it does.
+	Start(call CallFunc) error
+}
+
+// CallFunc calls the operation op with a JSON request, and returns its JSON
+// response. Operations are bounded by their timeouts, concurrency limits
+// and flags like over HTTP, and authenticated with the Credentials kept in
+// ctx by WithCredentials.
+type CallFunc func(ctx context.Context, op string, request []byte) (response []byte, err error)
+
+// makeCallFunc returns the CallFunc of ops, whose calls auth
+// authenticates.
+func makeCallFunc(ops map[string]operation, auth *callAuthenticator) CallFunc {
+	return func(ctx context.Context, name string, request []byte) ([]byte, error) {
+		op, ok := ops[name]
+		if !ok {
+			return nil, operationError(fmt.Sprintf("Unknown operation %q", name))
+		}
+		ctx, err := auth.authenticate(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		req, err := op.decode(request)
+		if err != nil {
+			return nil, malformedInputError{"request", err}
+		}
+		resp, err := op.Endpoint(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(resp)
+	}
+}
+
+// Handler returns the HTTP handler of the service, with its middlewares.
+func (s *Server) Handler() http.Handler {
+	return s.handler
+}
+
+// ListenAndServe serves HTTP on the address of the server, or on the Unix
+// socket of its configuration, or HTTPS when a certificate is configured.
+func (s *Server) ListenAndServe() error {
+	if s.unixSocket.Path != "" {
+		l, err := listen(s.http.Addr, s.unixSocket)
+		if err != nil {
+			return err
+		}
+		return s.Serve(l)
+	}
+	if s.http.TLSConfig != nil {
+		return s.http.ListenAndServeTLS("", "")
+	}
+	return s.http.ListenAndServe()
+}
+
+// Serve serves HTTP, or HTTPS when a certificate is configured, on l.
+func (s *Server) Serve(l net.Listener) error {
+	if s.http.TLSConfig != nil {
+		return s.http.ServeTLS(l, "", "")
+	}
+	return s.http.Serve(l)
+}
+
+// Shutdown stops the HTTP listener gracefully, like http.Server.Shutdown,
+// then what New started: the transports answer the calls being served
+// before closing, the admin listener is shut down, the jobs queued are run
+// or canceled once ctx is done, the reloads stop and the stores are
+// closed.
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.http.Shutdown(ctx)
+	if closeErr := s.closers.close(ctx); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// closers stop what New started in the background.
+type closers []func(ctx context.Context) error
+
+func (c *closers) add(close func(ctx context.Context) error) {
+	*c = append(*c, close)
+}
+
+// close runs the closers in the reverse order of their addition, and
+// returns the first error.
+func (c closers) close(ctx context.Context) error {
+	var err error
+	for i := len(c) - 1; i >= 0; i-- {
+		if closeErr := c[i](ctx); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// New builds the server described by opts. The background transports and
+// the admin listener of its configuration are started, but HTTP is only
+// served by ListenAndServe and Serve, or by whoever serves its Handler.
+// What was started is stopped by Shutdown, or right away when New fails.
+func New(opts ...Option) (_ *Server, err error) {
+	o := options{addr: defaultAddr, logOutput: os.Stderr}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	cfg, err := o.config()
+	if err != nil {
+		return nil, fmt.Errorf("cannot load config: %w", err)
+	}
+	logger, logLevel, err := newLogger(cfg.Log, o.logOutput)
+	if err != nil {
+		return nil, fmt.Errorf("invalid log config: %w", err)
+	}
+
+	keys := o.keys
+	if keys == nil && len(cfg.Crypto.Keys) > 0 {
+		if keys, err = newStaticKeyProvider(cfg.Crypto); err != nil {
+			return nil, fmt.Errorf("invalid crypto config: %w", err)
+		}
+	}
+	var signingKeys KeyProvider
+	if len(cfg.Signing.Keys) > 0 {
+		if signingKeys, err = newSigningKeyProvider(cfg.Signing); err != nil {
+			return nil, fmt.Errorf("invalid signing config: %w", err)
+		}
+	}
+	responseSigner, err := newResponseSigner(cfg.Signing.Responses)
+	if err != nil {
+		return nil, fmt.Errorf("invalid response signing config: %w", err)
+	}
+
+	for singular, plural := range cfg.Inflection.Irregular {
+		RegisterIrregular(singular, plural)
+	}
+	for _, w := range cfg.Inflection.Uncountable {
+		RegisterUncountable(w)
+	}
+
+	for name, pattern := range cfg.Redaction.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %q: %w", name, err)
+		}
+		RegisterRedactionDetector(name, RedactionDetector{Pattern: re})
+	}
+
+	for lang, terms := range cfg.Moderation.Wordlists {
+		for term, severity := range terms {
+			RegisterProfanity(lang, term, severity)
+		}
+	}
+
+	for lang, words := range cfg.WordFreq.StopWords {
+		RegisterStopWords(lang, words...)
+	}
+
+	stemmers, err := newStemmers(cfg.Stemming.Languages)
+	if err != nil {
+		return nil, fmt.Errorf("invalid stemming config: %w", err)
+	}
+
+	provider := o.metrics
+	if provider == nil {
+		if provider, err = newMetricsProvider(cfg.Metrics); err != nil {
+			return nil, fmt.Errorf("cannot export metrics: %w", err)
+		}
+	}
+	fieldKeys := []string{"method", "error", "tenant"}
+	requestCount := provider.NewCounter(
+		"request_count",
+		"Number of requests received.",
+		fieldKeys,
+	)
+	requestLatency := provider.NewLatencyHistogram(
+		"request_latency_seconds",
+		"Duration of requests in seconds.",
+		fieldKeys,
+	)
+	requestsInFlight := provider.NewGauge(
+		"requests_in_flight",
+		"Number of requests being served.",
+		[]string{"method", "tenant"},
+	)
+	requestSize := provider.NewSizeHistogram(
+		"request_size_bytes",
+		"Size of the input of requests in bytes.",
+		[]string{"method", "tenant"},
+	)
+	responseSize := provider.NewSizeHistogram(
+		"response_size_bytes",
+		"Size of the text output of requests in bytes.",
+		[]string{"method", "tenant"},
+	)
+	requestErrors := provider.NewCounter(
+		"request_errors",
+		"Number of failed requests, by class of error: validation, timeout or internal, and by error code.",
+		[]string{"method", "class", "code", "tenant"},
+	)
+	countResult := provider.NewSummary(
+		"count_result",
+		"The result of each count method.",
+		[]string{}, // no fields here
+	)
+	pipelineStepLatency := provider.NewLatencyHistogram(
+		"pipeline_step_latency_seconds",
+		"Duration of each pipeline step in seconds.",
+		[]string{"op", "error"},
+	)
+	wsMetrics := websocketMetrics{
+		Connections: provider.NewGauge(
+			"websocket_connections",
+			"Number of WebSocket connections currently open.",
+			[]string{},
+		),
+		Frames: provider.NewSummary(
+			"websocket_frames_per_connection",
+			"Number of frames handled by each WebSocket connection.",
+			[]string{},
+		),
+		Duration: provider.NewSummary(
+			"websocket_connection_duration_seconds",
+			"Lifetime of each WebSocket connection in seconds.",
+			[]string{},
+		),
+	}
+
+	svc := o.service
+	if svc == nil {
+		level.Info(logger).Log("msg", "loading language models")
+		svc = stringService{
+			languageDetector: newLanguageDetector(),
+			keys:             keys,
+			signingKeys:      signingKeys,
+			stemmers:         stemmers,
+		}
+	}
+	requestLogger, err := newRequestLogger(cfg.Log, errorCodeLogger{level.Info(logger)})
+	if err != nil {
+		return nil, fmt.Errorf("invalid log config: %w", err)
+	}
+	svc = loggingMiddleware{requestLogger, svc}
+	instrumenting := instrumentingMiddleware{
+		requestCount:     requestCount,
+		requestLatency:   requestLatency,
+		requestsInFlight: requestsInFlight,
+		requestSize:      requestSize,
+		responseSize:     responseSize,
+		requestErrors:    requestErrors,
+		countResult:      countResult,
+		next:             svc,
+	}
+	svc = instrumenting
+
+	// Every handler reports errors like encodeError, so that failures to
+	// read a request get a JSON "err", its "code" and a meaningful status.
+	serverOptions := []httptransport.ServerOption{
+		httptransport.ServerErrorEncoder(encodeError),
+		httptransport.ServerBefore(cacheBypassFromHTTP),
+		httptransport.ServerBefore(recordErrorCodes),
+	}
+
+	var started closers
+	defer func() {
+		if err != nil {
+			started.close(context.Background())
+		}
+	}()
+
+	tenants, err := newTenancy(cfg.Tenancy, log.With(logger, "component", "tenancy"))
+	if err != nil {
+		return nil, fmt.Errorf("cannot load tenants: %w", err)
+	}
+	started.add(func(context.Context) error {
+		tenants.close()
+		return nil
+	})
+
+	// Every operation is bounded by its timeout, the expensive ones by the
+	// concurrency limit, and all can be turned off with the flags
+	// "operation.<name>", whatever the transport.
+	timeouts := newOperationTimeouts(cfg.Timeouts)
+	limiter := newConcurrencyLimiter(cfg.Concurrency, concurrencyMetrics{
+		InFlight: provider.NewGauge(
+			"concurrency_in_flight",
+			"Number of expensive operations running.",
+			[]string{},
+		),
+		QueueDepth: provider.NewGauge(
+			"concurrency_queue_depth",
+			"Number of expensive operations waiting to run.",
+			[]string{},
+		),
+		Rejections: provider.NewCounter(
+			"concurrency_rejections",
+			"Number of calls rejected for overload, by reason: queue_full or queue_timeout.",
+			[]string{"op", "reason"},
+		),
+	})
+	flags, err := newFeatureFlags(cfg.Flags, provider.NewCounter(
+		"flag_evaluations",
+		"Number of evaluations of feature flags, by result: on or off.",
+		[]string{"flag", "result"},
+	), log.With(logger, "component", "flags"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid flags config: %w", err)
+	}
+	started.add(func(context.Context) error {
+		flags.close()
+		return nil
+	})
+	oidc, err := newOIDCProvider(cfg.OIDC, provider.NewCounter(
+		"oidc_token_validations",
+		"Number of access tokens checked, by result: valid, invalid or error.",
+		[]string{"result"},
+	), log.With(logger, "component", "oidc"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid oidc config: %w", err)
+	}
+	authz, err := newAuthorizer(cfg.Authorization, o.policyEngine, provider.NewCounter(
+		"authorization_denials",
+		"Number of calls denied by the authorization, by reason: role or error.",
+		[]string{"op", "reason"},
+	), log.With(logger, "component", "authorization"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid authorization config: %w", err)
+	}
+	// authorized checks the scopes of the access token and the roles of the
+	// identity of the calls of name.
+	authorized := func(name string, e endpoint.Endpoint) endpoint.Endpoint {
+		return oidc.authorized(name, authz.authorized(name, e))
+	}
+	tenantRejected := provider.NewCounter(
+		"tenant_rejected_requests",
+		"Number of requests rejected by the rate limits and toggles of tenants.",
+		[]string{"tenant", "reason"},
+	)
+	// The transports other than HTTP authenticate their calls like HTTP
+	// requests. Those that cannot carry credentials are refused while
+	// calls need them.
+	callAuth := &callAuthenticator{oidc: oidc, tenants: tenants, rejected: tenantRejected, authz: authz}
+	withoutCredentials := func(transport string) error {
+		if oidc.scoped() || authz != nil {
+			return fmt.Errorf("the %s transport cannot carry credentials, which the oidc scopes and the authorization need", transport)
+		}
+		return nil
+	}
+	router, err := newImplementationRouter(cfg.Routing, builtinImplementations(svc), instrumenting, implementationMetrics{
+		Calls: provider.NewCounter(
+			"implementation_calls",
+			"Number of calls of operations, by implementation and whether they failed.",
+			[]string{"op", "implementation", "error"},
+		),
+		Latency: provider.NewLatencyHistogram(
+			"implementation_latency_seconds",
+			"Duration of the calls of operations in seconds, by implementation.",
+			[]string{"op", "implementation"},
+		),
+	}, log.With(logger, "component", "routing"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid routing config: %w", err)
+	}
+	guarded := func(name string, e endpoint.Endpoint) endpoint.Endpoint {
+		return flags.gated(name, authorized(name, limiter.limited(name, timeouts.bounded(name, router.routed(name, e)))))
+	}
+	ops := newOperations(svc)
+	if err := loadPlugins(cfg.Plugins); err != nil {
+		return nil, fmt.Errorf("cannot load plugins: %w", err)
+	}
+	if err := addRegisteredOperations(ops, instrumenting); err != nil {
+		return nil, fmt.Errorf("invalid registered operations: %w", err)
+	}
+	if err := checkRequestRules(ops); err != nil {
+		return nil, fmt.Errorf("invalid request rules: %w", err)
+	}
+	// Jobs run the operations without their timeouts and concurrency
+	// limits, which the job queue replaces.
+	jobOps := make(map[string]operation, len(ops)+2)
+	for name, op := range ops {
+		jobOps[name] = operation{flags.gated(name, authorized(name, tenants.enabled(name, router.routed(name, op.Endpoint)))), op.Request, op.Response}
+		op.Endpoint = guarded(name, tenants.enabled(name, op.Endpoint))
+		ops[name] = op
+	}
+
+	cacheMetrics := resultCacheMetrics{
+		Hits: provider.NewCounter(
+			"cache_hits",
+			"Number of responses served from the cache.",
+			[]string{"op"},
+		),
+		Misses: provider.NewCounter(
+			"cache_misses",
+			"Number of cacheable requests not found in the cache.",
+			[]string{"op"},
+		),
+		HitRatio: provider.NewGauge(
+			"cache_hit_ratio",
+			"Share of cacheable requests served from the cache.",
+			[]string{"op"},
+		),
+		Evictions: provider.NewCounter(
+			"cache_evictions",
+			"Number of results evicted from the in-memory cache.",
+			[]string{},
+		),
+	}
+	cacheStore := o.cacheStore
+	if cacheStore == nil {
+		cacheStore = newCacheStore(cfg.Cache, cacheMetrics.Evictions)
+	}
+	cache, err := newResultCache(cfg.Cache, cacheStore, cfg.fingerprint(), ops, cacheMetrics, logger)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cache config: %w", err)
+	}
+	// The flags, scopes and roles of operations are checked before the
+	// cache, so that the operations turned off or not allowed are not
+	// served from it either.
+	cached := func(name string, e endpoint.Endpoint) endpoint.Endpoint {
+		return flags.gated(name, authorized(name, cache.cached(name, e)))
+	}
+
+	uppercaseEndpoint := makeUppercaseEndpoint(svc)
+	// uppercaseEndpoint = loggingMiddleware(logger)(uppercaseEndpoint)
+
+	uppercaseHandler := httptransport.NewServer(
+		cached("uppercase", guarded("uppercase", validated(uppercaseEndpoint))),
+		decodeUppercaseRequest,
+		encodeResponse,
+		serverOptions...,
+	)
+
+	countEnpoint := makeCountEndpoint(svc)
+	// countEnpoint = loggingMiddleware(logger)(countEnpoint)
+
+	countHandler := httptransport.NewServer(
+		cached("count", guarded("count", validated(countEnpoint))),
+		decodeCountRequest,
+		encodeResponse,
+		serverOptions...,
+	)
+
+	hashHandler := httptransport.NewServer(
+		cached("hash", guarded("hash", validated(makeHashEndpoint(svc)))),
+		decodeHashRequest,
+		encodeResponse,
+		serverOptions...,
+	)
+
+	encodeHandler := httptransport.NewServer(
+		cached("encode", guarded("encode", validated(makeEncodeEndpoint(svc)))),
+		decodeEncodeStringRequest,
+		encodeResponse,
+		serverOptions...,
+	)
+
+	decodeHandler := httptransport.NewServer(
+		cached("decode", guarded("decode", validated(makeDecodeEndpoint(svc)))),
+		decodeDecodeStringRequest,
+		encodeResponse,
+		serverOptions...,
+	)
+
+	urlEncodeHandler := httptransport.NewServer(
+		cached("urlencode", guarded("urlencode", validated(makeURLEncodeEndpoint(svc)))),
+		decodeURLEncodeRequest,
+		encodeResponse,
+		serverOptions...,
+	)
+
+	urlDecodeHandler := httptransport.NewServer(
+		cached("urldecode", guarded("urldecode", validated(makeURLDecodeEndpoint(svc)))),
+		decodeURLDecodeRequest,
+		encodeResponse,
+		serverOptions...,
+	)
+
+	htmlHandler := httptransport.NewServer(
+		cached("html", guarded("html", validated(makeHTMLEndpoint(svc)))),
+		decodeHTMLRequest,
+		encodeResponse,
+		serverOptions...,
+	)
+
+	slugifyHandler := httptransport.NewServer(
+		cached("slugify", guarded("slugify", validated(makeSlugifyEndpoint(svc)))),
+		decodeSlugifyRequest,
+		encodeResponse,
+		serverOptions...,
+	)
+
+	convertCaseHandler := httptransport.NewServer(
+		cached("convertcase", guarded("convertcase", validated(makeConvertCaseEndpoint(svc)))),
+		decodeConvertCaseRequest,
+		encodeResponse,
+		serverOptions...,
+	)
+
+	convertCaseBatchHandler := httptransport.NewServer(
+		guarded("convertcase/batch", validated(makeConvertCaseBatchEndpoint(svc))),
+		decodeConvertCaseBatchRequest,
+		encodeResponse,
+		serverOptions...,
+	)
+
+	similarityHandler := httptransport.NewServer(
+		cached("similarity", guarded("similarity", validated(makeSimilarityEndpoint(svc)))),
+		decodeSimilarityRequest,
+		encodeResponse,
+		serverOptions...,
+	)
+
+	palindromeHandler := httptransport.NewServer(
+		cached("palindrome", guarded("palindrome", validated(makePalindromeEndpoint(svc)))),
+		decodePalindromeRequest,
+		encodeResponse,
+		serverOptions...,
+	)
+
+	anagramHandler := httptransport.NewServer(
+		cached("anagram", guarded("anagram", validated(makeAnagramEndpoint(svc)))),
+		decodeAnagramRequest,
+		encodeResponse,
+		serverOptions...,
+	)
+
+	statsHandler := httptransport.NewServer(
+		cached("stats", guarded("stats", validated(makeStatsEndpoint(svc)))),
+		decodeStatsRequest,
+		encodeResponse,
+		serverOptions...,
+	)
+
+	detectLanguageHandler := httptransport.NewServer(
+		cached("detect-language", guarded("detect-language", validated(makeDetectLanguageEndpoint(svc)))),
+		decodeDetectLanguageRequest,
+		encodeResponse,
+		serverOptions...,
+	)
+
+	transliterateHandler := httptransport.NewServer(
+		cached("transliterate", guarded("transliterate", validated(makeTransliterateEndpoint(svc)))),
+		decodeTransliterateRequest,
+		encodeResponse,
+		serverOptions...,
+	)
+
+	randomHandler := httptransport.NewServer(
+		guarded("random", validated(makeRandomEndpoint(svc))),
+		decodeRandomRequest,
+		encodeResponse,
+		serverOptions...,
+	)
+
+	idHandler := httptransport.NewServer(
+		guarded("id", validated(makeIDEndpoint(svc))),
+		decodeIDRequest,
+		encodeResponse,
+		serverOptions...,
+	)
+
+	cipherHandler := httptransport.NewServer(
+		cached("cipher", guarded("cipher", validated(makeCipherEndpoint(svc)))),
+		decodeCipherRequest,
+		encodeResponse,
+		serverOptions...,
+	)
+
+	encryptHandler := httptransport.NewServer(
+		guarded("encrypt", validated(makeEncryptEndpoint(svc))),
+		decodeEncryptRequest,
+		encodeResponse,
+		serverOptions...,
+	)
+
+	decryptHandler := httptransport.NewServer(
+		guarded("decrypt", validated(makeDecryptEndpoint(svc))),
+		decodeDecryptRequest,
+		encodeResponse,
+		serverOptions...,
+	)
+
+	compressHandler := httptransport.NewServer(
+		guarded("compress", validated(makeCompressEndpoint(svc))),
+		decodeCompressRequest,
+		encodeResponse,
+		serverOptions...,
+	)
+
+	decompressHandler := httptransport.NewServer(
+		guarded("decompress", validated(makeDecompressEndpoint(svc))),
+		decodeDecompressRequest,
+		encodeResponse,
+		serverOptions...,
+	)
+
+	renderHandler := httptransport.NewServer(
+		guarded("render", validated(makeRenderEndpoint(svc))),
+		decodeRenderRequest,
+		encodeResponse,
+		serverOptions...,
+	)
+
+	diffHandler := httptransport.NewServer(
+		cached("diff", guarded("diff", validated(makeDiffEndpoint(svc)))),
+		decodeDiffRequest,
+		encodeResponse,
+		serverOptions...,
+	)
+
+	inflectHandler := httptransport.NewServer(
+		cached("inflect", guarded("inflect", validated(makeInflectEndpoint(svc)))),
+		decodeInflectRequest,
+		encodeResponse,
+		serverOptions...,
+	)
+
+	truncateHandler := httptransport.NewServer(
+		cached("truncate", guarded("truncate", validated(makeTruncateEndpoint(svc)))),
+		decodeTruncateRequest,
+		encodeResponse,
+		serverOptions...,
+	)
+
+	padHandler := httptransport.NewServer(
+		cached("pad", guarded("pad", validated(makePadEndpoint(svc)))),
+		decodePadRequest,
+		encodeResponse,
+		serverOptions...,
+	)
+
+	redactHandler := httptransport.NewServer(
+		cached("redact", guarded("redact", validated(makeRedactEndpoint(svc)))),
+		decodeRedactRequest,
+		encodeResponse,
+		serverOptions...,
+	)
+
+	moderateHandler := httptransport.NewServer(
+		cached("moderate", guarded("moderate", validated(makeModerateEndpoint(svc)))),
+		decodeModerateRequest,
+		encodeResponse,
+		serverOptions...,
+	)
+
+	wordFreqHandler := httptransport.NewServer(
+		cached("wordfreq", guarded("wordfreq", validated(makeWordFreqEndpoint(svc)))),
+		decodeWordFreqRequest,
+		encodeResponse,
+		serverOptions...,
+	)
+
+	tokenizeHandler := httptransport.NewServer(
+		cached("tokenize", guarded("tokenize", validated(makeTokenizeEndpoint(svc)))),
+		decodeTokenizeRequest,
+		encodeResponse,
+		serverOptions...,
+	)
+
+	stemHandler := httptransport.NewServer(
+		cached("stem", guarded("stem", validated(makeStemEndpoint(svc)))),
+		decodeStemRequest,
+		encodeResponse,
+		serverOptions...,
+	)
+
+	normalizeUnicodeHandler := httptransport.NewServer(
+		cached("normalize-unicode", guarded("normalize-unicode", validated(makeNormalizeUnicodeEndpoint(svc)))),
+		decodeNormalizeUnicodeRequest,
+		encodeResponse,
+		serverOptions...,
+	)
+
+	recodeHandler := httptransport.NewServer(
+		cached("recode", guarded("recode", validated(makeRecodeEndpoint(svc)))),
+		decodeRecodeRequest,
+		encodeResponse,
+		serverOptions...,
+	)
+
+	numWordsHandler := httptransport.NewServer(
+		cached("numwords", guarded("numwords", validated(makeNumWordsEndpoint(svc)))),
+		decodeNumWordsRequest,
+		encodeResponse,
+		serverOptions...,
+	)
+
+	signHandler := httptransport.NewServer(
+		guarded("sign", validated(makeSignEndpoint(svc))),
+		decodeSignRequest,
+		encodeResponse,
+		serverOptions...,
+	)
+
+	verifyHandler := httptransport.NewServer(
+		guarded("verify", validated(makeVerifyEndpoint(svc))),
+		decodeVerifyRequest,
+		encodeResponse,
+		serverOptions...,
+	)
+
+	jsonHandler := httptransport.NewServer(
+		cached("json", guarded("json", validated(makeJSONEndpoint(svc)))),
+		decodeJSONRequest,
+		encodeResponse,
+		serverOptions...,
+	)
+
+	csvParseHandler := httptransport.NewServer(
+		cached("csv-parse", guarded("csv-parse", validated(makeCSVParseEndpoint(svc)))),
+		decodeCSVParseRequest,
+		encodeResponse,
+		serverOptions...,
+	)
+
+	csvFormatHandler := httptransport.NewServer(
+		cached("csv-format", guarded("csv-format", validated(makeCSVFormatEndpoint(svc)))),
+		decodeCSVFormatRequest,
+		encodeResponse,
+		serverOptions...,
+	)
+
+	expandHandler := httptransport.NewServer(
+		cached("expand", guarded("expand", validated(makeExpandEndpoint(svc)))),
+		decodeExpandRequest,
+		encodeResponse,
+		serverOptions...,
+	)
+
+	emojiHandler := httptransport.NewServer(
+		cached("emoji", guarded("emoji", validated(makeEmojiEndpoint(svc)))),
+		decodeEmojiRequest,
+		encodeResponse,
+		serverOptions...,
+	)
+
+	wrapHandler := httptransport.NewServer(
+		cached("wrap", guarded("wrap", validated(makeWrapEndpoint(svc)))),
+		decodeWrapRequest,
+		encodeResponse,
+		serverOptions...,
+	)
+
+	phoneticHandler := httptransport.NewServer(
+		cached("phonetic", guarded("phonetic", validated(makePhoneticEndpoint(svc)))),
+		decodePhoneticRequest,
+		encodeResponse,
+		serverOptions...,
+	)
+
+	phoneticBatchHandler := httptransport.NewServer(
+		guarded("phonetic/batch", validated(makePhoneticBatchEndpoint(svc))),
+		decodePhoneticBatchRequest,
+		encodeResponse,
+		serverOptions...,
+	)
+
+	if cfg.NATS.URL != "" {
+		if err := withoutCredentials("NATS"); err != nil {
+			return nil, err
+		}
+		nc, err := serveNATS(cfg.NATS, ops, logger)
+		if err != nil {
+			return nil, fmt.Errorf("cannot start NATS transport: %w", err)
+		}
+		// Draining answers the messages received before closing.
+		started.add(func(context.Context) error { return nc.Drain() })
+	}
+	if cfg.AMQP.URL != "" {
+		if err := withoutCredentials("AMQP"); err != nil {
+			return nil, err
+		}
+		c, err := serveAMQP(cfg.AMQP, ops, logger)
+		if err != nil {
+			return nil, fmt.Errorf("cannot start AMQP worker: %w", err)
+		}
+		started.add(c.shutdown)
+	}
+	if cfg.Thrift.Addr != "" {
+		if err := withoutCredentials("Thrift"); err != nil {
+			return nil, err
+		}
+		t, err := serveThrift(cfg.Thrift, svc, ops)
+		if err != nil {
+			return nil, fmt.Errorf("cannot start Thrift transport: %w", err)
+		}
+		started.add(func(context.Context) error { return t.Stop() })
+	}
+	if cfg.Binary.Addr != "" || cfg.Binary.UnixSocket.Path != "" {
+		b, err := serveBinary(cfg.Binary, ops, callAuth, binaryMetrics{
+			Connections: provider.NewGauge(
+				"binary_connections",
+				"Number of connections of the binary transport currently open.",
+				[]string{},
+			),
+			Calls: provider.NewCounter(
+				"binary_calls",
+				"Number of calls over the binary transport, by operation.",
+				[]string{"op", "error"},
+			),
+			Latency: provider.NewLatencyHistogram(
+				"binary_call_latency_seconds",
+				"Duration of the calls over the binary transport in seconds, from their request read to their response written.",
+				[]string{"op"},
+			),
+		}, logger)
+		if err != nil {
+			return nil, fmt.Errorf("cannot start binary transport: %w", err)
+		}
+		started.add(b.shutdown)
+	}
+	if len(cfg.Kafka.Brokers) > 0 {
+		if err := withoutCredentials("Kafka"); err != nil {
+			return nil, err
+		}
+		p, err := newKafkaProcessor(cfg.Kafka, ops, kafkaMetrics{
+			Lag: provider.NewGauge(
+				"kafka_consumer_lag",
+				"Number of messages of the input topic not consumed yet.",
+				[]string{},
+			),
+			Messages: provider.NewCounter(
+				"kafka_messages_processed",
+				"Number of Kafka messages processed.",
+				[]string{"error"},
+			),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("invalid kafka config: %w", err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		stopped := make(chan struct{})
+		go func() {
+			defer close(stopped)
+			if err := p.Run(ctx); ctx.Err() == nil {
+				level.Error(logger).Log("transport", "kafka", "msg", "processor stopped", "err", err)
+			}
+		}()
+		started.add(func(ctx context.Context) error {
+			cancel()
+			select {
+			case <-stopped:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}
+	for _, t := range o.transports {
+		if err := t.Start(makeCallFunc(ops, callAuth)); err != nil {
+			return nil, fmt.Errorf("cannot start transport: %w", err)
+		}
+	}
+
+	apiRoutes := map[string]operation{
+		"pipeline": {Request: pipelineRequest{}, Response: pipelineResponse{}},
+		"batch":    {Request: batchRequest{}, Response: batchResponse{}},
+	}
+	for name, op := range ops {
+		apiRoutes[name] = op
+	}
+
+	graphqlSchema, err := newGraphQLSchema(ops)
+	if err != nil {
+		return nil, fmt.Errorf("cannot build GraphQL schema: %w", err)
+	}
+
+	pipelineHandler := httptransport.NewServer(
+		guarded("pipeline", makePipelineEndpoint(ops, pipelineStepLatency)),
+		decodePipelineRequest,
+		encodeResponse,
+		serverOptions...,
+	)
+
+	batchHandler := httptransport.NewServer(
+		guarded("batch", validated(makeBatchEndpoint(ops))),
+		decodeBatchRequest,
+		encodeResponse,
+		serverOptions...,
+	)
+
+	jobOps["pipeline"] = operation{makePipelineEndpoint(jobOps, pipelineStepLatency), pipelineRequest{}, pipelineResponse{}}
+	jobOps["batch"] = operation{validated(makeBatchEndpoint(jobOps)), batchRequest{}, batchResponse{}}
+	notifier := newWebhookNotifier(cfg.Jobs.Webhooks, signingKeys, webhookMetrics{
+		Attempts: provider.NewCounter(
+			"webhook_delivery_attempts",
+			"Number of attempts to deliver job notifications, by HTTP status.",
+			[]string{"status"},
+		),
+		Deliveries: provider.NewCounter(
+			"webhook_deliveries",
+			"Number of job notifications delivered or given up on.",
+			[]string{"result"},
+		),
+		Latency: provider.NewLatencyHistogram(
+			"webhook_delivery_latency_seconds",
+			"Duration of the attempts to deliver job notifications in seconds.",
+			[]string{"status"},
+		),
+	}, log.With(logger, "component", "webhooks"))
+	jobStore := o.jobStore
+	if jobStore == nil {
+		jobStore = newJobStore(cfg.Jobs)
+	}
+	jobs := newJobQueue(cfg.Jobs, jobStore, jobOps, notifier, log.With(logger, "component", "jobs"))
+	started.add(jobs.close)
+	jobsHandler := makeJobsHandler(jobs)
+
+	mux := http.NewServeMux()
+	mux.Handle("/uppercase", uppercaseHandler)
+	mux.Handle("/count", countHandler)
+	mux.Handle("/hash", hashHandler)
+	mux.Handle("/encode", encodeHandler)
+	mux.Handle("/decode", decodeHandler)
+	mux.Handle("/urlencode", urlEncodeHandler)
+	mux.Handle("/urldecode", urlDecodeHandler)
+	mux.Handle("/html", htmlHandler)
+	mux.Handle("/slugify", slugifyHandler)
+	mux.Handle("/convertcase", convertCaseHandler)
+	mux.Handle("/convertcase/batch", convertCaseBatchHandler)
+	mux.Handle("/similarity", similarityHandler)
+	mux.Handle("/analyze/palindrome", palindromeHandler)
+	mux.Handle("/analyze/anagram", anagramHandler)
+	mux.Handle("/stats", statsHandler)
+	mux.Handle("/detect-language", detectLanguageHandler)
+	if o.metrics == nil && cfg.Metrics.exports("prometheus") {
+		mux.Handle("/metrics", promhttp.Handler())
+	}
+	mux.Handle("/transliterate", transliterateHandler)
+	mux.Handle("/random", randomHandler)
+	mux.Handle("/id", idHandler)
+	mux.Handle("/cipher", cipherHandler)
+	mux.Handle("/encrypt", encryptHandler)
+	mux.Handle("/decrypt", decryptHandler)
+	mux.Handle("/compress", compressHandler)
+	mux.Handle("/decompress", decompressHandler)
+	mux.Handle("/render", renderHandler)
+	mux.Handle("/diff", diffHandler)
+	mux.Handle("/inflect", inflectHandler)
+	mux.Handle("/truncate", truncateHandler)
+	mux.Handle("/pad", padHandler)
+	mux.Handle("/redact", redactHandler)
+	mux.Handle("/moderate", moderateHandler)
+	mux.Handle("/wordfreq", wordFreqHandler)
+	mux.Handle("/tokenize", tokenizeHandler)
+	mux.Handle("/stem", stemHandler)
+	mux.Handle("/normalize-unicode", normalizeUnicodeHandler)
+	mux.Handle("/recode", recodeHandler)
+	mux.Handle("/numwords", numWordsHandler)
+	mux.Handle("/sign", signHandler)
+	mux.Handle("/verify", verifyHandler)
+	mux.Handle("/json", jsonHandler)
+	mux.Handle("/csv/parse", csvParseHandler)
+	mux.Handle("/csv/format", csvFormatHandler)
+	mux.Handle("/expand", expandHandler)
+	mux.Handle("/emoji", emojiHandler)
+	mux.Handle("/wrap", wrapHandler)
+	mux.Handle("/phonetic", phoneticHandler)
+	mux.Handle("/phonetic/batch", phoneticBatchHandler)
+	for name := range registry.Operations() {
+		op := ops[name]
+		mux.Handle(operationRoute(name), httptransport.NewServer(
+			op.Endpoint,
+			makeOperationDecoder(op),
+			encodeResponse,
+			serverOptions...,
+		))
+	}
+	mux.Handle("/pipeline", pipelineHandler)
+	mux.Handle("/batch", batchHandler)
+	mux.Handle("/jobs", jobsHandler)
+	mux.Handle("/jobs/", jobsHandler)
+	// The stream routes are checked like the calls of their operation,
+	// without its timeout and concurrency limit, which streams would hold
+	// for as long as their input lasts.
+	streamed := func(name string, h http.Handler) http.Handler {
+		return guardedStream(flags.gated(name, authorized(name, tenants.enabled(name, streamAdmitted))), h)
+	}
+	mux.Handle("/stream/uppercase", streamed("uppercase", makeUppercaseStreamHandler(svc)))
+	mux.Handle("/stream/count", streamed("count", makeCountStreamHandler(svc.CountStream)))
+	mux.Handle("/"+currentAPIVersion+"/stream/count", streamed("count", makeCountStreamHandler(svc.CountRunesStream)))
+	mux.Handle("/stream/csv/parse", streamed("csv-parse", makeCSVParseStreamHandler(svc)))
+	mux.Handle("/stream/csv/format", streamed("csv-format", makeCSVFormatStreamHandler(svc)))
+	mux.Handle("/ws", makeWebsocketHandler(ops, wsMetrics, logger))
+	mux.Handle("/rpc", newJSONRPCServer(ops))
+	mux.Handle("/graphql", makeGraphQLHandler(graphqlSchema))
+	mux.Handle("/openapi.json", makeOpenAPIHandler(apiRoutes, mux))
+	mux.HandleFunc("/docs", serveSwaggerUI)
+	if responseSigner != nil {
+		mux.HandleFunc(jwksRoute, responseSigner.jwks)
+	}
+	faults, err := newChaos(cfg.Chaos, provider.NewCounter(
+		"chaos_injected_faults",
+		"Number of faults injected in requests, by kind: latency, error or drop.",
+		[]string{"fault"},
+	), log.With(logger, "component", "chaos"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid chaos config: %w", err)
+	}
+	history, err := newHistoryStore(cfg.History, log.With(logger, "component", "history"))
+	if err != nil {
+		return nil, fmt.Errorf("cannot open history database: %w", err)
+	}
+	if history != nil {
+		started.add(func(context.Context) error { return history.Close() })
+	}
+	var effectiveConfig atomic.Pointer[config]
+	effectiveConfig.Store(&cfg)
+	shutdownAdmin, err := serveAdmin(cfg.Admin, logLevel, faults, jobs, history, flags, &effectiveConfig, log.With(logger, "component", "admin"))
+	if err != nil {
+		return nil, fmt.Errorf("cannot start admin listener: %w", err)
+	}
+	if shutdownAdmin != nil {
+		started.add(shutdownAdmin)
+	}
+	v2Routes := registerV2Routes(mux, newV2Operations(svc, ops, tenants, guarded), cached, serverOptions...)
+	compressionMinSize := cfg.HTTP.CompressionMinSize
+	if compressionMinSize == 0 {
+		compressionMinSize = defaultCompressionMinSize
+	}
+	idempotencyTTL := defaultIdempotencyTTL
+	if cfg.Idempotency.TTLSeconds > 0 {
+		idempotencyTTL = time.Duration(cfg.Idempotency.TTLSeconds) * time.Second
+	}
+	// The optional middlewares can be turned off for a share of requests
+	// with the flags "middleware.<name>".
+	idempotencyStore := o.idempotencyStore
+	if idempotencyStore == nil {
+		idempotencyStore = newIdempotencyStore(cfg.Idempotency)
+	}
+	shadow, err := newShadowMirror(cfg.Shadow, shadowMetrics{
+		Requests: provider.NewCounter(
+			"shadow_requests",
+			"Number of requests mirrored to the shadow, by result: match, mismatch, error or dropped.",
+			[]string{"route", "result"},
+		),
+		LatencyDelta: provider.NewSummary(
+			"shadow_latency_delta_seconds",
+			"Latency of the shadow minus that of the mirrored request in seconds.",
+			[]string{"route"},
+		),
+	}, log.With(logger, "component", "shadow"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid shadow config: %w", err)
+	}
+	handler := withBodyCodecs(mux)
+	handler = flags.middleware("shadow", withShadow(handler, shadow), handler)
+	handler = flags.middleware("idempotency", withIdempotency(handler, idempotencyStore, idempotencyTTL), handler)
+	handler = flags.middleware("etags", withETags(handler, cfg.HTTP, cfg.fingerprint()), handler)
+	handler = flags.middleware("cache", handler, withoutCache(handler))
+	if cfg.Quota.Path != "" {
+		q, err := newQuotas(cfg.Quota)
+		if err != nil {
+			return nil, fmt.Errorf("cannot open quota database: %w", err)
+		}
+		started.add(func(context.Context) error { return q.Close() })
+		handler = flags.middleware("quota", withQuotas(handler, q, log.With(logger, "component", "quota")), handler)
+	}
+	auditSink, err := newAuditSink(cfg.Audit)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open audit sink: %w", err)
+	}
+	if auditSink != nil {
+		started.add(func(context.Context) error { return auditSink.Close() })
+	}
+	// The history hashes inputs and API keys like the audit, so that its
+	// records can be matched with the audit ones.
+	hashKey, err := auditHashKey(cfg.Audit)
+	if err != nil {
+		return nil, fmt.Errorf("cannot make audit hash key: %w", err)
+	}
+	if auditSink != nil {
+		handler = flags.middleware("audit", withAudit(handler, auditSink, hashKey, log.With(logger, "component", "audit")), handler)
+	}
+	if history != nil {
+		handler = flags.middleware("history", withAudit(handler, history, hashKey, log.With(logger, "component", "history")), handler)
+	}
+	handler = withIdentities(handler, authz)
+	handler = withImplementationOverrides(handler)
+	handler = withTenancy(handler, tenants, tenantRejected)
+	// Access tokens are checked before the tenancy, which may take the
+	// tenant of requests from their token.
+	handler = withAccessTokens(handler, oidc)
+	handler = flags.middleware("ratelimits", handler, withoutRateLimits(handler))
+	handler = flags.middleware("chaos", withChaos(handler, faults), handler)
+	handler = withLimits(handler, cfg.HTTP)
+	handler = withResponseSigning(handler, responseSigner)
+	handler = flags.middleware("compression", withContentEncoding(handler, compressionMinSize), handler)
+	handler = withCORS(handler, cfg.CORS)
+	handler = withVersions(handler, v2Routes, provider.NewCounter(
+		"api_version_requests",
+		"Number of requests by API version, deprecated or not.",
+		[]string{"version", "deprecated"},
+	))
+	accessRules, err := newAccessRules(cfg.Access)
+	if err != nil {
+		return nil, fmt.Errorf("invalid access rules: %w", err)
+	}
+	handler = withAccessControl(handler, accessRules, provider.NewCounter(
+		"rejected_requests",
+		"Number of requests rejected by the access rules.",
+		[]string{"reason"},
+	))
+	handler = withLocales(handler)
+	for i := len(o.middlewares) - 1; i >= 0; i-- {
+		handler = o.middlewares[i](handler)
+	}
+	if _, err := unixSocketMode(cfg.HTTP.UnixSocket); err != nil {
+		return nil, fmt.Errorf("invalid http config: %w", err)
+	}
+	s := &Server{
+		handler:    handler,
+		http:       newHTTPServer(o.addr, handler, cfg.HTTP),
+		unixSocket: cfg.HTTP.UnixSocket,
+		closers:    started,
+	}
+	reloadSteps := []reloadStep{
+		reloadLogLevel(logLevel),
+		reloadTenants(tenants, log.With(logger, "component", "tenancy")),
+		reloadChaos(faults),
+		reloadFlags(flags),
+		reloadRouting(router),
+		reloadEffectiveConfig(&effectiveConfig),
+	}
+	if cfg.HTTP.TLS.CertFile != "" {
+		cert, err := newCertificate(cfg.HTTP.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load TLS certificate: %w", err)
+		}
+		s.http.TLSConfig = &tls.Config{GetCertificate: cert.GetCertificate}
+		reloadSteps = append(reloadSteps, reloadCertificate(cert))
+	}
+	if o.configPath != "" {
+		r := newReloader(o.configPath, provider.NewCounter(
+			"config_reloads",
+			"Number of configuration reloads, by result: applied or rejected.",
+			[]string{"result"},
+		), log.With(logger, "component", "reload"), reloadSteps...)
+		go r.run(time.Duration(cfg.Reload.WatchSeconds) * time.Second)
+		s.closers.add(func(context.Context) error {
+			r.close()
+			return nil
+		})
+	}
+	return s, nil
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// failingTransport fails to start.
+type failingTransport struct{}
+
+func (failingTransport) Start(CallFunc) error { return errors.New("cannot start") }
+
+// freeAddr returns a local address that nothing listens on.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func TestServerStops(t *testing.T) {
+	tests := []struct {
+		name string
+		// config is formatted with the addresses of the binary transport and
+		// of the admin listener.
+		config  string
+		opts    []Option
+		wantErr bool
+	}{
+		{
+			name:   "shutdown",
+			config: `{"binary": {"addr": %q}, "admin": {"addr": %q}}`,
+		},
+		{
+			name:    "transport failing after the binary one",
+			config:  `{"binary": {"addr": %q}, "admin": {"addr": %q}}`,
+			opts:    []Option{WithTransport(failingTransport{})},
+			wantErr: true,
+		},
+		{
+			name:    "certificate failing after the admin listener",
+			config:  `{"binary": {"addr": %q}, "admin": {"addr": %q}, "http": {"tls": {"cert_file": "missing.pem", "key_file": "missing.key"}}}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			binaryAddr, adminAddr := freeAddr(t), freeAddr(t)
+			opts := append([]Option{
+				WithConfigJSON([]byte(fmt.Sprintf(tt.config, binaryAddr, adminAddr))),
+				WithService(stringService{}),
+				WithLogOutput(io.Discard),
+				WithMetricsProvider(discardProvider{}),
+			}, tt.opts...)
+			s, err := New(opts...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("New error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil {
+				for _, addr := range []string{binaryAddr, adminAddr} {
+					conn, err := net.Dial("tcp", addr)
+					if err != nil {
+						t.Fatalf("%s not served: %v", addr, err)
+					}
+					conn.Close()
+				}
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				if err := s.Shutdown(ctx); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, addr := range []string{binaryAddr, adminAddr} {
+				l, err := net.Listen("tcp", addr)
+				if err != nil {
+					t.Fatalf("%s still in use: %v", addr, err)
+				}
+				l.Close()
+			}
+		})
+	}
+}
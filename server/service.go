@@ -0,0 +1,404 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-kit/kit/endpoint"
+	log "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+
+	"github.com/pemistahl/lingua-go"
+)
+
+type IStringService interface {
+	Uppercase(context.Context, string) (string, error)
+	Count(context.Context, string) (int, error)
+	CountRunes(context.Context, string) (int, error)
+	Hash(ctx context.Context, s, algorithm, encoding string) (string, error)
+	Encode(ctx context.Context, s, encoding string) (string, error)
+	Decode(ctx context.Context, s, encoding, padding string) (string, error)
+	URLEncode(ctx context.Context, s, mode string) (string, error)
+	URLDecode(ctx context.Context, s, mode string) (string, error)
+	HTML(ctx context.Context, s, op, policy string) (string, error)
+	Slugify(ctx context.Context, s, separator string, maxLength int, preserveUnicode bool) (string, error)
+	ConvertCase(ctx context.Context, s, target string) (output, detected string, err error)
+	Similarity(ctx context.Context, a, b, algorithm string) (distance, score float64, err error)
+	Palindrome(ctx context.Context, s string, normalize, ignorePunctuation bool) (ok bool, normalized string, err error)
+	Anagram(ctx context.Context, a, b string, normalize, ignorePunctuation bool) (ok bool, normalized []string, err error)
+	Stats(ctx context.Context, s string, wordsPerMinute int) (TextStats, error)
+	DetectLanguage(ctx context.Context, s string, maxResults int) ([]LanguageConfidence, error)
+	Transliterate(ctx context.Context, s, scheme string) (string, error)
+	Random(ctx context.Context, length int, classes []string, count int) ([]string, error)
+	ID(ctx context.Context, kind string, count int) ([]string, error)
+	Cipher(ctx context.Context, s, scheme, key string, decrypt bool) (string, error)
+	Encrypt(ctx context.Context, s string) (ciphertext, keyID string, err error)
+	Decrypt(ctx context.Context, s string) (plaintext, keyID string, err error)
+	Compress(ctx context.Context, s, algorithm string, level int) (string, error)
+	Decompress(ctx context.Context, s, algorithm string) (string, error)
+	Render(ctx context.Context, s string, data map[string]interface{}) (string, error)
+	Diff(ctx context.Context, a, b, granularity string, contextLines int) (DiffResult, error)
+	Inflect(ctx context.Context, s, op string) (string, error)
+	Truncate(ctx context.Context, s string, maxLength int, ellipsis string, wordBoundary bool) (string, error)
+	Pad(ctx context.Context, s string, width int, align, fill string) (string, error)
+	Redact(ctx context.Context, s string, types []string, mask string) (string, []RedactionFinding, error)
+	Moderate(ctx context.Context, s string, languages []string, mask string) (ModerationResult, error)
+	WordFreq(ctx context.Context, s string, limit, minCount int, stopWords, exclude []string, caseSensitive bool) ([]WordCount, error)
+	Tokenize(ctx context.Context, s, unit string, n int, lowercase, stripPunctuation bool) ([]string, error)
+	Stem(ctx context.Context, s, language, algorithm string) (string, error)
+	NormalizeUnicode(ctx context.Context, s, form string) (output string, normalized bool, err error)
+	Recode(ctx context.Context, s, charset string) (output, detected string, confidence float64, err error)
+	NumWords(ctx context.Context, n, locale string) (words, formatted string, err error)
+	Sign(ctx context.Context, s, keyID, algorithm, encoding string) (signature, signingKeyID string, err error)
+	Verify(ctx context.Context, s, signature, keyID, algorithm, encoding string) (ok bool, signingKeyID string, err error)
+	JSON(ctx context.Context, s, op, indent string) (string, error)
+	CSVParse(ctx context.Context, s string, dialect CSVDialect) ([][]string, error)
+	CSVFormat(ctx context.Context, rows [][]string, dialect CSVDialect) (string, error)
+	Expand(ctx context.Context, s string, vars map[string]string, missing string, maxDepth int) (output string, unset []string, err error)
+	Emoji(ctx context.Context, s, op string) (output string, count int, err error)
+	Wrap(ctx context.Context, s string, width int, align string, breakLongWords bool, indent, hangingIndent string) (string, error)
+	Phonetic(ctx context.Context, s, algorithm string) (code, alternate string, err error)
+	UppercaseStream(ctx context.Context, r io.Reader, w io.Writer) (n int64, err error)
+	CountStream(ctx context.Context, r io.Reader) (int64, error)
+	CountRunesStream(ctx context.Context, r io.Reader) (int64, error)
+	CSVParseStream(ctx context.Context, r io.Reader, dialect CSVDialect, record func([]string) error) (n int64, err error)
+	CSVFormatStream(ctx context.Context, r io.Reader, w io.Writer, dialect CSVDialect) (n int64, err error)
+}
+
+type stringService struct {
+	languageDetector lingua.LanguageDetector
+	keys             KeyProvider
+	signingKeys      KeyProvider
+	stemmers         map[string]map[string]stemmer
+}
+
+//
+// ────────────────────────────────────────────────────────── I ──────────
+//   :::::: U P P E R C A S E : :  :   :    :     :        :          :
+// ────────────────────────────────────────────────────────────────────
+//
+
+type uppercaseRequest struct {
+	S string `json:"s" validate:"required"`
+}
+
+type uppercaseResponse struct {
+	V   string `json:"v"`
+	Err string `json:"err,omitempty"`
+}
+
+func (stringService) Uppercase(ctx context.Context, s string) (string, error) {
+	return strings.ToUpper(s), nil
+}
+
+func makeUppercaseEndpoint(svc IStringService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(uppercaseRequest)
+		v, err := svc.Uppercase(ctx, req.S)
+		if err != nil {
+			return uppercaseResponse{"", err.Error()}, nil
+		}
+		return uppercaseResponse{v, ""}, nil
+	}
+}
+
+func decodeUppercaseRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request uppercaseRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+//
+// ────────────────────────────────────────────────── I ──────────
+//   :::::: C O U N T : :  :   :    :     :        :          :
+// ────────────────────────────────────────────────────────────
+//
+
+type countRequest struct {
+	S string `json:"s" validate:"required"`
+}
+
+type countResponse struct {
+	V   int    `json:"v"`
+	Err string `json:"err,omitempty"`
+}
+
+func (stringService) Count(ctx context.Context, s string) (int, error) {
+	return len(s), nil
+}
+
+func makeCountEndpoint(svc IStringService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(countRequest)
+		v, err := svc.Count(ctx, req.S)
+		if err != nil {
+			return countResponse{-1, err.Error()}, nil
+		}
+		return countResponse{v, ""}, nil
+	}
+}
+
+// CountRunes counts characters rather than bytes, for /v2/count.
+func (stringService) CountRunes(ctx context.Context, s string) (int, error) {
+	return utf8.RuneCountInString(s), nil
+}
+
+func makeCountRunesEndpoint(svc IStringService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(countRequest)
+		v, err := svc.CountRunes(ctx, req.S)
+		if err != nil {
+			return countResponse{-1, err.Error()}, nil
+		}
+		return countResponse{v, ""}, nil
+	}
+}
+
+func decodeCountRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request countRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+//
+// ──────────────────────────────────────────────── I ──────────
+//   :::::: M A I N : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────
+//
+
+// encodeResponse writes JSON unless another codec was negotiated. The
+// failures reported in the "err" field of response get their "code", and
+// are translated into the language of the request.
+func encodeResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	if hasErr(response) {
+		failure, err := withErrorCode(response, recordedErrorCode(ctx))
+		if err != nil {
+			return err
+		}
+		failure["err"] = localizedFailure(ctx, failure["err"].(string))
+		response = failure
+	}
+	if mediaType, c, ok := responseCodec(ctx); ok {
+		return writeWithCodec(w, mediaType, c, http.StatusOK, response)
+	}
+	return writeJSON(w, response)
+}
+
+// encodeError writes err with the same shape as the regular responses, so
+// clients only ever have to look at the "err" and "code" fields.
+// Validation errors also list the violations in "fields". Messages are in
+// the language of the request.
+func encodeError(ctx context.Context, err error, w http.ResponseWriter) {
+	l := localeFrom(ctx)
+	response := map[string]interface{}{"err": l.Error(err), "code": errorCodeOf(err)}
+	var invalid validationError
+	if errors.As(err, &invalid) {
+		response["fields"] = invalid.localizedViolations(l)
+	}
+	if mediaType, c, ok := responseCodec(ctx); ok {
+		writeWithCodec(w, mediaType, c, codeFrom(err), response)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(codeFrom(err))
+	json.NewEncoder(w).Encode(response)
+}
+
+func codeFrom(err error) int {
+	switch {
+	case errors.As(err, new(*http.MaxBytesError)), errors.Is(err, ErrTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return http.StatusRequestTimeout
+	case errors.As(err, new(validationError)):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, ErrUnknownEncoding), errors.As(err, new(malformedInputError)), errors.As(err, new(operationError)):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrUnsupportedMediaType):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, ErrAccessDenied), errors.Is(err, ErrInsufficientScope), errors.Is(err, ErrPermissionDenied):
+		return http.StatusForbidden
+	case errors.Is(err, ErrIdempotencyKeyReused):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, ErrIdempotencyInFlight):
+		return http.StatusConflict
+	case errors.Is(err, ErrQuotaExceeded), errors.Is(err, ErrRateLimited):
+		return http.StatusTooManyRequests
+	case errors.Is(err, ErrUnknownTenant), errors.Is(err, ErrUnknownLogLevel):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrOperationDisabled), errors.Is(err, ErrFeatureDisabled):
+		return http.StatusForbidden
+	case errors.Is(err, ErrUnauthorized), errors.Is(err, ErrInvalidToken):
+		return http.StatusUnauthorized
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, ErrOverloaded), errors.Is(err, ErrJobQueueFull), errors.Is(err, ErrJobQueueClosed):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, ErrUnknownJob), errors.Is(err, ErrUnknownFlag):
+		return http.StatusNotFound
+	case errors.Is(err, ErrCallbackNotAllowed), errors.Is(err, ErrNoCallback):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, ErrJobNotFinished), errors.Is(err, ErrCallbackInProgress):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// errorClass tells what kind of failure err is, to label metrics: a
+// "timeout", a "validation" error of the client, or an "internal" one.
+func errorClass(err error) string {
+	code := codeFrom(err)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), code == http.StatusRequestTimeout, code == http.StatusGatewayTimeout:
+		return "timeout"
+	case code < http.StatusInternalServerError:
+		return "validation"
+	}
+	return "internal"
+}
+
+//
+// ────────────────────────────────────────────────────────────── I ──────────
+//   :::::: M I D D L E W A R E S : :  :   :    :     :        :          :
+// ────────────────────────────────────────────────────────────────────────
+//
+
+//
+// ─── LOGGING ────────────────────────────────────────────────────────────────────
+//
+
+type loggingMiddleware struct {
+	logger log.Logger
+	next   IStringService
+}
+
+func (mw loggingMiddleware) Uppercase(ctx context.Context, s string) (output string, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "uppercase",
+			"tenant", tenantFrom(ctx),
+			"input", s,
+			"output", output,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	output, err = mw.next.Uppercase(ctx, s)
+	return
+}
+
+func (mw loggingMiddleware) Count(ctx context.Context, s string) (n int, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "count",
+			"tenant", tenantFrom(ctx),
+			"input", s,
+			"n", n,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	n, err = mw.next.Count(ctx, s)
+	return
+}
+
+func (mw loggingMiddleware) CountRunes(ctx context.Context, s string) (n int, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "count_runes",
+			"tenant", tenantFrom(ctx),
+			"input", s,
+			"n", n,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	n, err = mw.next.CountRunes(ctx, s)
+	return
+}
+
+// func loggingMiddleware(logger log.Logger) endpoint.Middleware {
+// 	return func(next endpoint.Endpoint) endpoint.Endpoint {
+// 		return func(ctx context.Context, request interface{}) (interface{}, error) {
+// 			logger.Log("mgs", "calling endpoint")
+// 			defer logger.Log("mgs", "called endpoint")
+// 			return next(ctx, request)
+// 		}
+// 	}
+// }
+
+//
+// ─── INSTRUMENTATION ────────────────────────────────────────────────────────────
+//
+
+type instrumentingMiddleware struct {
+	requestCount     metrics.Counter
+	requestLatency   metrics.Histogram
+	requestsInFlight metrics.Gauge
+	requestSize      metrics.Histogram
+	responseSize     metrics.Histogram
+	requestErrors    metrics.Counter
+	countResult      metrics.Histogram
+	next             IStringService
+}
+
+// begin counts a call to method as in flight, and returns the function
+// recording how it went once done. Responses with no text, like counts,
+// have a negative size and are not observed in responseSize.
+func (mw instrumentingMiddleware) begin(ctx context.Context, method, input string) func(outputBytes int, err error) {
+	tenant := tenantFrom(ctx)
+	inFlight := mw.requestsInFlight.With("method", method, "tenant", tenant)
+	inFlight.Add(1)
+	begin := time.Now()
+	return func(outputBytes int, err error) {
+		inFlight.Add(-1)
+		lvs := []string{"method", method, "error", fmt.Sprint(err != nil), "tenant", tenant}
+		mw.requestCount.With(lvs...).Add(1)
+		mw.requestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
+		mw.requestSize.With("method", method, "tenant", tenant).Observe(float64(len(input)))
+		if outputBytes >= 0 {
+			mw.responseSize.With("method", method, "tenant", tenant).Observe(float64(outputBytes))
+		}
+		if err != nil {
+			mw.requestErrors.With("method", method, "class", errorClass(err), "code", string(errorCodeOf(err)), "tenant", tenant).Add(1)
+			recordErrorCode(ctx, err)
+		}
+	}
+}
+
+func (mw instrumentingMiddleware) Uppercase(ctx context.Context, s string) (output string, err error) {
+	done := mw.begin(ctx, "uppercase", s)
+	defer func() { done(len(output), err) }()
+
+	output, err = mw.next.Uppercase(ctx, s)
+	return
+}
+
+func (mw instrumentingMiddleware) Count(ctx context.Context, s string) (n int, err error) {
+	done := mw.begin(ctx, "count", s)
+	defer func() {
+		done(-1, err)
+		mw.countResult.Observe(float64(n))
+	}()
+
+	n, err = mw.next.Count(ctx, s)
+	return
+}
+
+func (mw instrumentingMiddleware) CountRunes(ctx context.Context, s string) (n int, err error) {
+	done := mw.begin(ctx, "count_runes", s)
+	defer func() { done(-1, err) }()
+
+	n, err = mw.next.CountRunes(ctx, s)
+	return
+}
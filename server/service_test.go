@@ -0,0 +1,28 @@
+package server_test
+
+import (
+	"context"
+	"strings"
+
+	"github.com/anhle128/gokit-stringsvc/server"
+)
+
+// csvService serves the CSV operations with semicolons by default, and
+// the other operations with the service it wraps. It implements
+// IStringService outside of its package, as callers of WithService do.
+type csvService struct {
+	server.IStringService
+}
+
+var _ server.IStringService = csvService{}
+
+func (s csvService) CSVParse(ctx context.Context, text string, dialect server.CSVDialect) ([][]string, error) {
+	if dialect.Delimiter == "" {
+		dialect.Delimiter = ";"
+	}
+	return s.IStringService.CSVParse(ctx, text, dialect)
+}
+
+func (s csvService) Stats(ctx context.Context, text string, wordsPerMinute int) (server.TextStats, error) {
+	return s.IStringService.Stats(ctx, strings.TrimSpace(text), wordsPerMinute)
+}
@@ -0,0 +1,242 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"reflect"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/go-kit/kit/metrics"
+)
+
+//
+// ──────────────────────────────────────────────────── I ──────────
+//   :::::: S H A D O W : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────────
+//
+
+const (
+	// defaultShadowTimeout bounds the shadow requests.
+	defaultShadowTimeout = 5 * time.Second
+	// maxShadowInFlight bounds the shadow requests running at once. The
+	// requests mirrored beyond are dropped, so that a slow shadow never
+	// holds back the service.
+	maxShadowInFlight = 64
+	// shadowHeader marks the shadow requests.
+	shadowHeader = "X-Shadow-Request"
+)
+
+// shadowMetrics are the metrics of the shadow traffic, by route mirrored.
+type shadowMetrics struct {
+	// Requests counts the requests mirrored by "result": "match" when the
+	// shadow answered the same status and body, "mismatch", "error" when it
+	// could not answer, or "dropped" when too many were running.
+	Requests metrics.Counter
+	// LatencyDelta is the latency of the shadow minus that of the request.
+	LatencyDelta metrics.Histogram
+}
+
+// shadowMirror mirrors requests to a second implementation, to compare its
+// responses with those served.
+type shadowMirror struct {
+	percentage float64
+	routes     map[string]string
+	// base is the URL of the remote instance, or nil to mirror to the
+	// routes of this one.
+	base    *url.URL
+	client  *http.Client
+	timeout time.Duration
+	slots   chan struct{}
+	metrics shadowMetrics
+	logger  log.Logger
+}
+
+// newShadowMirror returns the mirror of cfg, nil when no request is
+// mirrored.
+func newShadowMirror(cfg shadowConfig, m shadowMetrics, logger log.Logger) (*shadowMirror, error) {
+	if err := validateRequest(cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Percentage == 0 || len(cfg.Routes) == 0 {
+		return nil, nil
+	}
+	s := &shadowMirror{
+		percentage: cfg.Percentage,
+		routes:     make(map[string]string, len(cfg.Routes)),
+		timeout:    millisecondsOr(cfg.TimeoutMS, defaultShadowTimeout),
+		slots:      make(chan struct{}, maxShadowInFlight),
+		metrics:    m,
+		logger:     logger,
+	}
+	for route, target := range cfg.Routes {
+		if target == "" {
+			target = route
+		}
+		s.routes[route] = target
+	}
+	if cfg.URL != "" {
+		base, err := url.Parse(cfg.URL)
+		if err != nil || !base.IsAbs() {
+			return nil, fmt.Errorf("invalid url %q", cfg.URL)
+		}
+		s.base = base
+		s.client = &http.Client{
+			Timeout:       s.timeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		}
+	} else {
+		for route, target := range s.routes {
+			if target == route {
+				return nil, fmt.Errorf("route %s is mirrored to itself", route)
+			}
+		}
+	}
+	level.Info(logger).Log("msg", "mirroring requests", "percentage", cfg.Percentage, "routes", len(s.routes), "url", cfg.URL)
+	return s, nil
+}
+
+// shadowResponse is what a request was answered, by the service or its
+// shadow.
+type shadowResponse struct {
+	status  int
+	body    []byte
+	latency time.Duration
+}
+
+// withShadow mirrors a share of the POST requests of the routes of s, once
+// next served them, and compares the responses. The responses of the
+// shadow are discarded. Without a remote instance, the requests are
+// mirrored to other routes of next, like /v2/count for /count.
+func withShadow(next http.Handler, s *shadowMirror) http.Handler {
+	if s == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target, ok := s.routes[r.URL.Path]
+		if !ok || r.Method != http.MethodPost || r.Header.Get(shadowHeader) != "" || rand.Float64()*100 >= s.percentage {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			encodeError(r.Context(), err, w)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		rec := &recordingWriter{ResponseWriter: w, status: http.StatusOK}
+		begin := time.Now()
+		next.ServeHTTP(rec, r)
+		served := shadowResponse{rec.status, rec.body.Bytes(), time.Since(begin)}
+
+		select {
+		case s.slots <- struct{}{}:
+		default:
+			s.metrics.Requests.With("route", r.URL.Path, "result", "dropped").Add(1)
+			return
+		}
+		// The shadow request outlives the request, but keeps its values,
+		// like its tenant.
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), s.timeout)
+		shadowReq := r.Clone(ctx)
+		go func() {
+			defer func() { <-s.slots }()
+			defer cancel()
+			s.compare(next, shadowReq, target, body, served)
+		}()
+	})
+}
+
+// compare sends r to the shadow at target, and records how its response
+// compares with served.
+func (s *shadowMirror) compare(next http.Handler, r *http.Request, target string, body []byte, served shadowResponse) {
+	route := r.URL.Path
+	shadow, err := s.send(next, r, target, body)
+	if err != nil {
+		s.metrics.Requests.With("route", route, "result", "error").Add(1)
+		level.Warn(s.logger).Log("msg", "shadow request failed", "route", route, "target", target, "err", err)
+		return
+	}
+	s.metrics.LatencyDelta.With("route", route).Observe((shadow.latency - served.latency).Seconds())
+	if shadow.status == served.status && sameBody(shadow.body, served.body) {
+		s.metrics.Requests.With("route", route, "result", "match").Add(1)
+		return
+	}
+	s.metrics.Requests.With("route", route, "result", "mismatch").Add(1)
+	level.Debug(s.logger).Log("msg", "shadow response differs", "route", route, "target", target,
+		"status", served.status, "shadow_status", shadow.status,
+	)
+}
+
+// send serves r at target by next, or by the remote instance.
+func (s *shadowMirror) send(next http.Handler, r *http.Request, target string, body []byte) (shadowResponse, error) {
+	r.Header.Set(shadowHeader, "true")
+	// The shadow must not replay nor reserve the idempotency key, and its
+	// body is compared uncompressed.
+	r.Header.Del(idempotencyKeyHeader)
+	r.Header.Del("Accept-Encoding")
+	begin := time.Now()
+	if s.base == nil {
+		r.URL.Path, r.RequestURI = target, ""
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		rec := &bufferWriter{header: make(http.Header), status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		if err := r.Context().Err(); err != nil {
+			return shadowResponse{}, err
+		}
+		return shadowResponse{rec.status, rec.body.Bytes(), time.Since(begin)}, nil
+	}
+
+	u := s.base.JoinPath(target)
+	u.RawQuery = r.URL.RawQuery
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return shadowResponse{}, err
+	}
+	req.Header = r.Header
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return shadowResponse{}, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, int64(len(body))+maxShadowResponse))
+	if err != nil {
+		return shadowResponse{}, err
+	}
+	return shadowResponse{resp.StatusCode, data, time.Since(begin)}, nil
+}
+
+// maxShadowResponse bounds the responses of the remote shadow read, beyond
+// the size of the request.
+const maxShadowResponse = 1 << 20
+
+// sameBody tells whether two responses are the same JSON value, whatever
+// the order of their fields, or else the same bytes.
+func sameBody(a, b []byte) bool {
+	var va, vb interface{}
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		return bytes.Equal(a, b)
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+// bufferWriter keeps the response of a shadow request served locally.
+type bufferWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *bufferWriter) Header() http.Header { return w.header }
+
+func (w *bufferWriter) WriteHeader(status int) { w.status = status }
+
+func (w *bufferWriter) Write(p []byte) (int, error) { return w.body.Write(p) }
@@ -0,0 +1,261 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+//
+// ────────────────────────────────────────────────────── I ──────────
+//   :::::: S I G N I N G : :  :   :    :     :        :          :
+// ────────────────────────────────────────────────────────────────
+//
+
+// minSigningKeySize is the smallest HMAC key accepted, in bytes.
+const minSigningKeySize = 16
+
+var (
+	// ErrNoSigningKey is returned when no signing key is configured.
+	ErrNoSigningKey = errors.New("Signing is not configured")
+	// ErrUnknownSigningKey is returned for key IDs that are not configured.
+	ErrUnknownSigningKey = errors.New("Unknown signing key")
+)
+
+// hmacAlgorithms are the hashes of the HMACs computed by Sign, by name.
+var hmacAlgorithms = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// newSigningKeyProvider returns the signing keys of cfg. Keys are rotated
+// by adding a key, making it active once every verifier knows about it,
+// and removing the old one once nothing signed with it is verified any
+// more.
+func newSigningKeyProvider(cfg signingConfig) (*staticKeyProvider, error) {
+	p := &staticKeyProvider{active: cfg.ActiveKey, keys: make(map[string][]byte), unknown: ErrUnknownSigningKey}
+	for id, encoded := range cfg.Keys {
+		if id == "" {
+			return nil, errors.New("signing key without ID")
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("signing key %q: %v", id, err)
+		}
+		if len(key) < minSigningKeySize {
+			return nil, fmt.Errorf("signing key %q is shorter than %d bytes", id, minSigningKeySize)
+		}
+		p.keys[id] = key
+	}
+	if _, ok := p.keys[p.active]; !ok {
+		return nil, fmt.Errorf("active signing key %q is not defined", p.active)
+	}
+	return p, nil
+}
+
+// signingKey returns the key keyID, or the active key when keyID is empty.
+func (svc stringService) signingKey(ctx context.Context, keyID string) (string, []byte, error) {
+	if svc.signingKeys == nil {
+		return "", nil, ErrNoSigningKey
+	}
+	if keyID == "" {
+		return svc.signingKeys.ActiveKey(ctx)
+	}
+	key, err := svc.signingKeys.Key(ctx, keyID)
+	return keyID, key, err
+}
+
+// hmacOf returns the HMAC of s with key.
+func hmacOf(s string, key []byte, algorithm string) ([]byte, error) {
+	if algorithm == "" {
+		algorithm = "sha256"
+	}
+	newHash, ok := hmacAlgorithms[algorithm]
+	if !ok {
+		return nil, errorf(CodeUnknownOption, "Unknown HMAC algorithm %q", algorithm)
+	}
+	mac := hmac.New(newHash, key)
+	mac.Write([]byte(s))
+	return mac.Sum(nil), nil
+}
+
+type signRequest struct {
+	S string `json:"s"`
+	// KeyID is the key to sign with, the active key by default.
+	KeyID string `json:"key_id" validate:"max=255,charset=printable"`
+	// Algorithm is "sha256" (the default) or "sha512".
+	Algorithm string `json:"algorithm" validate:"max=64,charset=printable"`
+	// Encoding of the signature, "hex" (the default) or "base64".
+	Encoding string `json:"encoding" validate:"max=64,charset=printable"`
+}
+
+type signResponse struct {
+	V     string `json:"v"`
+	KeyID string `json:"key_id,omitempty"`
+	Err   string `json:"err,omitempty"`
+}
+
+// Sign returns the HMAC of s with the key keyID, and the ID of that key,
+// which verifiers need once keys are rotated.
+func (svc stringService) Sign(ctx context.Context, s, keyID, algorithm, encoding string) (signature, signingKeyID string, err error) {
+	signingKeyID, key, err := svc.signingKey(ctx, keyID)
+	if err != nil {
+		return "", "", err
+	}
+	sum, err := hmacOf(s, key, algorithm)
+	if err != nil {
+		return "", "", err
+	}
+	switch encoding {
+	case "", "hex":
+		return hex.EncodeToString(sum), signingKeyID, nil
+	case "base64":
+		return base64.StdEncoding.EncodeToString(sum), signingKeyID, nil
+	default:
+		return "", "", errorf(CodeUnknownOption, "Unknown signature encoding %q", encoding)
+	}
+}
+
+func makeSignEndpoint(svc IStringService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(signRequest)
+		v, keyID, err := svc.Sign(ctx, req.S, req.KeyID, req.Algorithm, req.Encoding)
+		if err != nil {
+			return signResponse{"", "", err.Error()}, nil
+		}
+		return signResponse{v, keyID, ""}, nil
+	}
+}
+
+func decodeSignRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request signRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+type verifyRequest struct {
+	S         string `json:"s"`
+	Signature string `json:"signature" validate:"required"`
+	// KeyID is the key the signature was made with, the active key by
+	// default.
+	KeyID     string `json:"key_id" validate:"max=255,charset=printable"`
+	Algorithm string `json:"algorithm" validate:"max=64,charset=printable"`
+	Encoding  string `json:"encoding" validate:"max=64,charset=printable"`
+}
+
+type verifyResponse struct {
+	V     bool   `json:"v"`
+	KeyID string `json:"key_id,omitempty"`
+	Err   string `json:"err,omitempty"`
+}
+
+// Verify tells whether signature is the HMAC of s with the key keyID, in
+// constant time. Signatures that cannot be decoded do not match.
+func (svc stringService) Verify(ctx context.Context, s, signature, keyID, algorithm, encoding string) (ok bool, signingKeyID string, err error) {
+	signingKeyID, key, err := svc.signingKey(ctx, keyID)
+	if err != nil {
+		return false, "", err
+	}
+	sum, err := hmacOf(s, key, algorithm)
+	if err != nil {
+		return false, "", err
+	}
+	var got []byte
+	switch encoding {
+	case "", "hex":
+		got, err = hex.DecodeString(signature)
+	case "base64":
+		got, err = base64.StdEncoding.DecodeString(signature)
+	default:
+		return false, "", errorf(CodeUnknownOption, "Unknown signature encoding %q", encoding)
+	}
+	return err == nil && hmac.Equal(got, sum), signingKeyID, nil
+}
+
+func makeVerifyEndpoint(svc IStringService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(verifyRequest)
+		v, keyID, err := svc.Verify(ctx, req.S, req.Signature, req.KeyID, req.Algorithm, req.Encoding)
+		if err != nil {
+			return verifyResponse{false, "", err.Error()}, nil
+		}
+		return verifyResponse{v, keyID, ""}, nil
+	}
+}
+
+func decodeVerifyRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request verifyRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+//
+// ─── LOGGING ────────────────────────────────────────────────────────────────────
+//
+
+func (mw loggingMiddleware) Sign(ctx context.Context, s, keyID, algorithm, encoding string) (signature, signingKeyID string, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "sign",
+			"tenant", tenantFrom(ctx),
+			"input_size", len(s),
+			"key_id", signingKeyID,
+			"algorithm", algorithm,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	signature, signingKeyID, err = mw.next.Sign(ctx, s, keyID, algorithm, encoding)
+	return
+}
+
+func (mw loggingMiddleware) Verify(ctx context.Context, s, signature, keyID, algorithm, encoding string) (ok bool, signingKeyID string, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "verify",
+			"tenant", tenantFrom(ctx),
+			"input_size", len(s),
+			"key_id", signingKeyID,
+			"algorithm", algorithm,
+			"valid", ok,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	ok, signingKeyID, err = mw.next.Verify(ctx, s, signature, keyID, algorithm, encoding)
+	return
+}
+
+//
+// ─── INSTRUMENTATION ────────────────────────────────────────────────────────────
+//
+
+func (mw instrumentingMiddleware) Sign(ctx context.Context, s, keyID, algorithm, encoding string) (signature, signingKeyID string, err error) {
+	done := mw.begin(ctx, "sign", s)
+	defer func() { done(len(signature), err) }()
+
+	signature, signingKeyID, err = mw.next.Sign(ctx, s, keyID, algorithm, encoding)
+	return
+}
+
+func (mw instrumentingMiddleware) Verify(ctx context.Context, s, signature, keyID, algorithm, encoding string) (ok bool, signingKeyID string, err error) {
+	done := mw.begin(ctx, "verify", s)
+	defer func() { done(-1, err) }()
+
+	ok, signingKeyID, err = mw.next.Verify(ctx, s, signature, keyID, algorithm, encoding)
+	return
+}
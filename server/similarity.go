@@ -0,0 +1,254 @@
+package server
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+//
+// ──────────────────────────────────────────────────────────── I ──────────
+//   :::::: S I M I L A R I T Y : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────────────────
+//
+
+// maxSimilarityRunes bounds the length of the strings compared, as the
+// edit distance takes time in the product of their lengths.
+const maxSimilarityRunes = 1 << 14
+
+// similarityFunc compares a and b and returns the raw distance between them
+// along with a score normalized to [0, 1], where 1 means identical. The
+// quadratic ones stop with the error of ctx once it is done.
+type similarityFunc func(ctx context.Context, a, b []rune) (distance, score float64, err error)
+
+// similarityAlgorithms holds every algorithm the Similarity method knows
+// about, keyed by the name clients send in the "algorithm" field.
+var similarityAlgorithms = map[string]similarityFunc{
+	"levenshtein":  levenshtein,
+	"jaro-winkler": jaroWinkler,
+	"cosine":       cosineBigrams,
+}
+
+// RegisterSimilarity makes a new algorithm available to the /similarity
+// endpoint. It is meant to be called from init functions, before the server
+// starts.
+func RegisterSimilarity(name string, fn similarityFunc) {
+	similarityAlgorithms[name] = fn
+}
+
+type similarityRequest struct {
+	A         string `json:"a"`
+	B         string `json:"b"`
+	Algorithm string `json:"algorithm" validate:"max=64,charset=printable"`
+}
+
+type similarityResponse struct {
+	Distance float64 `json:"distance"`
+	Score    float64 `json:"score"`
+	Err      string  `json:"err,omitempty"`
+}
+
+func (stringService) Similarity(ctx context.Context, a, b, algorithm string) (distance, score float64, err error) {
+	if algorithm == "" {
+		algorithm = "levenshtein"
+	}
+	fn, ok := similarityAlgorithms[algorithm]
+	if !ok {
+		return 0, 0, errorf(CodeUnknownOption, "Unknown similarity algorithm %q", algorithm)
+	}
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) > maxSimilarityRunes || len(rb) > maxSimilarityRunes {
+		return 0, 0, ErrTooLarge
+	}
+	return fn(ctx, ra, rb)
+}
+
+// levenshtein returns the edit distance between a and b, normalized by the
+// length of the longer one.
+func levenshtein(ctx context.Context, a, b []rune) (float64, float64, error) {
+	if len(a) == 0 && len(b) == 0 {
+		return 0, 1, nil
+	}
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		if err := ctx.Err(); err != nil {
+			return 0, 0, err
+		}
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(minInt(prev[j]+1, cur[j-1]+1), prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	d := float64(prev[len(b)])
+	return d, 1 - d/float64(maxInt(len(a), len(b))), nil
+}
+
+// jaroWinkler returns the Jaro-Winkler similarity of a and b; the distance
+// is its complement.
+func jaroWinkler(ctx context.Context, a, b []rune) (float64, float64, error) {
+	if len(a) == 0 && len(b) == 0 {
+		return 0, 1, nil
+	}
+	if len(a) == 0 || len(b) == 0 {
+		return 1, 0, nil
+	}
+
+	window := maxInt(len(a), len(b))/2 - 1
+	if window < 0 {
+		window = 0
+	}
+	matchedA := make([]bool, len(a))
+	matchedB := make([]bool, len(b))
+	matches := 0
+	for i := range a {
+		if err := ctx.Err(); err != nil {
+			return 0, 0, err
+		}
+		lo, hi := maxInt(0, i-window), minInt(len(b), i+window+1)
+		for j := lo; j < hi; j++ {
+			if !matchedB[j] && a[i] == b[j] {
+				matchedA[i], matchedB[j] = true, true
+				matches++
+				break
+			}
+		}
+	}
+	if matches == 0 {
+		return 1, 0, nil
+	}
+
+	transpositions, j := 0, 0
+	for i := range a {
+		if !matchedA[i] {
+			continue
+		}
+		for !matchedB[j] {
+			j++
+		}
+		if a[i] != b[j] {
+			transpositions++
+		}
+		j++
+	}
+
+	m := float64(matches)
+	jaro := (m/float64(len(a)) + m/float64(len(b)) + (m-float64(transpositions)/2)/m) / 3
+
+	prefix := 0
+	for prefix < minInt(4, minInt(len(a), len(b))) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	score := jaro + float64(prefix)*0.1*(1-jaro)
+	return 1 - score, score, nil
+}
+
+// cosineBigrams compares the character bigram frequency vectors of a and b.
+func cosineBigrams(_ context.Context, a, b []rune) (float64, float64, error) {
+	va, vb := bigrams(a), bigrams(b)
+	if len(va) == 0 && len(vb) == 0 {
+		return 0, 1, nil
+	}
+	var dot, na, nb float64
+	for k, x := range va {
+		dot += x * vb[k]
+		na += x * x
+	}
+	for _, y := range vb {
+		nb += y * y
+	}
+	if na == 0 || nb == 0 {
+		return 1, 0, nil
+	}
+	score := dot / (math.Sqrt(na) * math.Sqrt(nb))
+	return 1 - score, score, nil
+}
+
+func bigrams(rs []rune) map[string]float64 {
+	v := make(map[string]float64)
+	if len(rs) == 1 {
+		v[string(rs)]++
+	}
+	for i := 0; i+1 < len(rs); i++ {
+		v[string(rs[i:i+2])]++
+	}
+	return v
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func makeSimilarityEndpoint(svc IStringService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(similarityRequest)
+		distance, score, err := svc.Similarity(ctx, req.A, req.B, req.Algorithm)
+		if err != nil {
+			return similarityResponse{0, 0, err.Error()}, nil
+		}
+		return similarityResponse{distance, score, ""}, nil
+	}
+}
+
+func decodeSimilarityRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request similarityRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+//
+// ─── LOGGING ────────────────────────────────────────────────────────────────────
+//
+
+func (mw loggingMiddleware) Similarity(ctx context.Context, a, b, algorithm string) (distance, score float64, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "similarity",
+			"tenant", tenantFrom(ctx),
+			"a", a,
+			"b", b,
+			"algorithm", algorithm,
+			"distance", distance,
+			"score", score,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	distance, score, err = mw.next.Similarity(ctx, a, b, algorithm)
+	return
+}
+
+//
+// ─── INSTRUMENTATION ────────────────────────────────────────────────────────────
+//
+
+func (mw instrumentingMiddleware) Similarity(ctx context.Context, a, b, algorithm string) (distance, score float64, err error) {
+	done := mw.begin(ctx, "similarity", a+b)
+	defer func() { done(-1, err) }()
+
+	distance, score, err = mw.next.Similarity(ctx, a, b, algorithm)
+	return
+}
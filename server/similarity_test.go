@@ -0,0 +1,45 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSimilarity(t *testing.T) {
+	long := strings.Repeat("a", maxSimilarityRunes)
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name      string
+		ctx       context.Context
+		a, b      string
+		algorithm string
+		wantScore float64
+		wantErr   error
+	}{
+		{name: "identical", a: "kitten", b: "kitten", wantScore: 1},
+		{name: "at the limit", a: long, b: long, algorithm: "cosine", wantScore: 1},
+		{name: "a too long", a: long + "a", b: "a", wantErr: ErrTooLarge},
+		{name: "b too long", a: "a", b: long + "é", algorithm: "jaro-winkler", wantErr: ErrTooLarge},
+		{name: "levenshtein canceled", ctx: canceled, a: "kitten", b: "sitting", wantErr: context.Canceled},
+		{name: "jaro-winkler canceled", ctx: canceled, a: "kitten", b: "sitting", algorithm: "jaro-winkler", wantErr: context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := tt.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			_, score, err := stringService{}.Similarity(ctx, tt.a, tt.b, tt.algorithm)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && score != tt.wantScore {
+				t.Errorf("score = %v, want %v", score, tt.wantScore)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/go-kit/kit/endpoint"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+//
+// ────────────────────────────────────────────────────── I ──────────
+//   :::::: S L U G I F Y : :  :   :    :     :        :          :
+// ────────────────────────────────────────────────────────────────
+//
+
+// foldedLetters covers the Latin letters that do not decompose into a base
+// letter plus combining marks.
+var foldedLetters = strings.NewReplacer(
+	"ß", "ss", "ẞ", "SS",
+	"æ", "ae", "Æ", "AE",
+	"œ", "oe", "Œ", "OE",
+	"ø", "o", "Ø", "O",
+	"đ", "d", "Đ", "D",
+	"ð", "d", "Ð", "D",
+	"ł", "l", "Ł", "L",
+	"þ", "th", "Þ", "TH",
+	"ı", "i",
+)
+
+// foldDiacritics strips combining marks from s, so that "Crème Brûlée"
+// becomes "Creme Brulee".
+func foldDiacritics(s string) string {
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	folded, _, err := transform.String(t, foldedLetters.Replace(s))
+	if err != nil {
+		return s
+	}
+	return folded
+}
+
+type slugifyRequest struct {
+	S               string `json:"s" validate:"required"`
+	Separator       string `json:"separator" validate:"max=16"`
+	MaxLength       int    `json:"max_length" validate:"min=0"`
+	PreserveUnicode bool   `json:"preserve_unicode"`
+}
+
+type slugifyResponse struct {
+	V   string `json:"v"`
+	Err string `json:"err,omitempty"`
+}
+
+func (stringService) Slugify(ctx context.Context, s, separator string, maxLength int, preserveUnicode bool) (string, error) {
+	if separator == "" {
+		separator = "-"
+	}
+	if !preserveUnicode {
+		s = foldDiacritics(s)
+	}
+
+	var words []string
+	for _, w := range strings.FieldsFunc(s, func(r rune) bool {
+		if !preserveUnicode && r > unicode.MaxASCII {
+			return true
+		}
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		words = append(words, strings.ToLower(w))
+	}
+
+	slug := strings.Join(words, separator)
+	if maxLength > 0 && len([]rune(slug)) > maxLength {
+		slug = string([]rune(slug)[:maxLength])
+		// Prefer cutting at a word boundary when there is one.
+		if i := strings.LastIndex(slug, separator); i > 0 {
+			slug = slug[:i]
+		}
+	}
+	return slug, nil
+}
+
+func makeSlugifyEndpoint(svc IStringService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(slugifyRequest)
+		v, err := svc.Slugify(ctx, req.S, req.Separator, req.MaxLength, req.PreserveUnicode)
+		if err != nil {
+			return slugifyResponse{"", err.Error()}, nil
+		}
+		return slugifyResponse{v, ""}, nil
+	}
+}
+
+func decodeSlugifyRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request slugifyRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+//
+// ─── LOGGING ────────────────────────────────────────────────────────────────────
+//
+
+func (mw loggingMiddleware) Slugify(ctx context.Context, s, separator string, maxLength int, preserveUnicode bool) (output string, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "slugify",
+			"tenant", tenantFrom(ctx),
+			"input", s,
+			"separator", separator,
+			"max_length", maxLength,
+			"preserve_unicode", preserveUnicode,
+			"output", output,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	output, err = mw.next.Slugify(ctx, s, separator, maxLength, preserveUnicode)
+	return
+}
+
+//
+// ─── INSTRUMENTATION ────────────────────────────────────────────────────────────
+//
+
+func (mw instrumentingMiddleware) Slugify(ctx context.Context, s, separator string, maxLength int, preserveUnicode bool) (output string, err error) {
+	done := mw.begin(ctx, "slugify", s)
+	defer func() { done(len(output), err) }()
+
+	output, err = mw.next.Slugify(ctx, s, separator, maxLength, preserveUnicode)
+	return
+}
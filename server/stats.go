@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/rivo/uniseg"
+)
+
+//
+// ────────────────────────────────────────────────── I ──────────
+//   :::::: S T A T S : :  :   :    :     :        :          :
+// ────────────────────────────────────────────────────────────
+//
+
+// defaultWordsPerMinute is the reading speed used when the request does not
+// provide one.
+const defaultWordsPerMinute = 200
+
+// TextStats are the counts of a text, from Stats.
+type TextStats struct {
+	Bytes       int `json:"bytes"`
+	Runes       int `json:"runes"`
+	Characters  int `json:"characters"`
+	Words       int `json:"words"`
+	Sentences   int `json:"sentences"`
+	Lines       int `json:"lines"`
+	Paragraphs  int `json:"paragraphs"`
+	ReadingTime int `json:"reading_time_seconds"`
+}
+
+type statsRequest struct {
+	S              string `json:"s"`
+	WordsPerMinute int    `json:"words_per_minute" validate:"min=0"`
+}
+
+type statsResponse struct {
+	V   TextStats `json:"v"`
+	Err string    `json:"err,omitempty"`
+}
+
+// Stats counts characters as user-perceived grapheme clusters, and words and
+// sentences following the Unicode text segmentation rules (UAX #29).
+func (stringService) Stats(ctx context.Context, s string, wordsPerMinute int) (TextStats, error) {
+	if wordsPerMinute <= 0 {
+		wordsPerMinute = defaultWordsPerMinute
+	}
+	st := TextStats{
+		Bytes:      len(s),
+		Runes:      len([]rune(s)),
+		Characters: uniseg.GraphemeClusterCount(s),
+	}
+
+	state := -1
+	for rest := s; rest != ""; {
+		var word string
+		word, rest, state = uniseg.FirstWordInString(rest, state)
+		if strings.IndexFunc(word, isWordRune) >= 0 {
+			st.Words++
+		}
+	}
+
+	state = -1
+	for rest := s; rest != ""; {
+		var sentence string
+		sentence, rest, state = uniseg.FirstSentenceInString(rest, state)
+		if strings.IndexFunc(sentence, isWordRune) >= 0 {
+			st.Sentences++
+		}
+	}
+
+	if s != "" {
+		st.Lines = strings.Count(strings.TrimSuffix(s, "\n"), "\n") + 1
+	}
+	inParagraph := false
+	for _, line := range strings.Split(s, "\n") {
+		blank := strings.TrimSpace(line) == ""
+		if !blank && !inParagraph {
+			st.Paragraphs++
+		}
+		inParagraph = !blank
+	}
+
+	st.ReadingTime = int(math.Ceil(float64(st.Words) * 60 / float64(wordsPerMinute)))
+	return st, nil
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func makeStatsEndpoint(svc IStringService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(statsRequest)
+		v, err := svc.Stats(ctx, req.S, req.WordsPerMinute)
+		if err != nil {
+			return statsResponse{v, err.Error()}, nil
+		}
+		return statsResponse{v, ""}, nil
+	}
+}
+
+func decodeStatsRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request statsRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+//
+// ─── LOGGING ────────────────────────────────────────────────────────────────────
+//
+
+func (mw loggingMiddleware) Stats(ctx context.Context, s string, wordsPerMinute int) (output TextStats, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "stats",
+			"tenant", tenantFrom(ctx),
+			"input", s,
+			"words", output.Words,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	output, err = mw.next.Stats(ctx, s, wordsPerMinute)
+	return
+}
+
+//
+// ─── INSTRUMENTATION ────────────────────────────────────────────────────────────
+//
+
+func (mw instrumentingMiddleware) Stats(ctx context.Context, s string, wordsPerMinute int) (output TextStats, err error) {
+	done := mw.begin(ctx, "stats", s)
+	defer func() { done(-1, err) }()
+
+	output, err = mw.next.Stats(ctx, s, wordsPerMinute)
+	return
+}
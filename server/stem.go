@@ -0,0 +1,179 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	porterstemmer "github.com/blevesearch/go-porterstemmer"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/kljensen/snowball/english"
+	"github.com/kljensen/snowball/french"
+	"github.com/kljensen/snowball/hungarian"
+	"github.com/kljensen/snowball/norwegian"
+	"github.com/kljensen/snowball/russian"
+	"github.com/kljensen/snowball/spanish"
+	"github.com/kljensen/snowball/swedish"
+	"github.com/rivo/uniseg"
+)
+
+//
+// ──────────────────────────────────────────────── I ──────────
+//   :::::: S T E M : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────
+//
+
+// stemmer returns the stem of a lower-case word.
+type stemmer func(word string) string
+
+// stemmingAlgorithms map algorithms to the stemmers of the languages they
+// support. Snowball stemmers also stem stop words, so that every word of
+// an index is stemmed the same way.
+var stemmingAlgorithms = map[string]map[string]stemmer{
+	"snowball": {
+		"en": snowballStemmer(english.Stem),
+		"es": snowballStemmer(spanish.Stem),
+		"fr": snowballStemmer(french.Stem),
+		"hu": snowballStemmer(hungarian.Stem),
+		"no": snowballStemmer(norwegian.Stem),
+		"ru": snowballStemmer(russian.Stem),
+		"sv": snowballStemmer(swedish.Stem),
+	},
+	"porter": {
+		"en": porterstemmer.StemString,
+	},
+}
+
+func snowballStemmer(stem func(word string, stemStopWords bool) string) stemmer {
+	return func(word string) string { return stem(word, true) }
+}
+
+// newStemmers returns the stemmers of languages, by algorithm, or of every
+// language when there is none.
+func newStemmers(languages []string) (map[string]map[string]stemmer, error) {
+	stemmers := make(map[string]map[string]stemmer, len(stemmingAlgorithms))
+	for algorithm, byLanguage := range stemmingAlgorithms {
+		stemmers[algorithm] = make(map[string]stemmer)
+		if len(languages) == 0 {
+			for lang, s := range byLanguage {
+				stemmers[algorithm][lang] = s
+			}
+		}
+	}
+	for _, lang := range languages {
+		supported := false
+		for algorithm, byLanguage := range stemmingAlgorithms {
+			if s, ok := byLanguage[lang]; ok {
+				stemmers[algorithm][lang] = s
+				supported = true
+			}
+		}
+		if !supported {
+			return nil, fmt.Errorf("no stemmer for language %q", lang)
+		}
+	}
+	return stemmers, nil
+}
+
+type stemRequest struct {
+	S string `json:"s"`
+	// Language is "en" by default.
+	Language string `json:"language" validate:"max=64,charset=printable"`
+	// Algorithm is "snowball" (the default) or "porter", which only
+	// supports English.
+	Algorithm string `json:"algorithm" validate:"max=64,charset=printable"`
+}
+
+type stemResponse struct {
+	V   string `json:"v"`
+	Err string `json:"err,omitempty"`
+}
+
+// Stem replaces every word of s, as segmented following UAX #29, with its
+// lower-case stem. Spaces and punctuation are kept.
+func (svc stringService) Stem(ctx context.Context, s, language, algorithm string) (string, error) {
+	if svc.stemmers == nil {
+		return "", errorf(CodeNotConfigured, "Stemming is not available")
+	}
+	if language == "" {
+		language = "en"
+	}
+	if algorithm == "" {
+		algorithm = "snowball"
+	}
+	byLanguage, ok := svc.stemmers[algorithm]
+	if !ok {
+		return "", errorf(CodeUnknownOption, "Unknown stemming algorithm %q", algorithm)
+	}
+	stem, ok := byLanguage[language]
+	if !ok {
+		return "", errorf(CodeUnknownOption, "No %s stemmer for language %q", algorithm, language)
+	}
+
+	var b strings.Builder
+	state := -1
+	for rest := s; rest != ""; {
+		var word string
+		word, rest, state = uniseg.FirstWordInString(rest, state)
+		if strings.IndexFunc(word, isWordRune) < 0 {
+			b.WriteString(word)
+			continue
+		}
+		b.WriteString(stem(strings.ToLower(word)))
+	}
+	return b.String(), nil
+}
+
+func makeStemEndpoint(svc IStringService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(stemRequest)
+		v, err := svc.Stem(ctx, req.S, req.Language, req.Algorithm)
+		if err != nil {
+			return stemResponse{v, err.Error()}, nil
+		}
+		return stemResponse{v, ""}, nil
+	}
+}
+
+func decodeStemRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request stemRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+//
+// ─── LOGGING ────────────────────────────────────────────────────────────────────
+//
+
+func (mw loggingMiddleware) Stem(ctx context.Context, s, language, algorithm string) (output string, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "stem",
+			"tenant", tenantFrom(ctx),
+			"input", s,
+			"language", language,
+			"algorithm", algorithm,
+			"output", output,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	output, err = mw.next.Stem(ctx, s, language, algorithm)
+	return
+}
+
+//
+// ─── INSTRUMENTATION ────────────────────────────────────────────────────────────
+//
+
+func (mw instrumentingMiddleware) Stem(ctx context.Context, s, language, algorithm string) (output string, err error) {
+	done := mw.begin(ctx, "stem", s)
+	defer func() { done(len(output), err) }()
+
+	output, err = mw.next.Stem(ctx, s, language, algorithm)
+	return
+}
@@ -0,0 +1,284 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+//
+// ──────────────────────────────────────────────────── I ──────────
+//   :::::: S T R E A M : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────────
+//
+
+// streamChunkSize is the size of the reads done by the streaming methods,
+// which bounds their memory use whatever the size of the input.
+const streamChunkSize = 32 << 10
+
+// UppercaseStream writes the upper case of everything read from r to w, one
+// chunk at a time. The output is the same as Uppercase on the whole input.
+func (stringService) UppercaseStream(ctx context.Context, r io.Reader, w io.Writer) (n int64, err error) {
+	err = forEachRuneChunk(ctx, r, func(chunk []byte) error {
+		written, err := w.Write(bytes.ToUpper(chunk))
+		n += int64(written)
+		return err
+	})
+	return n, err
+}
+
+// forEachRuneChunk calls fn with the chunks read from r until its end, of
+// at most streamChunkSize bytes plus a rune, so that the memory used does
+// not grow with the input. Runes split across reads are carried over to
+// the next chunk, which thus never ends in the middle of a rune, unless it
+// is the last one. Chunks are only valid until fn returns.
+func forEachRuneChunk(ctx context.Context, r io.Reader, fn func(chunk []byte) error) error {
+	buf := make([]byte, utf8.UTFMax+streamChunkSize)
+	carry := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		read, rerr := r.Read(buf[carry:])
+		chunk := buf[:carry+read]
+
+		cut := len(chunk)
+		if rerr == nil {
+			cut = completeRunes(chunk)
+		}
+		if cut > 0 {
+			if err := fn(chunk[:cut]); err != nil {
+				return err
+			}
+		}
+		carry = copy(buf, chunk[cut:])
+
+		if rerr == io.EOF {
+			return nil
+		}
+		if rerr != nil {
+			return rerr
+		}
+	}
+}
+
+// completeRunes returns the length of the longest prefix of p that does not
+// end in the middle of a rune.
+func completeRunes(p []byte) int {
+	for i := len(p) - 1; i >= 0 && i >= len(p)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(p[i]) {
+			if utf8.FullRune(p[i:]) {
+				return len(p)
+			}
+			return i
+		}
+	}
+	return len(p)
+}
+
+// CountStream counts the bytes read from r, like Count does for strings.
+func (stringService) CountStream(ctx context.Context, r io.Reader) (int64, error) {
+	return io.CopyBuffer(io.Discard, contextReader{ctx, r}, make([]byte, streamChunkSize))
+}
+
+// CountRunesStream counts the characters read from r, like CountRunes does
+// for strings: the bytes that are not valid UTF-8 count as one character
+// each.
+func (stringService) CountRunesStream(ctx context.Context, r io.Reader) (n int64, err error) {
+	err = forEachRuneChunk(ctx, r, func(chunk []byte) error {
+		n += int64(utf8.RuneCount(chunk))
+		return nil
+	})
+	return n, err
+}
+
+// contextReader stops reading once its context is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// isStreamRoute tells whether path is a stream route, whose responses the
+// middlewares must not hold back.
+func isStreamRoute(path string) bool {
+	return strings.HasPrefix(path, "/stream/") || strings.HasPrefix(path, "/"+currentAPIVersion+"/stream/")
+}
+
+// streamAdmitted ends the checks run before serving a stream: check in
+// guardedStream.
+func streamAdmitted(context.Context, interface{}) (interface{}, error) {
+	return nil, nil
+}
+
+// guardedStream serves the stream route of an operation with next once
+// check, the flag, scopes, roles and tenant toggle of the operation ending
+// in streamAdmitted, lets the request through. Streaming routes call the
+// service directly rather than its endpoints, so the checks are run before
+// the first read, and their errors answered like those of the endpoints.
+func guardedStream(check endpoint.Endpoint, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := check(r.Context(), nil); err != nil {
+			encodeError(r.Context(), err, w)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ndjsonWriter turns each write into a {"v": ...} line of newline-delimited
+// JSON, flushed right away so clients see the output as it is produced.
+type ndjsonWriter struct {
+	w   http.ResponseWriter
+	enc *json.Encoder
+}
+
+func newNDJSONWriter(w http.ResponseWriter) *ndjsonWriter {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	return &ndjsonWriter{w, json.NewEncoder(w)}
+}
+
+func (nw *ndjsonWriter) Write(p []byte) (int, error) {
+	if err := nw.enc.Encode(uppercaseResponse{V: string(p)}); err != nil {
+		return 0, err
+	}
+	if f, ok := nw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return len(p), nil
+}
+
+// fail writes the last line of a failed stream, response with the
+// failure err in its "err" field, and the code of err.
+func (nw *ndjsonWriter) fail(response interface{}, err error) {
+	if m, merr := withErrorCode(response, errorCodeOf(err)); merr == nil {
+		response = m
+	}
+	nw.enc.Encode(response)
+}
+
+// makeUppercaseStreamHandler serves /stream/uppercase. The request body is
+// the raw text to convert, usually sent chunked, or a multipart upload; the response is a stream
+// of {"v": chunk} lines, ending with an {"err": ...} line on failure.
+// go-kit's HTTP server decodes whole requests, so streaming routes are
+// plain handlers calling the service directly.
+func makeUppercaseStreamHandler(svc IStringService) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nw := newNDJSONWriter(w)
+		body, err := uploadBody(r)
+		if err == nil {
+			_, err = svc.UppercaseStream(r.Context(), body, nw)
+		}
+		if err != nil {
+			nw.fail(uppercaseResponse{Err: err.Error()}, err)
+		}
+	})
+}
+
+// makeCountStreamHandler serves /stream/count with the bytes of count, and
+// /v2/stream/count with its characters, answering with a single {"v": n}
+// line once the whole body has been read.
+func makeCountStreamHandler(count func(context.Context, io.Reader) (int64, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nw := newNDJSONWriter(w)
+		body, err := uploadBody(r)
+		var n int64
+		if err == nil {
+			n, err = count(r.Context(), body)
+		}
+		if err != nil {
+			nw.fail(countResponse{-1, err.Error()}, err)
+			return
+		}
+		nw.enc.Encode(struct {
+			V int64 `json:"v"`
+		}{n})
+	})
+}
+
+//
+// ─── LOGGING ────────────────────────────────────────────────────────────────────
+//
+
+func (mw loggingMiddleware) UppercaseStream(ctx context.Context, r io.Reader, w io.Writer) (n int64, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "uppercase_stream",
+			"tenant", tenantFrom(ctx),
+			"output_size", n,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	n, err = mw.next.UppercaseStream(ctx, r, w)
+	return
+}
+
+func (mw loggingMiddleware) CountStream(ctx context.Context, r io.Reader) (n int64, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "count_stream",
+			"tenant", tenantFrom(ctx),
+			"n", n,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	n, err = mw.next.CountStream(ctx, r)
+	return
+}
+
+func (mw loggingMiddleware) CountRunesStream(ctx context.Context, r io.Reader) (n int64, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "count_runes_stream",
+			"tenant", tenantFrom(ctx),
+			"n", n,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	n, err = mw.next.CountRunesStream(ctx, r)
+	return
+}
+
+//
+// ─── INSTRUMENTATION ────────────────────────────────────────────────────────────
+//
+
+func (mw instrumentingMiddleware) UppercaseStream(ctx context.Context, r io.Reader, w io.Writer) (n int64, err error) {
+	done := mw.begin(ctx, "uppercase_stream", "")
+	defer func() { done(int(n), err) }()
+
+	n, err = mw.next.UppercaseStream(ctx, r, w)
+	return
+}
+
+func (mw instrumentingMiddleware) CountStream(ctx context.Context, r io.Reader) (n int64, err error) {
+	done := mw.begin(ctx, "count_stream", "")
+	defer func() { done(-1, err) }()
+
+	n, err = mw.next.CountStream(ctx, r)
+	return
+}
+
+func (mw instrumentingMiddleware) CountRunesStream(ctx context.Context, r io.Reader) (n int64, err error) {
+	done := mw.begin(ctx, "count_runes_stream", "")
+	defer func() { done(-1, err) }()
+
+	n, err = mw.next.CountRunesStream(ctx, r)
+	return
+}
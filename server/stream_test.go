@@ -0,0 +1,232 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"unicode/utf8"
+)
+
+// piecesReader returns its pieces one read at a time.
+type piecesReader []string
+
+func (r *piecesReader) Read(p []byte) (int, error) {
+	if len(*r) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, (*r)[0])
+	if (*r)[0] = (*r)[0][n:]; (*r)[0] == "" {
+		*r = (*r)[1:]
+	}
+	return n, nil
+}
+
+func TestForEachRuneChunk(t *testing.T) {
+	long := strings.Repeat("a", streamChunkSize-1) + "é" + strings.Repeat("ß", streamChunkSize)
+	tests := []struct {
+		name   string
+		reader func() io.Reader
+		want   string
+	}{
+		{
+			name:   "empty",
+			reader: func() io.Reader { return strings.NewReader("") },
+		},
+		{
+			name:   "ASCII",
+			reader: func() io.Reader { return strings.NewReader("hello") },
+			want:   "hello",
+		},
+		{
+			name:   "rune split across reads",
+			reader: func() io.Reader { return &piecesReader{"ma\xc3", "\xb1ana"} },
+			want:   "mañana",
+		},
+		{
+			name:   "four-byte rune split in three",
+			reader: func() io.Reader { return &piecesReader{"a\xf0\x9f", "\x98", "\x80b"} },
+			want:   "a😀b",
+		},
+		{
+			name:   "one byte at a time",
+			reader: func() io.Reader { return iotest.OneByteReader(strings.NewReader("日本語 ß")) },
+			want:   "日本語 ß",
+		},
+		{
+			name:   "rune across the chunk size",
+			reader: func() io.Reader { return strings.NewReader(long) },
+			want:   long,
+		},
+		{
+			name:   "truncated rune at the end",
+			reader: func() io.Reader { return &piecesReader{"ab", "\xe2\x82"} },
+			want:   "ab\xe2\x82",
+		},
+		{
+			name:   "invalid byte mid-stream",
+			reader: func() io.Reader { return &piecesReader{"a\xe2\x82", "b"} },
+			want:   "a\xe2\x82b",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var chunks []string
+			err := forEachRuneChunk(context.Background(), tt.reader(), func(chunk []byte) error {
+				chunks = append(chunks, string(chunk))
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Join(chunks, ""); got != tt.want {
+				t.Fatalf("chunks join to %d bytes, want %d", len(got), len(tt.want))
+			}
+			for i, c := range chunks {
+				if len(c) > streamChunkSize+utf8.UTFMax {
+					t.Errorf("chunk %d has %d bytes", i, len(c))
+				}
+				if i < len(chunks)-1 && completeRunes([]byte(c)) != len(c) {
+					t.Errorf("chunk %d ends in the middle of a rune", i)
+				}
+			}
+
+			var out bytes.Buffer
+			if _, err := (stringService{}).UppercaseStream(context.Background(), tt.reader(), &out); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != strings.ToUpper(tt.want) {
+				t.Errorf("UppercaseStream differs from strings.ToUpper")
+			}
+			n, err := (stringService{}).CountRunesStream(context.Background(), tt.reader())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := int64(utf8.RuneCountInString(tt.want)); n != want {
+				t.Errorf("CountRunesStream = %d, want %d", n, want)
+			}
+		})
+	}
+}
+
+func TestStreamRoutesGuarded(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     string
+		route      string
+		apiKey     string
+		wantStatus int
+	}{
+		{name: "allowed", config: `{}`, route: "/stream/uppercase", wantStatus: http.StatusOK},
+		{
+			name:       "flag off",
+			config:     `{"flags": {"flags": {"operation.uppercase": {"enabled": false}}}}`,
+			route:      "/stream/uppercase",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "role missing",
+			config:     `{"authorization": {"operations": {"count": ["admin"]}}}`,
+			route:      "/stream/count",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "role missing on v2",
+			config:     `{"authorization": {"operations": {"count": ["admin"]}}}`,
+			route:      "/v2/stream/count",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "role granted",
+			config:     `{"authorization": {"operations": {"count": ["admin"]}, "api_keys": [{"api_key": "k", "roles": ["admin"]}]}}`,
+			route:      "/stream/count",
+			apiKey:     "k",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "scope missing",
+			config:     `{"oidc": {"issuer": "https://issuer.example", "scopes": {"csv-format": ["csv"]}}}`,
+			route:      "/stream/csv/format",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "disabled by the tenant",
+			config:     `{"tenancy": {"tenants": {"default": {"operations": {"csv-parse": false}}}}}`,
+			route:      "/stream/csv/parse",
+			wantStatus: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := New(
+				WithConfigJSON([]byte(tt.config)),
+				WithService(stringService{}),
+				WithLogOutput(io.Discard),
+				WithMetricsProvider(discardProvider{}),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Shutdown(context.Background())
+
+			body := &piecesReader{"a,b\n"}
+			r := httptest.NewRequest(http.MethodPost, tt.route, body)
+			if tt.apiKey != "" {
+				r.Header.Set("X-API-Key", tt.apiKey)
+			}
+			w := httptest.NewRecorder()
+			s.Handler().ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus != http.StatusOK && len(*body) == 0 {
+				t.Error("body read before the request was rejected")
+			}
+		})
+	}
+}
+
+// The stream routes are not bounded by the size limit of request bodies.
+func TestStreamCountPastBodyLimit(t *testing.T) {
+	s, err := New(
+		WithConfigJSON([]byte(`{}`)),
+		WithService(stringService{}),
+		WithLogOutput(io.Discard),
+		WithMetricsProvider(discardProvider{}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown(context.Background())
+
+	input := strings.Repeat("é", defaultMaxBodyBytes/2+1<<10)
+	tests := []struct {
+		route string
+		want  int
+	}{
+		{route: "/stream/count", want: len(input)},
+		{route: "/v2/stream/count", want: utf8.RuneCountInString(input)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.route, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, tt.route, strings.NewReader(input))
+			w := httptest.NewRecorder()
+			s.Handler().ServeHTTP(w, r)
+			var got struct {
+				V   int    `json:"v"`
+				Err string `json:"err"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatalf("%v: %s", err, w.Body)
+			}
+			if w.Code != http.StatusOK || got.V != tt.want {
+				t.Errorf("status %d, count %d, want %d: %s", w.Code, got.V, tt.want, got.Err)
+			}
+		})
+	}
+}
@@ -0,0 +1,328 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/go-kit/kit/metrics"
+	"golang.org/x/time/rate"
+)
+
+//
+// ────────────────────────────────────────────────────── I ──────────
+//   :::::: T E N A N C Y : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────────────
+//
+
+// tenantHeader names the tenant of a request, when no API key or resolver
+// identifies it and it comes from a trusted proxy. Requests of no tenant
+// belong to defaultTenant.
+const (
+	tenantHeader  = "X-Tenant-ID"
+	defaultTenant = "default"
+)
+
+// defaultTenantReload is how often tenants are reloaded from their file.
+const defaultTenantReload = time.Minute
+
+var (
+	// ErrUnknownTenant is returned for requests naming a tenant that is not
+	// configured, with a 400.
+	ErrUnknownTenant = errors.New("Unknown tenant")
+	// ErrRateLimited is returned to the tenants over their request rate,
+	// with a 429.
+	ErrRateLimited = errors.New("Rate limit exceeded")
+	// ErrOperationDisabled is returned for the operations toggled off for
+	// the tenant, with a 403.
+	ErrOperationDisabled = errors.New("Operation disabled for this tenant")
+)
+
+// tenantResolver returns the tenant of r, if it can tell.
+type tenantResolver func(r *http.Request) (string, bool)
+
+// tenantResolvers are consulted in order before the API key and the
+// X-Tenant-ID header.
+var tenantResolvers []tenantResolver
+
+// RegisterTenantResolver adds a way to identify tenants, like the claims of
+// an authentication token.
+func RegisterTenantResolver(resolve tenantResolver) {
+	tenantResolvers = append(tenantResolvers, resolve)
+}
+
+type tenantKey struct{}
+
+// tenantFrom returns the tenant of the request of ctx, to label logs and
+// metrics.
+func tenantFrom(ctx context.Context) string {
+	if tenant, ok := ctx.Value(tenantKey{}).(string); ok {
+		return tenant
+	}
+	return defaultTenant
+}
+
+// tenantStore loads the configuration of tenants, by name.
+type tenantStore interface {
+	Tenants() (map[string]tenantConfig, error)
+}
+
+type staticTenantStore map[string]tenantConfig
+
+func (s staticTenantStore) Tenants() (map[string]tenantConfig, error) {
+	return s, nil
+}
+
+// fileTenantStore reads tenants from a JSON file, which can be edited
+// without restarting the service.
+type fileTenantStore string
+
+func (path fileTenantStore) Tenants() (map[string]tenantConfig, error) {
+	data, err := os.ReadFile(string(path))
+	if err != nil {
+		return nil, err
+	}
+	var tenants map[string]tenantConfig
+	err = json.Unmarshal(data, &tenants)
+	return tenants, err
+}
+
+// tenant is a loaded tenantConfig.
+type tenant struct {
+	limiter *rate.Limiter // nil when unlimited
+	// disabled holds the names, routes and stream routes of the disabled
+	// operations.
+	disabled map[string]bool
+}
+
+// tenancy identifies the tenant of requests and applies its configuration.
+type tenancy struct {
+	store tenantStore
+	// trustedProxies may name the tenant of requests in tenantHeader.
+	trustedProxies []netip.Prefix
+	// stop ends the reloads of the tenants.
+	stop chan struct{}
+
+	mu       sync.RWMutex
+	tenants  map[string]*tenant
+	byAPIKey map[string]string
+}
+
+// newTenancy loads the tenants of cfg, and keeps reloading them when they
+// come from a file, until closed.
+func newTenancy(cfg tenancyConfig, logger log.Logger) (*tenancy, error) {
+	t := &tenancy{store: staticTenantStore(cfg.Tenants), stop: make(chan struct{})}
+	var err error
+	if t.trustedProxies, err = parsePrefixes(cfg.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("tenancy.trusted_proxies: %v", err)
+	}
+	if cfg.File != "" {
+		t.store = fileTenantStore(cfg.File)
+	}
+	if err := t.reload(); err != nil {
+		return nil, err
+	}
+	if cfg.File != "" {
+		interval := defaultTenantReload
+		if cfg.ReloadSeconds > 0 {
+			interval = time.Duration(cfg.ReloadSeconds) * time.Second
+		}
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					if err := t.reload(); err != nil {
+						level.Error(logger).Log("msg", "cannot reload tenants", "err", err)
+					}
+				case <-t.stop:
+					return
+				}
+			}
+		}()
+	}
+	return t, nil
+}
+
+// close stops reloading the tenants.
+func (t *tenancy) close() {
+	close(t.stop)
+}
+
+// reload replaces the tenants with those of the store. Rate limiters are
+// kept, so reloading does not refill them.
+func (t *tenancy) reload() error {
+	t.mu.RLock()
+	store := t.store
+	t.mu.RUnlock()
+	configs, err := store.Tenants()
+	if err != nil {
+		return err
+	}
+	t.mu.RLock()
+	previous := t.tenants
+	t.mu.RUnlock()
+
+	tenants := make(map[string]*tenant, len(configs))
+	byAPIKey := make(map[string]string)
+	for name, cfg := range configs {
+		tn := &tenant{disabled: make(map[string]bool)}
+		if cfg.RequestsPerSecond > 0 {
+			burst := cfg.Burst
+			if burst <= 0 {
+				burst = int(cfg.RequestsPerSecond) + 1
+			}
+			if old, ok := previous[name]; ok && old.limiter != nil {
+				tn.limiter = old.limiter
+				tn.limiter.SetLimit(rate.Limit(cfg.RequestsPerSecond))
+				tn.limiter.SetBurst(burst)
+			} else {
+				tn.limiter = rate.NewLimiter(rate.Limit(cfg.RequestsPerSecond), burst)
+			}
+		}
+		for op, enabled := range cfg.Operations {
+			if !enabled {
+				tn.disabled[op] = true
+				tn.disabled[operationRoute(op)] = true
+				for _, route := range operationStreamRoutes[op] {
+					tn.disabled[route] = true
+				}
+			}
+		}
+		for _, key := range cfg.APIKeys {
+			byAPIKey[key] = name
+		}
+		tenants[name] = tn
+	}
+
+	t.mu.Lock()
+	t.tenants, t.byAPIKey = tenants, byAPIKey
+	t.mu.Unlock()
+	return nil
+}
+
+// setStore loads the tenants from store from now on.
+func (t *tenancy) setStore(store tenantStore) error {
+	t.mu.Lock()
+	t.store = store
+	t.mu.Unlock()
+	return t.reload()
+}
+
+// identify returns the name of the tenant of r.
+func (t *tenancy) identify(r *http.Request) (string, error) {
+	for _, resolve := range tenantResolvers {
+		if name, ok := resolve(r); ok {
+			return name, nil
+		}
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	// The tenant claim of an access token outranks the headers, which the
+	// token authenticates.
+	if token := accessTokenFrom(r.Context()); token != nil && token.Tenant != "" {
+		if _, ok := t.tenants[token.Tenant]; !ok {
+			return "", ErrUnknownTenant
+		}
+		return token.Tenant, nil
+	}
+	if name, ok := t.byAPIKey[r.Header.Get(apiKeyHeader)]; ok {
+		return name, nil
+	}
+	if name := r.Header.Get(tenantHeader); name != "" && t.trusts(r) {
+		if _, ok := t.tenants[name]; !ok {
+			return "", ErrUnknownTenant
+		}
+		return name, nil
+	}
+	return defaultTenant, nil
+}
+
+// trusts tells whether the peer of r is a trusted proxy. The clients of the
+// Unix socket are trusted as 127.0.0.1, like by the access rules.
+func (t *tenancy) trusts(r *http.Request) bool {
+	if local, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok && local.Network() == "unix" {
+		return prefixesContain(t.trustedProxies, netip.AddrFrom4([4]byte{127, 0, 0, 1}))
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	return err == nil && prefixesContain(t.trustedProxies, addr.Unmap())
+}
+
+func (t *tenancy) tenant(name string) *tenant {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if tn, ok := t.tenants[name]; ok {
+		return tn
+	}
+	return &tenant{}
+}
+
+// enabled rejects the calls to the operation name by the tenants that
+// disabled it, for the transports invoking operations by name.
+func (t *tenancy) enabled(name string, next endpoint.Endpoint) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		if t.tenant(tenantFrom(ctx)).disabled[name] {
+			return nil, ErrOperationDisabled
+		}
+		return next(ctx, request)
+	}
+}
+
+type rateLimitsOffKey struct{}
+
+// withoutRateLimits lets the requests through the rate limits of their
+// tenant, while the flag "middleware.ratelimits" is off.
+func withoutRateLimits(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), rateLimitsOffKey{}, true)))
+	})
+}
+
+// withTenancy keeps the tenant of every request in its context, and rejects
+// the requests over the rate of their tenant or to the routes it disabled,
+// counting them in rejected by tenant and reason.
+func withTenancy(next http.Handler, t *tenancy, rejected metrics.Counter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, err := t.admit(r, rejected)
+		if err != nil {
+			encodeError(ctx, err, w)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// admit returns the context of r with its tenant, or why the tenant rejects
+// it, counted in rejected like by withTenancy.
+func (t *tenancy) admit(r *http.Request, rejected metrics.Counter) (context.Context, error) {
+	name, err := t.identify(r)
+	if err != nil {
+		return r.Context(), err
+	}
+	ctx := context.WithValue(r.Context(), tenantKey{}, name)
+	tn := t.tenant(name)
+	switch {
+	case tn.disabled[r.URL.Path]:
+		rejected.With("tenant", name, "reason", "disabled").Add(1)
+		return ctx, ErrOperationDisabled
+	case tn.limiter != nil && ctx.Value(rateLimitsOffKey{}) == nil && !tn.limiter.Allow():
+		rejected.With("tenant", name, "reason", "rate_limited").Add(1)
+		return ctx, ErrRateLimited
+	}
+	return ctx, nil
+}
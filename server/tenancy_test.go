@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics/discard"
+)
+
+func TestTenantHeader(t *testing.T) {
+	tenants, err := newTenancy(tenancyConfig{
+		Tenants: map[string]tenantConfig{
+			"acme":   {APIKeys: []string{"acme-key"}},
+			"globex": {},
+		},
+		TrustedProxies: []string{"10.0.0.0/8", "127.0.0.1"},
+	}, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tenants.close()
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		unix       bool
+		apiKey     string
+		header     string
+		wantTenant string
+		wantErr    error
+	}{
+		{name: "no header", remoteAddr: "10.1.2.3:1234", wantTenant: defaultTenant},
+		{name: "trusted proxy", remoteAddr: "10.1.2.3:1234", header: "globex", wantTenant: "globex"},
+		{name: "IPv4-mapped trusted proxy", remoteAddr: "[::ffff:10.1.2.3]:1234", header: "globex", wantTenant: "globex"},
+		{name: "Unix socket", unix: true, remoteAddr: "@", header: "globex", wantTenant: "globex"},
+		{name: "client", remoteAddr: "192.0.2.1:1234", header: "globex", wantTenant: defaultTenant},
+		{name: "unknown tenant", remoteAddr: "10.1.2.3:1234", header: "initech", wantErr: ErrUnknownTenant},
+		{name: "API key first", remoteAddr: "10.1.2.3:1234", apiKey: "acme-key", header: "globex", wantTenant: "acme"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/uppercase", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.unix {
+				r = r.WithContext(context.WithValue(r.Context(), http.LocalAddrContextKey, &net.UnixAddr{Name: "/run/stringsvc.sock", Net: "unix"}))
+			}
+			if tt.apiKey != "" {
+				r.Header.Set(apiKeyHeader, tt.apiKey)
+			}
+			if tt.header != "" {
+				r.Header.Set(tenantHeader, tt.header)
+			}
+			name, err := tenants.identify(r)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if name != tt.wantTenant {
+				t.Errorf("tenant = %q, want %q", name, tt.wantTenant)
+			}
+		})
+	}
+}
+
+func TestTenantDisabledRoutes(t *testing.T) {
+	tenants, err := newTenancy(tenancyConfig{
+		Tenants: map[string]tenantConfig{
+			"default": {Operations: map[string]bool{"uppercase": false, "count": false, "csv-parse": false}},
+		},
+	}, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tenants.close()
+
+	tests := []struct {
+		route   string
+		wantErr error
+	}{
+		{route: "/uppercase", wantErr: ErrOperationDisabled},
+		{route: "/stream/uppercase", wantErr: ErrOperationDisabled},
+		{route: "/count", wantErr: ErrOperationDisabled},
+		{route: "/stream/count", wantErr: ErrOperationDisabled},
+		{route: "/v2/stream/count", wantErr: ErrOperationDisabled},
+		{route: "/csv/parse", wantErr: ErrOperationDisabled},
+		{route: "/stream/csv/parse", wantErr: ErrOperationDisabled},
+		{route: "/stream/csv/format"},
+		{route: "/hash"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.route, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, tt.route, nil)
+			if _, err := tenants.admit(r, discard.NewCounter()); !errors.Is(err, tt.wantErr) {
+				t.Errorf("admit error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,281 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+//
+// ──────────────────────────────────────────────────── I ──────────
+//   :::::: T H R I F T : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────────
+//
+
+// The Thrift server implements thrift/stringsvc.thrift. Every argument of
+// that IDL is a string and every result a string or an i64, so the
+// processor is written by hand rather than generated, which keeps the
+// Thrift compiler out of the build.
+
+// thriftMethod handles one method of the IDL. args holds the string
+// arguments by field ID; the result is either a string or an int64.
+type thriftMethod func(ctx context.Context, args map[int16]string) (interface{}, error)
+
+// thriftOperationError is the OperationError exception of the IDL.
+type thriftOperationError struct {
+	message string
+	code    ErrorCode
+}
+
+func newThriftOperationError(err error) thriftOperationError {
+	return thriftOperationError{err.Error(), errorCodeOf(err)}
+}
+
+func (e thriftOperationError) Error() string { return e.message }
+
+// thriftProcessor dispatches incoming calls to its methods.
+type thriftProcessor struct {
+	methods map[string]thrift.TProcessorFunction
+}
+
+func newThriftProcessor(svc IStringService, ops map[string]operation) *thriftProcessor {
+	p := &thriftProcessor{methods: make(map[string]thrift.TProcessorFunction)}
+	p.AddToProcessorMap("Uppercase", thriftFunction{"Uppercase", func(ctx context.Context, args map[int16]string) (interface{}, error) {
+		if err := validateRequest(uppercaseRequest{args[1]}); err != nil {
+			return nil, newThriftOperationError(err)
+		}
+		v, err := svc.Uppercase(ctx, args[1])
+		if err != nil {
+			return nil, newThriftOperationError(err)
+		}
+		return v, nil
+	}})
+	p.AddToProcessorMap("Count", thriftFunction{"Count", func(ctx context.Context, args map[int16]string) (interface{}, error) {
+		if err := validateRequest(countRequest{args[1]}); err != nil {
+			return nil, newThriftOperationError(err)
+		}
+		n, err := svc.Count(ctx, args[1])
+		if err != nil {
+			return nil, newThriftOperationError(err)
+		}
+		return int64(n), nil
+	}})
+	p.AddToProcessorMap("Call", thriftFunction{"Call", func(ctx context.Context, args map[int16]string) (interface{}, error) {
+		op, ok := ops[args[1]]
+		if !ok {
+			return nil, newThriftOperationError(errorf(CodeUnknownOperation, "Unknown operation %q", args[1]))
+		}
+		req, err := op.decode([]byte(args[2]))
+		if err != nil {
+			return nil, newThriftOperationError(err)
+		}
+		ctx, codes := withErrorCodes(ctx)
+		resp, err := op.Endpoint(ctx, req)
+		if err != nil {
+			return nil, newThriftOperationError(err)
+		}
+		if hasErr(resp) {
+			if resp, err = withErrorCode(resp, codes.Code()); err != nil {
+				return nil, err
+			}
+		}
+		data, err := json.Marshal(resp)
+		return string(data), err
+	}})
+	return p
+}
+
+func (p *thriftProcessor) ProcessorMap() map[string]thrift.TProcessorFunction {
+	return p.methods
+}
+
+func (p *thriftProcessor) AddToProcessorMap(name string, fn thrift.TProcessorFunction) {
+	p.methods[name] = fn
+}
+
+func (p *thriftProcessor) Process(ctx context.Context, in, out thrift.TProtocol) (bool, thrift.TException) {
+	name, _, seqID, err := in.ReadMessageBegin(ctx)
+	if err != nil {
+		return false, thrift.WrapTException(err)
+	}
+	if fn, ok := p.methods[name]; ok {
+		return fn.Process(ctx, seqID, in, out)
+	}
+
+	in.Skip(ctx, thrift.STRUCT)
+	in.ReadMessageEnd(ctx)
+	exc := thrift.NewTApplicationException(thrift.UNKNOWN_METHOD, "Unknown function "+name)
+	out.WriteMessageBegin(ctx, name, thrift.EXCEPTION, seqID)
+	exc.Write(ctx, out)
+	out.WriteMessageEnd(ctx)
+	out.Flush(ctx)
+	return false, exc
+}
+
+// thriftFunction reads the arguments of a call, runs its method and writes
+// the reply, the way generated processor functions do.
+type thriftFunction struct {
+	name   string
+	method thriftMethod
+}
+
+func (f thriftFunction) Process(ctx context.Context, seqID int32, in, out thrift.TProtocol) (bool, thrift.TException) {
+	args, err := readThriftStringArgs(ctx, in)
+	if err == nil {
+		err = in.ReadMessageEnd(ctx)
+	}
+	if err != nil {
+		exc := thrift.NewTApplicationException(thrift.PROTOCOL_ERROR, err.Error())
+		out.WriteMessageBegin(ctx, f.name, thrift.EXCEPTION, seqID)
+		exc.Write(ctx, out)
+		out.WriteMessageEnd(ctx)
+		out.Flush(ctx)
+		return false, thrift.WrapTException(err)
+	}
+
+	result, err := f.method(ctx, args)
+	var opErr thriftOperationError
+	if err != nil && !errors.As(err, &opErr) {
+		exc := thrift.NewTApplicationException(thrift.INTERNAL_ERROR, err.Error())
+		out.WriteMessageBegin(ctx, f.name, thrift.EXCEPTION, seqID)
+		exc.Write(ctx, out)
+		out.WriteMessageEnd(ctx)
+		out.Flush(ctx)
+		return true, exc
+	}
+
+	if err := writeThriftResult(ctx, out, f.name, seqID, result, err != nil, opErr); err != nil {
+		return false, thrift.WrapTException(err)
+	}
+	return true, nil
+}
+
+// readThriftStringArgs reads an argument struct, skipping any field that is
+// not a string.
+func readThriftStringArgs(ctx context.Context, in thrift.TProtocol) (map[int16]string, error) {
+	args := make(map[int16]string)
+	if _, err := in.ReadStructBegin(ctx); err != nil {
+		return nil, err
+	}
+	for {
+		_, typ, id, err := in.ReadFieldBegin(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if typ == thrift.STOP {
+			break
+		}
+		if typ == thrift.STRING {
+			if args[id], err = in.ReadString(ctx); err != nil {
+				return nil, err
+			}
+		} else if err := in.Skip(ctx, typ); err != nil {
+			return nil, err
+		}
+		if err := in.ReadFieldEnd(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return args, in.ReadStructEnd(ctx)
+}
+
+// writeThriftResult writes a reply: the result as field 0 on success, or
+// the OperationError as field 1.
+func writeThriftResult(ctx context.Context, out thrift.TProtocol, name string, seqID int32, result interface{}, failed bool, opErr thriftOperationError) error {
+	if err := out.WriteMessageBegin(ctx, name, thrift.REPLY, seqID); err != nil {
+		return err
+	}
+	if err := out.WriteStructBegin(ctx, name+"_result"); err != nil {
+		return err
+	}
+
+	var err error
+	if failed {
+		err = writeThriftField(ctx, out, "err", thrift.STRUCT, 1, func() error {
+			if err := out.WriteStructBegin(ctx, "OperationError"); err != nil {
+				return err
+			}
+			err := writeThriftField(ctx, out, "message", thrift.STRING, 1, func() error {
+				return out.WriteString(ctx, opErr.message)
+			})
+			if err != nil {
+				return err
+			}
+			err = writeThriftField(ctx, out, "code", thrift.STRING, 2, func() error {
+				return out.WriteString(ctx, string(opErr.code))
+			})
+			if err != nil {
+				return err
+			}
+			if err := out.WriteFieldStop(ctx); err != nil {
+				return err
+			}
+			return out.WriteStructEnd(ctx)
+		})
+	} else {
+		switch v := result.(type) {
+		case string:
+			err = writeThriftField(ctx, out, "success", thrift.STRING, 0, func() error { return out.WriteString(ctx, v) })
+		case int64:
+			err = writeThriftField(ctx, out, "success", thrift.I64, 0, func() error { return out.WriteI64(ctx, v) })
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := out.WriteFieldStop(ctx); err != nil {
+		return err
+	}
+	if err := out.WriteStructEnd(ctx); err != nil {
+		return err
+	}
+	if err := out.WriteMessageEnd(ctx); err != nil {
+		return err
+	}
+	return out.Flush(ctx)
+}
+
+func writeThriftField(ctx context.Context, out thrift.TProtocol, name string, typ thrift.TType, id int16, write func() error) error {
+	if err := out.WriteFieldBegin(ctx, name, typ, id); err != nil {
+		return err
+	}
+	if err := write(); err != nil {
+		return err
+	}
+	return out.WriteFieldEnd(ctx)
+}
+
+// serveThrift listens on cfg.Addr and serves the Thrift interface in the
+// background, until the server returned is stopped.
+func serveThrift(cfg thriftConfig, svc IStringService, ops map[string]operation) (*thrift.TSimpleServer, error) {
+	var protocol thrift.TProtocolFactory
+	switch cfg.Protocol {
+	case "", "binary":
+		protocol = thrift.NewTBinaryProtocolFactoryConf(nil)
+	case "compact":
+		protocol = thrift.NewTCompactProtocolFactoryConf(nil)
+	case "json":
+		protocol = thrift.NewTJSONProtocolFactory()
+	default:
+		return nil, fmt.Errorf("Unknown Thrift protocol %q", cfg.Protocol)
+	}
+	var transport thrift.TTransportFactory = thrift.NewTBufferedTransportFactory(8192)
+	if cfg.Framed {
+		transport = thrift.NewTFramedTransportFactoryConf(transport, nil)
+	}
+
+	socket, err := thrift.NewTServerSocket(cfg.Addr)
+	if err != nil {
+		return nil, err
+	}
+	server := thrift.NewTSimpleServer4(newThriftProcessor(svc, ops), socket, transport, protocol)
+	if err := server.Listen(); err != nil {
+		return nil, err
+	}
+	go server.AcceptLoop()
+	return server, nil
+}
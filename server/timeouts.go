@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+//
+// ──────────────────────────────────────────────────────── I ──────────
+//   :::::: T I M E O U T S : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────────────
+//
+
+// defaultOperationTimeout bounds the operations without a timeout of their
+// own. It is below the write timeout of the server, so that clients get
+// the 504 rather than a closed connection.
+const defaultOperationTimeout = 30 * time.Second
+
+// deadlineError is returned for operations that outlive their timeout, with
+// a 504.
+type deadlineError struct {
+	op      string
+	timeout time.Duration
+}
+
+func (e deadlineError) Error() string {
+	return fmt.Sprintf("Operation %s timed out after %v", e.op, e.timeout)
+}
+
+func (e deadlineError) localize(l locale) string {
+	return l.Sprintf("Operation %s timed out after %v", e.op, e.timeout)
+}
+
+func (e deadlineError) Unwrap() error { return context.DeadlineExceeded }
+
+// operationTimeouts bounds the time of every operation.
+type operationTimeouts struct {
+	def  time.Duration
+	byOp map[string]time.Duration
+}
+
+func newOperationTimeouts(cfg timeoutConfig) operationTimeouts {
+	t := operationTimeouts{
+		def:  millisecondsOr(cfg.DefaultMS, defaultOperationTimeout),
+		byOp: make(map[string]time.Duration, len(cfg.Operations)),
+	}
+	for name, ms := range cfg.Operations {
+		t.byOp[name] = millisecondsOr(ms, t.def)
+	}
+	return t
+}
+
+func (t operationTimeouts) timeout(name string) time.Duration {
+	if d, ok := t.byOp[name]; ok {
+		return d
+	}
+	return t.def
+}
+
+// bounded gives the calls of the operation name a context with its
+// deadline, and answers them with a deadlineError once it has passed. The
+// methods that can run long stop at the deadline; the others finish in the
+// background, their result discarded.
+func (t operationTimeouts) bounded(name string, next endpoint.Endpoint) endpoint.Endpoint {
+	d := t.timeout(name)
+	if d <= 0 {
+		return next
+	}
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		ctx, cancel := context.WithTimeout(ctx, d)
+		defer cancel()
+		exceeded := func(err error) error {
+			if errors.Is(err, context.DeadlineExceeded) && ctx.Err() != nil {
+				return deadlineError{name, d}
+			}
+			return err
+		}
+
+		type result struct {
+			response interface{}
+			err      error
+		}
+		done := make(chan result, 1)
+		go func() {
+			response, err := next(ctx, request)
+			done <- result{response, err}
+		}()
+		select {
+		case r := <-done:
+			return r.response, exceeded(r.err)
+		case <-ctx.Done():
+			return nil, exceeded(ctx.Err())
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/rivo/uniseg"
+)
+
+//
+// ──────────────────────────────────────────────────────── I ──────────
+//   :::::: T O K E N I Z E : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────────────
+//
+
+// Limits of Tokenize. N-grams repeat every unit n times, so both the input
+// and n are bounded.
+const (
+	maxTokenizeInput = 1 << 20
+	maxNGram         = 10
+)
+
+// tokenizers split s into the units they are named after, following the
+// Unicode text segmentation rules (UAX #29). Characters are grapheme
+// clusters.
+var tokenizers = map[string]func(s string) []string{
+	"word":      wordTokens,
+	"sentence":  sentenceTokens,
+	"character": characterTokens,
+}
+
+// nGramSeparators join the units of n-grams, by unit. Sentences have no
+// n-grams.
+var nGramSeparators = map[string]string{
+	"word":      " ",
+	"character": "",
+}
+
+type tokenizeRequest struct {
+	S string `json:"s"`
+	// Unit is "word" (the default), "sentence" or "character".
+	Unit string `json:"unit" validate:"max=64,charset=printable"`
+	// N makes n-grams of n words or characters. Single units by default.
+	N                int  `json:"n" validate:"min=0"`
+	Lowercase        bool `json:"lowercase"`
+	StripPunctuation bool `json:"strip_punctuation"`
+}
+
+type tokenizeResponse struct {
+	V   []string `json:"v"`
+	Err string   `json:"err,omitempty"`
+}
+
+// Tokenize splits s into words, sentences or characters, or n-grams of
+// words or characters. Spaces are never tokens, nor are they part of
+// sentences. With stripPunctuation, punctuation is dropped before making
+// n-grams, so that they span it.
+func (stringService) Tokenize(ctx context.Context, s, unit string, n int, lowercase, stripPunctuation bool) ([]string, error) {
+	if len(s) > maxTokenizeInput {
+		return nil, ErrTooLarge
+	}
+	if unit == "" {
+		unit = "word"
+	}
+	tokenize, ok := tokenizers[unit]
+	if !ok {
+		return nil, errorf(CodeUnknownOption, "Unknown token unit %q", unit)
+	}
+	if n == 0 {
+		n = 1
+	}
+	separator, ok := nGramSeparators[unit]
+	if n > 1 && !ok {
+		return nil, errorf(CodeUnsupportedInput, "No n-grams of %s tokens", unit)
+	}
+	if n > maxNGram {
+		return nil, errorf(CodeOutOfRange, "N-grams are at most %d tokens long", maxNGram)
+	}
+
+	if lowercase {
+		s = strings.ToLower(s)
+	}
+	tokens := make([]string, 0)
+	for _, t := range tokenize(s) {
+		if stripPunctuation {
+			t = strings.TrimSpace(strings.Map(dropPunctuation, t))
+		}
+		if t != "" {
+			tokens = append(tokens, t)
+		}
+	}
+	if n == 1 {
+		return tokens, nil
+	}
+
+	grams := make([]string, 0, len(tokens))
+	for i := 0; i+n <= len(tokens); i++ {
+		grams = append(grams, strings.Join(tokens[i:i+n], separator))
+	}
+	return grams, nil
+}
+
+func wordTokens(s string) []string {
+	var tokens []string
+	state := -1
+	for rest := s; rest != ""; {
+		var word string
+		word, rest, state = uniseg.FirstWordInString(rest, state)
+		if strings.TrimSpace(word) != "" {
+			tokens = append(tokens, word)
+		}
+	}
+	return tokens
+}
+
+func sentenceTokens(s string) []string {
+	var tokens []string
+	state := -1
+	for rest := s; rest != ""; {
+		var sentence string
+		sentence, rest, state = uniseg.FirstSentenceInString(rest, state)
+		tokens = append(tokens, strings.TrimSpace(sentence))
+	}
+	return tokens
+}
+
+func characterTokens(s string) []string {
+	var tokens []string
+	g := uniseg.NewGraphemes(s)
+	for g.Next() {
+		if c := g.Str(); strings.TrimSpace(c) != "" {
+			tokens = append(tokens, c)
+		}
+	}
+	return tokens
+}
+
+// dropPunctuation is a strings.Map function removing punctuation.
+func dropPunctuation(r rune) rune {
+	if unicode.IsPunct(r) {
+		return -1
+	}
+	return r
+}
+
+func makeTokenizeEndpoint(svc IStringService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(tokenizeRequest)
+		v, err := svc.Tokenize(ctx, req.S, req.Unit, req.N, req.Lowercase, req.StripPunctuation)
+		if err != nil {
+			return tokenizeResponse{v, err.Error()}, nil
+		}
+		return tokenizeResponse{v, ""}, nil
+	}
+}
+
+func decodeTokenizeRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request tokenizeRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+//
+// ─── LOGGING ────────────────────────────────────────────────────────────────────
+//
+
+func (mw loggingMiddleware) Tokenize(ctx context.Context, s, unit string, n int, lowercase, stripPunctuation bool) (output []string, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "tokenize",
+			"tenant", tenantFrom(ctx),
+			"input_size", len(s),
+			"unit", unit,
+			"n", n,
+			"tokens", len(output),
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	output, err = mw.next.Tokenize(ctx, s, unit, n, lowercase, stripPunctuation)
+	return
+}
+
+//
+// ─── INSTRUMENTATION ────────────────────────────────────────────────────────────
+//
+
+func (mw instrumentingMiddleware) Tokenize(ctx context.Context, s, unit string, n int, lowercase, stripPunctuation bool) (output []string, err error) {
+	done := mw.begin(ctx, "tokenize", s)
+	defer func() { done(-1, err) }()
+
+	output, err = mw.next.Tokenize(ctx, s, unit, n, lowercase, stripPunctuation)
+	return
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/mozillazg/go-unidecode"
+)
+
+//
+// ────────────────────────────────────────────────────────────────── I ──────────
+//   :::::: T R A N S L I T E R A T E : :  :   :    :     :        :          :
+// ────────────────────────────────────────────────────────────────────────────
+//
+
+// transliterator converts s to another script, usually plain ASCII.
+type transliterator func(s string) string
+
+// transliterators holds the schemes clients can pick with the "scheme"
+// field. "ascii" is used when none is given.
+//
+//   - "ascii" approximates any script in ASCII, character by character. Han
+//     characters get their Mandarin reading (日本 becomes "Ri Ben").
+//   - "diacritics" only strips accents and leaves non-Latin scripts alone.
+//   - "kana" romanizes hiragana and katakana using Hepburn, then falls back
+//     to "ascii". Reading kanji as Japanese needs a dictionary-backed scheme,
+//     which can be plugged in with RegisterTransliterator.
+var transliterators = map[string]transliterator{
+	"ascii":      unidecode.Unidecode,
+	"diacritics": foldDiacritics,
+	"kana":       func(s string) string { return unidecode.Unidecode(romanizeKana(s)) },
+}
+
+// RegisterTransliterator makes a new scheme available to the /transliterate
+// endpoint. It is meant to be called from init functions, before the server
+// starts.
+func RegisterTransliterator(name string, fn transliterator) {
+	transliterators[name] = fn
+}
+
+type transliterateRequest struct {
+	S      string `json:"s"`
+	Scheme string `json:"scheme" validate:"max=64,charset=printable"`
+}
+
+type transliterateResponse struct {
+	V   string `json:"v"`
+	Err string `json:"err,omitempty"`
+}
+
+func (stringService) Transliterate(ctx context.Context, s, scheme string) (string, error) {
+	if scheme == "" {
+		scheme = "ascii"
+	}
+	fn, ok := transliterators[scheme]
+	if !ok {
+		return "", errorf(CodeUnknownOption, "Unknown transliteration scheme %q", scheme)
+	}
+	return fn(s), nil
+}
+
+// hiragana maps each hiragana to its Hepburn romanization. Katakana are
+// shifted to hiragana before the lookup.
+var hiragana = map[rune]string{
+	'あ': "a", 'い': "i", 'う': "u", 'え': "e", 'お': "o",
+	'か': "ka", 'き': "ki", 'く': "ku", 'け': "ke", 'こ': "ko",
+	'が': "ga", 'ぎ': "gi", 'ぐ': "gu", 'げ': "ge", 'ご': "go",
+	'さ': "sa", 'し': "shi", 'す': "su", 'せ': "se", 'そ': "so",
+	'ざ': "za", 'じ': "ji", 'ず': "zu", 'ぜ': "ze", 'ぞ': "zo",
+	'た': "ta", 'ち': "chi", 'つ': "tsu", 'て': "te", 'と': "to",
+	'だ': "da", 'ぢ': "ji", 'づ': "zu", 'で': "de", 'ど': "do",
+	'な': "na", 'に': "ni", 'ぬ': "nu", 'ね': "ne", 'の': "no",
+	'は': "ha", 'ひ': "hi", 'ふ': "fu", 'へ': "he", 'ほ': "ho",
+	'ば': "ba", 'び': "bi", 'ぶ': "bu", 'べ': "be", 'ぼ': "bo",
+	'ぱ': "pa", 'ぴ': "pi", 'ぷ': "pu", 'ぺ': "pe", 'ぽ': "po",
+	'ま': "ma", 'み': "mi", 'む': "mu", 'め': "me", 'も': "mo",
+	'や': "ya", 'ゆ': "yu", 'よ': "yo",
+	'ら': "ra", 'り': "ri", 'る': "ru", 'れ': "re", 'ろ': "ro",
+	'わ': "wa", 'ゐ': "i", 'ゑ': "e", 'を': "o", 'ん': "n", 'ゔ': "vu",
+	'ぁ': "a", 'ぃ': "i", 'ぅ': "u", 'ぇ': "e", 'ぉ': "o", 'ゎ': "wa",
+}
+
+// smallY are the small ya/yu/yo that combine with the preceding kana into a
+// single syllable, as in きょ (kyo) or しゃ (sha).
+var smallY = map[rune]string{'ゃ': "a", 'ゅ': "u", 'ょ': "o"}
+
+func romanizeKana(s string) string {
+	rs := []rune(s)
+	for i, r := range rs {
+		if r >= 'ァ' && r <= 'ヶ' {
+			rs[i] = r - ('ァ' - 'ぁ')
+		}
+	}
+
+	var b strings.Builder
+	geminate := false
+	for i := 0; i < len(rs); i++ {
+		r := rs[i]
+		roman, ok := hiragana[r]
+		switch {
+		case r == 'っ':
+			geminate = true
+			continue
+		case r == 'ー':
+			// The long vowel mark repeats the previous vowel.
+			out := b.String()
+			if last, _ := utf8.DecodeLastRuneInString(out); strings.ContainsRune("aeiou", last) {
+				b.WriteRune(last)
+			}
+			continue
+		case !ok:
+			b.WriteRune(r)
+			geminate = false
+			continue
+		}
+
+		if i+1 < len(rs) && strings.HasSuffix(roman, "i") && len(roman) > 1 {
+			if vowel, ok := smallY[rs[i+1]]; ok {
+				stem := strings.TrimSuffix(roman, "i")
+				if stem != "sh" && stem != "ch" && stem != "j" {
+					stem += "y"
+				}
+				roman = stem + vowel
+				i++
+			}
+		}
+		if geminate {
+			if strings.HasPrefix(roman, "ch") {
+				b.WriteByte('t')
+			} else if !strings.ContainsRune("aeiou", rune(roman[0])) {
+				b.WriteByte(roman[0])
+			}
+			geminate = false
+		}
+		b.WriteString(roman)
+	}
+	return b.String()
+}
+
+func makeTransliterateEndpoint(svc IStringService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(transliterateRequest)
+		v, err := svc.Transliterate(ctx, req.S, req.Scheme)
+		if err != nil {
+			return transliterateResponse{"", err.Error()}, nil
+		}
+		return transliterateResponse{v, ""}, nil
+	}
+}
+
+func decodeTransliterateRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request transliterateRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+//
+// ─── LOGGING ────────────────────────────────────────────────────────────────────
+//
+
+func (mw loggingMiddleware) Transliterate(ctx context.Context, s, scheme string) (output string, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "transliterate",
+			"tenant", tenantFrom(ctx),
+			"input", s,
+			"scheme", scheme,
+			"output", output,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	output, err = mw.next.Transliterate(ctx, s, scheme)
+	return
+}
+
+//
+// ─── INSTRUMENTATION ────────────────────────────────────────────────────────────
+//
+
+func (mw instrumentingMiddleware) Transliterate(ctx context.Context, s, scheme string) (output string, err error) {
+	done := mw.begin(ctx, "transliterate", s)
+	defer func() { done(len(output), err) }()
+
+	output, err = mw.next.Transliterate(ctx, s, scheme)
+	return
+}
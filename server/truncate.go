@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/rivo/uniseg"
+)
+
+//
+// ──────────────────────────────────────────────────────── I ──────────
+//   :::::: T R U N C A T E : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────────────
+//
+
+// defaultEllipsis is appended to truncated strings unless the request
+// provides its own, possibly empty, ellipsis.
+const defaultEllipsis = "…"
+
+type truncateRequest struct {
+	S            string  `json:"s"`
+	MaxLength    int     `json:"max_length"`
+	Ellipsis     *string `json:"ellipsis" validate:"max=64"`
+	WordBoundary bool    `json:"word_boundary"`
+}
+
+type truncateResponse struct {
+	V   string `json:"v"`
+	Err string `json:"err,omitempty"`
+}
+
+// Truncate shortens s to at most maxLength characters, ellipsis included.
+// Characters are grapheme clusters, so neither multi-byte runes nor
+// combining sequences or emoji are ever split. With wordBoundary, the cut
+// moves back to the end of the last complete word when there is one.
+func (stringService) Truncate(ctx context.Context, s string, maxLength int, ellipsis string, wordBoundary bool) (string, error) {
+	if maxLength <= 0 {
+		return "", errors.New("max_length must be positive")
+	}
+	if uniseg.GraphemeClusterCount(s) <= maxLength {
+		return s, nil
+	}
+	budget := maxLength - uniseg.GraphemeClusterCount(ellipsis)
+	if budget < 0 {
+		return "", errors.New("max_length is shorter than the ellipsis")
+	}
+
+	cut, state := 0, -1
+	for i := 0; i < budget; i++ {
+		var cluster string
+		cluster, _, _, state = uniseg.FirstGraphemeClusterInString(s[cut:], state)
+		cut += len(cluster)
+	}
+
+	if wordBoundary {
+		boundary, state := 0, -1
+		for offset := 0; offset < len(s); {
+			var word string
+			word, _, state = uniseg.FirstWordInString(s[offset:], state)
+			if offset+len(word) > cut {
+				break
+			}
+			offset += len(word)
+			boundary = offset
+		}
+		if boundary > 0 {
+			cut = boundary
+		}
+	}
+
+	return strings.TrimRightFunc(s[:cut], unicode.IsSpace) + ellipsis, nil
+}
+
+func makeTruncateEndpoint(svc IStringService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(truncateRequest)
+		ellipsis := defaultEllipsis
+		if req.Ellipsis != nil {
+			ellipsis = *req.Ellipsis
+		}
+		v, err := svc.Truncate(ctx, req.S, req.MaxLength, ellipsis, req.WordBoundary)
+		if err != nil {
+			return truncateResponse{"", err.Error()}, nil
+		}
+		return truncateResponse{v, ""}, nil
+	}
+}
+
+func decodeTruncateRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request truncateRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+//
+// ─── LOGGING ────────────────────────────────────────────────────────────────────
+//
+
+func (mw loggingMiddleware) Truncate(ctx context.Context, s string, maxLength int, ellipsis string, wordBoundary bool) (output string, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "truncate",
+			"tenant", tenantFrom(ctx),
+			"input", s,
+			"max_length", maxLength,
+			"word_boundary", wordBoundary,
+			"output", output,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	output, err = mw.next.Truncate(ctx, s, maxLength, ellipsis, wordBoundary)
+	return
+}
+
+//
+// ─── INSTRUMENTATION ────────────────────────────────────────────────────────────
+//
+
+func (mw instrumentingMiddleware) Truncate(ctx context.Context, s string, maxLength int, ellipsis string, wordBoundary bool) (output string, err error) {
+	done := mw.begin(ctx, "truncate", s)
+	defer func() { done(len(output), err) }()
+
+	output, err = mw.next.Truncate(ctx, s, maxLength, ellipsis, wordBoundary)
+	return
+}
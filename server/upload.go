@@ -0,0 +1,159 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"unicode/utf8"
+)
+
+//
+// ────────────────────────────────────────────────────── I ──────────
+//   :::::: U P L O A D S : :  :   :    :     :        :          :
+// ────────────────────────────────────────────────────────────────
+//
+
+// The streaming routes and /batch accept files, so that large documents
+// need not be wrapped in a JSON string: either raw, as the whole body of a
+// text/plain request, or as the file parts of a multipart/form-data one.
+// Multipart files are read as they arrive, never buffered to disk.
+
+type uploadLimitKey struct{}
+
+// withUploadLimit makes every file uploaded with the request of ctx fail
+// with ErrTooLarge past max bytes.
+func withUploadLimit(ctx context.Context, max int64) context.Context {
+	return context.WithValue(ctx, uploadLimitKey{}, max)
+}
+
+// uploadLimitFrom returns the size limit of uploaded files, or 0 when
+// only the limit of the request body applies.
+func uploadLimitFrom(ctx context.Context) int64 {
+	max, _ := ctx.Value(uploadLimitKey{}).(int64)
+	return max
+}
+
+// limitedUpload fails with ErrTooLarge once more than n bytes are read.
+type limitedUpload struct {
+	r io.Reader
+	n int64
+}
+
+func limitUpload(ctx context.Context, r io.Reader) io.Reader {
+	if max := uploadLimitFrom(ctx); max > 0 {
+		return &limitedUpload{r, max}
+	}
+	return r
+}
+
+func (u *limitedUpload) Read(p []byte) (int, error) {
+	if u.n <= 0 {
+		var b [1]byte
+		if n, err := u.r.Read(b[:]); n > 0 {
+			return 0, ErrTooLarge
+		} else if err != nil {
+			return 0, err
+		}
+		return 0, nil
+	}
+	if int64(len(p)) > u.n {
+		p = p[:u.n]
+	}
+	n, err := u.r.Read(p)
+	u.n -= int64(n)
+	return n, err
+}
+
+// isMultipart tells whether r is a multipart/form-data request.
+func isMultipart(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "multipart/form-data"
+}
+
+// uploadBody returns the input of the streaming routes: the first file of
+// a multipart/form-data request, or the body of any other.
+func uploadBody(r *http.Request) (io.Reader, error) {
+	if !isMultipart(r) {
+		return limitUpload(r.Context(), r.Body), nil
+	}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, malformedInputError{"multipart", err}
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, malformedInputError{"multipart", errors.New("no file part")}
+		}
+		if err != nil {
+			return nil, malformedInputError{"multipart", err}
+		}
+		if part.FileName() != "" {
+			return limitUpload(r.Context(), part), nil
+		}
+	}
+}
+
+// readUpload reads a whole uploaded file as a string.
+func readUpload(ctx context.Context, r io.Reader) (string, error) {
+	b, err := io.ReadAll(limitUpload(ctx, r))
+	if err != nil {
+		return "", err
+	}
+	if !utf8.Valid(b) {
+		return "", malformedInputError{"upload", errors.New("not valid UTF-8")}
+	}
+	return string(b), nil
+}
+
+// decodeBatchUpload decodes the batch requests that are not JSON. Every
+// file part of a multipart/form-data request is an item, and its "op" and
+// "options" fields are those of the JSON request, options being a JSON
+// object. The body of a text/plain request is a single item, and op and
+// options are given in the query string.
+func decodeBatchUpload(r *http.Request) (batchRequest, error) {
+	var request batchRequest
+	if !isMultipart(r) {
+		q := r.URL.Query()
+		request.Op = q.Get("op")
+		if options := q.Get("options"); options != "" {
+			if err := json.Unmarshal([]byte(options), &request.Options); err != nil {
+				return request, malformedInputError{"options", err}
+			}
+		}
+		s, err := readUpload(r.Context(), r.Body)
+		request.Items = []string{s}
+		return request, err
+	}
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return request, malformedInputError{"multipart", err}
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return request, nil
+		}
+		if err != nil {
+			return request, malformedInputError{"multipart", err}
+		}
+		value, err := readUpload(r.Context(), part)
+		if err != nil {
+			return request, err
+		}
+		switch {
+		case part.FileName() != "":
+			request.Items = append(request.Items, value)
+		case part.FormName() == "op":
+			request.Op = value
+		case part.FormName() == "options":
+			if err := json.Unmarshal([]byte(value), &request.Options); err != nil {
+				return request, malformedInputError{"options", err}
+			}
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+//
+// ────────────────────────────────────────────── I ──────────
+//   :::::: U R L : :  :   :    :     :        :          :
+// ────────────────────────────────────────────────────────
+//
+
+// URL modes:
+//   - "query" (default) escapes s so it can be placed inside a query component
+//   - "path" escapes s so it can be placed inside a path segment
+//   - "url" treats s as a complete URL and normalizes it
+const (
+	urlModeQuery = "query"
+	urlModePath  = "path"
+	urlModeURL   = "url"
+)
+
+// defaultPorts lists the ports that are implied by a scheme and stripped
+// during normalization.
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+	"ws":    "80",
+	"wss":   "443",
+	"ftp":   "21",
+}
+
+type urlEncodeRequest struct {
+	S    string `json:"s"`
+	Mode string `json:"mode" validate:"max=64,charset=printable"`
+}
+
+type urlEncodeResponse struct {
+	V   string `json:"v"`
+	Err string `json:"err,omitempty"`
+}
+
+type urlDecodeRequest struct {
+	S    string `json:"s"`
+	Mode string `json:"mode" validate:"max=64,charset=printable"`
+}
+
+type urlDecodeResponse struct {
+	V   string `json:"v"`
+	Err string `json:"err,omitempty"`
+}
+
+func (stringService) URLEncode(ctx context.Context, s, mode string) (string, error) {
+	switch mode {
+	case "", urlModeQuery:
+		return url.QueryEscape(s), nil
+	case urlModePath:
+		return url.PathEscape(s), nil
+	case urlModeURL:
+		return normalizeURL(s)
+	}
+	return "", errorf(CodeUnknownOption, "Unknown URL mode %q", mode)
+}
+
+func (stringService) URLDecode(ctx context.Context, s, mode string) (string, error) {
+	switch mode {
+	case "", urlModeQuery:
+		return url.QueryUnescape(s)
+	case urlModePath:
+		return url.PathUnescape(s)
+	case urlModeURL:
+		u, err := url.Parse(s)
+		if err != nil {
+			return "", err
+		}
+		query, err := url.QueryUnescape(u.RawQuery)
+		if err != nil {
+			return "", err
+		}
+		u.RawQuery = ""
+		v := u.Scheme + "://" + u.Host + u.Path
+		if query != "" {
+			v += "?" + query
+		}
+		if u.Fragment != "" {
+			v += "#" + u.Fragment
+		}
+		return v, nil
+	}
+	return "", errorf(CodeUnknownOption, "Unknown URL mode %q", mode)
+}
+
+// normalizeURL parses s and re-encodes it in a canonical form: lower-case
+// scheme and host, no default port, dot segments removed, path and query
+// re-escaped and query parameters sorted by key.
+func normalizeURL(s string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return "", errorf(CodeMalformedInput, "URL must be absolute")
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	host, port := u.Hostname(), u.Port()
+	host = strings.ToLower(host)
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if port != "" && port != defaultPorts[u.Scheme] {
+		host += ":" + port
+	}
+	u.Host = host
+
+	if u.Path == "" {
+		u.Path = "/"
+	} else if p := path.Clean(u.Path); p != u.Path {
+		if strings.HasSuffix(u.Path, "/") && p != "/" {
+			p += "/"
+		}
+		u.Path = p
+	}
+	u.RawPath = ""
+	u.RawQuery = u.Query().Encode()
+	return u.String(), nil
+}
+
+func makeURLEncodeEndpoint(svc IStringService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(urlEncodeRequest)
+		v, err := svc.URLEncode(ctx, req.S, req.Mode)
+		if err != nil {
+			return urlEncodeResponse{"", err.Error()}, nil
+		}
+		return urlEncodeResponse{v, ""}, nil
+	}
+}
+
+func makeURLDecodeEndpoint(svc IStringService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(urlDecodeRequest)
+		v, err := svc.URLDecode(ctx, req.S, req.Mode)
+		if err != nil {
+			return urlDecodeResponse{"", err.Error()}, nil
+		}
+		return urlDecodeResponse{v, ""}, nil
+	}
+}
+
+func decodeURLEncodeRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request urlEncodeRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+func decodeURLDecodeRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request urlDecodeRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+//
+// ─── LOGGING ────────────────────────────────────────────────────────────────────
+//
+
+func (mw loggingMiddleware) URLEncode(ctx context.Context, s, mode string) (output string, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "urlencode",
+			"tenant", tenantFrom(ctx),
+			"input", s,
+			"mode", mode,
+			"output", output,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	output, err = mw.next.URLEncode(ctx, s, mode)
+	return
+}
+
+func (mw loggingMiddleware) URLDecode(ctx context.Context, s, mode string) (output string, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "urldecode",
+			"tenant", tenantFrom(ctx),
+			"input", s,
+			"mode", mode,
+			"output", output,
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	output, err = mw.next.URLDecode(ctx, s, mode)
+	return
+}
+
+//
+// ─── INSTRUMENTATION ────────────────────────────────────────────────────────────
+//
+
+func (mw instrumentingMiddleware) URLEncode(ctx context.Context, s, mode string) (output string, err error) {
+	done := mw.begin(ctx, "urlencode", s)
+	defer func() { done(len(output), err) }()
+
+	output, err = mw.next.URLEncode(ctx, s, mode)
+	return
+}
+
+func (mw instrumentingMiddleware) URLDecode(ctx context.Context, s, mode string) (output string, err error) {
+	done := mw.begin(ctx, "urldecode", s)
+	defer func() { done(len(output), err) }()
+
+	output, err = mw.next.URLDecode(ctx, s, mode)
+	return
+}
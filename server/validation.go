@@ -0,0 +1,278 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+//
+// ──────────────────────────────────────────────────────────── I ──────────
+//   :::::: V A L I D A T I O N : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────────────────
+//
+
+// Request fields declare their constraints in a `validate` tag, a comma
+// separated list of rules:
+//
+//	required        not the zero value, so not empty for strings and lists
+//	notblank        not empty nor only made of white space, for strings
+//	min=N, max=N    bounds of numbers, or of the length of strings (in
+//	                runes), lists and maps
+//	oneof=a b c     one of the given values
+//	charset=NAME    only characters of NAME: ascii, printable or alnum, for
+//	                strings
+//
+// The rules are checked by validated before the endpoint runs, so the
+// service methods can assume valid input. Rules that do not apply to the
+// type of their field are configuration errors.
+
+// validationRule checks the value of a field and describes the violation
+// when it fails.
+type validationRule struct {
+	Name  string
+	Check func(v reflect.Value) bool
+	// Message takes the name of the field before its arguments, as in
+	// "%s is required".
+	Message localizedMessage
+}
+
+// fieldViolation is a rule a request field does not follow.
+type fieldViolation struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+
+	message localizedMessage
+}
+
+// validationError lists every violation of a request. It is answered with
+// a 422.
+type validationError struct {
+	Violations []fieldViolation
+}
+
+func (e validationError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		messages[i] = v.Message
+	}
+	return "Invalid request: " + strings.Join(messages, "; ")
+}
+
+func (e validationError) localize(l locale) string {
+	violations := e.localizedViolations(l)
+	messages := make([]string, len(violations))
+	for i, v := range violations {
+		messages[i] = v.Message
+	}
+	return l.Sprintf("Invalid request: %s", strings.Join(messages, "; "))
+}
+
+// localizedViolations returns the violations with their messages in the
+// language of l.
+func (e validationError) localizedViolations(l locale) []fieldViolation {
+	if len(l) == 0 {
+		return e.Violations
+	}
+	violations := make([]fieldViolation, len(e.Violations))
+	for i, v := range e.Violations {
+		v.Message = v.message.localize(l)
+		violations[i] = v
+	}
+	return violations
+}
+
+// validationCharsets are the character sets of the charset rule.
+var validationCharsets = map[string]func(rune) bool{
+	"ascii":     func(r rune) bool { return r < utf8.RuneSelf },
+	"printable": unicode.IsPrint,
+	"alnum":     func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) },
+}
+
+// fieldRules are the rules of a field, by index in its struct.
+type fieldRules struct {
+	Index int
+	Name  string
+	Rules []validationRule
+}
+
+// validationRules caches the rules of request types.
+var validationRules sync.Map // reflect.Type → []fieldRules
+
+// validated checks requests against the rules of their type before passing
+// them to next.
+func validated(next endpoint.Endpoint) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		if err := validateRequest(request); err != nil {
+			return nil, err
+		}
+		return next(ctx, request)
+	}
+}
+
+// validateRequest returns a validationError when req breaks any rule.
+func validateRequest(req interface{}) error {
+	v := reflect.ValueOf(req)
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	fields, err := rulesOf(v.Type())
+	if err != nil {
+		return err
+	}
+	var violations []fieldViolation
+	for _, f := range fields {
+		for _, rule := range f.Rules {
+			if !rule.Check(v.Field(f.Index)) {
+				message := localizedMessage{rule.Message.format, append([]interface{}{f.Name}, rule.Message.args...)}
+				violations = append(violations, fieldViolation{f.Name, rule.Name, message.Error(), message})
+			}
+		}
+	}
+	if len(violations) > 0 {
+		return validationError{violations}
+	}
+	return nil
+}
+
+// checkRequestRules returns the first invalid rule of the requests of ops,
+// so that it fails on startup rather than on every request.
+func checkRequestRules(ops map[string]operation) error {
+	for name, op := range ops {
+		t := reflect.TypeOf(op.Request)
+		if t == nil || t.Kind() != reflect.Struct {
+			continue
+		}
+		if _, err := rulesOf(t); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func rulesOf(t reflect.Type) ([]fieldRules, error) {
+	if cached, ok := validationRules.Load(t); ok {
+		return cached.([]fieldRules), nil
+	}
+	var fields []fieldRules
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("validate")
+		if tag == "" {
+			continue
+		}
+		fr := fieldRules{Index: i, Name: jsonFieldName(f)}
+		for _, spec := range strings.Split(tag, ",") {
+			rule, err := parseValidationRule(spec, f.Type)
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %v", t.Name(), f.Name, err)
+			}
+			fr.Rules = append(fr.Rules, rule)
+		}
+		fields = append(fields, fr)
+	}
+	validationRules.Store(t, fields)
+	return fields, nil
+}
+
+// parseValidationRule returns the rule of spec for the fields of type t.
+func parseValidationRule(spec string, t reflect.Type) (validationRule, error) {
+	name, arg := spec, ""
+	if i := strings.IndexByte(spec, '='); i >= 0 {
+		name, arg = spec[:i], spec[i+1:]
+	}
+	switch name {
+	case "notblank", "charset":
+		if t.Kind() != reflect.String {
+			return validationRule{}, fmt.Errorf("rule %q does not apply to %s", name, t)
+		}
+	case "min", "max":
+		if !measurable(t) {
+			return validationRule{}, fmt.Errorf("rule %q does not apply to %s", name, t)
+		}
+	}
+	switch name {
+	case "required":
+		return validationRule{name, func(v reflect.Value) bool { return !v.IsZero() }, localizedMessage{"%s is required", nil}}, nil
+	case "notblank":
+		return validationRule{name, func(v reflect.Value) bool {
+			return strings.TrimSpace(v.String()) != ""
+		}, localizedMessage{"%s must not be blank", nil}}, nil
+	case "min", "max":
+		n, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return validationRule{}, fmt.Errorf("invalid bound %q", arg)
+		}
+		if name == "min" {
+			return validationRule{name, func(v reflect.Value) bool { return measure(v) >= n }, localizedMessage{"%s must be at least %s", []interface{}{arg}}}, nil
+		}
+		return validationRule{name, func(v reflect.Value) bool { return measure(v) <= n }, localizedMessage{"%s must be at most %s", []interface{}{arg}}}, nil
+	case "oneof":
+		allowed := strings.Fields(arg)
+		return validationRule{name, func(v reflect.Value) bool {
+			for _, a := range allowed {
+				if fmt.Sprint(v.Interface()) == a {
+					return true
+				}
+			}
+			return false
+		}, localizedMessage{"%s must be one of %s", []interface{}{strings.Join(allowed, ", ")}}}, nil
+	case "charset":
+		in, ok := validationCharsets[arg]
+		if !ok {
+			return validationRule{}, fmt.Errorf("unknown charset %q", arg)
+		}
+		return validationRule{name, func(v reflect.Value) bool {
+			for _, r := range v.String() {
+				if !in(r) {
+					return false
+				}
+			}
+			return true
+		}, localizedMessage{"%s must only contain %s characters", []interface{}{arg}}}, nil
+	}
+	return validationRule{}, fmt.Errorf("unknown rule %q", name)
+}
+
+// measurable tells whether min and max apply to the values of t.
+func measurable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	case reflect.Ptr:
+		return measurable(t.Elem())
+	}
+	return false
+}
+
+// measure returns what min and max compare: the value of numbers and the
+// length of strings, lists and maps. Nil pointers measure 0.
+func measure(v reflect.Value) float64 {
+	switch v.Kind() {
+	case reflect.String:
+		return float64(utf8.RuneCountInString(v.String()))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.Ptr:
+		if v.IsNil() {
+			return 0
+		}
+		return measure(v.Elem())
+	}
+	return float64(v.Len())
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateRequest(t *testing.T) {
+	type bounded struct {
+		Small  uint8             `json:"small" validate:"max=10"`
+		Ratio  float32           `json:"ratio" validate:"min=0,max=1"`
+		Labels map[string]string `json:"labels" validate:"max=1"`
+		Limit  *int              `json:"limit" validate:"min=1"`
+		Name   string            `json:"name" validate:"max=8,charset=printable"`
+	}
+	two := 2
+	tests := []struct {
+		name       string
+		req        interface{}
+		wantFields []string
+		wantErr    bool
+	}{
+		{name: "valid", req: bounded{Small: 10, Ratio: 1, Labels: map[string]string{"a": "b"}, Limit: &two, Name: "héllo"}},
+		{name: "nil pointer", req: bounded{Limit: nil}, wantFields: []string{"limit"}},
+		{name: "pointer", req: bounded{Limit: &two}},
+		{
+			name:       "out of bounds",
+			req:        bounded{Small: 11, Ratio: 1.5, Labels: map[string]string{"a": "b", "c": "d"}, Limit: &two, Name: "too long a name"},
+			wantFields: []string{"small", "ratio", "labels", "name"},
+		},
+		{name: "control character", req: bounded{Limit: &two, Name: "a\x00b"}, wantFields: []string{"name"}},
+		{name: "long option", req: hashRequest{S: "a", Algorithm: strings.Repeat("a", 65)}, wantFields: []string{"algorithm"}},
+		{name: "notblank on a number", req: struct {
+			N int `validate:"notblank"`
+		}{}, wantErr: true},
+		{name: "charset on a list", req: struct {
+			L []string `validate:"charset=ascii"`
+		}{}, wantErr: true},
+		{name: "max on a bool", req: struct {
+			B bool `validate:"max=1"`
+		}{}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateRequest(tt.req)
+			var verr validationError
+			if !errors.As(err, &verr) {
+				if (err != nil) != tt.wantErr || len(tt.wantFields) > 0 {
+					t.Fatalf("error = %v, want violations of %v", err, tt.wantFields)
+				}
+				return
+			}
+			var fields []string
+			for _, v := range verr.Violations {
+				if len(fields) == 0 || fields[len(fields)-1] != v.Field {
+					fields = append(fields, v.Field)
+				}
+			}
+			if strings.Join(fields, ",") != strings.Join(tt.wantFields, ",") {
+				t.Errorf("violations of %v, want %v", fields, tt.wantFields)
+			}
+		})
+	}
+}
+
+func TestRequestRules(t *testing.T) {
+	if err := checkRequestRules(newOperations(stringService{})); err != nil {
+		t.Fatal(err)
+	}
+}
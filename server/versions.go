@@ -0,0 +1,180 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/metrics"
+	httptransport "github.com/go-kit/kit/transport/http"
+)
+
+//
+// ──────────────────────────────────────────────────────── I ──────────
+//   :::::: V E R S I O N S : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────────────
+//
+
+// The API has two versions:
+//
+//	v1  the original routes, also served without prefix: Count counts
+//	    bytes, and failures of the service are answered with a 200 and an
+//	    "err" field. It is deprecated.
+//	v2  the operations under /v2: Count counts characters, and every
+//	    failure is answered with an error status and an "error" object.
+const (
+	legacyAPIVersion  = "v1"
+	currentAPIVersion = "v2"
+)
+
+// operationError is a failure reported by the service in the "err" field
+// of a response, answered with a 400 by v2.
+type operationError string
+
+func (e operationError) Error() string {
+	return string(e)
+}
+
+// apiError is the "error" object of v2 responses.
+type apiError struct {
+	Message string           `json:"message"`
+	Code    ErrorCode        `json:"code"`
+	Fields  []fieldViolation `json:"fields,omitempty"`
+}
+
+// newV2Operations returns the operations of v2: those of ops, with Count
+// counting characters. tenants applies its toggles to the operations it
+// replaces, and guard bounds them like those of ops.
+func newV2Operations(svc IStringService, ops map[string]operation, tenants *tenancy, guard func(string, endpoint.Endpoint) endpoint.Endpoint) map[string]operation {
+	v2 := make(map[string]operation, len(ops))
+	for name, op := range ops {
+		v2[name] = op
+	}
+	v2["count"] = operation{
+		guard("count", tenants.enabled("count", validated(makeCountRunesEndpoint(svc)))),
+		countRequest{},
+		countResponse{},
+	}
+	return v2
+}
+
+// registerV2Routes serves the operations of v2 that have a v1 route on
+// mux, under /v2. The results of Count are not cached, since the cache is
+// keyed by operation name and v1 counts differently.
+func registerV2Routes(mux *http.ServeMux, ops map[string]operation, cached func(string, endpoint.Endpoint) endpoint.Endpoint, options ...httptransport.ServerOption) map[string]bool {
+	routes := make(map[string]bool)
+	for name, op := range ops {
+		route := operationRoute(name)
+		req, _ := http.NewRequest(http.MethodPost, route, nil)
+		if _, pattern := mux.Handler(req); pattern != route {
+			continue
+		}
+		endpoint := op.Endpoint
+		if name != "count" {
+			endpoint = cached(name, endpoint)
+		}
+		mux.Handle("/"+currentAPIVersion+route, httptransport.NewServer(
+			endpoint,
+			makeOperationDecoder(op),
+			encodeV2Response,
+			append(options, httptransport.ServerErrorEncoder(encodeV2Error))...,
+		))
+		routes[route] = true
+	}
+	return routes
+}
+
+// makeOperationDecoder decodes the JSON requests of op, for the routes that
+// have no decoder of their own.
+func makeOperationDecoder(op operation) httptransport.DecodeRequestFunc {
+	return func(_ context.Context, r *http.Request) (interface{}, error) {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		req, err := op.decode(data)
+		if err != nil {
+			return nil, malformedInputError{"request", err}
+		}
+		return req, nil
+	}
+}
+
+// encodeV2Response answers the failures reported in the "err" field of
+// response with an error status.
+func encodeV2Response(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	if hasErr(response) {
+		data, err := json.Marshal(response)
+		if err != nil {
+			return err
+		}
+		var result operationResult
+		if err := json.Unmarshal(data, &result); err != nil {
+			return err
+		}
+		encodeV2Error(ctx, withCode(recordedErrorCode(ctx), operationError(localizedFailure(ctx, result.Err))), w)
+		return nil
+	}
+	return encodeResponse(ctx, w, response)
+}
+
+// encodeV2Error writes err as an "error" object, with the status of
+// codeFrom, in the language of the request.
+func encodeV2Error(ctx context.Context, err error, w http.ResponseWriter) {
+	l := localeFrom(ctx)
+	body := apiError{Message: l.Error(err), Code: errorCodeOf(err)}
+	var invalid validationError
+	if errors.As(err, &invalid) {
+		body.Fields = invalid.localizedViolations(l)
+	}
+	response := map[string]interface{}{"error": body}
+	if mediaType, c, ok := responseCodec(ctx); ok {
+		writeWithCodec(w, mediaType, c, codeFrom(err), response)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(codeFrom(err))
+	json.NewEncoder(w).Encode(response)
+}
+
+// withVersions serves /v1 routes with the unprefixed ones, and counts the
+// requests of every version in requests. The routes of deprecated versions
+// get a Deprecation header, and a Link to their v2 successor if any.
+// v2Routes are the routes served under /v2, without prefix.
+func withVersions(next http.Handler, v2Routes map[string]bool, requests metrics.Counter) http.Handler {
+	v1Prefix := "/" + legacyAPIVersion
+	v2Prefix := "/" + currentAPIVersion
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		switch {
+		case strings.HasPrefix(path, v2Prefix+"/"):
+			requests.With("version", currentAPIVersion, "deprecated", "false").Add(1)
+			next.ServeHTTP(w, r)
+			return
+		case strings.HasPrefix(path, v1Prefix+"/"):
+			path = strings.TrimPrefix(path, v1Prefix)
+			r2 := new(http.Request)
+			*r2 = *r
+			r2.URL = new(url.URL)
+			*r2.URL = *r.URL
+			r2.URL.Path, r2.URL.RawPath = path, ""
+			r = r2
+		case !v2Routes[path]:
+			// Routes outside of the operations, like /metrics, are not
+			// versioned.
+			next.ServeHTTP(w, r)
+			return
+		}
+		requests.With("version", legacyAPIVersion, "deprecated", "true").Add(1)
+		w.Header().Set("Deprecation", "true")
+		if v2Routes[path] {
+			w.Header().Set("Link", "<"+v2Prefix+path+`>; rel="successor-version"`)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
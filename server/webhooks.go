@@ -0,0 +1,344 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+)
+
+//
+// ──────────────────────────────────────────────────────── I ──────────
+//   :::::: W E B H O O K S : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────────────
+//
+
+// Jobs submitted with a "callback_url" are POSTed to it once finished, the
+// body being the job as GET /jobs/{id} returns it. Failed deliveries are
+// retried with an exponential backoff, and can be redelivered from the
+// admin listener with POST /admin/jobs/{id}/redeliver.
+//
+// Callback URLs may point to any public host when no hosts are allowed by
+// the config, but not to the private, loopback or link-local addresses of
+// the network of the service, which are checked once resolved, when
+// dialing. A job has one delivery at a time.
+//
+// When signing keys are configured, notifications are signed with the
+// active one: X-Stringsvc-Signature is the hex HMAC-SHA256 of the
+// timestamp, a dot and the body, which receivers check against the key
+// named by X-Stringsvc-Key-Id, and the timestamp against their clock to
+// reject replays.
+
+// Headers of the notifications.
+const (
+	webhookSignatureHeader = "X-Stringsvc-Signature"
+	webhookKeyIDHeader     = "X-Stringsvc-Key-Id"
+	webhookTimestampHeader = "X-Stringsvc-Timestamp"
+)
+
+// Defaults of the "webhooks" settings of the "jobs" config section.
+const (
+	defaultWebhookMaxAttempts = 5
+	defaultWebhookBackoff     = time.Second
+	defaultWebhookMaxBackoff  = 5 * time.Minute
+	defaultWebhookTimeout     = 10 * time.Second
+)
+
+var (
+	// ErrCallbackNotAllowed is returned for callback URLs whose host is not
+	// allowed by the config, with a 422.
+	ErrCallbackNotAllowed = errors.New("Callback host not allowed")
+	// ErrNoCallback is returned when redelivering the notification of a job
+	// submitted without callback URL, with a 422.
+	ErrNoCallback = errors.New("Job has no callback URL")
+	// ErrJobNotFinished is returned when redelivering the notification of a
+	// job still pending or running, with a 409.
+	ErrJobNotFinished = errors.New("Job not finished yet")
+	// ErrCallbackInProgress is returned when redelivering the notification
+	// of a job while it is being delivered, with a 409.
+	ErrCallbackInProgress = errors.New("Notification of the job being delivered")
+)
+
+// Statuses of the notifications of jobs.
+const (
+	callbackPending   = "pending"
+	callbackDelivered = "delivered"
+	callbackFailed    = "failed"
+)
+
+// jobCallback is the state of the notification of a job.
+type jobCallback struct {
+	URL string `json:"url"`
+	// Status is "pending" until the notification is delivered, or "failed"
+	// once every attempt failed.
+	Status      string     `json:"status"`
+	Attempts    int        `json:"attempts"`
+	LastError   string     `json:"last_error,omitempty"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+}
+
+type webhookMetrics struct {
+	// Attempts counts the delivery attempts by "status", the HTTP status of
+	// the response or "error".
+	Attempts metrics.Counter
+	// Deliveries counts the notifications by "result", "delivered" or
+	// "failed" once every attempt failed.
+	Deliveries metrics.Counter
+	Latency    metrics.Histogram
+}
+
+// webhookNotifier delivers the notifications of finished jobs.
+type webhookNotifier struct {
+	client       *http.Client
+	keys         KeyProvider
+	allowedHosts map[string]bool
+	// private allows the callback URLs to private networks without
+	// allowed hosts.
+	private     bool
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	metrics     webhookMetrics
+	logger      log.Logger
+
+	mu sync.Mutex
+	// delivering holds the IDs of the jobs whose notification is being
+	// delivered.
+	delivering map[string]bool
+}
+
+// newWebhookNotifier returns the notifier of cfg, signing with keys unless
+// nil.
+func newWebhookNotifier(cfg webhookConfig, keys KeyProvider, m webhookMetrics, logger log.Logger) *webhookNotifier {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// A proxy would dial the addresses that are not allowed.
+	transport.Proxy = nil
+	if len(cfg.AllowedHosts) == 0 && !cfg.AllowPrivateNetworks {
+		dialer := &net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+			Control: func(_, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if ip, err := netip.ParseAddr(host); err != nil || !publicAddr(ip) {
+					return ErrCallbackNotAllowed
+				}
+				return nil
+			},
+		}
+		transport.DialContext = dialer.DialContext
+	}
+	n := &webhookNotifier{
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   millisecondsOr(cfg.TimeoutMS, defaultWebhookTimeout),
+			// Redirects could lead to hosts that are not allowed.
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		keys:        keys,
+		maxAttempts: cfg.MaxAttempts,
+		backoff:     millisecondsOr(cfg.BackoffMS, defaultWebhookBackoff),
+		maxBackoff:  millisecondsOr(cfg.MaxBackoffMS, defaultWebhookMaxBackoff),
+		metrics:     m,
+		logger:      logger,
+		delivering:  make(map[string]bool),
+		private:     cfg.AllowPrivateNetworks,
+	}
+	if n.maxAttempts <= 0 {
+		n.maxAttempts = defaultWebhookMaxAttempts
+	}
+	if len(cfg.AllowedHosts) > 0 {
+		n.allowedHosts = make(map[string]bool, len(cfg.AllowedHosts))
+		for _, h := range cfg.AllowedHosts {
+			n.allowedHosts[strings.ToLower(h)] = true
+		}
+	}
+	return n
+}
+
+// publicAddr tells whether ip is a public unicast address.
+func publicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !ip.IsLoopback() && !ip.IsLinkLocalUnicast()
+}
+
+// check rejects the callback URLs that are not absolute HTTP ones, or
+// whose host is not allowed. Those whose host is a name are checked again
+// once resolved, by the client of n.
+func (n *webhookNotifier) check(callbackURL string) error {
+	u, err := url.Parse(callbackURL)
+	if err != nil {
+		return malformedInputError{"callback URL", err}
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return operationError("Callback URL must be an absolute http or https URL")
+	}
+	if n.allowedHosts != nil {
+		if !n.allowedHosts[strings.ToLower(u.Hostname())] {
+			return ErrCallbackNotAllowed
+		}
+		return nil
+	}
+	if ip, err := netip.ParseAddr(u.Hostname()); err == nil && !n.private && !publicAddr(ip) {
+		return ErrCallbackNotAllowed
+	}
+	return nil
+}
+
+// claim marks the notification of the job id as being delivered, unless it
+// already is.
+func (n *webhookNotifier) claim(id string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.delivering[id] {
+		return false
+	}
+	n.delivering[id] = true
+	return true
+}
+
+func (n *webhookNotifier) release(id string) {
+	n.mu.Lock()
+	delete(n.delivering, id)
+	n.mu.Unlock()
+}
+
+// deliver POSTs the notification of the finished job j, claimed by claim,
+// until it is accepted or every attempt failed, recording its state in j
+// with save. It releases j once done.
+func (n *webhookNotifier) deliver(j Job, save func(Job)) {
+	defer n.release(j.ID)
+	cb := *j.Callback
+	notification := j
+	notification.Callback = nil
+	body, err := json.Marshal(notification)
+	if err != nil {
+		n.logger.Log("msg", "cannot encode job notification", "job", j.ID, "err", err)
+		return
+	}
+
+	delay := n.backoff
+	for cb.Attempts < n.maxAttempts {
+		if cb.Attempts > 0 {
+			time.Sleep(delay)
+			if delay *= 2; delay > n.maxBackoff {
+				delay = n.maxBackoff
+			}
+		}
+		cb.Attempts++
+		err := n.post(cb.URL, body)
+		if err == nil {
+			delivered := time.Now().UTC()
+			cb.Status, cb.LastError, cb.DeliveredAt = callbackDelivered, "", &delivered
+			break
+		}
+		cb.LastError = err.Error()
+		// Retries would dial the addresses that are not allowed again.
+		if cb.Attempts == n.maxAttempts || errors.Is(err, ErrCallbackNotAllowed) {
+			cb.Status = callbackFailed
+		}
+		// Every attempt is recorded, so that clients polling the job can see
+		// why its notification is late.
+		state := cb
+		j.Callback = &state
+		save(j)
+		if cb.Status == callbackFailed {
+			break
+		}
+	}
+	if cb.Status == callbackPending {
+		return
+	}
+	n.metrics.Deliveries.With("result", cb.Status).Add(1)
+	if cb.Status == callbackFailed {
+		n.logger.Log("msg", "cannot deliver job notification", "job", j.ID, "url", cb.URL, "attempts", cb.Attempts, "err", cb.LastError)
+		return
+	}
+	j.Callback = &cb
+	save(j)
+}
+
+// post sends a signed notification to callbackURL, which must accept it
+// with a 2xx status.
+func (n *webhookNotifier) post(callbackURL string, body []byte) (err error) {
+	status := "error"
+	defer func(begin time.Time) {
+		n.metrics.Attempts.With("status", status).Add(1)
+		n.metrics.Latency.With("status", status).Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	ctx := context.Background()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, callbackURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	if n.keys != nil {
+		keyID, key, err := n.keys.ActiveKey(ctx)
+		if err != nil {
+			return err
+		}
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		sum, err := hmacOf(timestamp+"."+string(body), key, "sha256")
+		if err != nil {
+			return err
+		}
+		req.Header.Set(webhookSignatureHeader, hex.EncodeToString(sum))
+		req.Header.Set(webhookKeyIDHeader, keyID)
+		req.Header.Set(webhookTimestampHeader, timestamp)
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	status = strconv.Itoa(resp.StatusCode)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Callback answered %s", resp.Status)
+	}
+	return nil
+}
+
+// makeJobRedeliveryHandler redelivers the notification of a finished job
+// on POST /admin/jobs/{id}/redeliver, answering with a 202 and the job.
+func makeJobRedeliveryHandler(q *jobQueue) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/admin/jobs/"), "/redeliver")
+		if !ok || id == "" || strings.Contains(id, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		j, err := q.Redeliver(r.Context(), id)
+		if err != nil {
+			encodeError(r.Context(), err, w)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(j)
+	})
+}
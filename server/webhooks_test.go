@@ -0,0 +1,97 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics/discard"
+)
+
+func newTestWebhookNotifier(cfg webhookConfig) *webhookNotifier {
+	cfg.MaxAttempts, cfg.BackoffMS = 3, 1
+	return newWebhookNotifier(cfg, nil, webhookMetrics{
+		Attempts:   discard.NewCounter(),
+		Deliveries: discard.NewCounter(),
+		Latency:    discard.NewHistogram(),
+	}, log.NewNopLogger())
+}
+
+func TestWebhookCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     webhookConfig
+		url     string
+		wantErr error
+	}{
+		{name: "public host", url: "https://hooks.example.com/job"},
+		{name: "public address", url: "http://93.184.216.34/job"},
+		{name: "loopback", url: "http://127.0.0.1:8080/job", wantErr: ErrCallbackNotAllowed},
+		{name: "IPv6 loopback", url: "http://[::1]/job", wantErr: ErrCallbackNotAllowed},
+		{name: "IPv4-mapped loopback", url: "http://[::ffff:127.0.0.1]/job", wantErr: ErrCallbackNotAllowed},
+		{name: "private", url: "http://10.1.2.3/job", wantErr: ErrCallbackNotAllowed},
+		{name: "link-local", url: "http://169.254.169.254/latest/meta-data", wantErr: ErrCallbackNotAllowed},
+		{name: "unspecified", url: "http://0.0.0.0/job", wantErr: ErrCallbackNotAllowed},
+		{name: "private networks allowed", cfg: webhookConfig{AllowPrivateNetworks: true}, url: "http://10.1.2.3/job"},
+		{name: "allowed host", cfg: webhookConfig{AllowedHosts: []string{"10.1.2.3"}}, url: "http://10.1.2.3/job"},
+		{name: "host not allowed", cfg: webhookConfig{AllowedHosts: []string{"hooks.example.com"}}, url: "http://other.example.com/job", wantErr: ErrCallbackNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := newTestWebhookNotifier(tt.cfg).check(tt.url); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("check(%q) = %v, want %v", tt.url, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWebhookDeliver(t *testing.T) {
+	calls := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer receiver.Close()
+	// localhost passes the check, but resolves to a loopback address.
+	url := "http://localhost:" + receiver.URL[len("http://127.0.0.1:"):]
+
+	tests := []struct {
+		name         string
+		cfg          webhookConfig
+		wantStatus   string
+		wantAttempts int
+		wantCalls    int
+	}{
+		{name: "loopback once resolved", wantStatus: callbackFailed, wantAttempts: 1},
+		{name: "private networks allowed", cfg: webhookConfig{AllowPrivateNetworks: true}, wantStatus: callbackDelivered, wantAttempts: 1, wantCalls: 1},
+		{name: "allowed host", cfg: webhookConfig{AllowedHosts: []string{"localhost"}}, wantStatus: callbackDelivered, wantAttempts: 1, wantCalls: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls = 0
+			n := newTestWebhookNotifier(tt.cfg)
+			if err := n.check(url); err != nil {
+				t.Fatal(err)
+			}
+			var saved Job
+			j := Job{ID: "j1", Status: jobDone, Callback: &jobCallback{URL: url, Status: callbackPending}}
+			if !n.claim(j.ID) {
+				t.Fatal("cannot claim the job")
+			}
+			if n.claim(j.ID) {
+				t.Fatal("claimed the job being delivered")
+			}
+			n.deliver(j, func(j Job) { saved = j })
+			if saved.Callback == nil || saved.Callback.Status != tt.wantStatus || saved.Callback.Attempts != tt.wantAttempts {
+				t.Fatalf("callback = %+v, want %s after %d attempts", saved.Callback, tt.wantStatus, tt.wantAttempts)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("receiver called %d times, want %d", calls, tt.wantCalls)
+			}
+			if !n.claim(j.ID) {
+				t.Error("job not released once delivered")
+			}
+		})
+	}
+}
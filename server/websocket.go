@@ -0,0 +1,121 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/go-kit/kit/metrics"
+	"github.com/gorilla/websocket"
+)
+
+//
+// ────────────────────────────────────────────────────────── I ──────────
+//   :::::: W E B S O C K E T : :  :   :    :     :        :          :
+// ────────────────────────────────────────────────────────────────────
+//
+
+const (
+	// wsIdleTimeout closes connections that have not sent a frame for that
+	// long. Clients keep idle sessions open with pings.
+	wsIdleTimeout = 60 * time.Second
+	// wsWriteTimeout bounds the time spent sending a single result.
+	wsWriteTimeout = 10 * time.Second
+	// wsMaxFrameSize is the largest frame a client may send.
+	wsMaxFrameSize = 1 << 20
+)
+
+// websocketMetrics are recorded once per connection, when it closes. The
+// operations themselves are measured by the service middlewares.
+type websocketMetrics struct {
+	Connections metrics.Gauge
+	Frames      metrics.Histogram
+	Duration    metrics.Histogram
+}
+
+// wsFrame is what the server sends back for each frame it receives. ID is
+// copied from the request frame so clients can pipeline calls.
+type wsFrame struct {
+	ID   interface{}     `json:"id,omitempty"`
+	Op   string          `json:"op"`
+	V    json.RawMessage `json:"v,omitempty"`
+	Err  string          `json:"err,omitempty"`
+	Code ErrorCode       `json:"code,omitempty"`
+}
+
+// makeWebsocketHandler serves /ws. Each text frame is a JSON object naming
+// the operation in "op", with the fields of its request alongside, for
+// instance {"op": "uppercase", "s": "hello"}. Frames are handled in order,
+// one at a time per connection.
+func makeWebsocketHandler(ops map[string]operation, m websocketMetrics, logger log.Logger) http.Handler {
+	// The default origin check only accepts same-origin connections.
+	upgrader := websocket.Upgrader{}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// Upgrade has already replied with an HTTP error.
+			return
+		}
+		defer conn.Close()
+
+		frames := 0
+		m.Connections.Add(1)
+		defer func(begin time.Time) {
+			m.Connections.Add(-1)
+			m.Frames.Observe(float64(frames))
+			m.Duration.Observe(time.Since(begin).Seconds())
+		}(time.Now())
+
+		conn.SetReadLimit(wsMaxFrameSize)
+		conn.SetReadDeadline(time.Now().Add(wsIdleTimeout))
+		conn.SetPingHandler(func(data string) error {
+			conn.SetReadDeadline(time.Now().Add(wsIdleTimeout))
+			return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(wsWriteTimeout))
+		})
+
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+					level.Warn(logger).Log("transport", "websocket", "remote", r.RemoteAddr, "err", err)
+				}
+				return
+			}
+			conn.SetReadDeadline(time.Now().Add(wsIdleTimeout))
+			frames++
+
+			conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := conn.WriteJSON(handleWebsocketFrame(r, ops, data)); err != nil {
+				level.Warn(logger).Log("transport", "websocket", "remote", r.RemoteAddr, "err", err)
+				return
+			}
+		}
+	})
+}
+
+func handleWebsocketFrame(r *http.Request, ops map[string]operation, data []byte) wsFrame {
+	var request map[string]interface{}
+	if err := json.Unmarshal(data, &request); err != nil {
+		return wsFrame{Err: err.Error(), Code: CodeMalformedInput}
+	}
+	name, _ := request["op"].(string)
+	resp := wsFrame{ID: request["id"], Op: name}
+	delete(request, "op")
+	delete(request, "id")
+
+	op, ok := ops[name]
+	if !ok {
+		resp.Err, resp.Code = fmt.Sprintf("Unknown operation %q", name), CodeUnknownOperation
+		return resp
+	}
+	result, err := op.invoke(r.Context(), request)
+	if err != nil {
+		resp.Err, resp.Code = err.Error(), errorCodeOf(err)
+		return resp
+	}
+	resp.V, resp.Err, resp.Code = result.V, result.Err, result.Code
+	return resp
+}
@@ -0,0 +1,208 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/rivo/uniseg"
+	"golang.org/x/text/cases"
+)
+
+//
+// ──────────────────────────────────────────────────────── I ──────────
+//   :::::: W O R D F R E Q : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────────────
+//
+
+// Limits of WordFreq. Counting keeps every distinct word in memory, so the
+// input is bounded well below the body limit.
+const (
+	maxWordFreqInput     = 1 << 20
+	defaultWordFreqLimit = 10
+)
+
+// stopWords maps languages to the words WordFreq can leave out. The
+// built-in lists only hold the most frequent function words; deployments
+// extend them with the "wordfreq" section of the config file.
+var stopWords = map[string]map[string]bool{
+	"en": wordSet(
+		"a", "about", "after", "all", "also", "an", "and", "any", "are", "as", "at",
+		"be", "been", "but", "by", "can", "could", "did", "do", "does", "for", "from",
+		"had", "has", "have", "he", "her", "him", "his", "how", "i", "if", "in", "into",
+		"is", "it", "its", "just", "me", "more", "my", "no", "not", "of", "on", "one",
+		"or", "our", "out", "she", "so", "some", "than", "that", "the", "their", "them",
+		"then", "there", "these", "they", "this", "to", "up", "us", "was", "we", "were",
+		"what", "when", "which", "who", "will", "with", "would", "you", "your",
+	),
+	"es": wordSet(
+		"a", "al", "como", "con", "de", "del", "el", "en", "es", "esta", "la", "las",
+		"le", "lo", "los", "más", "no", "o", "para", "pero", "por", "que", "se", "si",
+		"sin", "su", "sus", "un", "una", "y", "ya",
+	),
+	"vi": wordSet(
+		"và", "của", "là", "có", "được", "cho", "không", "những", "các", "một",
+		"này", "với", "trong", "đã", "để", "thì", "mà", "khi", "cũng", "như",
+	),
+}
+
+func wordSet(words ...string) map[string]bool {
+	set := make(map[string]bool, len(words))
+	for _, w := range words {
+		set[w] = true
+	}
+	return set
+}
+
+// RegisterStopWords adds words to the stop words of language. It is meant
+// to be called before the server starts.
+func RegisterStopWords(language string, words ...string) {
+	if stopWords[language] == nil {
+		stopWords[language] = make(map[string]bool)
+	}
+	for _, w := range words {
+		stopWords[language][cases.Fold().String(w)] = true
+	}
+}
+
+// WordCount is the number of occurrences of a word, from WordFreq.
+type WordCount struct {
+	Word  string `json:"word"`
+	Count int    `json:"count"`
+}
+
+type wordFreqRequest struct {
+	S string `json:"s"`
+	// Limit is the number of words returned, 10 by default.
+	Limit    int `json:"limit" validate:"min=0"`
+	MinCount int `json:"min_count" validate:"min=0"`
+	// StopWords lists the languages whose stop words are left out.
+	StopWords []string `json:"stop_words" validate:"max=64"`
+	// Exclude lists more words to leave out.
+	Exclude       []string `json:"exclude" validate:"max=10000"`
+	CaseSensitive bool     `json:"case_sensitive"`
+}
+
+type wordFreqResponse struct {
+	V   []WordCount `json:"v"`
+	Err string      `json:"err,omitempty"`
+}
+
+// WordFreq returns the limit most frequent words of s seen at least
+// minCount times, the most frequent first and ties in alphabetical order.
+// Words are segmented following UAX #29 and case folded unless
+// caseSensitive; stop words and excluded words are matched regardless of
+// case.
+func (stringService) WordFreq(ctx context.Context, s string, limit, minCount int, stopWordLanguages, exclude []string, caseSensitive bool) ([]WordCount, error) {
+	if len(s) > maxWordFreqInput {
+		return nil, ErrTooLarge
+	}
+	if limit <= 0 {
+		limit = defaultWordFreqLimit
+	}
+	fold := cases.Fold()
+	skipped := make(map[string]bool)
+	for _, lang := range stopWordLanguages {
+		list, ok := stopWords[lang]
+		if !ok {
+			return nil, errorf(CodeUnknownOption, "Unknown stop word language %q", lang)
+		}
+		for w := range list {
+			skipped[w] = true
+		}
+	}
+	for _, w := range exclude {
+		skipped[fold.String(w)] = true
+	}
+
+	counts := make(map[string]int)
+	state := -1
+	for rest := s; rest != ""; {
+		var word string
+		word, rest, state = uniseg.FirstWordInString(rest, state)
+		if strings.IndexFunc(word, isWordRune) < 0 {
+			continue
+		}
+		folded := fold.String(word)
+		if skipped[folded] {
+			continue
+		}
+		if !caseSensitive {
+			word = folded
+		}
+		counts[word]++
+	}
+
+	words := make([]WordCount, 0, len(counts))
+	for w, n := range counts {
+		if n >= minCount {
+			words = append(words, WordCount{w, n})
+		}
+	}
+	sort.Slice(words, func(i, j int) bool {
+		if words[i].Count != words[j].Count {
+			return words[i].Count > words[j].Count
+		}
+		return words[i].Word < words[j].Word
+	})
+	if len(words) > limit {
+		words = words[:limit]
+	}
+	return words, nil
+}
+
+func makeWordFreqEndpoint(svc IStringService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(wordFreqRequest)
+		v, err := svc.WordFreq(ctx, req.S, req.Limit, req.MinCount, req.StopWords, req.Exclude, req.CaseSensitive)
+		if err != nil {
+			return wordFreqResponse{v, err.Error()}, nil
+		}
+		return wordFreqResponse{v, ""}, nil
+	}
+}
+
+func decodeWordFreqRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request wordFreqRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+//
+// ─── LOGGING ────────────────────────────────────────────────────────────────────
+//
+
+func (mw loggingMiddleware) WordFreq(ctx context.Context, s string, limit, minCount int, stopWordLanguages, exclude []string, caseSensitive bool) (output []WordCount, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "wordfreq",
+			"tenant", tenantFrom(ctx),
+			"input_size", len(s),
+			"limit", limit,
+			"min_count", minCount,
+			"stop_words", strings.Join(stopWordLanguages, ","),
+			"words", len(output),
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	output, err = mw.next.WordFreq(ctx, s, limit, minCount, stopWordLanguages, exclude, caseSensitive)
+	return
+}
+
+//
+// ─── INSTRUMENTATION ────────────────────────────────────────────────────────────
+//
+
+func (mw instrumentingMiddleware) WordFreq(ctx context.Context, s string, limit, minCount int, stopWordLanguages, exclude []string, caseSensitive bool) (output []WordCount, err error) {
+	done := mw.begin(ctx, "wordfreq", s)
+	defer func() { done(-1, err) }()
+
+	output, err = mw.next.WordFreq(ctx, s, limit, minCount, stopWordLanguages, exclude, caseSensitive)
+	return
+}
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/rivo/uniseg"
+)
+
+//
+// ──────────────────────────────────────────────── I ──────────
+//   :::::: W R A P : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────
+//
+
+// Limits of Wrap.
+const (
+	maxWrapInput = 1 << 20
+	maxWrapWidth = 4096
+)
+
+type wrapRequest struct {
+	S     string `json:"s"`
+	Width int    `json:"width" validate:"min=1"`
+	// Align is "left" (the default), "right", "center" or "full".
+	Align string `json:"align" validate:"max=64,charset=printable"`
+	// BreakLongWords splits the words wider than a line; they overflow it
+	// otherwise.
+	BreakLongWords bool `json:"break_long_words"`
+	// Indent starts the first line of every paragraph, and HangingIndent
+	// the following ones.
+	Indent        string `json:"indent"`
+	HangingIndent string `json:"hanging_indent"`
+}
+
+type wrapResponse struct {
+	V   string `json:"v"`
+	Err string `json:"err,omitempty"`
+}
+
+// Wrap wraps every line of s, a paragraph, to width display columns,
+// indents included. Words are separated by a single space, and aligned
+// within the width; "full" justification leaves the last line of every
+// paragraph aligned left.
+func (stringService) Wrap(ctx context.Context, s string, width int, align string, breakLongWords bool, indent, hangingIndent string) (string, error) {
+	if len(s) > maxWrapInput {
+		return "", ErrTooLarge
+	}
+	if width < 1 || width > maxWrapWidth {
+		return "", errorf(CodeOutOfRange, "Width must be between 1 and %d", maxWrapWidth)
+	}
+	switch align {
+	case "":
+		align = "left"
+	case "left", "right", "center", "full":
+	default:
+		return "", errorf(CodeUnknownOption, "Unknown alignment %q", align)
+	}
+	if displayWidth.StringWidth(indent) >= width || displayWidth.StringWidth(hangingIndent) >= width {
+		return "", errorf(CodeOutOfRange, "Indents must be narrower than the width")
+	}
+
+	var out []string
+	for _, paragraph := range strings.Split(s, "\n") {
+		lines := wrapWords(strings.Fields(paragraph), func(line int) int {
+			if line == 0 {
+				return width - displayWidth.StringWidth(indent)
+			}
+			return width - displayWidth.StringWidth(hangingIndent)
+		}, breakLongWords)
+		if len(lines) == 0 {
+			out = append(out, "")
+		}
+		for i, words := range lines {
+			prefix, room := indent, width-displayWidth.StringWidth(indent)
+			if i > 0 {
+				prefix, room = hangingIndent, width-displayWidth.StringWidth(hangingIndent)
+			}
+			out = append(out, prefix+alignWords(words, room, align, i == len(lines)-1))
+		}
+	}
+	return strings.Join(out, "\n"), nil
+}
+
+// wrapWords fills lines with words greedily, room(i) being the width of
+// line i. Words wider than their line are split between grapheme clusters
+// when breakLongWords is set.
+func wrapWords(words []string, room func(line int) int, breakLongWords bool) [][]string {
+	var lines [][]string
+	var line []string
+	lineWidth := 0
+	for _, word := range words {
+		for word != "" {
+			wordWidth := displayWidth.StringWidth(word)
+			needed := wordWidth
+			if len(line) > 0 {
+				needed += lineWidth + 1
+			}
+			switch {
+			case needed <= room(len(lines)):
+				line, lineWidth, word = append(line, word), needed, ""
+				continue
+			case len(line) > 0:
+			case !breakLongWords:
+				line, word = []string{word}, ""
+			default:
+				head := cutToWidth(word, room(len(lines)))
+				line, word = []string{head}, word[len(head):]
+			}
+			lines, line, lineWidth = append(lines, line), nil, 0
+		}
+	}
+	if len(line) > 0 {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// cutToWidth returns the longest prefix of s at most width columns wide,
+// and at least its first grapheme cluster.
+func cutToWidth(s string, width int) string {
+	cut, used, state := 0, 0, -1
+	for rest := s; rest != ""; {
+		var cluster string
+		cluster, rest, _, state = uniseg.FirstGraphemeClusterInString(rest, state)
+		w := displayWidth.StringWidth(cluster)
+		if cut > 0 && used+w > width {
+			break
+		}
+		cut, used = cut+len(cluster), used+w
+	}
+	return s[:cut]
+}
+
+// alignWords joins words with spaces and aligns them within room columns.
+func alignWords(words []string, room int, align string, last bool) string {
+	text := strings.Join(words, " ")
+	extra := room - displayWidth.StringWidth(text)
+	if extra <= 0 {
+		return text
+	}
+	switch {
+	case align == "right":
+		return strings.Repeat(" ", extra) + text
+	case align == "center":
+		return strings.Repeat(" ", extra/2) + text
+	case align == "full" && !last && len(words) > 1:
+		var b strings.Builder
+		gaps := len(words) - 1
+		for i, word := range words {
+			if i > 0 {
+				spaces := 1 + extra/gaps
+				if i <= extra%gaps {
+					spaces++
+				}
+				b.WriteString(strings.Repeat(" ", spaces))
+			}
+			b.WriteString(word)
+		}
+		return b.String()
+	}
+	return text
+}
+
+func makeWrapEndpoint(svc IStringService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(wrapRequest)
+		output, err := svc.Wrap(ctx, req.S, req.Width, req.Align, req.BreakLongWords, req.Indent, req.HangingIndent)
+		if err != nil {
+			return wrapResponse{"", err.Error()}, nil
+		}
+		return wrapResponse{output, ""}, nil
+	}
+}
+
+func decodeWrapRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var request wrapRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+//
+// ─── LOGGING ────────────────────────────────────────────────────────────────────
+//
+
+func (mw loggingMiddleware) Wrap(ctx context.Context, s string, width int, align string, breakLongWords bool, indent, hangingIndent string) (output string, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "wrap",
+			"tenant", tenantFrom(ctx),
+			"input_size", len(s),
+			"width", width,
+			"align", align,
+			"output_size", len(output),
+			"err", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+	output, err = mw.next.Wrap(ctx, s, width, align, breakLongWords, indent, hangingIndent)
+	return
+}
+
+//
+// ─── INSTRUMENTATION ────────────────────────────────────────────────────────────
+//
+
+func (mw instrumentingMiddleware) Wrap(ctx context.Context, s string, width int, align string, breakLongWords bool, indent, hangingIndent string) (output string, err error) {
+	done := mw.begin(ctx, "wrap", s)
+	defer func() { done(len(output), err) }()
+
+	output, err = mw.next.Wrap(ctx, s, width, align, breakLongWords, indent, hangingIndent)
+	return
+}
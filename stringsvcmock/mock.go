@@ -0,0 +1,832 @@
+// Package stringsvcmock is a fake stringsvc for the tests of the services
+// using it. Its Service implements client.Service without any server: calls
+// return the responses scripted for them, or call the function set for
+// their method, or return zero values, and are recorded to be checked.
+//
+//	svc := stringsvcmock.New()
+//	svc.Returns("Uppercase", "HELLO")
+//	svc.Fails("Hash", errors.New("Unknown hash algorithm"))
+//	svc.SlugifyFunc = func(_ context.Context, s, _ string, _ int, _ bool) (string, error) {
+//		return strings.ToLower(s), nil
+//	}
+//	svc.SetLatency(50 * time.Millisecond)
+//
+//	thing := NewThing(svc) // takes a client.Service
+//	...
+//	if calls := svc.Calls("Uppercase"); len(calls) != 1 || calls[0].Args[0] != "hello" {
+//		t.Errorf("Uppercase called with %v", calls)
+//	}
+package stringsvcmock
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/anhle128/gokit-stringsvc/client"
+)
+
+//
+// ──────────────────────────────────────────────── I ──────────
+//   :::::: M O C K : :  :   :    :     :        :          :
+// ──────────────────────────────────────────────────────────
+//
+
+// Call is a recorded call to a method of Service.
+type Call struct {
+	Method string
+	// Args are the arguments of the call, but its context.
+	Args []interface{}
+	// Results are the results of the call, but its error.
+	Results []interface{}
+	Err     error
+}
+
+// response is a scripted response.
+type response struct {
+	results []interface{}
+	err     error
+}
+
+// Service is a fake client.Service. The functions of its fields answer the
+// calls to their method that have no scripted response; they must be set
+// before the first call. A Service is safe for concurrent use.
+type Service struct {
+	UppercaseFunc        func(context.Context, string) (string, error)
+	CountFunc            func(context.Context, string) (int, error)
+	CountRunesFunc       func(context.Context, string) (int, error)
+	HashFunc             func(context.Context, string, string, string) (string, error)
+	EncodeFunc           func(context.Context, string, string) (string, error)
+	DecodeFunc           func(context.Context, string, string, string) (string, error)
+	URLEncodeFunc        func(context.Context, string, string) (string, error)
+	URLDecodeFunc        func(context.Context, string, string) (string, error)
+	HTMLFunc             func(context.Context, string, string, string) (string, error)
+	SlugifyFunc          func(context.Context, string, string, int, bool) (string, error)
+	ConvertCaseFunc      func(context.Context, string, string) (string, string, error)
+	SimilarityFunc       func(context.Context, string, string, string) (float64, float64, error)
+	PalindromeFunc       func(context.Context, string, bool, bool) (bool, string, error)
+	AnagramFunc          func(context.Context, string, string, bool, bool) (bool, []string, error)
+	StatsFunc            func(context.Context, string, int) (client.TextStats, error)
+	DetectLanguageFunc   func(context.Context, string, int) ([]client.LanguageConfidence, error)
+	TransliterateFunc    func(context.Context, string, string) (string, error)
+	RandomFunc           func(context.Context, int, []string, int) ([]string, error)
+	IDFunc               func(context.Context, string, int) ([]string, error)
+	CipherFunc           func(context.Context, string, string, string, bool) (string, error)
+	EncryptFunc          func(context.Context, string) (string, string, error)
+	DecryptFunc          func(context.Context, string) (string, string, error)
+	CompressFunc         func(context.Context, string, string, int) (string, error)
+	DecompressFunc       func(context.Context, string, string) (string, error)
+	RenderFunc           func(context.Context, string, map[string]interface{}) (string, error)
+	DiffFunc             func(context.Context, string, string, string, int) (client.DiffResult, error)
+	InflectFunc          func(context.Context, string, string) (string, error)
+	TruncateFunc         func(context.Context, string, int, string, bool) (string, error)
+	PadFunc              func(context.Context, string, int, string, string) (string, error)
+	RedactFunc           func(context.Context, string, []string, string) (string, []client.RedactionFinding, error)
+	ModerateFunc         func(context.Context, string, []string, string) (client.ModerationResult, error)
+	WordFreqFunc         func(context.Context, string, int, int, []string, []string, bool) ([]client.WordCount, error)
+	TokenizeFunc         func(context.Context, string, string, int, bool, bool) ([]string, error)
+	StemFunc             func(context.Context, string, string, string) (string, error)
+	NormalizeUnicodeFunc func(context.Context, string, string) (string, bool, error)
+	RecodeFunc           func(context.Context, string, string) (string, string, float64, error)
+	NumWordsFunc         func(context.Context, string, string) (string, string, error)
+	SignFunc             func(context.Context, string, string, string, string) (string, string, error)
+	VerifyFunc           func(context.Context, string, string, string, string, string) (bool, string, error)
+	JSONFunc             func(context.Context, string, string, string) (string, error)
+	CSVParseFunc         func(context.Context, string, client.CSVDialect) ([][]string, error)
+	CSVFormatFunc        func(context.Context, [][]string, client.CSVDialect) (string, error)
+	ExpandFunc           func(context.Context, string, map[string]string, string, int) (string, []string, error)
+	EmojiFunc            func(context.Context, string, string) (string, int, error)
+	WrapFunc             func(context.Context, string, int, string, bool, string, string) (string, error)
+	PhoneticFunc         func(context.Context, string, string) (string, string, error)
+	UppercaseStreamFunc  func(context.Context, io.Reader, io.Writer) (int64, error)
+	CountStreamFunc      func(context.Context, io.Reader) (int64, error)
+	CountRunesStreamFunc func(context.Context, io.Reader) (int64, error)
+
+	mu        sync.Mutex
+	latency   time.Duration
+	responses map[string][]response
+	calls     []Call
+}
+
+var _ client.Service = (*Service)(nil)
+
+// New returns a Service answering zero values.
+func New() *Service {
+	return &Service{responses: make(map[string][]response)}
+}
+
+// Returns scripts the next response of method, like "Uppercase", that has no
+// response scripted yet: results are its results but its error, in order.
+func (m *Service) Returns(method string, results ...interface{}) {
+	m.script(method, response{results: results})
+}
+
+// Fails scripts the next response of method that has no response scripted
+// yet as a failure with err.
+func (m *Service) Fails(method string, err error) {
+	m.script(method, response{err: err})
+}
+
+func (m *Service) script(method string, r response) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.responses[method] = append(m.responses[method], r)
+}
+
+// SetLatency delays every call by d, or until its context is done.
+func (m *Service) SetLatency(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.latency = d
+}
+
+// Calls returns the calls to method so far, in order, or all of them when
+// method is empty.
+func (m *Service) Calls(method string) []Call {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var calls []Call
+	for _, c := range m.calls {
+		if method == "" || c.Method == method {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// Reset forgets the calls and the scripted responses.
+func (m *Service) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls = nil
+	m.responses = make(map[string][]response)
+}
+
+// call answers a call to method: after the latency, it sets the results
+// pointed by results to those of the next scripted response, or calls fn
+// if not nil. The call is recorded.
+func (m *Service) call(ctx context.Context, method string, args []interface{}, fn func() error, results ...interface{}) error {
+	m.mu.Lock()
+	latency := m.latency
+	queue := m.responses[method]
+	var scripted *response
+	if len(queue) > 0 {
+		scripted = &queue[0]
+		m.responses[method] = queue[1:]
+	}
+	m.mu.Unlock()
+
+	var err error
+	if latency > 0 {
+		timer := time.NewTimer(latency)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			err = ctx.Err()
+		}
+	}
+	switch {
+	case err != nil:
+	case scripted != nil && scripted.err != nil:
+		err = scripted.err
+	case scripted != nil:
+		err = fill(method, scripted.results, results)
+	case fn != nil:
+		err = fn()
+	}
+
+	c := Call{Method: method, Args: args, Err: err}
+	for _, r := range results {
+		c.Results = append(c.Results, reflect.ValueOf(r).Elem().Interface())
+	}
+	m.mu.Lock()
+	m.calls = append(m.calls, c)
+	m.mu.Unlock()
+	return err
+}
+
+// fill sets the results pointed by ptrs to values. A nil value leaves its
+// result zero.
+func fill(method string, values, ptrs []interface{}) error {
+	if len(values) != len(ptrs) {
+		return fmt.Errorf("stringsvcmock: %s scripted with %d results, it has %d", method, len(values), len(ptrs))
+	}
+	for i, v := range values {
+		if v == nil {
+			continue
+		}
+		dst := reflect.ValueOf(ptrs[i]).Elem()
+		src := reflect.ValueOf(v)
+		switch {
+		case src.Type().AssignableTo(dst.Type()):
+			dst.Set(src)
+		case numeric(src.Kind()) && numeric(dst.Kind()):
+			dst.Set(src.Convert(dst.Type()))
+		default:
+			return fmt.Errorf("stringsvcmock: %s scripted with a %s for its %s result %d", method, src.Type(), dst.Type(), i+1)
+		}
+	}
+	return nil
+}
+
+// numeric tells whether k is a kind of number, so that untyped constants
+// can be scripted for any numeric result.
+func numeric(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Float64
+}
+
+//
+// ─── SERVICE ────────────────────────────────────────────────────────────────────
+//
+
+func (m *Service) Uppercase(ctx context.Context, s string) (output string, err error) {
+	var fn func() error
+	if m.UppercaseFunc != nil {
+		fn = func() (err error) {
+			output, err = m.UppercaseFunc(ctx, s)
+			return
+		}
+	}
+	err = m.call(ctx, "Uppercase", []interface{}{s}, fn, &output)
+	return
+}
+
+func (m *Service) Count(ctx context.Context, s string) (n int, err error) {
+	var fn func() error
+	if m.CountFunc != nil {
+		fn = func() (err error) {
+			n, err = m.CountFunc(ctx, s)
+			return
+		}
+	}
+	err = m.call(ctx, "Count", []interface{}{s}, fn, &n)
+	return
+}
+
+func (m *Service) CountRunes(ctx context.Context, s string) (n int, err error) {
+	var fn func() error
+	if m.CountRunesFunc != nil {
+		fn = func() (err error) {
+			n, err = m.CountRunesFunc(ctx, s)
+			return
+		}
+	}
+	err = m.call(ctx, "CountRunes", []interface{}{s}, fn, &n)
+	return
+}
+
+func (m *Service) Hash(ctx context.Context, s, algorithm, encoding string) (output string, err error) {
+	var fn func() error
+	if m.HashFunc != nil {
+		fn = func() (err error) {
+			output, err = m.HashFunc(ctx, s, algorithm, encoding)
+			return
+		}
+	}
+	err = m.call(ctx, "Hash", []interface{}{s, algorithm, encoding}, fn, &output)
+	return
+}
+
+func (m *Service) Encode(ctx context.Context, s, encoding string) (output string, err error) {
+	var fn func() error
+	if m.EncodeFunc != nil {
+		fn = func() (err error) {
+			output, err = m.EncodeFunc(ctx, s, encoding)
+			return
+		}
+	}
+	err = m.call(ctx, "Encode", []interface{}{s, encoding}, fn, &output)
+	return
+}
+
+func (m *Service) Decode(ctx context.Context, s, encoding, padding string) (output string, err error) {
+	var fn func() error
+	if m.DecodeFunc != nil {
+		fn = func() (err error) {
+			output, err = m.DecodeFunc(ctx, s, encoding, padding)
+			return
+		}
+	}
+	err = m.call(ctx, "Decode", []interface{}{s, encoding, padding}, fn, &output)
+	return
+}
+
+func (m *Service) URLEncode(ctx context.Context, s, mode string) (output string, err error) {
+	var fn func() error
+	if m.URLEncodeFunc != nil {
+		fn = func() (err error) {
+			output, err = m.URLEncodeFunc(ctx, s, mode)
+			return
+		}
+	}
+	err = m.call(ctx, "URLEncode", []interface{}{s, mode}, fn, &output)
+	return
+}
+
+func (m *Service) URLDecode(ctx context.Context, s, mode string) (output string, err error) {
+	var fn func() error
+	if m.URLDecodeFunc != nil {
+		fn = func() (err error) {
+			output, err = m.URLDecodeFunc(ctx, s, mode)
+			return
+		}
+	}
+	err = m.call(ctx, "URLDecode", []interface{}{s, mode}, fn, &output)
+	return
+}
+
+func (m *Service) HTML(ctx context.Context, s, op, policy string) (output string, err error) {
+	var fn func() error
+	if m.HTMLFunc != nil {
+		fn = func() (err error) {
+			output, err = m.HTMLFunc(ctx, s, op, policy)
+			return
+		}
+	}
+	err = m.call(ctx, "HTML", []interface{}{s, op, policy}, fn, &output)
+	return
+}
+
+func (m *Service) Slugify(ctx context.Context, s, separator string, maxLength int, preserveUnicode bool) (output string, err error) {
+	var fn func() error
+	if m.SlugifyFunc != nil {
+		fn = func() (err error) {
+			output, err = m.SlugifyFunc(ctx, s, separator, maxLength, preserveUnicode)
+			return
+		}
+	}
+	err = m.call(ctx, "Slugify", []interface{}{s, separator, maxLength, preserveUnicode}, fn, &output)
+	return
+}
+
+func (m *Service) ConvertCase(ctx context.Context, s, target string) (output, detected string, err error) {
+	var fn func() error
+	if m.ConvertCaseFunc != nil {
+		fn = func() (err error) {
+			output, detected, err = m.ConvertCaseFunc(ctx, s, target)
+			return
+		}
+	}
+	err = m.call(ctx, "ConvertCase", []interface{}{s, target}, fn, &output, &detected)
+	return
+}
+
+func (m *Service) Similarity(ctx context.Context, a, b, algorithm string) (distance, score float64, err error) {
+	var fn func() error
+	if m.SimilarityFunc != nil {
+		fn = func() (err error) {
+			distance, score, err = m.SimilarityFunc(ctx, a, b, algorithm)
+			return
+		}
+	}
+	err = m.call(ctx, "Similarity", []interface{}{a, b, algorithm}, fn, &distance, &score)
+	return
+}
+
+func (m *Service) Palindrome(ctx context.Context, s string, normalize, ignorePunctuation bool) (ok bool, normalized string, err error) {
+	var fn func() error
+	if m.PalindromeFunc != nil {
+		fn = func() (err error) {
+			ok, normalized, err = m.PalindromeFunc(ctx, s, normalize, ignorePunctuation)
+			return
+		}
+	}
+	err = m.call(ctx, "Palindrome", []interface{}{s, normalize, ignorePunctuation}, fn, &ok, &normalized)
+	return
+}
+
+func (m *Service) Anagram(ctx context.Context, a, b string, normalize, ignorePunctuation bool) (ok bool, normalized []string, err error) {
+	var fn func() error
+	if m.AnagramFunc != nil {
+		fn = func() (err error) {
+			ok, normalized, err = m.AnagramFunc(ctx, a, b, normalize, ignorePunctuation)
+			return
+		}
+	}
+	err = m.call(ctx, "Anagram", []interface{}{a, b, normalize, ignorePunctuation}, fn, &ok, &normalized)
+	return
+}
+
+func (m *Service) Stats(ctx context.Context, s string, wordsPerMinute int) (output client.TextStats, err error) {
+	var fn func() error
+	if m.StatsFunc != nil {
+		fn = func() (err error) {
+			output, err = m.StatsFunc(ctx, s, wordsPerMinute)
+			return
+		}
+	}
+	err = m.call(ctx, "Stats", []interface{}{s, wordsPerMinute}, fn, &output)
+	return
+}
+
+func (m *Service) DetectLanguage(ctx context.Context, s string, maxResults int) (output []client.LanguageConfidence, err error) {
+	var fn func() error
+	if m.DetectLanguageFunc != nil {
+		fn = func() (err error) {
+			output, err = m.DetectLanguageFunc(ctx, s, maxResults)
+			return
+		}
+	}
+	err = m.call(ctx, "DetectLanguage", []interface{}{s, maxResults}, fn, &output)
+	return
+}
+
+func (m *Service) Transliterate(ctx context.Context, s, scheme string) (output string, err error) {
+	var fn func() error
+	if m.TransliterateFunc != nil {
+		fn = func() (err error) {
+			output, err = m.TransliterateFunc(ctx, s, scheme)
+			return
+		}
+	}
+	err = m.call(ctx, "Transliterate", []interface{}{s, scheme}, fn, &output)
+	return
+}
+
+func (m *Service) Random(ctx context.Context, length int, classes []string, count int) (output []string, err error) {
+	var fn func() error
+	if m.RandomFunc != nil {
+		fn = func() (err error) {
+			output, err = m.RandomFunc(ctx, length, classes, count)
+			return
+		}
+	}
+	err = m.call(ctx, "Random", []interface{}{length, classes, count}, fn, &output)
+	return
+}
+
+func (m *Service) ID(ctx context.Context, kind string, count int) (output []string, err error) {
+	var fn func() error
+	if m.IDFunc != nil {
+		fn = func() (err error) {
+			output, err = m.IDFunc(ctx, kind, count)
+			return
+		}
+	}
+	err = m.call(ctx, "ID", []interface{}{kind, count}, fn, &output)
+	return
+}
+
+func (m *Service) Cipher(ctx context.Context, s, scheme, key string, decrypt bool) (output string, err error) {
+	var fn func() error
+	if m.CipherFunc != nil {
+		fn = func() (err error) {
+			output, err = m.CipherFunc(ctx, s, scheme, key, decrypt)
+			return
+		}
+	}
+	err = m.call(ctx, "Cipher", []interface{}{s, scheme, key, decrypt}, fn, &output)
+	return
+}
+
+func (m *Service) Encrypt(ctx context.Context, s string) (ciphertext, keyID string, err error) {
+	var fn func() error
+	if m.EncryptFunc != nil {
+		fn = func() (err error) {
+			ciphertext, keyID, err = m.EncryptFunc(ctx, s)
+			return
+		}
+	}
+	err = m.call(ctx, "Encrypt", []interface{}{s}, fn, &ciphertext, &keyID)
+	return
+}
+
+func (m *Service) Decrypt(ctx context.Context, s string) (plaintext, keyID string, err error) {
+	var fn func() error
+	if m.DecryptFunc != nil {
+		fn = func() (err error) {
+			plaintext, keyID, err = m.DecryptFunc(ctx, s)
+			return
+		}
+	}
+	err = m.call(ctx, "Decrypt", []interface{}{s}, fn, &plaintext, &keyID)
+	return
+}
+
+func (m *Service) Compress(ctx context.Context, s, algorithm string, level int) (output string, err error) {
+	var fn func() error
+	if m.CompressFunc != nil {
+		fn = func() (err error) {
+			output, err = m.CompressFunc(ctx, s, algorithm, level)
+			return
+		}
+	}
+	err = m.call(ctx, "Compress", []interface{}{s, algorithm, level}, fn, &output)
+	return
+}
+
+func (m *Service) Decompress(ctx context.Context, s, algorithm string) (output string, err error) {
+	var fn func() error
+	if m.DecompressFunc != nil {
+		fn = func() (err error) {
+			output, err = m.DecompressFunc(ctx, s, algorithm)
+			return
+		}
+	}
+	err = m.call(ctx, "Decompress", []interface{}{s, algorithm}, fn, &output)
+	return
+}
+
+func (m *Service) Render(ctx context.Context, s string, data map[string]interface{}) (output string, err error) {
+	var fn func() error
+	if m.RenderFunc != nil {
+		fn = func() (err error) {
+			output, err = m.RenderFunc(ctx, s, data)
+			return
+		}
+	}
+	err = m.call(ctx, "Render", []interface{}{s, data}, fn, &output)
+	return
+}
+
+func (m *Service) Diff(ctx context.Context, a, b, granularity string, contextLines int) (output client.DiffResult, err error) {
+	var fn func() error
+	if m.DiffFunc != nil {
+		fn = func() (err error) {
+			output, err = m.DiffFunc(ctx, a, b, granularity, contextLines)
+			return
+		}
+	}
+	err = m.call(ctx, "Diff", []interface{}{a, b, granularity, contextLines}, fn, &output)
+	return
+}
+
+func (m *Service) Inflect(ctx context.Context, s, op string) (output string, err error) {
+	var fn func() error
+	if m.InflectFunc != nil {
+		fn = func() (err error) {
+			output, err = m.InflectFunc(ctx, s, op)
+			return
+		}
+	}
+	err = m.call(ctx, "Inflect", []interface{}{s, op}, fn, &output)
+	return
+}
+
+func (m *Service) Truncate(ctx context.Context, s string, maxLength int, ellipsis string, wordBoundary bool) (output string, err error) {
+	var fn func() error
+	if m.TruncateFunc != nil {
+		fn = func() (err error) {
+			output, err = m.TruncateFunc(ctx, s, maxLength, ellipsis, wordBoundary)
+			return
+		}
+	}
+	err = m.call(ctx, "Truncate", []interface{}{s, maxLength, ellipsis, wordBoundary}, fn, &output)
+	return
+}
+
+func (m *Service) Pad(ctx context.Context, s string, width int, align, fill string) (output string, err error) {
+	var fn func() error
+	if m.PadFunc != nil {
+		fn = func() (err error) {
+			output, err = m.PadFunc(ctx, s, width, align, fill)
+			return
+		}
+	}
+	err = m.call(ctx, "Pad", []interface{}{s, width, align, fill}, fn, &output)
+	return
+}
+
+func (m *Service) Redact(ctx context.Context, s string, types []string, mask string) (output string, findings []client.RedactionFinding, err error) {
+	var fn func() error
+	if m.RedactFunc != nil {
+		fn = func() (err error) {
+			output, findings, err = m.RedactFunc(ctx, s, types, mask)
+			return
+		}
+	}
+	err = m.call(ctx, "Redact", []interface{}{s, types, mask}, fn, &output, &findings)
+	return
+}
+
+func (m *Service) Moderate(ctx context.Context, s string, languages []string, mask string) (output client.ModerationResult, err error) {
+	var fn func() error
+	if m.ModerateFunc != nil {
+		fn = func() (err error) {
+			output, err = m.ModerateFunc(ctx, s, languages, mask)
+			return
+		}
+	}
+	err = m.call(ctx, "Moderate", []interface{}{s, languages, mask}, fn, &output)
+	return
+}
+
+func (m *Service) WordFreq(ctx context.Context, s string, limit, minCount int, stopWords, exclude []string, caseSensitive bool) (output []client.WordCount, err error) {
+	var fn func() error
+	if m.WordFreqFunc != nil {
+		fn = func() (err error) {
+			output, err = m.WordFreqFunc(ctx, s, limit, minCount, stopWords, exclude, caseSensitive)
+			return
+		}
+	}
+	err = m.call(ctx, "WordFreq", []interface{}{s, limit, minCount, stopWords, exclude, caseSensitive}, fn, &output)
+	return
+}
+
+func (m *Service) Tokenize(ctx context.Context, s, unit string, n int, lowercase, stripPunctuation bool) (output []string, err error) {
+	var fn func() error
+	if m.TokenizeFunc != nil {
+		fn = func() (err error) {
+			output, err = m.TokenizeFunc(ctx, s, unit, n, lowercase, stripPunctuation)
+			return
+		}
+	}
+	err = m.call(ctx, "Tokenize", []interface{}{s, unit, n, lowercase, stripPunctuation}, fn, &output)
+	return
+}
+
+func (m *Service) Stem(ctx context.Context, s, language, algorithm string) (output string, err error) {
+	var fn func() error
+	if m.StemFunc != nil {
+		fn = func() (err error) {
+			output, err = m.StemFunc(ctx, s, language, algorithm)
+			return
+		}
+	}
+	err = m.call(ctx, "Stem", []interface{}{s, language, algorithm}, fn, &output)
+	return
+}
+
+func (m *Service) NormalizeUnicode(ctx context.Context, s, form string) (output string, normalized bool, err error) {
+	var fn func() error
+	if m.NormalizeUnicodeFunc != nil {
+		fn = func() (err error) {
+			output, normalized, err = m.NormalizeUnicodeFunc(ctx, s, form)
+			return
+		}
+	}
+	err = m.call(ctx, "NormalizeUnicode", []interface{}{s, form}, fn, &output, &normalized)
+	return
+}
+
+func (m *Service) Recode(ctx context.Context, s, charset string) (output, detected string, confidence float64, err error) {
+	var fn func() error
+	if m.RecodeFunc != nil {
+		fn = func() (err error) {
+			output, detected, confidence, err = m.RecodeFunc(ctx, s, charset)
+			return
+		}
+	}
+	err = m.call(ctx, "Recode", []interface{}{s, charset}, fn, &output, &detected, &confidence)
+	return
+}
+
+func (m *Service) NumWords(ctx context.Context, n, locale string) (words, formatted string, err error) {
+	var fn func() error
+	if m.NumWordsFunc != nil {
+		fn = func() (err error) {
+			words, formatted, err = m.NumWordsFunc(ctx, n, locale)
+			return
+		}
+	}
+	err = m.call(ctx, "NumWords", []interface{}{n, locale}, fn, &words, &formatted)
+	return
+}
+
+func (m *Service) Sign(ctx context.Context, s, keyID, algorithm, encoding string) (signature, signingKeyID string, err error) {
+	var fn func() error
+	if m.SignFunc != nil {
+		fn = func() (err error) {
+			signature, signingKeyID, err = m.SignFunc(ctx, s, keyID, algorithm, encoding)
+			return
+		}
+	}
+	err = m.call(ctx, "Sign", []interface{}{s, keyID, algorithm, encoding}, fn, &signature, &signingKeyID)
+	return
+}
+
+func (m *Service) Verify(ctx context.Context, s, signature, keyID, algorithm, encoding string) (ok bool, signingKeyID string, err error) {
+	var fn func() error
+	if m.VerifyFunc != nil {
+		fn = func() (err error) {
+			ok, signingKeyID, err = m.VerifyFunc(ctx, s, signature, keyID, algorithm, encoding)
+			return
+		}
+	}
+	err = m.call(ctx, "Verify", []interface{}{s, signature, keyID, algorithm, encoding}, fn, &ok, &signingKeyID)
+	return
+}
+
+func (m *Service) JSON(ctx context.Context, s, op, indent string) (output string, err error) {
+	var fn func() error
+	if m.JSONFunc != nil {
+		fn = func() (err error) {
+			output, err = m.JSONFunc(ctx, s, op, indent)
+			return
+		}
+	}
+	err = m.call(ctx, "JSON", []interface{}{s, op, indent}, fn, &output)
+	return
+}
+
+func (m *Service) CSVParse(ctx context.Context, s string, dialect client.CSVDialect) (records [][]string, err error) {
+	var fn func() error
+	if m.CSVParseFunc != nil {
+		fn = func() (err error) {
+			records, err = m.CSVParseFunc(ctx, s, dialect)
+			return
+		}
+	}
+	err = m.call(ctx, "CSVParse", []interface{}{s, dialect}, fn, &records)
+	return
+}
+
+func (m *Service) CSVFormat(ctx context.Context, rows [][]string, dialect client.CSVDialect) (output string, err error) {
+	var fn func() error
+	if m.CSVFormatFunc != nil {
+		fn = func() (err error) {
+			output, err = m.CSVFormatFunc(ctx, rows, dialect)
+			return
+		}
+	}
+	err = m.call(ctx, "CSVFormat", []interface{}{rows, dialect}, fn, &output)
+	return
+}
+
+func (m *Service) Expand(ctx context.Context, s string, vars map[string]string, missing string, maxDepth int) (output string, unset []string, err error) {
+	var fn func() error
+	if m.ExpandFunc != nil {
+		fn = func() (err error) {
+			output, unset, err = m.ExpandFunc(ctx, s, vars, missing, maxDepth)
+			return
+		}
+	}
+	err = m.call(ctx, "Expand", []interface{}{s, vars, missing, maxDepth}, fn, &output, &unset)
+	return
+}
+
+func (m *Service) Emoji(ctx context.Context, s, op string) (output string, count int, err error) {
+	var fn func() error
+	if m.EmojiFunc != nil {
+		fn = func() (err error) {
+			output, count, err = m.EmojiFunc(ctx, s, op)
+			return
+		}
+	}
+	err = m.call(ctx, "Emoji", []interface{}{s, op}, fn, &output, &count)
+	return
+}
+
+func (m *Service) Wrap(ctx context.Context, s string, width int, align string, breakLongWords bool, indent, hangingIndent string) (output string, err error) {
+	var fn func() error
+	if m.WrapFunc != nil {
+		fn = func() (err error) {
+			output, err = m.WrapFunc(ctx, s, width, align, breakLongWords, indent, hangingIndent)
+			return
+		}
+	}
+	err = m.call(ctx, "Wrap", []interface{}{s, width, align, breakLongWords, indent, hangingIndent}, fn, &output)
+	return
+}
+
+func (m *Service) Phonetic(ctx context.Context, s, algorithm string) (code, alternate string, err error) {
+	var fn func() error
+	if m.PhoneticFunc != nil {
+		fn = func() (err error) {
+			code, alternate, err = m.PhoneticFunc(ctx, s, algorithm)
+			return
+		}
+	}
+	err = m.call(ctx, "Phonetic", []interface{}{s, algorithm}, fn, &code, &alternate)
+	return
+}
+
+func (m *Service) UppercaseStream(ctx context.Context, r io.Reader, w io.Writer) (n int64, err error) {
+	var fn func() error
+	if m.UppercaseStreamFunc != nil {
+		fn = func() (err error) {
+			n, err = m.UppercaseStreamFunc(ctx, r, w)
+			return
+		}
+	}
+	err = m.call(ctx, "UppercaseStream", []interface{}{r, w}, fn, &n)
+	return
+}
+
+func (m *Service) CountStream(ctx context.Context, r io.Reader) (n int64, err error) {
+	var fn func() error
+	if m.CountStreamFunc != nil {
+		fn = func() (err error) {
+			n, err = m.CountStreamFunc(ctx, r)
+			return
+		}
+	}
+	err = m.call(ctx, "CountStream", []interface{}{r}, fn, &n)
+	return
+}
+
+func (m *Service) CountRunesStream(ctx context.Context, r io.Reader) (n int64, err error) {
+	var fn func() error
+	if m.CountRunesStreamFunc != nil {
+		fn = func() (err error) {
+			n, err = m.CountRunesStreamFunc(ctx, r)
+			return
+		}
+	}
+	err = m.call(ctx, "CountRunesStream", []interface{}{r}, fn, &n)
+	return
+}
@@ -0,0 +1,272 @@
+// Package stringsvctest checks the wire format of stringsvc against golden
+// files. Each golden file holds one request and the response the service
+// answers it with, for every operation, and for its failures.
+//
+// Implementations of the service are checked by running the cases against
+// their handler, which is served by an httptest.Server:
+//
+//	func TestWireFormat(t *testing.T) {
+//		cases, err := stringsvctest.Cases()
+//		if err != nil {
+//			t.Fatal(err)
+//		}
+//		stringsvctest.Run(t, handler, cases)
+//	}
+//
+// or against a running instance with RunURL. Clients are checked the other
+// way around, by calling the server of NewServer, which answers the golden
+// requests with their golden responses and fails the test on any other
+// request.
+package stringsvctest
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+//
+// ────────────────────────────────────────────────── I ──────────
+//   :::::: C A S E S : :  :   :    :     :        :          :
+// ────────────────────────────────────────────────────────────
+//
+
+//go:embed testdata/*.json
+var golden embed.FS
+
+// Request is the request of a case.
+type Request struct {
+	Method string            `json:"method"`
+	Path   string            `json:"path"`
+	Header map[string]string `json:"header,omitempty"`
+	// Body is the JSON body of the request, if any.
+	Body json.RawMessage `json:"body,omitempty"`
+}
+
+// Response is the expected response of a case. Only the headers it lists
+// are compared.
+type Response struct {
+	Status int               `json:"status"`
+	Header map[string]string `json:"header,omitempty"`
+	Body   json.RawMessage   `json:"body,omitempty"`
+}
+
+// Case is a golden request and response.
+type Case struct {
+	Name     string   `json:"name"`
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+	// Ignore lists the top-level fields of the response body whose values
+	// vary between calls, like random strings or IDs. They must be present,
+	// but their values are not compared.
+	Ignore []string `json:"ignore,omitempty"`
+}
+
+// Cases returns the golden cases of the service, by name.
+func Cases() ([]Case, error) {
+	return Load(golden, "testdata")
+}
+
+// Load reads the cases of the JSON files of dir in fsys, like those of
+// Cases, so that implementations can keep cases of their own.
+func Load(fsys fs.FS, dir string) ([]Case, error) {
+	names, err := fs.Glob(fsys, path.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	cases := make([]Case, 0, len(names))
+	for _, name := range names {
+		b, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		var c Case
+		if err := json.Unmarshal(b, &c); err != nil {
+			return nil, fmt.Errorf("invalid case %s: %w", name, err)
+		}
+		if c.Name == "" {
+			c.Name = strings.TrimSuffix(path.Base(name), ".json")
+		}
+		cases = append(cases, c)
+	}
+	sort.Slice(cases, func(i, j int) bool { return cases[i].Name < cases[j].Name })
+	return cases, nil
+}
+
+//
+// ────────────────────────────────────────────────────── I ──────────
+//   :::::: S E R V E R S : :  :   :    :     :        :          :
+// ────────────────────────────────────────────────────────────────
+//
+
+// Run serves h with an httptest.Server, and checks its response to every
+// case in a subtest named after the case.
+func Run(t *testing.T, h http.Handler, cases []Case) {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	RunURL(t, srv.URL, cases)
+}
+
+// RunURL checks the responses of the instance at baseURL to every case, in
+// subtests named after the cases.
+func RunURL(t *testing.T, baseURL string, cases []Case) {
+	t.Helper()
+	for _, c := range cases {
+		c := c
+		t.Run(c.Name, func(t *testing.T) {
+			if err := check(baseURL, c); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func check(baseURL string, c Case) error {
+	var body io.Reader
+	if len(c.Request.Body) > 0 {
+		body = bytes.NewReader(c.Request.Body)
+	}
+	req, err := http.NewRequest(c.Request.Method, strings.TrimSuffix(baseURL, "/")+c.Request.Path, body)
+	if err != nil {
+		return err
+	}
+	for name, value := range c.Request.Header {
+		req.Header.Set(name, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	got, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != c.Response.Status {
+		return fmt.Errorf("%s %s: status %d, want %d (body %s)", c.Request.Method, c.Request.Path, resp.StatusCode, c.Response.Status, got)
+	}
+	for name, want := range c.Response.Header {
+		if value := resp.Header.Get(name); value != want {
+			return fmt.Errorf("%s %s: header %s is %q, want %q", c.Request.Method, c.Request.Path, name, value, want)
+		}
+	}
+	if err := compare(got, c.Response.Body, c.Ignore); err != nil {
+		return fmt.Errorf("%s %s: %w", c.Request.Method, c.Request.Path, err)
+	}
+	return nil
+}
+
+// NewServer returns a server answering the requests of cases with their
+// responses, for the tests of clients. It fails t on requests that match
+// no case, and is closed at the end of the test.
+func NewServer(t testing.TB, cases []Case) *httptest.Server {
+	var mu sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		for _, c := range cases {
+			if c.Request.Method != r.Method || c.Request.Path != r.URL.RequestURI() {
+				continue
+			}
+			if compare(body, c.Request.Body, nil) != nil {
+				continue
+			}
+			for name, value := range c.Response.Header {
+				w.Header().Set(name, value)
+			}
+			w.WriteHeader(c.Response.Status)
+			w.Write(c.Response.Body)
+			return
+		}
+		mu.Lock()
+		t.Errorf("stringsvctest: no case for %s %s with body %s", r.Method, r.URL.RequestURI(), body)
+		mu.Unlock()
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// compare fails unless the JSON documents got and want are equal, but for
+// the values of the top-level fields ignore. Empty documents are equal to
+// each other only.
+func compare(got, want []byte, ignore []string) error {
+	got, want = bytes.TrimSpace(got), bytes.TrimSpace(want)
+	if len(got) == 0 || len(want) == 0 {
+		if len(got) != len(want) {
+			return fmt.Errorf("body is %q, want %q", got, want)
+		}
+		return nil
+	}
+	var g, w interface{}
+	if err := json.Unmarshal(got, &g); err != nil {
+		return fmt.Errorf("body %q is not JSON: %w", got, err)
+	}
+	if err := json.Unmarshal(want, &w); err != nil {
+		return fmt.Errorf("golden body %q is not JSON: %w", want, err)
+	}
+	if len(ignore) > 0 {
+		gm, gok := g.(map[string]interface{})
+		wm, wok := w.(map[string]interface{})
+		if !gok || !wok {
+			return fmt.Errorf("body is %s, want an object like %s", got, want)
+		}
+		for _, field := range ignore {
+			if _, ok := gm[field]; !ok {
+				return fmt.Errorf("body %s has no %q field", got, field)
+			}
+			delete(gm, field)
+			delete(wm, field)
+		}
+	}
+	if !equal(g, w) {
+		return fmt.Errorf("body is %s, want %s", got, want)
+	}
+	return nil
+}
+
+// equal tells whether the decoded JSON values a and b are equal. Numbers
+// are equal within a relative error of 1e-9, since scores summing floats
+// in map order vary in their last digits.
+func equal(a, b interface{}) bool {
+	switch a := a.(type) {
+	case float64:
+		b, ok := b.(float64)
+		return ok && math.Abs(a-b) <= 1e-9*math.Max(math.Abs(a), math.Abs(b))
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !equal(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		b, ok := b.(map[string]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for k, v := range a {
+			if w, ok := b[k]; !ok || !equal(v, w) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
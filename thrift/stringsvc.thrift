@@ -0,0 +1,27 @@
+/*
+ * Thrift interface of the string service. The server side is implemented
+ * by hand in server/thrift.go; clients generate their bindings from this
+ * file as usual, for instance with
+ *
+ *     thrift --gen go thrift/stringsvc.thrift
+ */
+
+namespace go stringsvc
+
+/* Raised when an operation rejects its input, like the "err" field of the
+ * HTTP responses. code is its code in the catalog of errors, like
+ * ERR_EMPTY_INPUT, for clients to branch on. */
+exception OperationError {
+  1: string message
+  2: string code
+}
+
+service StringService {
+  string Uppercase(1: string s) throws (1: OperationError err),
+
+  i64 Count(1: string s) throws (1: OperationError err),
+
+  /* Call runs any operation by name. request is its JSON request object and
+   * the result its JSON response, exactly as over HTTP. */
+  string Call(1: string op, 2: string request) throws (1: OperationError err),
+}